@@ -36,9 +36,10 @@ var Registration = &config.Registration{
 }
 
 var defaultSettings = Settings{
-	BatchMaxDuration:  metav1.Duration{Duration: time.Second * 10},
-	BatchIdleDuration: metav1.Duration{Duration: time.Second * 1},
-	DriftEnabled:      false,
+	BatchMaxDuration:         metav1.Duration{Duration: time.Second * 10},
+	BatchIdleDuration:        metav1.Duration{Duration: time.Second * 1},
+	DriftEnabled:             false,
+	AnnotateProvisioningPods: false,
 }
 
 type Settings struct {
@@ -46,6 +47,9 @@ type Settings struct {
 	BatchIdleDuration metav1.Duration
 	// This feature flag is temporary and will be removed in the near future.
 	DriftEnabled bool
+	// AnnotateProvisioningPods annotates pending pods with the provisioner and instance types of the capacity being
+	// launched for them, so that controllers gating pod readiness on node creation can react before the pods bind
+	AnnotateProvisioningPods bool
 }
 
 // NewSettingsFromConfigMap creates a Settings from the supplied ConfigMap
@@ -56,6 +60,7 @@ func NewSettingsFromConfigMap(cm *v1.ConfigMap) (Settings, error) {
 		AsMetaDuration("batchMaxDuration", &s.BatchMaxDuration),
 		AsMetaDuration("batchIdleDuration", &s.BatchIdleDuration),
 		configmap.AsBool("featureGates.driftEnabled", &s.DriftEnabled),
+		configmap.AsBool("annotateProvisioningPods", &s.AnnotateProvisioningPods),
 	); err != nil {
 		// Failing to parse means that there is some error in the Settings, so we should crash
 		panic(fmt.Sprintf("parsing settings, %v", err))
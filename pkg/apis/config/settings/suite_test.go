@@ -46,6 +46,7 @@ var _ = Describe("Validation", func() {
 		Expect(s.BatchMaxDuration.Duration).To(Equal(time.Second * 10))
 		Expect(s.BatchIdleDuration.Duration).To(Equal(time.Second))
 		Expect(s.DriftEnabled).To(BeFalse())
+		Expect(s.AnnotateProvisioningPods).To(BeFalse())
 	})
 	It("should succeed to set custom values", func() {
 		cm := &v1.ConfigMap{
@@ -53,12 +54,14 @@ var _ = Describe("Validation", func() {
 				"batchMaxDuration":          "30s",
 				"batchIdleDuration":         "5s",
 				"featureGates.driftEnabled": "true",
+				"annotateProvisioningPods":  "true",
 			},
 		}
 		s, _ := settings.NewSettingsFromConfigMap(cm)
 		Expect(s.BatchMaxDuration.Duration).To(Equal(time.Second * 30))
 		Expect(s.BatchIdleDuration.Duration).To(Equal(time.Second * 5))
 		Expect(s.DriftEnabled).To(BeTrue())
+		Expect(s.AnnotateProvisioningPods).To(BeTrue())
 	})
 	It("should fail validation with panic when batchMaxDuration is negative", func() {
 		defer ExpectPanic()
@@ -36,6 +36,8 @@ const (
 	MachineNameLabelKey     = Group + "/machine-name"
 	LabelNodeInitialized    = Group + "/initialized"
 	LabelCapacityType       = Group + "/capacity-type"
+	// SchedulingProfileLabelKey is set on a provisioner to select the scheduling profile of its nodes
+	SchedulingProfileLabelKey = Group + "/scheduling-profile"
 )
 
 // Karpenter specific annotations
@@ -45,6 +47,30 @@ const (
 	EmptinessTimestampAnnotationKey    = Group + "/emptiness-timestamp"
 	ProviderCompatabilityAnnotationKey = Group + "/compatibility/provider"
 	VoluntaryDisruptionAnnotationKey   = Group + "/voluntary-disruption"
+	DedicatedNodePodAnnotationKey      = Group + "/dedicated-node"
+	MinZonesPodAnnotationKey           = Group + "/min-zones"
+	// PodGroupPodAnnotationKey names the group of pods in the pod's namespace that are scheduled all-or-nothing
+	PodGroupPodAnnotationKey = Group + "/pod-group"
+	// InjectTolerationsProvisionerAnnotationKey marks a provisioner whose taints are tolerated by every pod, e.g.
+	// because a mutating webhook adds the tolerations, so pods aren't checked against its taints during scheduling
+	InjectTolerationsProvisionerAnnotationKey = Group + "/inject-tolerations"
+	// ProvisioningProvisionerPodAnnotationKey and ProvisioningInstanceTypesPodAnnotationKey are set on a pending pod
+	// that new capacity is being launched for, naming the provisioner and the instance types that may be launched.
+	// They're removed once the pod binds.
+	ProvisioningProvisionerPodAnnotationKey   = Group + "/provisioning-provisioner"
+	ProvisioningInstanceTypesPodAnnotationKey = Group + "/provisioning-instance-types"
+	// PreferredInstanceFamiliesPodAnnotationKey lists the comma separated instance families that a pod prefers to be
+	// scheduled to when choosing between existing nodes
+	PreferredInstanceFamiliesPodAnnotationKey = Group + "/preferred-instance-families"
+	// PreferredNodePodAnnotationKey names an existing node that a pod prefers to be scheduled to, which is tried before
+	// the other existing nodes if it has room for the pod
+	PreferredNodePodAnnotationKey = Group + "/preferred-node"
+	// DataZonePodAnnotationKey names the zone that a pod's data lives in, which the pod prefers to be scheduled to when
+	// SchedulerOptions.Zones.CrossZonePenalty is set, to avoid the cost of transferring the data across zones
+	DataZonePodAnnotationKey = Group + "/data-zone"
+	// SpreadThenPackPodAnnotationKey names the group of pods in the pod's namespace whose first pod in each batch is
+	// scheduled to a new node, e.g. for high availability, while the rest of the group is packed onto existing capacity
+	SpreadThenPackPodAnnotationKey = Group + "/spread-then-pack"
 
 	// Karpenter specific annotation values
 	VoluntaryDisruptionDriftedAnnotationValue = "drifted"
@@ -24,6 +24,16 @@ import (
 type Limits struct {
 	// Resources contains all the allocatable resources that Karpenter supports for limiting.
 	Resources v1.ResourceList `json:"resources,omitempty"`
+	// MaxInstanceResources caps the capacity of the instance types that nodes are launched with, so that small pods
+	// aren't packed onto very large nodes. Larger instance types are only launched for pods that don't fit on a
+	// smaller one.
+	// +optional
+	MaxInstanceResources v1.ResourceList `json:"maxInstanceResources,omitempty"`
+	// MaxNodeRequests caps the resources that the pods scheduled to a single node can request in total, including
+	// daemonset overhead, so that a node's failure only affects a bounded share of the workload. Pods that would exceed
+	// it are scheduled to another node, unless a pod exceeds it on its own in which case it gets a node to itself.
+	// +optional
+	MaxNodeRequests v1.ResourceList `json:"maxNodeRequests,omitempty"`
 }
 
 func (l *Limits) ExceededBy(resources v1.ResourceList) error {
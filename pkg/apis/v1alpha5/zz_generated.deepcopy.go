@@ -131,6 +131,20 @@ func (in *Limits) DeepCopyInto(out *Limits) {
 			(*out)[key] = val.DeepCopy()
 		}
 	}
+	if in.MaxInstanceResources != nil {
+		in, out := &in.MaxInstanceResources, &out.MaxInstanceResources
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.MaxNodeRequests != nil {
+		in, out := &in.MaxNodeRequests, &out.MaxNodeRequests
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Limits.
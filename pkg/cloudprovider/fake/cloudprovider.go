@@ -44,6 +44,8 @@ type CloudProvider struct {
 	mu                 sync.Mutex
 	CreateCalls        []*v1alpha1.Machine
 	AllowedCreateCalls int
+	// GetInstanceTypesErrors are returned, in order, by successive GetInstanceTypes calls before it starts succeeding
+	GetInstanceTypesErrors []error
 }
 
 var _ cloudprovider.CloudProvider = (*CloudProvider)(nil)
@@ -60,6 +62,7 @@ func (c *CloudProvider) Reset() {
 	defer c.mu.Unlock()
 	c.CreateCalls = []*v1alpha1.Machine{}
 	c.AllowedCreateCalls = math.MaxInt
+	c.GetInstanceTypesErrors = nil
 }
 
 func (c *CloudProvider) Create(ctx context.Context, machine *v1alpha1.Machine) (*v1.Node, error) {
@@ -114,6 +117,14 @@ func (c *CloudProvider) Create(ctx context.Context, machine *v1alpha1.Machine) (
 }
 
 func (c *CloudProvider) GetInstanceTypes(_ context.Context, _ *v1alpha5.Provisioner) ([]*cloudprovider.InstanceType, error) {
+	c.mu.Lock()
+	if len(c.GetInstanceTypesErrors) > 0 {
+		err := c.GetInstanceTypesErrors[0]
+		c.GetInstanceTypesErrors = c.GetInstanceTypesErrors[1:]
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.mu.Unlock()
 	if c.InstanceTypes != nil {
 		return c.InstanceTypes, nil
 	}
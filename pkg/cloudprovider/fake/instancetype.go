@@ -92,10 +92,11 @@ func NewInstanceType(options InstanceTypeOptions) *cloudprovider.InstanceType {
 	}
 
 	return &cloudprovider.InstanceType{
-		Name:         options.Name,
-		Requirements: requirements,
-		Offerings:    options.Offerings,
-		Capacity:     options.Resources,
+		Name:              options.Name,
+		Requirements:      requirements,
+		Offerings:         options.Offerings,
+		Capacity:          options.Resources,
+		AttachableVolumes: options.AttachableVolumes,
 		Overhead: &cloudprovider.InstanceTypeOverhead{
 			KubeReserved: v1.ResourceList{
 				v1.ResourceCPU:    resource.MustParse("100m"),
@@ -169,6 +170,8 @@ type InstanceTypeOptions struct {
 	Architecture     string
 	OperatingSystems utilsets.String
 	Resources        v1.ResourceList
+	// AttachableVolumes is the maximum number of volumes that can be attached to the instance type, unlimited if zero
+	AttachableVolumes int
 }
 
 func priceFromResources(resources v1.ResourceList) float64 {
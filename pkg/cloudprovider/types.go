@@ -16,6 +16,8 @@ package cloudprovider
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
@@ -81,6 +83,9 @@ type InstanceType struct {
 	// Overhead is the amount of resource overhead expected to be used by kubelet and any other system daemons outside
 	// of Kubernetes.
 	Overhead *InstanceTypeOverhead
+	// AttachableVolumes is the maximum number of volumes that can be attached to the instance type, zero if the
+	// instance type doesn't limit the number of volumes
+	AttachableVolumes int
 }
 
 type InstanceTypeOverhead struct {
@@ -105,6 +110,14 @@ type Offering struct {
 	// Available is added so that Offerings can return all offerings that have ever existed for an instance type,
 	// so we can get historical pricing data for calculating savings in consolidation
 	Available bool
+	// ReservationExpiry is the time that the capacity reservation backing the offering expires, it's zero if the
+	// offering isn't a reservation or the reservation doesn't expire
+	ReservationExpiry time.Time
+}
+
+// ExpiresBefore returns true if the offering is a capacity reservation that expires before the given time
+func (o Offering) ExpiresBefore(t time.Time) bool {
+	return !o.ReservationExpiry.IsZero() && o.ReservationExpiry.Before(t)
 }
 
 type Offerings []Offering
@@ -138,3 +151,23 @@ func (ofs Offerings) Cheapest() Offering {
 		return a.Price < b.Price
 	})
 }
+
+// TransientError is an error returned by a cloud provider call that is expected to succeed if retried shortly
+// (e.g. throttling or a momentarily unavailable API)
+type TransientError struct {
+	error
+}
+
+func NewTransientError(err error) *TransientError {
+	return &TransientError{error: err}
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.error
+}
+
+// IsTransientError returns true if any error in the chain is a TransientError
+func IsTransientError(err error) bool {
+	var transientErr *TransientError
+	return errors.As(err, &transientErr)
+}
@@ -36,7 +36,7 @@ type Controller struct {
 func NewController(cluster *state.Cluster) *Controller {
 	return &Controller{
 		cluster:  cluster,
-		scrapers: []scraper.Scraper{scraper.NewNodeScraper(cluster)},
+		scrapers: []scraper.Scraper{scraper.NewNodeScraper(cluster), scraper.NewClusterScraper(cluster)},
 	}
 }
 
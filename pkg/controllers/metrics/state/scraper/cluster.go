@@ -0,0 +1,70 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scraper
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/controllers/state"
+)
+
+var (
+	discoveredObjectsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "karpenter",
+			Subsystem: "cluster_state",
+			Name:      "discovered_objects",
+			Help:      "Number of nodes and pods that have been discovered in the cluster.",
+		},
+	)
+	reconciledObjectsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "karpenter",
+			Subsystem: "cluster_state",
+			Name:      "reconciled_objects",
+			Help:      "Number of discovered nodes and pods that have been reconciled into the cluster state. The cluster state is initialized once this is equal to the number of discovered objects.",
+		},
+	)
+	fragmentationGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "karpenter",
+			Subsystem: "cluster_state",
+			Name:      "fragmentation",
+			Help:      "Capacity left available across nodes, in multiples of the smallest node's allocatable CPU and memory. Higher values mean more capacity could be reclaimed by consolidation.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(discoveredObjectsGauge, reconciledObjectsGauge, fragmentationGauge)
+}
+
+type ClusterScraper struct {
+	cluster *state.Cluster
+}
+
+func NewClusterScraper(cluster *state.Cluster) *ClusterScraper {
+	return &ClusterScraper{cluster: cluster}
+}
+
+func (cs *ClusterScraper) Scrape(_ context.Context) {
+	done, total := cs.cluster.InitializationProgress()
+	reconciledObjectsGauge.Set(float64(done))
+	discoveredObjectsGauge.Set(float64(total))
+	fragmentationGauge.Set(Fragmentation(cs.cluster))
+}
@@ -0,0 +1,71 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scraper
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/controllers/state"
+)
+
+// fragmentationResources are the resources that the fragmentation score measures wasted capacity across
+var fragmentationResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+// Fragmentation scores how fragmented the capacity of the cluster is as the sum of the capacity left available on
+// each node, measured in multiples of the smallest node's allocatable capacity and averaged across CPU and memory. A
+// score of 2 means that the capacity wasted across the cluster adds up to two of the smallest nodes. Nodes that are
+// marked for deletion are excluded as their capacity is about to be removed.
+func Fragmentation(cluster *state.Cluster) float64 {
+	// The nodes can't be kept past the callback, so the capacity that they have available is copied
+	var available []v1.ResourceList
+	smallest := v1.ResourceList{}
+	cluster.ForEachNode(func(n *state.Node) bool {
+		if n.MarkedForDeletion {
+			return true
+		}
+		available = append(available, n.Available.DeepCopy())
+		for _, resourceName := range fragmentationResources {
+			allocatable, ok := n.Allocatable[resourceName]
+			if !ok || allocatable.IsZero() {
+				continue
+			}
+			if current, ok := smallest[resourceName]; !ok || allocatable.Cmp(current) < 0 {
+				smallest[resourceName] = allocatable.DeepCopy()
+			}
+		}
+		return true
+	})
+
+	var score float64
+	for _, nodeAvailable := range available {
+		var wasted float64
+		var count int
+		for _, resourceName := range fragmentationResources {
+			size, ok := smallest[resourceName]
+			if !ok {
+				continue
+			}
+			available := nodeAvailable[resourceName]
+			if available.Sign() > 0 {
+				wasted += available.AsApproximateFloat64() / size.AsApproximateFloat64()
+			}
+			count++
+		}
+		if count > 0 {
+			score += wasted / float64(count)
+		}
+	}
+	return score
+}
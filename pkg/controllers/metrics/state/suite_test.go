@@ -55,6 +55,7 @@ var podController controller.Controller
 var cloudProvider *fake.CloudProvider
 var provisioner *v1alpha5.Provisioner
 var nodeScraper *statemetrics.NodeScraper
+var clusterScraper *statemetrics.ClusterScraper
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -74,6 +75,7 @@ var _ = BeforeSuite(func() {
 	nodeController = state.NewNodeController(env.Client, cluster)
 	podController = state.NewPodController(env.Client, cluster)
 	nodeScraper = statemetrics.NewNodeScraper(cluster)
+	clusterScraper = statemetrics.NewClusterScraper(cluster)
 	ExpectApplied(ctx, env.Client, provisioner)
 })
 
@@ -124,3 +126,97 @@ var _ = Describe("Node Metrics", func() {
 		}
 	})
 })
+
+var _ = Describe("Cluster State Metrics", func() {
+	It("should update the initialization progress metrics", func() {
+		node := test.Node()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		clusterScraper.Scrape(ctx)
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(BeNumerically(">", 0))
+		Expect(ExpectMetric("karpenter_cluster_state_reconciled_objects").Metric[0].GetGauge().GetValue()).To(BeNumerically("==", done))
+		Expect(ExpectMetric("karpenter_cluster_state_discovered_objects").Metric[0].GetGauge().GetValue()).To(BeNumerically("==", total))
+	})
+	It("should increase the fragmentation score as nodes become under-utilized", func() {
+		nodeOptions := test.NodeOptions{
+			ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("4Gi")},
+		}
+		nodes := []*v1.Node{test.Node(nodeOptions), test.Node(nodeOptions)}
+		var pods []*v1.Pod
+		for _, node := range nodes {
+			pod := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("3"),
+					v1.ResourceMemory: resource.MustParse("3Gi"),
+				}},
+			})
+			ExpectApplied(ctx, env.Client, node, pod)
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+			ExpectManualBinding(ctx, env.Client, pod, node)
+			ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+			pods = append(pods, pod)
+		}
+		utilized := statemetrics.Fragmentation(cluster)
+
+		// freeing the capacity of one node should add it to the fragmentation score
+		ExpectDeleted(ctx, env.Client, pods[0])
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pods[0]))
+		underUtilized := statemetrics.Fragmentation(cluster)
+		Expect(underUtilized).To(BeNumerically(">", utilized))
+
+		ExpectDeleted(ctx, env.Client, pods[1])
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pods[1]))
+		Expect(statemetrics.Fragmentation(cluster)).To(BeNumerically(">", underUtilized))
+
+		clusterScraper.Scrape(ctx)
+		Expect(ExpectMetric("karpenter_cluster_state_fragmentation").Metric[0].GetGauge().GetValue()).To(BeNumerically("==", statemetrics.Fragmentation(cluster)))
+	})
+	It("should not score nodes that are marked for deletion", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("4Gi")},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		withNode := statemetrics.Fragmentation(cluster)
+
+		cluster.MarkForDeletion(node.Name)
+		Expect(statemetrics.Fragmentation(cluster)).To(BeNumerically("<", withNode))
+		cluster.UnmarkForDeletion(node.Name)
+	})
+	It("should score the nodes while their pods are updated concurrently", func() {
+		// run with -race to detect the nodes being read after they're released
+		node := test.Node(test.NodeOptions{
+			ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("4Gi")},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		var pods []*v1.Pod
+		for i := 0; i < 100; i++ {
+			pods = append(pods, test.Pod(test.PodOptions{
+				NodeName:             node.Name,
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10m")}},
+			}))
+		}
+		updated := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(updated)
+			for _, pod := range pods {
+				Expect(cluster.UpdatePod(ctx, pod)).To(Succeed())
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			Expect(statemetrics.Fragmentation(cluster)).To(BeNumerically(">=", 0))
+		}
+		Eventually(updated).Should(BeClosed())
+		for _, pod := range pods {
+			cluster.DeletePod(client.ObjectKeyFromObject(pod))
+		}
+	})
+})
@@ -24,6 +24,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/events"
 	corecontroller "github.com/aws/karpenter-core/pkg/operator/controller"
 	"github.com/aws/karpenter-core/pkg/utils/pod"
@@ -56,6 +57,11 @@ func (c *Controller) Reconcile(_ context.Context, p *v1.Pod) (reconcile.Result,
 	if pod.IsProvisionable(p) {
 		c.provisioner.Trigger()
 	}
+	// Pods are only annotated while the capacity for them is being provisioned, so clear the annotations once bound
+	if p.Spec.NodeName != "" {
+		delete(p.Annotations, v1alpha5.ProvisioningProvisionerPodAnnotationKey)
+		delete(p.Annotations, v1alpha5.ProvisioningInstanceTypesPodAnnotationKey)
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -17,6 +17,7 @@ package provisioning
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/imdario/mergo"
 	"github.com/prometheus/client_golang/prometheus"
@@ -36,6 +37,7 @@ import (
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/aws/karpenter-core/pkg/apis/config/settings"
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/operator/controller"
 	"github.com/aws/karpenter-core/pkg/operator/injection"
@@ -72,22 +74,25 @@ type Provisioner struct {
 	coreV1Client   corev1.CoreV1Interface
 	batcher        *Batcher
 	volumeTopology *VolumeTopology
-	cluster        *state.Cluster
-	recorder       events.Recorder
-	cm             *pretty.ChangeMonitor
+	// defaultTopologySpread applies the default topology spread constraints to pods that don't declare any
+	defaultTopologySpread *DefaultTopologySpread
+	cluster               *state.Cluster
+	recorder              events.Recorder
+	cm                    *pretty.ChangeMonitor
 }
 
 func NewProvisioner(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface,
 	recorder events.Recorder, cloudProvider cloudprovider.CloudProvider, cluster *state.Cluster) *Provisioner {
 	p := &Provisioner{
-		batcher:        NewBatcher(),
-		cloudProvider:  cloudProvider,
-		kubeClient:     kubeClient,
-		coreV1Client:   coreV1Client,
-		volumeTopology: NewVolumeTopology(kubeClient),
-		cluster:        cluster,
-		recorder:       recorder,
-		cm:             pretty.NewChangeMonitor(),
+		batcher:               NewBatcher(),
+		cloudProvider:         cloudProvider,
+		kubeClient:            kubeClient,
+		coreV1Client:          coreV1Client,
+		volumeTopology:        NewVolumeTopology(kubeClient),
+		defaultTopologySpread: NewDefaultTopologySpread(kubeClient),
+		cluster:               cluster,
+		recorder:              recorder,
+		cm:                    pretty.NewChangeMonitor(),
 	}
 	return p
 }
@@ -101,6 +106,10 @@ func (p *Provisioner) Trigger() {
 }
 
 func (p *Provisioner) Builder(_ context.Context, mgr manager.Manager) controller.Builder {
+	// Pods that were bound to a deleted node may need new capacity, so we re-run scheduling
+	p.cluster.OnNodeDeleted(func(_ string) {
+		p.Trigger()
+	})
 	return controller.NewSingletonManagedBy(mgr)
 }
 
@@ -122,16 +131,18 @@ func (p *Provisioner) Reconcile(ctx context.Context, _ reconcile.Request) (resul
 	// prevents over-provisioning at the cost of potentially under-provisioning which will self-heal during the next
 	// scheduling loop when we launch a new node.  When this order is reversed, our node capacity may be reduced by pods
 	// that have bound which we then provision new un-needed capacity for.
+	// The nodes are read from a snapshot of the cluster state, which is only copied again for the nodes that have
+	// changed since the last reconcile.
 	var stateNodes []*state.Node
 	var markedForDeletionNodes []*state.Node
-	p.cluster.ForEachNode(func(node *state.Node) bool {
+	p.cluster.Snapshot().ForEachNode(func(node *state.Node) bool {
 		// We don't consider the nodes that are MarkedForDeletion since this capacity shouldn't be considered
 		// as persistent capacity for the cluster (since it will soon be removed). Additionally, we are scheduling for
 		// the pods that are on these nodes so the MarkedForDeletion node capacity can't be considered.
 		if !node.MarkedForDeletion {
-			stateNodes = append(stateNodes, node.DeepCopy())
+			stateNodes = append(stateNodes, node)
 		} else {
-			markedForDeletionNodes = append(markedForDeletionNodes, node.DeepCopy())
+			markedForDeletionNodes = append(markedForDeletionNodes, node)
 		}
 		return true
 	})
@@ -190,12 +201,31 @@ func (p *Provisioner) LaunchMachines(ctx context.Context, machines []*scheduler.
 			machineNames[i] = machineName
 		}
 	})
+	p.recordProvisionedNodes(ctx, machines, machineNames)
 	if err := multierr.Combine(errs...); err != nil {
 		return machineNames, err
 	}
 	return machineNames, nil
 }
 
+// recordProvisionedNodes publishes an event on each provisioner with the nodes that were launched from it
+func (p *Provisioner) recordProvisionedNodes(ctx context.Context, machines []*scheduler.Node, machineNames []string) {
+	nodeNames := map[string][]string{}
+	for i, machineName := range machineNames {
+		if machineName != "" {
+			nodeNames[machines[i].ProvisionerName] = append(nodeNames[machines[i].ProvisionerName], machineName)
+		}
+	}
+	for provisionerName, names := range nodeNames {
+		provisioner := &v1alpha5.Provisioner{}
+		if err := p.kubeClient.Get(ctx, types.NamespacedName{Name: provisionerName}, provisioner); err != nil {
+			logging.FromContext(ctx).With("provisioner", provisionerName).Debugf("getting provisioner for its events, %s", err)
+			continue
+		}
+		p.recorder.Publish(events.ProvisionerProvisionedNodes(provisioner, names))
+	}
+}
+
 func (p *Provisioner) GetPendingPods(ctx context.Context) ([]*v1.Pod, error) {
 	var podList v1.PodList
 	if err := p.kubeClient.List(ctx, &podList, client.MatchingFields{"spec.nodeName": ""}); err != nil {
@@ -255,12 +285,17 @@ func (p *Provisioner) NewScheduler(ctx context.Context, pods []*v1.Pod, stateNod
 			continue
 		}
 		// Create node template
-		machines = append(machines, scheduler.NewMachineTemplate(provisioner))
+		machineTemplate := scheduler.NewMachineTemplate(provisioner)
+		machines = append(machines, machineTemplate)
 		// Get instance type options
 		instanceTypeOptions, err := p.cloudProvider.GetInstanceTypes(ctx, provisioner)
 		if err != nil {
 			return nil, fmt.Errorf("getting instance types, %w", err)
 		}
+		// Transform before the domains are constructed, so that topology only considers the instance types that remain
+		if opts.InstanceTypes.InstanceTypeTransform != nil {
+			instanceTypeOptions = opts.InstanceTypes.InstanceTypeTransform(provisioner.Name, instanceTypeOptions)
+		}
 		instanceTypes[provisioner.Name] = append(instanceTypes[provisioner.Name], instanceTypeOptions...)
 
 		// Construct Topology Domains
@@ -269,31 +304,46 @@ func (p *Provisioner) NewScheduler(ctx context.Context, pods []*v1.Pod, stateNod
 				domains[key] = domains[key].Union(sets.NewString(requirement.Values()...))
 			}
 		}
-		for key, requirement := range scheduling.NewNodeSelectorRequirements(provisioner.Spec.Requirements...) {
+		// The template requirements include the provisioner's labels, so that pod affinities and topology spread across
+		// arbitrary keys (e.g. a rack label) know about the domains that the provisioner can launch nodes into
+		for key, requirement := range machineTemplate.Requirements {
 			if requirement.Operator() == v1.NodeSelectorOpIn {
 				domains[key] = domains[key].Union(sets.NewString(requirement.Values()...))
 			}
 		}
 	}
 	if len(machines) == 0 {
-		return nil, fmt.Errorf("no provisioners found")
+		return nil, scheduler.ErrNoProvisioners
+	}
+
+	// Excluded zones aren't registered as topology domains, otherwise topology spread would expect pods to be placed
+	// in them
+	if domains[v1.LabelTopologyZone] != nil {
+		domains[v1.LabelTopologyZone] = domains[v1.LabelTopologyZone].Difference(sets.NewString(opts.Zones.ExcludedZones...))
 	}
 
 	// inject topology constraints
-	pods = p.injectTopology(ctx, pods)
+	pods = p.injectTopology(ctx, pods, opts.Pods.DefaultTopologySpreadConstraints)
 
 	// Calculate cluster topology
-	topology, err := scheduler.NewTopology(ctx, p.kubeClient, p.cluster, domains, pods)
+	maxTopologyDomains := opts.MaxTopologyDomains
+	if maxTopologyDomains == 0 {
+		maxTopologyDomains = scheduler.DefaultMaxTopologyDomains
+	}
+	topology, err := scheduler.NewTopology(ctx, p.kubeClient, p.cluster, domains, pods, maxTopologyDomains, opts.StrictTopologyDomains,
+		opts.Pods.IgnoreDaemonSetAntiAffinity)
 	if err != nil {
 		return nil, fmt.Errorf("tracking topology counts, %w", err)
 	}
 
 	// Calculate daemon overhead
-	daemonOverhead, err := p.getDaemonOverhead(ctx, machines)
+	daemonPods, err := p.getDaemonPods(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("getting daemon overhead, %w", err)
 	}
-	return scheduler.NewScheduler(ctx, p.kubeClient, machines, provisionerList.Items, p.cluster, stateNodes, topology, instanceTypes, daemonOverhead, p.recorder, opts), nil
+	daemonOverhead := getDaemonOverhead(machines, daemonPods)
+	return scheduler.NewScheduler(ctx, p.kubeClient, machines, provisionerList.Items, p.cluster, stateNodes, topology, instanceTypes, daemonOverhead, daemonPods,
+		p.recorder, opts), nil
 }
 
 func (p *Provisioner) schedule(ctx context.Context, pods []*v1.Pod, stateNodes []*state.Node) ([]*scheduler.Node, error) {
@@ -316,15 +366,22 @@ func (p *Provisioner) launch(ctx context.Context, machine *scheduler.Node, opts
 		return "", fmt.Errorf("getting current resource usage, %w", err)
 	}
 	if err := latest.Spec.Limits.ExceededBy(latest.Status.Resources); err != nil {
+		p.recorder.Publish(events.ProvisionerLimitsExceeded(latest, err))
 		return "", err
 	}
 
 	logging.FromContext(ctx).Infof("launching %s", machine)
+	// Reserve the largest capacity that the node could launch with until it's tracked, so that solves in the meantime
+	// don't launch beyond the provisioner's limits. If the node is launched but can't be tracked, the reservation holds
+	// until it expires.
+	reservation := p.cluster.Reserve(machine.ProvisionerName, resources.MaxResources(lo.Map(machine.InstanceTypeOptions,
+		func(it *cloudprovider.InstanceType, _ int) v1.ResourceList { return it.Capacity })...))
 	k8sNode, err := p.cloudProvider.Create(
 		logging.WithLogger(ctx, logging.FromContext(ctx).Named("cloudprovider")),
 		machine.ToMachine(latest),
 	)
 	if err != nil {
+		p.cluster.Release(reservation)
 		return "", fmt.Errorf("creating cloud provider instance, %w", err)
 	}
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("node", k8sNode.Name))
@@ -347,30 +404,70 @@ func (p *Provisioner) launch(ctx context.Context, machine *scheduler.Node, opts
 			return "", fmt.Errorf("creating node %s, %w", k8sNode.Name, err)
 		}
 	}
-	p.cluster.NominateNodeForPod(k8sNode.Name)
+	recordPodNomination := functional.ResolveOptions[LaunchOptions](opts...).RecordPodNomination
+	// Replacement nodes for deprovisioning stay nominated regardless of where any pending pods end up, since they're
+	// waiting on the pods of the nodes that they're replacing
+	if recordPodNomination {
+		p.cluster.NominateNodeForPod(k8sNode.Name, machine.Pods...)
+	} else {
+		p.cluster.NominateNodeForPod(k8sNode.Name)
+	}
 	if err := p.cluster.UpdateNode(ctx, k8sNode); err != nil {
 		return "", fmt.Errorf("updating cluster state, %w", err)
 	}
-	if functional.ResolveOptions[LaunchOptions](opts...).RecordPodNomination {
+	p.cluster.Release(reservation)
+	if recordPodNomination {
 		for _, pod := range machine.Pods {
 			p.recorder.Publish(events.NominatePod(pod, k8sNode))
 		}
+		if settings.FromContext(ctx).AnnotateProvisioningPods {
+			p.annotateProvisioningPods(ctx, machine)
+		}
 	}
 	return k8sNode.Name, nil
 }
 
-func (p *Provisioner) getDaemonOverhead(ctx context.Context, nodeTemplates []*scheduler.MachineTemplate) (map[*scheduler.MachineTemplate]v1.ResourceList, error) {
-	overhead := map[*scheduler.MachineTemplate]v1.ResourceList{}
+// maxAnnotatedInstanceTypes bounds the number of instance types recorded on a provisioning pod, since a machine can
+// have hundreds of instance type options
+const maxAnnotatedInstanceTypes = 10
 
+// annotateProvisioningPods records the provisioner and instance types of the machine on each of the pods that it was
+// launched for. Failing to annotate a pod doesn't fail the launch, since the capacity has already been created.
+func (p *Provisioner) annotateProvisioningPods(ctx context.Context, machine *scheduler.Node) {
+	instanceTypeNames := lo.Map(machine.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	if len(instanceTypeNames) > maxAnnotatedInstanceTypes {
+		instanceTypeNames = instanceTypeNames[:maxAnnotatedInstanceTypes]
+	}
+	for _, pod := range machine.Pods {
+		stored := pod.DeepCopy()
+		annotated := pod.DeepCopy()
+		annotated.Annotations = lo.Assign(annotated.Annotations, map[string]string{
+			v1alpha5.ProvisioningProvisionerPodAnnotationKey:   machine.ProvisionerName,
+			v1alpha5.ProvisioningInstanceTypesPodAnnotationKey: strings.Join(instanceTypeNames, ","),
+		})
+		if err := p.kubeClient.Patch(ctx, annotated, client.MergeFrom(stored)); client.IgnoreNotFound(err) != nil {
+			logging.FromContext(ctx).Errorf("annotating pod %s, %s", client.ObjectKeyFromObject(pod), err)
+		}
+	}
+}
+
+// getDaemonPods returns a pod for each daemonset in the cluster, which is used to determine the daemonsets that will
+// run on a node
+func (p *Provisioner) getDaemonPods(ctx context.Context) ([]*v1.Pod, error) {
 	daemonSetList := &appsv1.DaemonSetList{}
 	if err := p.kubeClient.List(ctx, daemonSetList); err != nil {
 		return nil, fmt.Errorf("listing daemonsets, %w", err)
 	}
+	return lo.Map(daemonSetList.Items, func(daemonSet appsv1.DaemonSet, _ int) *v1.Pod {
+		return &v1.Pod{Spec: daemonSet.Spec.Template.Spec}
+	}), nil
+}
 
+func getDaemonOverhead(nodeTemplates []*scheduler.MachineTemplate, daemonPods []*v1.Pod) map[*scheduler.MachineTemplate]v1.ResourceList {
+	overhead := map[*scheduler.MachineTemplate]v1.ResourceList{}
 	for _, nodeTemplate := range nodeTemplates {
 		var daemons []*v1.Pod
-		for _, daemonSet := range daemonSetList.Items {
-			p := &v1.Pod{Spec: daemonSet.Spec.Template.Spec}
+		for _, p := range daemonPods {
 			if err := nodeTemplate.Taints.Tolerates(p); err != nil {
 				continue
 			}
@@ -381,14 +478,14 @@ func (p *Provisioner) getDaemonOverhead(ctx context.Context, nodeTemplates []*sc
 		}
 		overhead[nodeTemplate] = resources.RequestsForPods(daemons...)
 	}
-
-	return overhead, nil
+	return overhead
 }
 
 func (p *Provisioner) Validate(ctx context.Context, pod *v1.Pod) error {
 	return multierr.Combine(
 		validateProvisionerNameCanExist(pod),
 		validateAffinity(pod),
+		validateMinZones(pod),
 		p.volumeTopology.validatePersistentVolumeClaims(ctx, pod),
 	)
 }
@@ -405,11 +502,21 @@ func validateProvisionerNameCanExist(p *v1.Pod) error {
 	return nil
 }
 
-func (p *Provisioner) injectTopology(ctx context.Context, pods []*v1.Pod) []*v1.Pod {
+func validateMinZones(p *v1.Pod) error {
+	value, ok := p.Annotations[v1alpha5.MinZonesPodAnnotationKey]
+	if ok && pod.MinZones(p) == 0 && value != "0" {
+		return fmt.Errorf("invalid %s annotation %q, must be a non-negative integer", v1alpha5.MinZonesPodAnnotationKey, value)
+	}
+	return nil
+}
+
+func (p *Provisioner) injectTopology(ctx context.Context, pods []*v1.Pod, defaultConstraints []v1.TopologySpreadConstraint) []*v1.Pod {
 	var schedulablePods []*v1.Pod
 	for _, pod := range pods {
 		if err := p.volumeTopology.Inject(ctx, pod); err != nil {
 			logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).Errorf("getting volume topology requirements, %s", err)
+		} else if err := p.defaultTopologySpread.Inject(ctx, pod, defaultConstraints); err != nil {
+			logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).Errorf("getting default topology spread constraints, %s", err)
 		} else {
 			schedulablePods = append(schedulablePods, pod)
 		}
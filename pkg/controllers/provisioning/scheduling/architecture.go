@@ -0,0 +1,90 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// ArchitectureResolver resolves the architectures that a container image can run on, e.g. from the platforms listed
+// in its manifest
+type ArchitectureResolver interface {
+	Architectures(ctx context.Context, image string) ([]string, error)
+}
+
+// architectureInference infers the architecture of pods that don't constrain it from their container images, so that
+// they aren't launched on nodes of an architecture that their images can't run on. It's best-effort, the architecture
+// is only inferred if every image resolves and they have exactly one architecture in common. The architectures of each
+// image are cached for the lifetime of the scheduler.
+type architectureInference struct {
+	resolver ArchitectureResolver
+	images   map[string]sets.String // image -> architectures, nil if the image couldn't be resolved
+}
+
+func newArchitectureInference(resolver ArchitectureResolver) *architectureInference {
+	if resolver == nil {
+		return nil
+	}
+	return &architectureInference{
+		resolver: resolver,
+		images:   map[string]sets.String{},
+	}
+}
+
+// Requirement returns a requirement for the architecture inferred from the pod's images, or nil if inference is
+// disabled, the pod already constrains its architecture or its architecture can't be inferred
+func (a *architectureInference) Requirement(ctx context.Context, pod *v1.Pod, podRequirements scheduling.Requirements) *scheduling.Requirement {
+	if a == nil || podRequirements.Has(v1.LabelArchStable) {
+		return nil
+	}
+	var architectures sets.String
+	for _, container := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		imageArchitectures := a.architectures(ctx, container.Image)
+		if imageArchitectures == nil {
+			return nil
+		}
+		if architectures == nil {
+			architectures = imageArchitectures
+		} else {
+			architectures = architectures.Intersection(imageArchitectures)
+		}
+	}
+	if architectures.Len() != 1 {
+		return nil
+	}
+	return scheduling.NewRequirement(v1.LabelArchStable, v1.NodeSelectorOpIn, architectures.UnsortedList()...)
+}
+
+func (a *architectureInference) architectures(ctx context.Context, image string) sets.String {
+	if architectures, ok := a.images[image]; ok {
+		return architectures
+	}
+	resolved, err := a.resolver.Architectures(ctx, image)
+	if err != nil {
+		logging.FromContext(ctx).With("image", image).Debugf("unable to resolve image architectures, %s", err)
+	}
+	var architectures sets.String
+	if err == nil && len(resolved) > 0 {
+		architectures = sets.NewString(resolved...)
+	}
+	a.images[image] = architectures
+	return architectures
+}
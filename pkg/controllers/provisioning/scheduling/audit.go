@@ -0,0 +1,133 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+)
+
+// AuditSink durably records the provisioning decisions of the solves that aren't simulations, e.g. for compliance,
+// beyond the events and logs that are only kept for a short time. A record is written after each such solve.
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+}
+
+// AuditRecord is the outcome of a solve: the new nodes that it launches, the existing nodes that it schedules pods to,
+// and the pods that it couldn't schedule
+type AuditRecord struct {
+	Time          time.Time           `json:"time"`
+	NewNodes      []AuditNewNode      `json:"newNodes,omitempty"`
+	ExistingNodes []AuditExistingNode `json:"existingNodes,omitempty"`
+	Failures      []AuditFailure      `json:"failures,omitempty"`
+}
+
+// AuditNewNode is a new node and the pods scheduled to it, its instance types are listed in order of preference
+type AuditNewNode struct {
+	Provisioner   string   `json:"provisioner"`
+	InstanceTypes []string `json:"instanceTypes"`
+	Pods          []string `json:"pods"`
+}
+
+// AuditExistingNode is an existing node and the pods scheduled to it
+type AuditExistingNode struct {
+	Provisioner string   `json:"provisioner"`
+	Node        string   `json:"node"`
+	Pods        []string `json:"pods"`
+}
+
+// AuditFailure is a pod that couldn't be scheduled, with the provisioners that were considered for it and the reason
+// that it's counted by in the provisioning decision
+type AuditFailure struct {
+	Pod          string   `json:"pod"`
+	Provisioners []string `json:"provisioners"`
+	Reason       string   `json:"reason"`
+	Error        string   `json:"error"`
+}
+
+// NopAuditSink discards the records, it's the default if no sink is configured
+type NopAuditSink struct{}
+
+func (NopAuditSink) Write(context.Context, AuditRecord) error {
+	return nil
+}
+
+// JSONLinesAuditSink writes each record to the writer as a single line of JSON. It's safe for concurrent use.
+type JSONLinesAuditSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewJSONLinesAuditSink(writer io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{writer: writer}
+}
+
+func (s *JSONLinesAuditSink) Write(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshaling audit record, %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing audit record, %w", err)
+	}
+	return nil
+}
+
+// recordAudit writes the outcome of the solve to the audit sink. A record that can't be written is logged rather than
+// failing the solve, as the decisions have already been made.
+func (s *Scheduler) recordAudit(ctx context.Context, failures []AuditFailure) {
+	record := AuditRecord{Time: s.opts.Clock.Now(), Failures: failures}
+	for _, node := range s.newNodes {
+		if len(node.Pods) == 0 {
+			continue
+		}
+		record.NewNodes = append(record.NewNodes, AuditNewNode{
+			Provisioner:   node.ProvisionerName,
+			InstanceTypes: lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name }),
+			Pods:          podNames(node.Pods),
+		})
+	}
+	for _, node := range s.existingNodes {
+		if len(node.Pods) == 0 {
+			continue
+		}
+		record.ExistingNodes = append(record.ExistingNodes, AuditExistingNode{
+			Provisioner: node.Node.Labels[v1alpha5.ProvisionerNameLabelKey],
+			Node:        node.Node.Name,
+			Pods:        podNames(node.Pods),
+		})
+	}
+	if err := s.opts.Reporting.AuditSink.Write(ctx, record); err != nil {
+		logging.FromContext(ctx).Errorf("recording scheduling audit, %s", err)
+	}
+}
+
+func podNames(pods []*v1.Pod) []string {
+	return lo.Map(pods, func(pod *v1.Pod, _ int) string { return client.ObjectKeyFromObject(pod).String() })
+}
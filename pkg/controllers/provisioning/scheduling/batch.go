@@ -0,0 +1,148 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+)
+
+// BatchQueue accumulates pending pods into batches to Solve together, so that pods which arrive close together are
+// packed onto the same new nodes rather than each being solved as it arrives. A batch is ready once its oldest pod has
+// waited for the window, or as soon as it reaches the maximum size. Pods that are added while they're already queued
+// aren't duplicated. It's safe for concurrent use.
+type BatchQueue struct {
+	clock   clock.Clock
+	window  time.Duration
+	maxSize int
+
+	mu       sync.Mutex
+	pods     []*v1.Pod
+	arrivals map[types.UID]time.Time // pod uid -> when the pod was queued
+}
+
+// NewBatchQueue constructs a queue whose batches wait up to the window after their oldest pod is queued and hold up to
+// maxSize pods. A maxSize of zero doesn't bound the size of a batch.
+func NewBatchQueue(clk clock.Clock, window time.Duration, maxSize int) *BatchQueue {
+	return &BatchQueue{
+		clock:    clk,
+		window:   window,
+		maxSize:  maxSize,
+		arrivals: map[types.UID]time.Time{},
+	}
+}
+
+// Add queues the pods that aren't already queued
+func (q *BatchQueue) Add(pods ...*v1.Pod) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, pod := range pods {
+		if _, ok := q.arrivals[pod.UID]; ok {
+			continue
+		}
+		q.arrivals[pod.UID] = q.clock.Now()
+		q.pods = append(q.pods, pod)
+	}
+}
+
+// Len returns the number of queued pods
+func (q *BatchQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pods)
+}
+
+// Ready returns true if the next batch is ready to be solved
+func (q *BatchQueue) Ready() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wait, ok := q.untilReady()
+	return ok && wait <= 0
+}
+
+// untilReady returns how long until the next batch is ready, or false if there are no pods queued
+func (q *BatchQueue) untilReady() (time.Duration, bool) {
+	if len(q.pods) == 0 {
+		return 0, false
+	}
+	if q.maxSize > 0 && len(q.pods) >= q.maxSize {
+		return 0, true
+	}
+	return q.window - q.clock.Since(q.arrivals[q.pods[0].UID]), true
+}
+
+// Pop removes and returns the next batch, the oldest pods up to the maximum size, whether or not it's ready. The pods
+// that remain queued keep their place, so the next batch is ready once the oldest of them has waited for the window.
+func (q *BatchQueue) Pop() []*v1.Pod {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	size := len(q.pods)
+	if q.maxSize > 0 && size > q.maxSize {
+		size = q.maxSize
+	}
+	batch := q.pods[:size:size]
+	q.pods = q.pods[size:]
+	for _, pod := range batch {
+		delete(q.arrivals, pod.UID)
+	}
+	return batch
+}
+
+// Next queues the pods received from the stream until the next batch is ready and returns it. If the stream is closed,
+// the pods queued so far are returned without waiting for the rest of the window, and nil is returned once there are
+// none left. If the context is canceled, nil is returned and the queued pods are kept for the next call.
+func (q *BatchQueue) Next(ctx context.Context, pods <-chan *v1.Pod) []*v1.Pod {
+	for {
+		q.mu.Lock()
+		wait, queued := q.untilReady()
+		q.mu.Unlock()
+		if queued && wait <= 0 {
+			return q.Pop()
+		}
+		// An empty queue waits for the first pod of the batch without a deadline
+		var timer clock.Timer
+		var timeout <-chan time.Time
+		if queued {
+			timer = q.clock.NewTimer(wait)
+			timeout = timer.C()
+		}
+		closed, canceled := false, false
+		select {
+		case pod, ok := <-pods:
+			if ok {
+				q.Add(pod)
+			} else {
+				closed = true
+			}
+		case <-timeout:
+		case <-ctx.Done():
+			canceled = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if canceled || (closed && !queued) {
+			return nil
+		}
+		if closed {
+			return q.Pop()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// CostFunc returns the cost of launching the instance type with the offering, e.g. to apply negotiated pricing that
+// differs from the offering's price
+type CostFunc func(*cloudprovider.InstanceType, cloudprovider.Offering) float64
+
+// offeringPrice is the cost of an offering if no CostFunc is provided
+func offeringPrice(_ *cloudprovider.InstanceType, offering cloudprovider.Offering) float64 {
+	return offering.Price
+}
+
+// TotalCost is the key of the estimate returned by EstimateCost that holds the cost summed across all provisioners. It
+// can't collide with a provisioner name as those can't be empty.
+const TotalCost = ""
+
+// EstimateCost returns the price of the new nodes that would be launched to run the pods, keyed by the name of the
+// provisioner that they would be launched from, along with the total under the TotalCost key. The pods are solved in
+// simulation mode, so no events are recorded, and each node is priced at the cheapest available offering of its
+// instance type options that's compatible with its requirements, as costed by SchedulerOptions.InstanceTypes.CostFunc
+// if it's set. Pods that fit on existing nodes don't add to the estimate. Like Solve, it can only be called once per
+// scheduler.
+func (s *Scheduler) EstimateCost(ctx context.Context, pods []*v1.Pod) (map[string]float64, error) {
+	simulationMode := s.opts.SimulationMode
+	s.opts.SimulationMode = true
+	defer func() { s.opts.SimulationMode = simulationMode }()
+
+	nodes, _, err := s.Solve(ctx, pods)
+	if err != nil {
+		return nil, fmt.Errorf("simulating scheduling, %w", err)
+	}
+	costs := map[string]float64{TotalCost: 0}
+	for _, n := range nodes {
+		price, ok := s.cheapestPrice(n)
+		if !ok {
+			return nil, fmt.Errorf("no available offering for %s", n)
+		}
+		costs[n.ProvisionerName] += price
+		costs[TotalCost] += price
+	}
+	return costs, nil
+}
+
+// cheapestPrice returns the price of the cheapest available offering across the node's instance type options that's
+// compatible with the node's requirements and not in an excluded zone
+func (s *Scheduler) cheapestPrice(n *Node) (float64, bool) {
+	cost := s.opts.InstanceTypes.CostFunc
+	if cost == nil {
+		cost = offeringPrice
+	}
+	price := math.MaxFloat64
+	for _, it := range n.InstanceTypeOptions {
+		if itPrice, ok := cheapestCost(it, n.Requirements, n.excludedZones, cost); ok && itPrice < price {
+			price = itPrice
+		}
+	}
+	return price, price != math.MaxFloat64
+}
+
+// cheapestCost returns the cost of the instance type's cheapest available offering that's compatible with the
+// requirements and not in an excluded zone
+func cheapestCost(it *cloudprovider.InstanceType, requirements scheduling.Requirements, excludedZones sets.String, cost CostFunc) (float64, bool) {
+	price := math.MaxFloat64
+	for _, offering := range it.Offerings.Available().Requirements(requirements) {
+		if excludedZones.Has(offering.Zone) {
+			continue
+		}
+		if offeringCost := cost(it, offering); offeringCost < price {
+			price = offeringCost
+		}
+	}
+	return price, price != math.MaxFloat64
+}
+
+// orderByCost sorts the instance types by the cost of their cheapest offering that's compatible with the requirements
+// and not in an excluded zone. The order of instance types that cost the same is maintained.
+func orderByCost(instanceTypes []*cloudprovider.InstanceType, requirements scheduling.Requirements, excludedZones sets.String, cost CostFunc) {
+	costs := map[*cloudprovider.InstanceType]float64{}
+	for _, it := range instanceTypes {
+		costs[it], _ = cheapestCost(it, requirements, excludedZones, cost)
+	}
+	sort.SliceStable(instanceTypes, func(a, b int) bool { return costs[instanceTypes[a]] < costs[instanceTypes[b]] })
+}
@@ -0,0 +1,66 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"math"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// preferDataZone narrows the node to the zone with the lowest cost, where the cost of a zone is that of the cheapest
+// available offering in it across the node's instance type options plus the penalty for each of the node's pods whose
+// data zone is another zone. Like balanceZone it's a preference, the node is only narrowed to a zone that it can launch
+// into, and a node without pods that name a data zone or that can only launch into a single zone is unchanged. Ties
+// are broken by the zone name.
+func preferDataZone(node *Node, penalty float64, cost CostFunc) {
+	dataZones := lo.FilterMap(node.Pods, func(pod *v1.Pod, _ int) (string, bool) {
+		zone, ok := pod.Annotations[v1alpha5.DataZonePodAnnotationKey]
+		return zone, ok && zone != ""
+	})
+	if len(dataZones) == 0 {
+		return
+	}
+	costs := map[string]float64{}
+	for _, it := range node.InstanceTypeOptions {
+		for _, offering := range it.Offerings.Available().Requirements(node.Requirements) {
+			if node.excludedZones.Has(offering.Zone) {
+				continue
+			}
+			if offeringCost, ok := costs[offering.Zone]; !ok || cost(it, offering) < offeringCost {
+				costs[offering.Zone] = cost(it, offering)
+			}
+		}
+	}
+	if len(costs) < 2 {
+		return
+	}
+	zone, lowest := "", math.MaxFloat64
+	for _, candidate := range lo.Keys(costs) {
+		total := costs[candidate] + penalty*float64(lo.CountBy(dataZones, func(dataZone string) bool { return dataZone != candidate }))
+		if total < lowest || (total == lowest && candidate < zone) {
+			zone, lowest = candidate, total
+		}
+	}
+	node.Requirements.Add(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, zone))
+	node.InstanceTypeOptions = lo.Filter(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) bool {
+		return hasOffering(it, node.Requirements, node.excludedZones)
+	})
+}
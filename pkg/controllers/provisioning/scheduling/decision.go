@@ -0,0 +1,77 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/karpenter-core/pkg/events"
+)
+
+// The reasons that pods which failed to schedule are counted by in the provisioning decision event, from the most to
+// the least specific
+const (
+	FailureReasonUnknownResources          = "UnknownResources"
+	FailureReasonUnsatisfiableRequirements = "UnsatisfiableRequirements"
+	FailureReasonMutualConflict            = "MutualConflict"
+	FailureReasonMaxNewNodes               = "MaxNewNodes"
+	FailureReasonMaxTopologyDomains        = "MaxTopologyDomains"
+	FailureReasonInsufficientCapacity      = "InsufficientCapacity"
+	FailureReasonIncompatible              = "Incompatible"
+)
+
+// failureReason returns the most specific reason that a pod failed to schedule. Pods that are too large for every
+// provisioner's instance types have insufficient capacity, any other failure is an incompatibility.
+func failureReason(failure schedulingFailure, unknownResources error, unsatisfiableRequirements error, mutualConflict error, err error) string {
+	switch {
+	case unknownResources != nil:
+		return FailureReasonUnknownResources
+	case unsatisfiableRequirements != nil:
+		return FailureReasonUnsatisfiableRequirements
+	case mutualConflict != nil:
+		return FailureReasonMutualConflict
+	case errors.Is(err, ErrMaxNewNodesExceeded):
+		return FailureReasonMaxNewNodes
+	case errors.Is(err, ErrMaxTopologyDomainsExceeded):
+		return FailureReasonMaxTopologyDomains
+	case len(failure.provisioners) > 0 && len(failure.shortfall) == len(failure.provisioners):
+		return FailureReasonInsufficientCapacity
+	default:
+		return FailureReasonIncompatible
+	}
+}
+
+// recordDecision publishes a single event on the DecisionEventObject that summarizes the outcome of the solve
+func (s *Scheduler) recordDecision(failureReasons map[string]int) {
+	if s.opts.Reporting.DecisionEventObject == nil {
+		return
+	}
+	scheduledToExistingNodes := 0
+	for _, node := range s.existingNodes {
+		scheduledToExistingNodes += len(node.Pods)
+	}
+	scheduledToNewNodes := 0
+	newNodes := map[string]int{}
+	for _, node := range s.newNodes {
+		scheduledToNewNodes += len(node.Pods)
+		key := node.ProvisionerName
+		if len(node.InstanceTypeOptions) > 0 {
+			key = fmt.Sprintf("%s/%s", node.ProvisionerName, node.InstanceTypeOptions[0].Name)
+		}
+		newNodes[key]++
+	}
+	s.recorder.Publish(events.ProvisioningDecision(s.opts.Reporting.DecisionEventObject, scheduledToExistingNodes, scheduledToNewNodes, newNodes, failureReasons))
+}
@@ -0,0 +1,61 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DeviceClaimResolver resolves the number of devices of each device class that a pod's resource claims request, e.g.
+// from the ResourceClaimTemplates that the pod references. The pod API that Karpenter is built against predates
+// dynamic resource allocation, so the claims are resolved by the caller rather than read from the pod's spec.
+type DeviceClaimResolver interface {
+	DeviceClaims(ctx context.Context, pod *v1.Pod) (map[string]int64, error)
+}
+
+// resolveDeviceRequests resolves the devices that each pod's resource claims request, as the resource that instance
+// types advertise the devices of their device class as, so that the pods are only packed onto instance types with
+// enough devices. The devices are counted with the pods' requests when they're packed rather than added to the pods,
+// as the same pods may be solved more than once, e.g. when simulating consolidation. Device classes without a resource
+// aren't countable and are left to the claim's driver, and the devices of pods whose claims can't be resolved aren't
+// counted.
+func (s *Scheduler) resolveDeviceRequests(ctx context.Context, pods []*v1.Pod) {
+	if s.opts.Packing.DeviceClaims == nil || len(s.opts.Packing.DeviceClassResources) == 0 {
+		return
+	}
+	for _, pod := range pods {
+		claims, err := s.opts.Packing.DeviceClaims.DeviceClaims(ctx, pod)
+		if err != nil {
+			logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).Errorf("resolving device claims, %s", err)
+			continue
+		}
+		devices := v1.ResourceList{}
+		for deviceClass, count := range claims {
+			if resourceName, ok := s.opts.Packing.DeviceClassResources[deviceClass]; ok && count > 0 {
+				quantity := devices[resourceName]
+				quantity.Add(*resource.NewQuantity(count, resource.DecimalSI))
+				devices[resourceName] = quantity
+			}
+		}
+		if len(devices) > 0 {
+			s.packing.devices[pod] = devices
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Predicate identifies the check that rejected a pod from a node
+type Predicate string
+
+const (
+	PredicateDedicated      Predicate = "Dedicated"
+	PredicateTaints         Predicate = "Taints"
+	PredicateHostPorts      Predicate = "HostPorts"
+	PredicateVolumes        Predicate = "Volumes"
+	PredicateResources      Predicate = "Resources"
+	PredicateNodeAffinity   Predicate = "NodeAffinity"
+	PredicateTopology       Predicate = "Topology"
+	PredicateInstanceTypes  Predicate = "InstanceTypes"
+	PredicateLimits         Predicate = "Limits"
+	PredicateDaemonOverhead Predicate = "DaemonOverhead"
+	PredicateNamespace      Predicate = "Namespace"
+	PredicatePlacement      Predicate = "Placement"
+)
+
+// predicateError tags an error with the predicate that produced it without changing its message
+type predicateError struct {
+	error
+	predicate Predicate
+}
+
+func (e *predicateError) Unwrap() error {
+	return e.error
+}
+
+func rejectedBy(predicate Predicate, err error) error {
+	return &predicateError{error: err, predicate: predicate}
+}
+
+// PredicateOf returns the predicate that produced the error, or an empty predicate if it's unknown
+func PredicateOf(err error) Predicate {
+	var predicateErr *predicateError
+	if errors.As(err, &predicateErr) {
+		return predicateErr.predicate
+	}
+	return ""
+}
+
+// Rejection is the reason a single candidate couldn't fit a pod
+type Rejection struct {
+	// Name is the node name for existing nodes, and the provisioner name for new nodes and provisioners
+	Name      string
+	Predicate Predicate
+	Err       error
+	// HadCapacity is true if an existing node had room for the pod's requests, so that it was rejected for another
+	// reason, e.g. topology skew
+	HadCapacity bool
+}
+
+func (r Rejection) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: ", r.Name)
+	if r.HadCapacity {
+		fmt.Fprint(&sb, "had capacity but ")
+	}
+	if r.Predicate != "" {
+		fmt.Fprintf(&sb, "%s, ", r.Predicate)
+	}
+	fmt.Fprint(&sb, r.Err)
+	return sb.String()
+}
+
+// Diagnosis explains where a single pod can be scheduled, or why it can't be scheduled anywhere
+type Diagnosis struct {
+	Pod *v1.Pod
+	// ExistingNodes are the existing nodes that rejected the pod
+	ExistingNodes []Rejection
+	// NewNodes are the nodes that the scheduler already planned to launch and that rejected the pod
+	NewNodes []Rejection
+	// Provisioners are the provisioners that a new node for the pod couldn't be launched from
+	Provisioners []Rejection
+	// Relaxations are the kinds of preferences that were relaxed before the last attempt to place the pod
+	Relaxations []string
+	// ScheduledTo is the existing node name, or the provisioner name of the new node, that the pod fits on. It's empty
+	// if the pod doesn't fit anywhere.
+	ScheduledTo string
+}
+
+func (d *Diagnosis) Schedulable() bool {
+	return d.ScheduledTo != ""
+}
+
+func (d *Diagnosis) String() string {
+	var sb strings.Builder
+	if d.Schedulable() {
+		fmt.Fprintf(&sb, "pod %s can schedule to %s", client.ObjectKeyFromObject(d.Pod), d.ScheduledTo)
+	} else {
+		fmt.Fprintf(&sb, "pod %s can't schedule", client.ObjectKeyFromObject(d.Pod))
+	}
+	if len(d.Relaxations) > 0 {
+		fmt.Fprintf(&sb, " after relaxing %s", strings.Join(d.Relaxations, ", "))
+	}
+	for _, group := range []struct {
+		kind       string
+		rejections []Rejection
+	}{{"existing node", d.ExistingNodes}, {"new node", d.NewNodes}, {"provisioner", d.Provisioners}} {
+		for _, r := range group.rejections {
+			fmt.Fprintf(&sb, "\n  %s %s", group.kind, r)
+		}
+	}
+	return sb.String()
+}
+
+// The recording methods are no-ops on a nil diagnosis so that scheduling only pays for a diagnosis when it's requested
+
+func (d *Diagnosis) schedule(name string) {
+	if d != nil {
+		d.ScheduledTo = name
+	}
+}
+
+func (d *Diagnosis) rejectExistingNode(node *ExistingNode, pod *v1.Pod, err error) {
+	if d != nil {
+		rejection := newRejection(node.Node.Name, err)
+		rejection.HadCapacity = node.hasCapacityFor(pod)
+		d.ExistingNodes = append(d.ExistingNodes, rejection)
+	}
+}
+
+func (d *Diagnosis) rejectNewNode(name string, err error) {
+	if d != nil {
+		d.NewNodes = append(d.NewNodes, newRejection(name, err))
+	}
+}
+
+func (d *Diagnosis) rejectProvisioner(name string, err error) {
+	if d != nil {
+		d.Provisioners = append(d.Provisioners, newRejection(name, err))
+	}
+}
+
+func newRejection(name string, err error) Rejection {
+	return Rejection{Name: name, Predicate: PredicateOf(err), Err: err}
+}
+
+// newDiagnosis returns a diagnosis for the next attempt to place the pod during Solve that carries over the
+// relaxations of its previous attempts, or nil if diagnoses aren't recorded
+func (s *Scheduler) newDiagnosis(pod *v1.Pod) *Diagnosis {
+	if !s.opts.Reporting.VerboseDiagnosis {
+		return nil
+	}
+	diagnosis := &Diagnosis{Pod: pod}
+	if previous, ok := s.diagnoses[pod]; ok {
+		diagnosis.Relaxations = previous.Relaxations
+	}
+	s.diagnoses[pod] = diagnosis
+	return diagnosis
+}
+
+// Diagnosis returns the diagnosis of the last attempt to place the pod during Solve, or nil if diagnoses aren't
+// recorded or the pod wasn't solved
+func (s *Scheduler) Diagnosis(pod *v1.Pod) *Diagnosis {
+	return s.diagnoses[pod]
+}
+
+// WhyUnschedulable attempts to place a single pod, relaxing its preferences in the same way that Solve does, and
+// reports the reason that each candidate rejected it. It's intended for scheduling a single pod against the current
+// cluster, so a placement is recorded by the scheduler as it would be by Solve; the pod itself isn't modified.
+func (s *Scheduler) WhyUnschedulable(ctx context.Context, pod *v1.Pod) (*Diagnosis, error) {
+	if len(s.machineTemplates) == 0 {
+		return nil, ErrNoProvisioners
+	}
+	// relaxation modifies the pod
+	pod = pod.DeepCopy()
+	var relaxations []string
+	for {
+		diagnosis := &Diagnosis{Pod: pod, Relaxations: relaxations}
+		if err := s.add(ctx, pod, diagnosis); err == nil {
+			return diagnosis, nil
+		}
+		kind, relaxed := s.preferences.Relax(ctx, pod)
+		if !relaxed {
+			return diagnosis, nil
+		}
+		relaxations = append(relaxations, kind)
+		if err := s.topology.Update(ctx, pod); err != nil {
+			return nil, fmt.Errorf("updating topology, %w", err)
+		}
+	}
+}
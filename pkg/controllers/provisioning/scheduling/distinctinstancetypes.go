@@ -0,0 +1,66 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+)
+
+// limitDistinctInstanceTypes narrows the instance type options of the nodes to a shared set of at most limit instance
+// types, so that the nodes of a batch launch as few distinct instance types. The set is chosen greedily, each instance
+// type in it is the one that's an option of the most nodes that aren't yet covered, ties broken by how highly the nodes
+// prefer it and then by name. Each covered node keeps those of its options that are in the set, in their order. The
+// options already satisfy the pods' requirements, so narrowing them can't violate them, but a node that can't be
+// covered by the set keeps all of its options, in which case more than limit instance types may be launched.
+func limitDistinctInstanceTypes(nodes []*Node, limit int) {
+	nodes = lo.Filter(nodes, func(n *Node, _ int) bool { return len(n.InstanceTypeOptions) > 0 })
+	chosen := sets.NewString()
+	uncovered := nodes
+	for chosen.Len() < limit && len(uncovered) > 0 {
+		coverage := map[string]int{} // instance type -> number of uncovered nodes that it's an option of
+		rank := map[string]int{}     // instance type -> sum of its positions in the options of those nodes
+		for _, n := range uncovered {
+			for i, it := range n.InstanceTypeOptions {
+				coverage[it.Name]++
+				rank[it.Name] += i
+			}
+		}
+		best := lo.MinBy(lo.Keys(coverage), func(a, b string) bool {
+			if coverage[a] != coverage[b] {
+				return coverage[a] > coverage[b]
+			}
+			if rank[a] != rank[b] {
+				return rank[a] < rank[b]
+			}
+			return a < b
+		})
+		chosen.Insert(best)
+		uncovered = lo.Reject(uncovered, func(n *Node, _ int) bool { return hasInstanceType(n, best) })
+	}
+	for _, n := range nodes {
+		if narrowed := lo.Filter(n.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) bool {
+			return chosen.Has(it.Name)
+		}); len(narrowed) > 0 {
+			n.InstanceTypeOptions = narrowed
+		}
+	}
+}
+
+func hasInstanceType(n *Node, name string) bool {
+	return lo.ContainsBy(n.InstanceTypeOptions, func(it *cloudprovider.InstanceType) bool { return it.Name == name })
+}
@@ -0,0 +1,36 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+)
+
+// diversifyInstanceTypes rotates the instance type options of each node by the number of nodes from the same
+// provisioner that precede it, so that consecutive nodes list a different instance type first. The set of options of
+// each node is unchanged, only the order that they're preferred in.
+func diversifyInstanceTypes(nodes []*Node) {
+	rotations := map[string]int{}
+	for _, n := range nodes {
+		options := n.InstanceTypeOptions
+		if len(options) == 0 {
+			continue
+		}
+		offset := rotations[n.ProvisionerName] % len(options)
+		rotations[n.ProvisionerName]++
+		// The instance type options may be shared with the machine template, so they're copied before being reordered
+		n.InstanceTypeOptions = append(append([]*cloudprovider.InstanceType{}, options[offset:]...), options[:offset]...)
+	}
+}
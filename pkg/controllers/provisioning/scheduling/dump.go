@@ -0,0 +1,126 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"sort"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// SchedulerState is a snapshot of the internal state of a scheduler for debugging scheduling anomalies, e.g. to be
+// logged or served on demand. It shares no memory with the scheduler, so it can be kept and marshaled to JSON after
+// the scheduler has moved on.
+type SchedulerState struct {
+	NewNodes      []NewNodeState      `json:"newNodes,omitempty"`
+	ExistingNodes []ExistingNodeState `json:"existingNodes,omitempty"`
+	// RemainingResources are the resources that each provisioner with limits can still launch, keyed by provisioner
+	RemainingResources map[string]map[string]string `json:"remainingResources,omitempty"`
+	Topologies         []TopologyState              `json:"topologies,omitempty"`
+}
+
+// NewNodeState is a new node, the pods scheduled to it and the instance types that it can launch as, in order of
+// preference once the solve has finished
+type NewNodeState struct {
+	Provisioner   string                       `json:"provisioner"`
+	InstanceTypes []string                     `json:"instanceTypes"`
+	Pods          []string                     `json:"pods"`
+	Requests      map[string]string            `json:"requests,omitempty"`
+	Requirements  []v1.NodeSelectorRequirement `json:"requirements,omitempty"`
+}
+
+// ExistingNodeState is an existing node and the pods scheduled to it by the solve. Requests are those of the pods and
+// of the daemonsets that have yet to run on the node, Available is what remained after the pods already bound to it.
+type ExistingNodeState struct {
+	Node        string            `json:"node"`
+	Provisioner string            `json:"provisioner,omitempty"`
+	Pods        []string          `json:"pods,omitempty"`
+	Requests    map[string]string `json:"requests,omitempty"`
+	Available   map[string]string `json:"available,omitempty"`
+}
+
+// TopologyState is a topology spread, pod affinity or anti-affinity tracked by the scheduler and the number of pods
+// that it counts in each of its domains. Inverse topologies track the anti-affinities of pods that are already bound,
+// so that the pods they select aren't scheduled next to them.
+type TopologyState struct {
+	Key      string           `json:"key"`
+	Type     string           `json:"type"`
+	Selector string           `json:"selector,omitempty"`
+	Inverse  bool             `json:"inverse,omitempty"`
+	Domains  map[string]int32 `json:"domains,omitempty"`
+	Exceeded bool             `json:"exceeded,omitempty"`
+}
+
+// Dump returns a snapshot of the scheduler's new nodes, existing nodes, remaining resources and topology domains. It
+// can be called after a solve, or during one from a callback such as OnPlacement, but like the rest of the scheduler
+// it isn't safe to call concurrently with a solve on another goroutine.
+func (s *Scheduler) Dump() *SchedulerState {
+	state := &SchedulerState{RemainingResources: map[string]map[string]string{}}
+	for _, n := range s.newNodes {
+		requirements := n.Requirements.NodeSelectorRequirements()
+		sort.Slice(requirements, func(i, j int) bool { return requirements[i].Key < requirements[j].Key })
+		state.NewNodes = append(state.NewNodes, NewNodeState{
+			Provisioner:   n.ProvisionerName,
+			InstanceTypes: lo.Map(n.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name }),
+			Pods:          podNames(n.Pods),
+			Requests:      resources.StringMap(n.Requests),
+			Requirements:  requirements,
+		})
+	}
+	for _, n := range s.existingNodes {
+		state.ExistingNodes = append(state.ExistingNodes, ExistingNodeState{
+			Node:        n.Node.Name,
+			Provisioner: n.Node.Labels[v1alpha5.ProvisionerNameLabelKey],
+			Pods:        podNames(n.Pods),
+			Requests:    resources.StringMap(n.requests),
+			Available:   resources.StringMap(n.available),
+		})
+	}
+	for provisionerName, remaining := range s.remainingResources {
+		state.RemainingResources[provisionerName] = resources.StringMap(remaining)
+	}
+	for inverse, topologies := range map[bool]map[uint64]*TopologyGroup{false: s.topology.topologies, true: s.topology.inverseTopologies} {
+		for _, tg := range topologies {
+			state.Topologies = append(state.Topologies, TopologyState{
+				Key:      tg.Key,
+				Type:     tg.Type.String(),
+				Selector: metav1.FormatLabelSelector(tg.selector),
+				Inverse:  inverse,
+				Domains:  lo.Assign(tg.domains),
+				Exceeded: tg.exceeded,
+			})
+		}
+	}
+	sort.Slice(state.Topologies, func(i, j int) bool {
+		a, b := state.Topologies[i], state.Topologies[j]
+		if a.Inverse != b.Inverse {
+			return !a.Inverse
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Selector < b.Selector
+	})
+	return state
+}
@@ -27,21 +27,43 @@ import (
 	"github.com/aws/karpenter-core/pkg/utils/resources"
 )
 
+// pressureTaints are the taints that the node lifecycle controller applies to nodes with an active pressure condition
+var pressureTaints = map[v1.NodeConditionType]v1.Taint{
+	v1.NodeMemoryPressure: {Key: v1.TaintNodeMemoryPressure, Effect: v1.TaintEffectNoSchedule},
+	v1.NodeDiskPressure:   {Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule},
+	v1.NodePIDPressure:    {Key: v1.TaintNodePIDPressure, Effect: v1.TaintEffectNoSchedule},
+}
+
+// ephemeralTaints are applied to nodes while they start up or when they're briefly unavailable, so they're ignored
+var ephemeralTaints = []v1.Taint{
+	{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoSchedule},
+	{Key: v1.TaintNodeUnreachable, Effect: v1.TaintEffectNoSchedule},
+}
+
+// unschedulableTaint is the taint that the node lifecycle controller applies to cordoned nodes
+var unschedulableTaint = v1.Taint{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}
+
 type ExistingNode struct {
 	Pods          []*v1.Pod
 	Node          *v1.Node
 	requests      v1.ResourceList
 	topology      *Topology
 	requirements  scheduling.Requirements
+	allocatable   v1.ResourceList
 	available     v1.ResourceList
 	taints        []v1.Taint
 	hostPortUsage *scheduling.HostPortUsage
 	volumeUsage   *scheduling.VolumeLimits
 	volumeLimits  scheduling.VolumeCount
+	packing       *packing
+	// namespaceSelectors are added to the requirements of the pods in each namespace
+	namespaceSelectors namespaceNodeSelectors
 }
 
-func NewExistingNode(n *state.Node, topology *Topology, startupTaints []v1.Taint, daemonResources v1.ResourceList) *ExistingNode {
-	// The state node passed in here must be a deep copy from cluster state as we modify it
+func NewExistingNode(n *state.Node, topology *Topology, startupTaints []v1.Taint, daemonResources v1.ResourceList, packing *packing,
+	namespaceSelectors namespaceNodeSelectors, cordonLabels []string) *ExistingNode {
+	// The state node passed in here may be shared with a cluster state snapshot, so the usage that's modified as pods
+	// are added is copied rather than modified in place
 	// the remaining daemonResources to schedule are the total daemonResources minus what has already scheduled
 	remainingDaemonResources := resources.Subtract(daemonResources, n.DaemonSetRequested)
 	// If unexpected daemonset pods schedule to the node due to labels appearing on the node which cause the
@@ -54,35 +76,55 @@ func NewExistingNode(n *state.Node, topology *Topology, startupTaints []v1.Taint
 		}
 	}
 	node := &ExistingNode{
-		Node:          n.Node,
-		available:     n.Available,
-		topology:      topology,
-		requests:      remainingDaemonResources,
-		requirements:  scheduling.NewLabelRequirements(n.Node.Labels),
-		hostPortUsage: n.HostPortUsage,
-		volumeUsage:   n.VolumeUsage,
-		volumeLimits:  n.VolumeLimits,
+		Node:               n.Node,
+		allocatable:        n.Allocatable,
+		available:          n.Available,
+		topology:           topology,
+		requests:           remainingDaemonResources,
+		requirements:       scheduling.NewLabelRequirements(n.Node.Labels),
+		hostPortUsage:      n.HostPortUsage.DeepCopy(),
+		volumeUsage:        n.VolumeUsage.DeepCopy(),
+		volumeLimits:       n.VolumeLimits,
+		packing:            packing,
+		namespaceSelectors: namespaceSelectors,
 	}
 
-	ephemeralTaints := []v1.Taint{
-		{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoSchedule},
-		{Key: v1.TaintNodeUnreachable, Effect: v1.TaintEffectNoSchedule},
-	}
+	ignoredTaints := append([]v1.Taint{}, ephemeralTaints...)
 	// Only consider startup taints until the node is initialized. Without this, if the startup taint is generic and
 	// re-appears on the node for a different reason (e.g. the node is cordoned) we will assume that pods can
 	// schedule against the node in the future incorrectly.
 	if n.Node.Labels[v1alpha5.LabelNodeInitialized] != "true" {
-		ephemeralTaints = append(ephemeralTaints, startupTaints...)
+		ignoredTaints = append(ignoredTaints, startupTaints...)
 	}
 
 	// Filter out ignored taints
 	node.taints = lo.Reject(n.Node.Spec.Taints, func(taint v1.Taint, _ int) bool {
-		_, rejected := lo.Find(ephemeralTaints, func(t v1.Taint) bool {
+		_, rejected := lo.Find(ignoredTaints, func(t v1.Taint) bool {
 			return t.Key == taint.Key && t.Value == taint.Value && t.Effect == taint.Effect
 		})
 		return rejected
 	})
 
+	// The node lifecycle controller only taints a node under pressure after the kubelet reports the condition, so we
+	// add the taints for any active pressure conditions ourselves to avoid scheduling to the node in the meantime
+	for _, condition := range n.Node.Status.Conditions {
+		taint, ok := pressureTaints[condition.Type]
+		if !ok || condition.Status != v1.ConditionTrue {
+			continue
+		}
+		if !lo.ContainsBy(node.taints, func(t v1.Taint) bool { return t.MatchTaint(&taint) }) {
+			node.taints = append(node.taints, taint)
+		}
+	}
+
+	// Similarly, a cordoned node is only tainted once the node lifecycle controller observes the cordon. Nodes with any
+	// of the cordon labels are treated as if they were cordoned.
+	if n.Node.Spec.Unschedulable || lo.ContainsBy(cordonLabels, func(key string) bool { _, ok := n.Node.Labels[key]; return ok }) {
+		if !lo.ContainsBy(node.taints, func(t v1.Taint) bool { return t.MatchTaint(&unschedulableTaint) }) {
+			node.taints = append(node.taints, unschedulableTaint)
+		}
+	}
+
 	// If the in-flight node doesn't have a hostname yet, we treat it's unique name as the hostname.  This allows toppology
 	// with hostname keys to schedule correctly.
 	hostname := n.Node.Labels[v1.LabelHostname]
@@ -94,57 +136,91 @@ func NewExistingNode(n *state.Node, topology *Topology, startupTaints []v1.Taint
 	return node
 }
 
+// Add schedules the pod to the node if it passes the same checks as CanAdd
 func (n *ExistingNode) Add(ctx context.Context, pod *v1.Pod) error {
+	requests, nodeRequirements, err := n.canAdd(ctx, pod)
+	if err != nil {
+		return err
+	}
+
+	// Update node
+	n.Pods = append(n.Pods, pod)
+	n.requests = requests
+	n.requirements = nodeRequirements
+	n.topology.Record(pod, nodeRequirements)
+	n.hostPortUsage.Add(ctx, pod)
+	n.volumeUsage.Add(ctx, pod)
+	return nil
+}
+
+// CanAdd returns an error if the pod can't be scheduled to the node, without modifying the node so that it can be
+// called repeatedly, e.g. to test whether pods would fit on a node during consolidation
+func (n *ExistingNode) CanAdd(ctx context.Context, pod *v1.Pod) error {
+	_, _, err := n.canAdd(ctx, pod)
+	return err
+}
+
+// canAdd checks the pod against the node, returning the node's requests and requirements if the pod is added
+func (n *ExistingNode) canAdd(ctx context.Context, pod *v1.Pod) (v1.ResourceList, scheduling.Requirements, error) {
 	// Check Taints
 	if err := scheduling.Taints(n.taints).Tolerates(pod); err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateTaints, err)
 	}
 
 	if err := n.hostPortUsage.Validate(pod); err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateHostPorts, err)
 	}
 
 	// determine the number of volumes that will be mounted if the pod schedules
 	mountedVolumeCount, err := n.volumeUsage.Validate(ctx, pod)
 	if err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateVolumes, err)
 	}
 	if mountedVolumeCount.Exceeds(n.volumeLimits) {
-		return fmt.Errorf("would exceed node volume limits")
+		return nil, nil, rejectedBy(PredicateVolumes, fmt.Errorf("would exceed node volume limits"))
 	}
 
 	// check resource requests first since that's a pretty likely reason the pod won't schedule on an in-flight
 	// node, which at this point can't be increased in size
-	requests := resources.Merge(n.requests, resources.RequestsForPods(pod))
+	requests := resources.Merge(n.requests, n.packing.requests(pod))
 
 	if !resources.Fits(requests, n.available) {
-		return fmt.Errorf("exceeds node resources")
+		return nil, nil, rejectedBy(PredicateResources, fmt.Errorf("exceeds node resources"))
 	}
 
 	nodeRequirements := scheduling.NewRequirements(n.requirements.Values()...)
-	podRequirements := scheduling.NewPodRequirements(pod)
+	podRequirements := n.namespaceSelectors.podRequirements(pod)
 	// Check Node Affinity Requirements
 	if err := nodeRequirements.Compatible(podRequirements); err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateNodeAffinity, err)
 	}
 	nodeRequirements.Add(podRequirements.Values()...)
 
 	// Check Topology Requirements
 	topologyRequirements, err := n.topology.AddRequirements(podRequirements, nodeRequirements, pod)
 	if err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateTopology, err)
 	}
 	if err = nodeRequirements.Compatible(topologyRequirements); err != nil {
-		return err
+		return nil, nil, rejectedBy(PredicateTopology, err)
 	}
 	nodeRequirements.Add(topologyRequirements.Values()...)
+	return requests, nodeRequirements, nil
+}
 
-	// Update node
-	n.Pods = append(n.Pods, pod)
-	n.requests = requests
-	n.requirements = nodeRequirements
-	n.topology.Record(pod, nodeRequirements)
-	n.hostPortUsage.Add(ctx, pod)
-	n.volumeUsage.Add(ctx, pod)
-	return nil
+// hasCapacityFor returns true if the node has room for the pod's requests, regardless of whether the pod is otherwise
+// compatible with the node
+func (n *ExistingNode) hasCapacityFor(pod *v1.Pod) bool {
+	return resources.Fits(resources.Merge(n.requests, n.packing.requests(pod)), n.available)
+}
+
+// Allocatable returns the resources on the node that are available to pods
+func (n *ExistingNode) Allocatable() v1.ResourceList {
+	return n.allocatable
+}
+
+// Remaining returns the resources on the node that aren't requested by its pods, including those scheduled to it during
+// this solve, or reserved for the daemonsets that haven't scheduled to it yet
+func (n *ExistingNode) Remaining() v1.ResourceList {
+	return resources.Subtract(n.available, n.requests)
 }
@@ -0,0 +1,114 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+)
+
+// instanceTypeGenerationRegex matches the generation that follows the leading letters of instance type names such as
+// m5.large or c6g.xlarge
+var instanceTypeGenerationRegex = regexp.MustCompile(`^[a-z]+(\d+)`)
+
+// InstanceTypePreferences order the instance type options of new nodes so that preferred instance types are listed
+// first. The preferences are soft, instance types are only reordered and never excluded.
+type InstanceTypePreferences struct {
+	// Families are instance type families in order of preference, e.g. "c6g", "m6i". Instance types of families that
+	// aren't listed are ordered after those that are.
+	Families []string
+	// FamilyLabelKey is the requirement key that an instance type's family is read from. If it's unset or the instance
+	// type doesn't have a single value for it, the family is the instance type name up to the first ".".
+	FamilyLabelKey string
+	// PreferNewerGenerations orders instance types of newer generations first
+	PreferNewerGenerations bool
+	// GenerationLabelKey is the requirement key that an instance type's generation is read from. If it's unset or the
+	// instance type doesn't have a single value for it, the generation is the number that follows the leading letters
+	// of the instance type name, e.g. 5 for m5.large.
+	GenerationLabelKey string
+}
+
+// IsEmpty returns true if there are no preferences to order the instance types by
+func (p InstanceTypePreferences) IsEmpty() bool {
+	return len(p.Families) == 0 && !p.PreferNewerGenerations
+}
+
+// Order sorts the instance types by the preferences, the order of instance types that are equally preferred is
+// maintained
+func (p InstanceTypePreferences) Order(instanceTypes []*cloudprovider.InstanceType) {
+	if p.IsEmpty() {
+		return
+	}
+	familyRanks := map[string]int{}
+	for i, family := range p.Families {
+		if _, ok := familyRanks[family]; !ok {
+			familyRanks[family] = i
+		}
+	}
+	familyRank := func(it *cloudprovider.InstanceType) int {
+		if rank, ok := familyRanks[p.family(it)]; ok {
+			return rank
+		}
+		return len(p.Families)
+	}
+	sort.SliceStable(instanceTypes, func(a, b int) bool {
+		if rankA, rankB := familyRank(instanceTypes[a]), familyRank(instanceTypes[b]); rankA != rankB {
+			return rankA < rankB
+		}
+		if p.PreferNewerGenerations {
+			return p.generation(instanceTypes[a]) > p.generation(instanceTypes[b])
+		}
+		return false
+	})
+}
+
+func (p InstanceTypePreferences) family(it *cloudprovider.InstanceType) string {
+	if value, ok := singleValue(it, p.FamilyLabelKey); ok {
+		return value
+	}
+	family, _, _ := strings.Cut(it.Name, ".")
+	return family
+}
+
+// generation returns the generation of the instance type, or zero if it's unknown so that instance types of unknown
+// generations are the least preferred
+func (p InstanceTypePreferences) generation(it *cloudprovider.InstanceType) int {
+	if value, ok := singleValue(it, p.GenerationLabelKey); ok {
+		if generation, err := strconv.Atoi(value); err == nil {
+			return generation
+		}
+	}
+	if match := instanceTypeGenerationRegex.FindStringSubmatch(strings.ToLower(it.Name)); match != nil {
+		if generation, err := strconv.Atoi(match[1]); err == nil {
+			return generation
+		}
+	}
+	return 0
+}
+
+func singleValue(it *cloudprovider.InstanceType, key string) (string, bool) {
+	if key == "" || !it.Requirements.Has(key) {
+		return "", false
+	}
+	requirement := it.Requirements.Get(key)
+	if requirement.Len() != 1 {
+		return "", false
+	}
+	return requirement.Any(), true
+}
@@ -0,0 +1,100 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceTypeStability remembers the instance type that was preferred for the pods of each workload when they were
+// last scheduled to new nodes, so that the scheduler can count how often the preference changes between solves.
+// Frequent changes for the same workload indicate flapping offerings or price data. It outlives any single scheduler,
+// and is safe for concurrent use.
+type InstanceTypeStability struct {
+	mu        sync.Mutex
+	preferred map[string]string // owner -> instance type preferred for its pods by the last solve that scheduled them
+}
+
+// NewInstanceTypeStability constructs an instance type stability tracker that hasn't observed any solves
+func NewInstanceTypeStability() *InstanceTypeStability {
+	return &InstanceTypeStability{preferred: map[string]string{}}
+}
+
+// Observe records the instance type that was preferred for the owner's pods and returns true if it differs from the
+// instance type that was preferred for them the last time they were observed
+func (s *InstanceTypeStability) Observe(owner string, instanceType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.preferred[owner]
+	s.preferred[owner] = instanceType
+	return ok && previous != instanceType
+}
+
+// recordInstanceTypeStability observes the instance type preferred for the pods of each workload on the new nodes,
+// counting the workloads whose preferred instance type changed since the last solve by the provisioner of their node.
+// If a workload's pods are split across new nodes that prefer different instance types, the one preferred for most of
+// its pods is observed, ties broken by name. Pods without a controller aren't observed, as there's nothing to tell
+// them apart from the pods of the next solve.
+func (s *Scheduler) recordInstanceTypeStability() {
+	if s.opts.Reporting.InstanceTypeStability == nil {
+		return
+	}
+	type preference struct{ provisioner, instanceType string }
+	counts := map[string]map[preference]int{} // owner -> preferred instance type -> number of pods
+	for _, node := range s.newNodes {
+		if len(node.InstanceTypeOptions) == 0 {
+			continue
+		}
+		for _, pod := range node.Pods {
+			owner := controllerKey(pod)
+			if owner == "" {
+				continue
+			}
+			if counts[owner] == nil {
+				counts[owner] = map[preference]int{}
+			}
+			counts[owner][preference{node.ProvisionerName, node.InstanceTypeOptions[0].Name}]++
+		}
+	}
+	for owner, preferences := range counts {
+		var candidates []preference
+		for p := range preferences {
+			candidates = append(candidates, p)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if preferences[candidates[i]] != preferences[candidates[j]] {
+				return preferences[candidates[i]] > preferences[candidates[j]]
+			}
+			return candidates[i].instanceType < candidates[j].instanceType
+		})
+		if s.opts.Reporting.InstanceTypeStability.Observe(owner, candidates[0].instanceType) {
+			preferredInstanceTypeChangesCounter.WithLabelValues(candidates[0].provisioner).Inc()
+		}
+	}
+}
+
+// controllerKey identifies the workload that controls the pod, or is empty if the pod doesn't have a controller
+func controllerKey(pod *v1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s", pod.Namespace, owner.Kind, owner.Name)
+}
@@ -0,0 +1,72 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+)
+
+// LaunchFailures records the instance types that recently failed to launch, e.g. for insufficient capacity, so that
+// the schedulers that it's passed to don't launch new nodes as them until the failure expires. It outlives any single
+// scheduler, as launch failures are reported after the solve that chose the instance type. It's safe for concurrent use.
+type LaunchFailures struct {
+	clock clock.Clock
+
+	mu       sync.Mutex
+	expiries map[string]time.Time // instance type name -> when its launch failure expires
+}
+
+// NewLaunchFailures constructs an empty set of launch failures that expire according to the clock
+func NewLaunchFailures(clk clock.Clock) *LaunchFailures {
+	return &LaunchFailures{
+		clock:    clk,
+		expiries: map[string]time.Time{},
+	}
+}
+
+// Record avoids the instance type until the expiry. A failure that's recorded again for an instance type that's already
+// avoided extends the expiry, but never shortens it.
+func (l *LaunchFailures) Record(instanceType string, expiry time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if existing, ok := l.expiries[instanceType]; ok && existing.After(expiry) {
+		return
+	}
+	l.expiries[instanceType] = expiry
+}
+
+// InstanceTypes returns the names of the instance types whose launch failures haven't expired, forgetting those that
+// have. It's empty for nil launch failures.
+func (l *LaunchFailures) InstanceTypes() sets.String {
+	if l == nil {
+		return sets.NewString()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	instanceTypes := sets.NewString()
+	for instanceType, expiry := range l.expiries {
+		if !now.Before(expiry) {
+			delete(l.expiries, instanceType)
+			continue
+		}
+		instanceTypes.Insert(instanceType)
+	}
+	return instanceTypes
+}
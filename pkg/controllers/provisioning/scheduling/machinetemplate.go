@@ -44,6 +44,15 @@ type MachineTemplate struct {
 	Requirements        scheduling.Requirements
 	Requests            v1.ResourceList
 	Kubelet             *v1alpha5.KubeletConfiguration
+	// InjectTolerations assumes that pods will be mutated to tolerate the taints, so pods are scheduled as if they
+	// tolerate them
+	InjectTolerations bool
+	// SchedulingProfile is the name of the scheduling profile that the provisioner selects, if any
+	SchedulingProfile string
+	// MaxInstanceResources caps the capacity of the instance types, unless no smaller instance type fits
+	MaxInstanceResources v1.ResourceList
+	// MaxNodeRequests caps the total requests of the pods scheduled to a node, unless a pod exceeds it on its own
+	MaxNodeRequests v1.ResourceList
 }
 
 func NewMachineTemplate(provisioner *v1alpha5.Provisioner) *MachineTemplate {
@@ -51,16 +60,25 @@ func NewMachineTemplate(provisioner *v1alpha5.Provisioner) *MachineTemplate {
 	requirements := scheduling.NewRequirements()
 	requirements.Add(scheduling.NewNodeSelectorRequirements(provisioner.Spec.Requirements...).Values()...)
 	requirements.Add(scheduling.NewLabelRequirements(labels).Values()...)
+	var maxInstanceResources, maxNodeRequests v1.ResourceList
+	if provisioner.Spec.Limits != nil {
+		maxInstanceResources = provisioner.Spec.Limits.MaxInstanceResources
+		maxNodeRequests = provisioner.Spec.Limits.MaxNodeRequests
+	}
 	return &MachineTemplate{
-		ProvisionerName: provisioner.Name,
-		Provider:        provisioner.Spec.Provider,
-		ProviderRef:     provisioner.Spec.ProviderRef,
-		Kubelet:         provisioner.Spec.KubeletConfiguration,
-		Annotations:     provisioner.Spec.Annotations,
-		Labels:          labels,
-		Taints:          provisioner.Spec.Taints,
-		StartupTaints:   provisioner.Spec.StartupTaints,
-		Requirements:    requirements,
+		ProvisionerName:      provisioner.Name,
+		Provider:             provisioner.Spec.Provider,
+		ProviderRef:          provisioner.Spec.ProviderRef,
+		Kubelet:              provisioner.Spec.KubeletConfiguration,
+		Annotations:          provisioner.Spec.Annotations,
+		Labels:               labels,
+		Taints:               provisioner.Spec.Taints,
+		StartupTaints:        provisioner.Spec.StartupTaints,
+		Requirements:         requirements,
+		InjectTolerations:    provisioner.Annotations[v1alpha5.InjectTolerationsProvisionerAnnotationKey] == "true",
+		SchedulingProfile:    provisioner.Labels[v1alpha5.SchedulingProfileLabelKey],
+		MaxInstanceResources: maxInstanceResources,
+		MaxNodeRequests:      maxNodeRequests,
 	}
 }
 
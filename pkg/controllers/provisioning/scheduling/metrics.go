@@ -0,0 +1,114 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/aws/karpenter-core/pkg/metrics"
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(relaxationsCounter, unavailableInstanceTypesGauge, newNodesGauge, newNodePodsGauge, newNodeRequestsGauge,
+		instanceTypeOptionsHistogram, preferredInstanceTypeChangesCounter, limitUsagePctGauge)
+}
+
+const (
+	schedulingSubsystem = "scheduling"
+	relaxationTypeLabel = "type"
+	resourceTypeLabel   = "resource_type"
+)
+
+var relaxationsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "relaxations_total",
+		Help:      "Number of pod preferences that were relaxed so that the pod could schedule. Labeled by the type of preference.",
+	},
+	[]string{relaxationTypeLabel},
+)
+
+var unavailableInstanceTypesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "unavailable_instance_types",
+		Help:      "Number of instance types that have no available offerings and can't be launched. Labeled by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var newNodesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "new_nodes",
+		Help:      "Number of new nodes that the last scheduling decision launches. Labeled by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var newNodePodsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "new_node_pods",
+		Help:      "Number of pods scheduled to the new nodes of the last scheduling decision. Labeled by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var newNodeRequestsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "new_node_requests",
+		Help:      "Resources requested on the new nodes of the last scheduling decision, including daemonset overhead. Labeled by provisioner and resource type.",
+	},
+	[]string{metrics.ProvisionerLabel, resourceTypeLabel},
+)
+
+var instanceTypeOptionsHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "new_node_instance_type_options",
+		Help:      "Number of instance type options of each new node that scheduling decisions launch. Nodes with few options are more likely to fail to launch when capacity is constrained. Labeled by provisioner.",
+		Buckets:   []float64{1, 2, 3, 5, 10, 20, 50, 100},
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var preferredInstanceTypeChangesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "preferred_instance_type_changes_total",
+		Help:      "Number of times that the instance type preferred for a workload's pods changed from the previous scheduling decision that launched new nodes for them. Frequent changes indicate flapping offerings or prices. Labeled by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+var limitUsagePctGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: schedulingSubsystem,
+		Name:      "limit_usage_pct",
+		Help:      "Percentage of each provisioner limit that's used after the last scheduling decision, by the existing nodes and the new nodes at the capacity of their largest instance type option. It exceeds 100 if the limit was already exceeded. Labeled by provisioner and resource type.",
+	},
+	[]string{metrics.ProvisionerLabel, resourceTypeLabel},
+)
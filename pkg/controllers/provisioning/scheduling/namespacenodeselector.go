@@ -0,0 +1,98 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// NamespaceNodeSelectorAnnotationKey is the annotation that the PodNodeSelector admission plugin reads the default
+// node selector of a namespace from
+const NamespaceNodeSelectorAnnotationKey = "scheduler.alpha.kubernetes.io/node-selector"
+
+// NamespaceNodeSelectorLister lists the default node selector of a namespace, which is applied to the pods in the
+// namespace in addition to their own node selector
+type NamespaceNodeSelectorLister interface {
+	NodeSelector(ctx context.Context, namespace string) (map[string]string, error)
+}
+
+// NamespaceAnnotationNodeSelectorLister lists the default node selectors of namespaces like the PodNodeSelector
+// admission plugin, from the namespace's node selector annotation or the cluster default if it isn't annotated
+type NamespaceAnnotationNodeSelectorLister struct {
+	KubeClient client.Client
+	// ClusterDefault is the node selector of the namespaces without the annotation
+	ClusterDefault map[string]string
+}
+
+func (l NamespaceAnnotationNodeSelectorLister) NodeSelector(ctx context.Context, namespace string) (map[string]string, error) {
+	ns := &v1.Namespace{}
+	if err := l.KubeClient.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return nil, fmt.Errorf("getting namespace, %w", err)
+	}
+	annotation, ok := ns.Annotations[NamespaceNodeSelectorAnnotationKey]
+	if !ok {
+		return l.ClusterDefault, nil
+	}
+	selector, err := labels.ConvertSelectorToLabelsMap(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("parsing node selector annotation %q, %w", annotation, err)
+	}
+	return selector, nil
+}
+
+// namespaceNodeSelectors are the default node selectors of the namespaces of the pods being solved, keyed by namespace.
+// They're merged into the requirements of each pod rather than into its node selector so that solving a pod doesn't
+// modify it. A nil namespaceNodeSelectors adds nothing to the pods' requirements.
+type namespaceNodeSelectors map[string]map[string]string
+
+// podRequirements returns the requirements of the pod, constrained by the default node selector of its namespace like
+// the PodNodeSelector admission plugin would constrain them. The pod's own node selector takes precedence over the
+// namespace's for the same key.
+func (n namespaceNodeSelectors) podRequirements(pod *v1.Pod) scheduling.Requirements {
+	requirements := scheduling.NewPodRequirements(pod)
+	for key, value := range n[pod.Namespace] {
+		if _, ok := pod.Spec.NodeSelector[key]; !ok {
+			requirements.Add(scheduling.NewRequirement(key, v1.NodeSelectorOpIn, value))
+		}
+	}
+	return requirements
+}
+
+// listNamespaceNodeSelectors lists the default node selector of each pod's namespace. The node selectors of the
+// namespaces that can't be listed aren't applied.
+func (s *Scheduler) listNamespaceNodeSelectors(ctx context.Context, pods []*v1.Pod) {
+	if s.opts.Pods.NamespaceNodeSelectors == nil {
+		return
+	}
+	for _, pod := range pods {
+		if _, ok := s.namespaceSelectors[pod.Namespace]; ok {
+			continue
+		}
+		selector, err := s.opts.Pods.NamespaceNodeSelectors.NodeSelector(ctx, pod.Namespace)
+		if err != nil {
+			logging.FromContext(ctx).With("namespace", pod.Namespace).Errorf("listing namespace node selector, %s", err)
+		}
+		s.namespaceSelectors[pod.Namespace] = selector
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// NamespaceProvisioners restricts the pods of the namespaces that it selects to provisioning from the named
+// provisioners, e.g. so that each tenant of a cluster only launches capacity from its own provisioners
+type NamespaceProvisioners struct {
+	// Namespaces are the names of the namespaces that are selected
+	Namespaces []string
+	// Selector if set also selects the namespaces whose labels match it. An empty selector doesn't select anything.
+	Selector labels.Selector
+	// Provisioners are the names of the provisioners that the pods of the selected namespaces can provision from
+	Provisioners []string
+}
+
+// Matches returns true if the namespace is selected
+func (n NamespaceProvisioners) Matches(namespace *v1.Namespace) bool {
+	return lo.Contains(n.Namespaces, namespace.Name) ||
+		(n.Selector != nil && !n.Selector.Empty() && n.Selector.Matches(labels.Set(namespace.Labels)))
+}
+
+// allowedProvisioners returns the names of the provisioners that the pod can provision from, the union of those of
+// every NamespaceProvisioners that selects its namespace, or nil if its namespace isn't selected by any and so isn't
+// restricted
+func (s *Scheduler) allowedProvisioners(ctx context.Context, pod *v1.Pod) (sets.String, error) {
+	if len(s.opts.Pods.NamespaceProvisioners) == 0 {
+		return nil, nil
+	}
+	if allowed, ok := s.namespaceProvisioners[pod.Namespace]; ok {
+		return allowed, nil
+	}
+	// the namespace's labels are only needed if it could be selected by them
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pod.Namespace}}
+	if lo.ContainsBy(s.opts.Pods.NamespaceProvisioners, func(n NamespaceProvisioners) bool { return n.Selector != nil && !n.Selector.Empty() }) {
+		if err := s.kubeClient.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+			return nil, fmt.Errorf("getting namespace, %w", err)
+		}
+	}
+	var allowed sets.String
+	for _, namespaceProvisioners := range s.opts.Pods.NamespaceProvisioners {
+		if namespaceProvisioners.Matches(namespace) {
+			allowed = allowed.Union(sets.NewString(namespaceProvisioners.Provisioners...))
+		}
+	}
+	s.namespaceProvisioners[pod.Namespace] = allowed
+	return allowed, nil
+}
+
+// namespaceAllows returns an error if the provisioner isn't one that the pod's namespace can provision from
+func namespaceAllows(allowed sets.String, namespace string, provisionerName string) error {
+	if allowed == nil || allowed.Has(provisionerName) {
+		return nil
+	}
+	return rejectedBy(PredicateNamespace, fmt.Errorf("namespace %q can't provision from provisioner %q", namespace, provisionerName))
+}
@@ -17,15 +17,20 @@ package scheduling
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync/atomic"
 
 	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
 	"github.com/aws/karpenter-core/pkg/scheduling"
+	podutils "github.com/aws/karpenter-core/pkg/utils/pod"
 	"github.com/aws/karpenter-core/pkg/utils/resources"
 )
 
@@ -33,15 +38,34 @@ import (
 // will be turned into one or more actual node instances within the cluster after bin packing.
 type Node struct {
 	MachineTemplate
+	nodeOptions
 
 	Pods          []*v1.Pod
 	topology      *Topology
 	hostPortUsage *scheduling.HostPortUsage
+	// volumeClaims are the persistent volume claims of the node's pods, each of which requires a volume attachment
+	volumeClaims sets.String
+	// dedicated is true if the node was created for a pod that requires a node of its own
+	dedicated bool
+}
+
+// nodeOptions are the scheduler's state that a new node is checked against as pods are added to it
+type nodeOptions struct {
+	tolerationCache *scheduling.TolerationCache
+	excludedZones   sets.String
+	// failedInstanceTypes are the instance types that recently failed to launch, which the node isn't launched as
+	failedInstanceTypes sets.String
+	granularity         map[v1.ResourceName]resource.Scale
+	packing             *packing
+	// architectures infers the architecture of pods that don't constrain it, nil if inference is disabled
+	architectures      *architectureInference
+	namespaceSelectors namespaceNodeSelectors
 }
 
 var nodeID int64
 
-func NewNode(machineTemplate *MachineTemplate, topology *Topology, daemonResources v1.ResourceList, instanceTypes []*cloudprovider.InstanceType) *Node {
+func NewNode(machineTemplate *MachineTemplate, topology *Topology, daemonResources v1.ResourceList, instanceTypes []*cloudprovider.InstanceType,
+	opts nodeOptions) *Node {
 	// Copy the template, and add hostname
 	hostname := fmt.Sprintf("hostname-placeholder-%04d", atomic.AddInt64(&nodeID, 1))
 	topology.Register(v1.LabelHostname, hostname)
@@ -49,69 +73,116 @@ func NewNode(machineTemplate *MachineTemplate, topology *Topology, daemonResourc
 	template.Requirements = scheduling.NewRequirements()
 	template.Requirements.Add(machineTemplate.Requirements.Values()...)
 	template.Requirements.Add(scheduling.NewRequirement(v1.LabelHostname, v1.NodeSelectorOpIn, hostname))
+	// The zone exclusion is carried on the requirements so that the launched machine also avoids the excluded zones
+	if opts.excludedZones.Len() > 0 {
+		template.Requirements.Add(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpNotIn, opts.excludedZones.UnsortedList()...))
+	}
 	template.InstanceTypeOptions = instanceTypes
 	template.Requests = daemonResources
 
 	return &Node{
 		MachineTemplate: template,
+		nodeOptions:     opts,
 		hostPortUsage:   scheduling.NewHostPortUsage(),
+		volumeClaims:    sets.NewString(),
 		topology:        topology,
 	}
 }
 
 func (m *Node) Add(ctx context.Context, pod *v1.Pod) error {
-	// Check Taints
-	if err := m.Taints.Tolerates(pod); err != nil {
-		return err
+	// Check Dedicated Nodes
+	if m.dedicated {
+		return rejectedBy(PredicateDedicated, fmt.Errorf("node is dedicated to pod %s", client.ObjectKeyFromObject(m.Pods[0])))
+	}
+	dedicated := podutils.HasDedicatedNode(pod)
+	if dedicated && len(m.Pods) > 0 {
+		return rejectedBy(PredicateDedicated, fmt.Errorf("pod requires a dedicated node"))
+	}
+
+	// Check Taints, unless the pod will be mutated to tolerate them
+	if !m.InjectTolerations {
+		if err := m.tolerationCache.ToleratesIndefinitely(m.Taints, pod); err != nil {
+			return rejectedBy(PredicateTaints, err)
+		}
 	}
 
 	// exposed host ports on the node
 	if err := m.hostPortUsage.Validate(pod); err != nil {
-		return err
+		return rejectedBy(PredicateHostPorts, err)
 	}
 
 	nodeRequirements := scheduling.NewRequirements(m.Requirements.Values()...)
-	podRequirements := scheduling.NewPodRequirements(pod)
+	podRequirements := m.namespaceSelectors.podRequirements(pod)
+	if architecture := m.architectures.Requirement(ctx, pod, podRequirements); architecture != nil {
+		podRequirements.Add(architecture)
+	}
 
 	// Check Node Affinity Requirements
 	if err := nodeRequirements.Compatible(podRequirements); err != nil {
-		return fmt.Errorf("incompatible requirements, %w", err)
+		return rejectedBy(PredicateNodeAffinity, fmt.Errorf("incompatible requirements, %w", err))
 	}
 	nodeRequirements.Add(podRequirements.Values()...)
 
 	// Check Topology Requirements
 	topologyRequirements, err := m.topology.AddRequirements(podRequirements, nodeRequirements, pod)
 	if err != nil {
-		return err
+		return rejectedBy(PredicateTopology, err)
 	}
 	if err = nodeRequirements.Compatible(topologyRequirements); err != nil {
-		return err
+		return rejectedBy(PredicateTopology, err)
 	}
 	nodeRequirements.Add(topologyRequirements.Values()...)
 
 	// Check instance type combinations
-	requests := resources.Merge(m.Requests, resources.RequestsForPods(pod))
-	instanceTypes := filterInstanceTypesByRequirements(m.InstanceTypeOptions, nodeRequirements, requests)
+	podRequests := m.packing.requests(pod)
+	requests := resources.Merge(m.Requests, podRequests)
+	// A pod that exceeds the cap on its own is still scheduled to an empty node, as no other node could hold it
+	if len(m.Pods) > 0 {
+		if err := exceedsMaxNodeRequests(requests, m.MaxNodeRequests); err != nil {
+			return rejectedBy(PredicateResources, err)
+		}
+	}
+	volumeClaims := m.volumeClaims.Union(podVolumeClaims(pod))
+	instanceTypes := filterInstanceTypesByRequirements(m.InstanceTypeOptions, nodeRequirements, requests, volumeClaims.Len(), m.excludedZones,
+		m.failedInstanceTypes, m.MaxInstanceResources, m.granularity)
 	if len(instanceTypes) == 0 {
-		return fmt.Errorf("no instance type satisfied resources %s and requirements %s", resources.String(resources.RequestsForPods(pod)), nodeRequirements)
+		shortfall := largestInstanceTypeShortfall(m.InstanceTypeOptions, nodeRequirements, requests, m.excludedZones, m.granularity)
+		if volumeClaims.Len() > 0 {
+			return rejectedBy(PredicateInstanceTypes, fmt.Errorf("no instance type satisfied resources %s, %d volume(s) and requirements %s%s",
+				resources.String(podRequests), volumeClaims.Len(), nodeRequirements, shortfall))
+		}
+		return rejectedBy(PredicateInstanceTypes, fmt.Errorf("no instance type satisfied resources %s and requirements %s%s", resources.String(podRequests),
+			nodeRequirements, shortfall))
 	}
 
 	// Update node
 	m.Pods = append(m.Pods, pod)
+	m.dedicated = dedicated
 	m.InstanceTypeOptions = instanceTypes
 	m.Requests = requests
 	m.Requirements = nodeRequirements
+	m.volumeClaims = volumeClaims
 	m.topology.Record(pod, nodeRequirements)
 	m.hostPortUsage.Add(ctx, pod)
 	return nil
 }
 
 // FinalizeScheduling is called once all scheduling has completed and allows the node to perform any cleanup
-// necessary before its requirements are used for instance launching
-func (m *Node) FinalizeScheduling() {
+// necessary before its requirements are used for instance launching. If a cost function is given, the instance type
+// options are ordered by their cost rather than the order that the cloud provider listed them in.
+func (m *Node) FinalizeScheduling(preferences InstanceTypePreferences, cost CostFunc) {
 	// We need nodes to have hostnames for topology purposes, but we don't want to pass that node name on to consumers
 	// of the node as it will be displayed in error messages
 	delete(m.Requirements, v1.LabelHostname)
+	// The instance type options are shared with the machine template, so they're copied before being reordered. The
+	// pods' preferred node affinity takes precedence over the provisioner's instance type preferences, which take
+	// precedence over cost.
+	m.InstanceTypeOptions = append([]*cloudprovider.InstanceType{}, m.InstanceTypeOptions...)
+	if cost != nil {
+		orderByCost(m.InstanceTypeOptions, m.Requirements, m.excludedZones, cost)
+	}
+	preferences.Order(m.InstanceTypeOptions)
+	orderByPreferredAffinity(m.InstanceTypeOptions, m.Pods)
 }
 
 func (m *Node) String() string {
@@ -134,9 +205,87 @@ func InstanceTypeList(instanceTypeOptions []*cloudprovider.InstanceType) string
 	return itSb.String()
 }
 
-func filterInstanceTypesByRequirements(instanceTypes []*cloudprovider.InstanceType, requirements scheduling.Requirements, requests v1.ResourceList) []*cloudprovider.InstanceType {
+// filterInstanceTypesByRequirements returns the instance types that are compatible with the requirements, fit the
+// requests and volumes and have an available offering, skipping those that recently failed to launch. Instance types
+// with more capacity than maxResources are dropped, unless none of the smaller instance types remain.
+func filterInstanceTypesByRequirements(instanceTypes []*cloudprovider.InstanceType, requirements scheduling.Requirements, requests v1.ResourceList,
+	volumes int, excludedZones sets.String, failedInstanceTypes sets.String, maxResources v1.ResourceList,
+	granularity map[v1.ResourceName]resource.Scale) []*cloudprovider.InstanceType {
+	instanceTypes = lo.Filter(instanceTypes, func(instanceType *cloudprovider.InstanceType, _ int) bool {
+		return !failedInstanceTypes.Has(instanceType.Name) && compatible(instanceType, requirements) && fits(instanceType, requests, volumes, granularity) && hasOffering(instanceType, requirements, excludedZones)
+	})
+	if capped := filterByMaxResources(instanceTypes, maxResources); len(capped) > 0 {
+		return capped
+	}
+	return instanceTypes
+}
+
+// filterByMaxResources returns the instance types whose capacity doesn't exceed maxResources
+func filterByMaxResources(instanceTypes []*cloudprovider.InstanceType, maxResources v1.ResourceList) []*cloudprovider.InstanceType {
+	return lo.Filter(instanceTypes, func(instanceType *cloudprovider.InstanceType, _ int) bool {
+		for resourceName, maxQuantity := range maxResources {
+			if resources.Cmp(instanceType.Capacity[resourceName], maxQuantity) > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// exceedsMaxNodeRequests returns an error if the requests exceed the cap on any resource
+func exceedsMaxNodeRequests(requests v1.ResourceList, maxNodeRequests v1.ResourceList) error {
+	for resourceName, maxQuantity := range maxNodeRequests {
+		if quantity := requests[resourceName]; resources.Cmp(quantity, maxQuantity) > 0 {
+			return fmt.Errorf("requests of %s for %s exceed the node's maximum of %s", quantity.String(), resourceName, maxQuantity.String())
+		}
+	}
+	return nil
+}
+
+// MinimumViableInstanceType returns the cheapest of the instance types that could run the pod alongside the daemon
+// overhead if launched from the machine template, applying the same checks as scheduling the pod to a new node. Ties in
+// price are broken by the smaller instance type.
+func MinimumViableInstanceType(pod *v1.Pod, machineTemplate *MachineTemplate, instanceTypes []*cloudprovider.InstanceType,
+	daemonResources v1.ResourceList) (*cloudprovider.InstanceType, error) {
+	if !machineTemplate.InjectTolerations {
+		if err := machineTemplate.Taints.ToleratesIndefinitely(pod); err != nil {
+			return nil, err
+		}
+	}
+	requirements := scheduling.NewRequirements(machineTemplate.Requirements.Values()...)
+	podRequirements := scheduling.NewPodRequirements(pod)
+	if err := requirements.Compatible(podRequirements); err != nil {
+		return nil, fmt.Errorf("incompatible requirements, %w", err)
+	}
+	requirements.Add(podRequirements.Values()...)
+
+	requests := resources.Merge(daemonResources, resources.RequestsForPods(pod))
+	viable := filterInstanceTypesByRequirements(instanceTypes, requirements, requests, podVolumeClaims(pod).Len(), nil, nil, machineTemplate.MaxInstanceResources,
+		resources.DefaultGranularity)
+	if len(viable) == 0 {
+		return nil, fmt.Errorf("no instance type satisfied resources %s and requirements %s%s", resources.String(resources.RequestsForPods(pod)), requirements,
+			largestInstanceTypeShortfall(instanceTypes, requirements, requests, nil, resources.DefaultGranularity))
+	}
+	price := func(it *cloudprovider.InstanceType) float64 {
+		return it.Offerings.Available().Requirements(requirements).Cheapest().Price
+	}
+	return lo.MinBy(viable, func(a, b *cloudprovider.InstanceType) bool {
+		if price(a) != price(b) {
+			return price(a) < price(b)
+		}
+		if cmp := a.Capacity.Cpu().Cmp(*b.Capacity.Cpu()); cmp != 0 {
+			return cmp < 0
+		}
+		return a.Capacity.Memory().Cmp(*b.Capacity.Memory()) < 0
+	}), nil
+}
+
+// UsableInstanceTypes returns the instance types that are compatible with the machine template's own requirements and
+// have a compatible available offering, i.e. the instance types that the provisioner could launch before any pod
+// narrows them down
+func UsableInstanceTypes(machineTemplate *MachineTemplate, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
 	return lo.Filter(instanceTypes, func(instanceType *cloudprovider.InstanceType, _ int) bool {
-		return compatible(instanceType, requirements) && fits(instanceType, requests) && hasOffering(instanceType, requirements)
+		return compatible(instanceType, machineTemplate.Requirements) && hasOffering(instanceType, machineTemplate.Requirements, nil)
 	})
 }
 
@@ -144,12 +293,115 @@ func compatible(instanceType *cloudprovider.InstanceType, requirements schedulin
 	return instanceType.Requirements.Intersects(requirements) == nil
 }
 
-func fits(instanceType *cloudprovider.InstanceType, requests v1.ResourceList) bool {
-	return resources.Fits(resources.Merge(requests, instanceType.Overhead.Total()), instanceType.Capacity)
+// fits returns true if the requests and the instance type overhead fit within the capacity of the instance type, and the
+// volumes can be attached to it. The requests are rounded up to the granularity so that they're compared at the same
+// precision regardless of how they were summed. The capacity isn't rounded, as rounding it up would make the instance
+// type look larger than it is.
+func fits(instanceType *cloudprovider.InstanceType, requests v1.ResourceList, volumes int, granularity map[v1.ResourceName]resource.Scale) bool {
+	if instanceType.AttachableVolumes > 0 && volumes > instanceType.AttachableVolumes {
+		return false
+	}
+	return resources.Fits(resources.RoundUp(resources.Merge(requests, instanceType.Overhead.Total()), granularity), instanceType.Capacity)
 }
 
-func hasOffering(instanceType *cloudprovider.InstanceType, requirements scheduling.Requirements) bool {
+// largestInstanceTypeShortfall describes by how much the requests and overhead exceed the capacity of the largest
+// instance type, by cpu and then memory, that's compatible with the requirements and has an available offering, e.g.
+// ", short by 2 cpu and 4Gi memory on the largest available instance type m5.large". It's empty if there's no such
+// instance type or if it has enough of every resource, i.e. it was rejected for its volume or resource limits instead.
+func largestInstanceTypeShortfall(instanceTypes []*cloudprovider.InstanceType, requirements scheduling.Requirements, requests v1.ResourceList,
+	excludedZones sets.String, granularity map[v1.ResourceName]resource.Scale) string {
+	candidates := lo.Filter(instanceTypes, func(instanceType *cloudprovider.InstanceType, _ int) bool {
+		return compatible(instanceType, requirements) && hasOffering(instanceType, requirements, excludedZones)
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	largest := lo.MaxBy(candidates, func(a, b *cloudprovider.InstanceType) bool {
+		if cmp := a.Capacity.Cpu().Cmp(*b.Capacity.Cpu()); cmp != 0 {
+			return cmp > 0
+		}
+		return a.Capacity.Memory().Cmp(*b.Capacity.Memory()) > 0
+	})
+	required := resources.RoundUp(resources.Merge(requests, largest.Overhead.Total()), granularity)
+	capacity := largest.Capacity
+	resourceNames := lo.Keys(required)
+	sort.Slice(resourceNames, func(i, j int) bool { return resourceNames[i] < resourceNames[j] })
+	var shortfall []string
+	for _, resourceName := range resourceNames {
+		quantity := required[resourceName]
+		if quantity.Cmp(capacity[resourceName]) <= 0 {
+			continue
+		}
+		quantity.Sub(capacity[resourceName])
+		shortfall = append(shortfall, fmt.Sprintf("%s %s", quantity.String(), resourceName))
+	}
+	if len(shortfall) == 0 {
+		return ""
+	}
+	described := shortfall[len(shortfall)-1]
+	if len(shortfall) > 1 {
+		described = fmt.Sprintf("%s and %s", strings.Join(shortfall[:len(shortfall)-1], ", "), described)
+	}
+	return fmt.Sprintf(", short by %s on the largest available instance type %s", described, largest.Name)
+}
+
+// packing determines the resources that pods are packed onto nodes by. It's shared by the scheduler and its nodes. A nil
+// *packing packs pods by their requests.
+type packing struct {
+	// defaultRequests are used for any resource that a pod doesn't request
+	defaultRequests v1.ResourceList
+	// byLimits packs pods by the greater of each container's request and limit
+	byLimits bool
+	// devices are the resources of the devices that each pod's resource claims request. They're held here rather than
+	// added to the pods' requests so that solving a pod doesn't modify it.
+	devices map[*v1.Pod]v1.ResourceList
+}
+
+// requests returns the resources that the pods are packed onto nodes by, their requests or if packing by limits the
+// greater of each container's request and limit, plus the devices that they claim, with the defaults substituted for
+// any resource that a pod doesn't request. The defaults are substituted per pod, so the requests of several pods sum
+// to the requests that they're each packed by.
+func (p *packing) requests(pods ...*v1.Pod) v1.ResourceList {
+	if p == nil {
+		return resources.RequestsForPods(pods...)
+	}
+	requests := v1.ResourceList{}
+	for _, pod := range pods {
+		podRequests := resources.RequestsForPods(pod)
+		if p.byLimits {
+			podRequests = resources.RequestsOrLimitsForPods(pod)
+		}
+		if devices, ok := p.devices[pod]; ok {
+			podRequests = resources.Merge(podRequests, devices)
+		}
+		requests = resources.Merge(requests, resources.DefaultRequests(podRequests, p.defaultRequests))
+	}
+	return requests
+}
+
+// podVolumeClaims returns the namespaced names of the pod's persistent volume claims, including those of its generic
+// ephemeral volumes. Pods that share a claim share its volume attachment.
+func podVolumeClaims(pod *v1.Pod) sets.String {
+	claims := sets.NewString()
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claims.Insert(fmt.Sprintf("%s/%s", pod.Namespace, volume.PersistentVolumeClaim.ClaimName))
+		case volume.Ephemeral != nil:
+			// the claim of an ephemeral volume is named after the pod and the volume
+			claims.Insert(fmt.Sprintf("%s/%s-%s", pod.Namespace, pod.Name, volume.Name))
+		}
+	}
+	return claims
+}
+
+// hasOffering returns true if the instance type has an available offering that is compatible with the requirements.
+// Offerings in any of the excluded zones are treated as unavailable.
+func hasOffering(instanceType *cloudprovider.InstanceType, requirements scheduling.Requirements, excludedZones sets.String) bool {
 	for _, offering := range instanceType.Offerings.Available() {
+		if excludedZones.Has(offering.Zone) {
+			continue
+		}
 		if (!requirements.Has(v1.LabelTopologyZone) || requirements.Get(v1.LabelTopologyZone).Has(offering.Zone)) &&
 			(!requirements.Has(v1alpha5.LabelCapacityType) || requirements.Get(v1alpha5.LabelCapacityType).Has(offering.CapacityType)) {
 			return true
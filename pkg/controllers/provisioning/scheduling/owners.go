@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+)
+
+// OwnerSelector selects the pods that are owned by a controller, e.g. Job to select the pods of every job
+type OwnerSelector struct {
+	// APIVersion is the API version of the owner, e.g. batch/v1, any API version matches if empty
+	APIVersion string
+	// Kind is the kind of the owner, e.g. Job
+	Kind string
+	// Name is the name of the owner, any owner of the kind matches if empty
+	Name string
+}
+
+// Matches returns true if the owner reference refers to an owner that the selector selects
+func (o OwnerSelector) Matches(owner metav1.OwnerReference) bool {
+	return owner.Kind == o.Kind &&
+		(o.APIVersion == "" || owner.APIVersion == o.APIVersion) &&
+		(o.Name == "" || owner.Name == o.Name)
+}
+
+// includedPods filters out the pods that are owned by an excluded owner
+func (s *Scheduler) includedPods(ctx context.Context, pods []*v1.Pod) []*v1.Pod {
+	if len(s.opts.Pods.ExcludedOwners) == 0 {
+		return pods
+	}
+	included := lo.Reject(pods, func(pod *v1.Pod, _ int) bool {
+		return lo.ContainsBy(pod.OwnerReferences, func(owner metav1.OwnerReference) bool {
+			return lo.ContainsBy(s.opts.Pods.ExcludedOwners, func(selector OwnerSelector) bool { return selector.Matches(owner) })
+		})
+	})
+	if len(included) != len(pods) && !s.opts.SimulationMode {
+		logging.FromContext(ctx).Debugf("excluding %d pod(s) owned by excluded owners", len(pods)-len(included))
+	}
+	return included
+}
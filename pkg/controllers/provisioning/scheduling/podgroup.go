@@ -0,0 +1,101 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	podutils "github.com/aws/karpenter-core/pkg/utils/pod"
+)
+
+// podGroup is a group of pods that are gang scheduled, either every pod in the group is scheduled or none are
+type podGroup struct {
+	name string
+	pods []*v1.Pod
+}
+
+// podGroups separates the pods that are members of a pod group from those that aren't, the groups are ordered by name
+func podGroups(pods []*v1.Pod) ([]podGroup, []*v1.Pod) {
+	members := map[string][]*v1.Pod{}
+	var ungrouped []*v1.Pod
+	for _, pod := range pods {
+		if name := podutils.PodGroup(pod); name != "" {
+			members[name] = append(members[name], pod)
+		} else {
+			ungrouped = append(ungrouped, pod)
+		}
+	}
+	if len(members) == 0 {
+		return nil, pods
+	}
+	groups := lo.MapToSlice(members, func(name string, pods []*v1.Pod) podGroup { return podGroup{name: name, pods: pods} })
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups, ungrouped
+}
+
+// solveGroup schedules the pods of the group and returns them all as failed to schedule, undoing the placement of
+// those that were scheduled, unless every pod in the group was scheduled
+func (s *Scheduler) solveGroup(ctx context.Context, group podGroup, errors map[*v1.Pod]error, relaxations map[*v1.Pod]int) []*v1.Pod {
+	restore := s.checkpoint()
+	failed := s.solveQueue(ctx, NewQueue(append([]*v1.Pod{}, group.pods...)...), errors, relaxations)
+	if len(failed) == 0 {
+		return nil
+	}
+	restore()
+	err := fmt.Errorf("%d of %d pod(s) in pod group %q can't be scheduled", len(failed), len(group.pods), group.name)
+	for _, pod := range group.pods {
+		errors[pod] = multierr.Append(err, errors[pod])
+	}
+	return group.pods
+}
+
+// checkpoint captures the state that scheduling pods modifies and returns a function that restores it, discarding any
+// new nodes created since
+func (s *Scheduler) checkpoint() func() {
+	newNodes := append([]*Node{}, s.newNodes...)
+	newNodeStates := lo.Map(newNodes, func(n *Node, _ int) Node {
+		state := *n
+		state.hostPortUsage = n.hostPortUsage.DeepCopy()
+		return state
+	})
+	existingNodeStates := lo.Map(s.existingNodes, func(n *ExistingNode, _ int) ExistingNode {
+		state := *n
+		state.hostPortUsage = n.hostPortUsage.DeepCopy()
+		state.volumeUsage = n.volumeUsage.DeepCopy()
+		return state
+	})
+	remainingResources := lo.Assign(s.remainingResources)
+	spreadGroups := sets.NewString(s.spreadGroups.UnsortedList()...)
+	restoreTopology := s.topology.checkpoint()
+	return func() {
+		for i, n := range newNodes {
+			*n = newNodeStates[i]
+		}
+		s.newNodes = newNodes
+		for i, n := range s.existingNodes {
+			*n = existingNodeStates[i]
+		}
+		s.remainingResources = remainingResources
+		s.spreadGroups = spreadGroups
+		restoreTopology()
+	}
+}
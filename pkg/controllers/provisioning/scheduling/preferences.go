@@ -33,26 +33,42 @@ type Preferences struct {
 	ToleratePreferNoSchedule bool
 }
 
-func (p *Preferences) Relax(ctx context.Context, pod *v1.Pod) bool {
+// The kinds of preferences that can be relaxed
+const (
+	RelaxationNodeAffinity               = "node_affinity"
+	RelaxationPodAffinity                = "pod_affinity"
+	RelaxationPodAntiAffinity            = "pod_anti_affinity"
+	RelaxationTopologySpread             = "topology_spread"
+	RelaxationPreferNoScheduleToleration = "prefer_no_schedule_toleration"
+)
+
+type relaxation struct {
+	kind  string
+	relax func(*v1.Pod) *string
+}
+
+// Relax removes the next preference from the pod, returning the kind of the preference that was relaxed and false if
+// there are no preferences left to relax
+func (p *Preferences) Relax(ctx context.Context, pod *v1.Pod) (string, bool) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)))
-	relaxations := []func(*v1.Pod) *string{
-		p.removeRequiredNodeAffinityTerm,
-		p.removePreferredPodAffinityTerm,
-		p.removePreferredPodAntiAffinityTerm,
-		p.removePreferredNodeAffinityTerm,
-		p.removeTopologySpreadScheduleAnyway}
+	relaxations := []relaxation{
+		{RelaxationNodeAffinity, p.removeRequiredNodeAffinityTerm},
+		{RelaxationPodAffinity, p.removePreferredPodAffinityTerm},
+		{RelaxationPodAntiAffinity, p.removePreferredPodAntiAffinityTerm},
+		{RelaxationNodeAffinity, p.removePreferredNodeAffinityTerm},
+		{RelaxationTopologySpread, p.removeTopologySpreadScheduleAnyway}}
 
 	if p.ToleratePreferNoSchedule {
-		relaxations = append(relaxations, p.toleratePreferNoScheduleTaints)
+		relaxations = append(relaxations, relaxation{RelaxationPreferNoScheduleToleration, p.toleratePreferNoScheduleTaints})
 	}
 
-	for _, relaxFunc := range relaxations {
-		if reason := relaxFunc(pod); reason != nil {
+	for _, r := range relaxations {
+		if reason := r.relax(pod); reason != nil {
 			logging.FromContext(ctx).Debugf("relaxing soft constraints for pod since it previously failed to schedule, %s", ptr.StringValue(reason))
-			return true
+			return r.kind, true
 		}
 	}
-	return false
+	return "", false
 }
 
 func (p *Preferences) removePreferredNodeAffinityTerm(pod *v1.Pod) *string {
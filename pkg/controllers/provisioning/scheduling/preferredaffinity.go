@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// orderByPreferredAffinity sorts the instance types by the aggregate weight of the pods' preferred node affinity terms
+// that they match, so that the instance types the pods prefer most are listed first. Only the heaviest preferred term
+// of a pod is enforced while scheduling, the lighter ones would otherwise have no effect on which instance type is
+// launched. The order of instance types with equal weight is maintained.
+func orderByPreferredAffinity(instanceTypes []*cloudprovider.InstanceType, pods []*v1.Pod) {
+	var terms []v1.PreferredSchedulingTerm
+	for _, pod := range pods {
+		if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+			continue
+		}
+		terms = append(terms, pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if len(terms) == 0 {
+		return
+	}
+	requirements := make([]scheduling.Requirements, len(terms))
+	for i, term := range terms {
+		requirements[i] = scheduling.NewNodeSelectorRequirements(term.Preference.MatchExpressions...)
+	}
+	weights := make(map[*cloudprovider.InstanceType]int64, len(instanceTypes))
+	for _, instanceType := range instanceTypes {
+		for i, term := range terms {
+			if compatible(instanceType, requirements[i]) {
+				weights[instanceType] += int64(term.Weight)
+			}
+		}
+	}
+	sort.SliceStable(instanceTypes, func(a, b int) bool {
+		return weights[instanceTypes[a]] > weights[instanceTypes[b]]
+	})
+}
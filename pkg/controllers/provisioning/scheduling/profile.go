@@ -0,0 +1,66 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"context"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+)
+
+// SchedulingProfile is a named set of options for the new nodes launched from a provisioner. A provisioner selects a
+// profile with the v1alpha5.SchedulingProfileLabelKey label, so that provisioners can be scheduled differently within
+// the same solve. Provisioners that don't select a profile use the SchedulerOptions.
+type SchedulingProfile struct {
+	// Name is the value of the label that selects the profile
+	Name string
+	// InstanceTypePreferences order the instance type options of new nodes, replacing
+	// SchedulerOptions.InstanceTypes.InstanceTypePreferences
+	InstanceTypePreferences InstanceTypePreferences
+	// ExcludedZones are zones that new nodes won't be launched into, in addition to SchedulerOptions.Zones.ExcludedZones
+	ExcludedZones []string
+	// DiversifyInstanceTypes rotates the instance type options of new nodes, replacing
+	// SchedulerOptions.InstanceTypes.DiversifyInstanceTypes
+	DiversifyInstanceTypes bool
+	// BalanceZones prefers to launch new nodes into the zone with the fewest nodes of the provisioner, replacing
+	// SchedulerOptions.Zones.BalanceZones
+	BalanceZones bool
+}
+
+// resolveProfiles resolves the scheduling profile of each machine template. Templates that select a profile that
+// doesn't exist fall back to the scheduler options.
+func (s *Scheduler) resolveProfiles(ctx context.Context) {
+	profiles := lo.KeyBy(s.opts.Profiles, func(p SchedulingProfile) string { return p.Name })
+	for _, nodeTemplate := range s.machineTemplates {
+		profile, ok := profiles[nodeTemplate.SchedulingProfile]
+		if !ok {
+			if nodeTemplate.SchedulingProfile != "" && !s.opts.SimulationMode {
+				logging.FromContext(ctx).With("provisioner", nodeTemplate.ProvisionerName, "profile", nodeTemplate.SchedulingProfile).
+					Errorf("scheduling profile selected by %s not found, using the default options", v1alpha5.SchedulingProfileLabelKey)
+			}
+			profile = SchedulingProfile{
+				InstanceTypePreferences: s.opts.InstanceTypes.InstanceTypePreferences,
+				DiversifyInstanceTypes:  s.opts.InstanceTypes.DiversifyInstanceTypes,
+				BalanceZones:            s.opts.Zones.BalanceZones,
+			}
+		}
+		s.profiles[nodeTemplate.ProvisionerName] = profile
+		s.templateExcludedZones[nodeTemplate.ProvisionerName] = s.excludedZones.Union(sets.NewString(profile.ExcludedZones...))
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// ProvisionerRelaxation is a change to a provisioner's requirements that would let it launch a node for a pod that no
+// provisioner's requirements admit, e.g. allowing another zone or instance type
+type ProvisionerRelaxation struct {
+	Provisioner string
+	// Requirements replace the provisioner's requirements for their keys, listing the values that its instance types
+	// offer for the pod. A requirement without values no longer restricts its key.
+	Requirements []v1.NodeSelectorRequirement
+}
+
+func (r ProvisionerRelaxation) String() string {
+	return fmt.Sprintf("provisioner %q would admit the pod requiring %s", r.Provisioner, strings.Join(lo.Map(r.Requirements,
+		func(requirement v1.NodeSelectorRequirement, _ int) string {
+			return scheduling.NewRequirement(requirement.Key, requirement.Operator, requirement.Values...).String()
+		}), " AND "))
+}
+
+// ProvisionerRelaxations returns the smallest relaxation of each provisioner's requirements that would admit the pod,
+// relaxing at most maxUnsatisfiableRequirements of their keys, ordered by the number of keys relaxed and then by
+// provisioner. It's empty if a provisioner already admits the pod's requirements, as they aren't why it failed to
+// schedule. Only the requirements are relaxed, the pod may still fail to schedule for other reasons, e.g. its taints.
+func (s *Scheduler) ProvisionerRelaxations(pod *v1.Pod) []ProvisionerRelaxation {
+	podRequirements := s.namespaceSelectors.podRequirements(pod)
+	if lo.ContainsBy(s.machineTemplates, func(nodeTemplate *MachineTemplate) bool {
+		return s.provides(nodeTemplate, podRequirements)
+	}) {
+		return nil
+	}
+	var relaxations []ProvisionerRelaxation
+	for _, nodeTemplate := range s.machineTemplates {
+		if relaxation, ok := s.provisionerRelaxation(nodeTemplate, podRequirements); ok {
+			relaxations = append(relaxations, relaxation)
+		}
+	}
+	sort.SliceStable(relaxations, func(i, j int) bool {
+		if len(relaxations[i].Requirements) != len(relaxations[j].Requirements) {
+			return len(relaxations[i].Requirements) < len(relaxations[j].Requirements)
+		}
+		return relaxations[i].Provisioner < relaxations[j].Provisioner
+	})
+	return relaxations
+}
+
+// provisionerRelaxation searches combinations of the keys that the template or the pod constrain in increasing size for
+// one that, if the template deferred to the pod's requirements for them, would let the template provide the pod
+func (s *Scheduler) provisionerRelaxation(nodeTemplate *MachineTemplate, podRequirements scheduling.Requirements) (ProvisionerRelaxation, bool) {
+	// the provisioner can't be relaxed to admit a pod that requires another provisioner
+	if nodeTemplate.Requirements.Get(v1alpha5.ProvisionerNameLabelKey).Intersection(podRequirements.Get(v1alpha5.ProvisionerNameLabelKey)).Len() == 0 {
+		return ProvisionerRelaxation{}, false
+	}
+	keys := nodeTemplate.Requirements.Keys().Union(podRequirements.Keys()).Difference(sets.NewString(v1alpha5.ProvisionerNameLabelKey, v1.LabelHostname)).List()
+	var relaxed *MachineTemplate
+	var relaxedKeys []string
+	for size := 1; size <= lo.Min([]int{len(keys), maxUnsatisfiableRequirements}) && relaxed == nil; size++ {
+		forEachCombination(len(keys), size, func(indices []int) bool {
+			candidate := lo.Map(indices, func(i int, _ int) string { return keys[i] })
+			template := *nodeTemplate
+			template.Requirements = scheduling.NewRequirements(lo.Reject(nodeTemplate.Requirements.Values(), func(r *scheduling.Requirement, _ int) bool {
+				return lo.Contains(candidate, r.Key)
+			})...)
+			for _, key := range candidate {
+				if podRequirements.Has(key) {
+					template.Requirements.Add(podRequirements.Get(key))
+				}
+			}
+			if !s.provides(&template, podRequirements) {
+				return true
+			}
+			relaxed, relaxedKeys = &template, candidate
+			return false
+		})
+	}
+	if relaxed == nil {
+		return ProvisionerRelaxation{}, false
+	}
+	combined := scheduling.NewRequirements(relaxed.Requirements.Values()...)
+	combined.Add(podRequirements.Values()...)
+	instanceTypes := lo.Filter(s.instanceTypes[nodeTemplate.ProvisionerName], func(it *cloudprovider.InstanceType, _ int) bool {
+		return compatible(it, combined) && hasOffering(it, combined, s.templateExcludedZones[nodeTemplate.ProvisionerName])
+	})
+	excludedZones := s.templateExcludedZones[nodeTemplate.ProvisionerName]
+	return ProvisionerRelaxation{
+		Provisioner: nodeTemplate.ProvisionerName,
+		Requirements: lo.Map(relaxedKeys, func(key string, _ int) v1.NodeSelectorRequirement {
+			return relaxedRequirement(key, combined, instanceTypes, excludedZones)
+		}),
+	}, true
+}
+
+// relaxedRequirement returns the requirement for a relaxed key that allows the values that the instance types offer
+// within the combined requirements, the zones and capacity types of their compatible offerings. Keys that the instance
+// types don't define fall back to the pod's requirement, or to no restriction at all if the pod doesn't constrain them.
+func relaxedRequirement(key string, combined scheduling.Requirements, instanceTypes []*cloudprovider.InstanceType, excludedZones sets.String) v1.NodeSelectorRequirement {
+	values := sets.NewString()
+	for _, it := range instanceTypes {
+		switch key {
+		case v1.LabelTopologyZone, v1alpha5.LabelCapacityType:
+			for _, offering := range it.Offerings.Available().Requirements(combined) {
+				if excludedZones.Has(offering.Zone) {
+					continue
+				}
+				values.Insert(lo.Ternary(key == v1.LabelTopologyZone, offering.Zone, offering.CapacityType))
+			}
+		default:
+			if !it.Requirements.Has(key) {
+				continue
+			}
+			if offered := it.Requirements.Get(key).Intersection(combined.Get(key)); offered.Operator() == v1.NodeSelectorOpIn {
+				values.Insert(offered.Values()...)
+			}
+		}
+	}
+	if values.Len() > 0 {
+		return v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: values.List()}
+	}
+	return combined.Get(key).NodeSelectorRequirement()
+}
@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	podutils "github.com/aws/karpenter-core/pkg/utils/pod"
+)
+
+// avoidExpiringReservations drops the instance type options of a node with long-lived pods whose only compatible
+// offerings are capacity reservations that expire within the window. The preference is soft, the options are left
+// unchanged if every instance type would be dropped. The cloud provider chooses between the offerings of an instance
+// type at launch, so instance types that also have an offering that doesn't expire soon are kept.
+func avoidExpiringReservations(node *Node, now time.Time, window time.Duration) {
+	if window <= 0 || !lo.ContainsBy(node.Pods, func(pod *v1.Pod) bool { return isLongLived(pod, window) }) {
+		return
+	}
+	expiry := now.Add(window)
+	preferred := lo.Filter(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) bool {
+		return lo.ContainsBy(it.Offerings.Available().Requirements(node.Requirements), func(offering cloudprovider.Offering) bool {
+			return !node.excludedZones.Has(offering.Zone) && !offering.ExpiresBefore(expiry)
+		})
+	})
+	if len(preferred) > 0 {
+		node.InstanceTypeOptions = preferred
+	}
+}
+
+// isLongLived returns true if the pod may still be running once the window has elapsed. Pods run by jobs and pods
+// with an active deadline within the window are expected to complete before then.
+func isLongLived(pod *v1.Pod, window time.Duration) bool {
+	if podutils.IsOwnedByJob(pod) {
+		return false
+	}
+	if pod.Spec.ActiveDeadlineSeconds != nil && time.Duration(*pod.Spec.ActiveDeadlineSeconds)*time.Second <= window {
+		return false
+	}
+	return true
+}
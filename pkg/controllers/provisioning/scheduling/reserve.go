@@ -0,0 +1,52 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// reservedResources are the resources that the consolidation reserve is kept for, as they're what limits pods from
+// being rescheduled onto a node
+var reservedResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+// reserveCapacity drops the instance type options of the node that don't have room for the percentage of its pods' CPU
+// and memory requests on top of its requests, so that pods from other nodes can be rescheduled onto it during
+// consolidation without launching a new node. The reserve is soft, the options are left unchanged if no instance type
+// has room for it.
+func reserveCapacity(node *Node, percent int) {
+	if percent <= 0 || len(node.Pods) == 0 {
+		return
+	}
+	requested := node.packing.requests(node.Pods...)
+	reserve := v1.ResourceList{}
+	for _, resourceName := range reservedResources {
+		if quantity, ok := requested[resourceName]; ok {
+			reserve[resourceName] = *resource.NewMilliQuantity(quantity.MilliValue()*int64(percent)/100, quantity.Format)
+		}
+	}
+	requests := resources.Merge(node.Requests, reserve)
+	preferred := lo.Filter(node.InstanceTypeOptions, func(instanceType *cloudprovider.InstanceType, _ int) bool {
+		return fits(instanceType, requests, node.volumeClaims.Len(), node.granularity)
+	})
+	if len(preferred) > 0 {
+		node.InstanceTypeOptions = preferred
+	}
+}
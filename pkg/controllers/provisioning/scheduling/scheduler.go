@@ -16,12 +16,23 @@ package scheduling
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -29,18 +40,242 @@ import (
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
 	"github.com/aws/karpenter-core/pkg/controllers/state"
 	"github.com/aws/karpenter-core/pkg/events"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+	podutils "github.com/aws/karpenter-core/pkg/utils/pod"
 	"github.com/aws/karpenter-core/pkg/utils/resources"
 )
 
-// SchedulerOptions can be used to control the scheduling, these options are currently only used during consolidation.
+// SchedulerOptions control how pods are scheduled, both when provisioning and when simulating scheduling, e.g. to
+// consolidate. Related options are grouped, and the zero value of each option is its default.
 type SchedulerOptions struct {
 	// SimulationMode if true will prevent recording of the pod nomination decisions as events
 	SimulationMode bool
+	// MaxTopologyDomains is the maximum number of domains tracked per topology key, topologies with more domains are
+	// treated as best-effort unless StrictTopologyDomains is set. Defaults to DefaultMaxTopologyDomains if unset, a
+	// negative value disables the cap.
+	MaxTopologyDomains int
+	// StrictTopologyDomains fails the pods whose topologies have more domains than MaxTopologyDomains with
+	// ErrMaxTopologyDomainsExceeded rather than treating the topologies as best-effort, so that their constraints are
+	// never silently relaxed. The pods are reported with the other failures, while the pods that were already scheduled
+	// are kept.
+	StrictTopologyDomains bool
+	// MaxNewNodes is the maximum number of new nodes that a solve creates, bounding the memory that a very large batch
+	// allocates. Pods that need a new node once it's reached fail to schedule with ErrMaxNewNodesExceeded and are
+	// reported with the other failures, while the pods that were already scheduled are kept. A value <= 0 is unlimited.
+	MaxNewNodes int
+	// Profiles are the scheduling profiles that provisioners can select to override these options for their nodes
+	Profiles []SchedulingProfile
+	// ExclusionSelector if set stops scheduling to the provisioners whose labels and requirements match it and to the
+	// existing nodes whose labels match it, e.g. to drain scheduling away from a deprecated provisioner without
+	// deleting it. An empty selector doesn't exclude anything.
+	ExclusionSelector labels.Selector
+	// Frozen stops scheduling in an emergency without stopping the controllers. A frozen solve schedules nothing and
+	// reports no failures, the pods are left pending and no new nodes are launched. A SchedulingFrozen event is
+	// published on the Reporting.DecisionEventObject instead of the decision.
+	Frozen bool
+	// OnPlacement if set is called each time that a pod is placed on a new node, after the node has been updated with
+	// the pod, so that platforms can apply their own policy, e.g. vetoing instance types. Returning an error vetoes the
+	// placement, the pod is removed from the node again and the next candidate node is tried, as if the node had
+	// rejected it. It isn't called for pods placed on existing nodes.
+	OnPlacement func(pod *v1.Pod, node *Node) error
+	// Clock is used to determine when reservations expire, startup taints linger, and terminating pods are assumed to be
+	// gone, defaults to the real clock if unset
+	Clock clock.Clock
+	// Packing controls how the requests of pods are counted when packing them onto nodes
+	Packing PackingOptions
+	// InstanceTypes controls which instance types new nodes can be launched as and the order of their options
+	InstanceTypes InstanceTypeSelectionOptions
+	// Zones controls the zones that new nodes are launched into
+	Zones ZoneOptions
+	// Pods controls which pods are scheduled and the constraints that are added to them
+	Pods PodOptions
+	// ExistingNodes controls how pods are scheduled to existing nodes
+	ExistingNodes ExistingNodeOptions
+	// Reporting controls how the outcome of each solve is recorded
+	Reporting ReportingOptions
 }
 
+// PackingOptions control how the requests of pods are counted when packing them onto nodes
+type PackingOptions struct {
+	// Granularity is the precision per resource that requests are rounded up to before checking whether pods fit on an
+	// instance type, defaults to resources.DefaultGranularity if unset
+	Granularity map[v1.ResourceName]resource.Scale
+	// DefaultPodRequests are used in place of the requests for any resource that a pod doesn't request, so that pods
+	// without requests consume nominal capacity and can't be packed onto a node without bound. The pods aren't modified.
+	DefaultPodRequests v1.ResourceList
+	// PackByLimits packs pods onto nodes by the greater of each container's request and limit rather than by its request,
+	// so that the pods of a node can't together use more memory than it has and trigger OOM kills. Pods are less densely
+	// packed, and containers without limits are still packed by their requests. The daemonset overhead and the pods
+	// already bound to existing nodes are accounted for by their requests.
+	PackByLimits bool
+	// DeviceClaims if set resolves the devices that pods request through resource claims, which are counted with the pods'
+	// requests during Solve as the resource of their device class in DeviceClassResources, so that pods requesting N
+	// devices only fit instance types with at least N of them. The pods aren't modified.
+	DeviceClaims DeviceClaimResolver
+	// DeviceClassResources maps each countable device class to the resource that instance types advertise the number of
+	// its devices as, e.g. a GPU device class to nvidia.com/gpu. The devices of other device classes aren't counted.
+	DeviceClassResources map[string]v1.ResourceName
+}
+
+// InstanceTypeSelectionOptions control which instance types new nodes can be launched as and the order of their options
+type InstanceTypeSelectionOptions struct {
+	// InstanceTypeTransform if set is called with each provisioner's instance types before the scheduler is built,
+	// returning the instance types that its new nodes can be launched as, e.g. to filter them or to adjust their
+	// requirements or overhead
+	InstanceTypeTransform InstanceTypeTransform
+	// InstanceTypePreferences order the instance type options of new nodes, e.g. to prefer newer instance type
+	// generations while still falling back to older ones
+	InstanceTypePreferences InstanceTypePreferences
+	// DiversifyInstanceTypes rotates the instance type options of the new nodes in a batch so that each node prefers a
+	// different instance type, spreading launches across more capacity pools (e.g. for spot resilience) rather than
+	// every node preferring the same instance type. It's applied after InstanceTypePreferences.
+	DiversifyInstanceTypes bool
+	// MaxDistinctInstanceTypes if positive narrows the instance type options of the new nodes of each solve to a shared
+	// set of at most this many instance types, for operational simplicity. It's best-effort, the pods' requirements take
+	// precedence, and a node whose options can't be covered by the set keeps all of them. It's applied before
+	// DiversifyInstanceTypes, which then only rotates the remaining options.
+	MaxDistinctInstanceTypes int
+	// CostFunc if set overrides the price of the offerings when ordering the instance type options of new nodes and
+	// estimating their cost, e.g. to apply negotiated pricing. The instance type options are left in the order that the
+	// cloud provider listed them, cheapest first, if unset.
+	CostFunc CostFunc
+	// LaunchFailures if set are the instance types that recently failed to launch, which new nodes aren't launched as
+	// until the failures expire. The instance types are read once when the scheduler is built.
+	LaunchFailures *LaunchFailures
+	// ReservationExpiryWindow if set steers the new nodes of long-lived pods away from instance types whose only
+	// offerings are capacity reservations that expire within the window. Pods run by jobs or with an active deadline
+	// within the window aren't long-lived. The preference is soft and disabled if unset.
+	ReservationExpiryWindow time.Duration
+	// ConsolidationReserve if set is the percentage of the CPU and memory requested by the pods on a new node that is
+	// kept spare on top of their requests, preferring instance types with room for it so that pods can later be
+	// consolidated onto the node without launching another. The reserve is soft and disabled if unset.
+	ConsolidationReserve int
+	// ExclusiveLimits excludes the instance types whose capacity would exactly use up the remaining limits of their
+	// provisioner. By default limits are inclusive, so a provisioner with a CPU limit of 16 can launch a 16 CPU node.
+	ExclusiveLimits bool
+}
+
+// ZoneOptions control the zones that new nodes are launched into
+type ZoneOptions struct {
+	// ExcludedZones are zones that new nodes won't be launched into, e.g. to steer capacity away from a zone during
+	// an incident without modifying the provisioners
+	ExcludedZones []string
+	// BalanceZones prefers to launch each new node into the zone with the fewest nodes of its provisioner, so that the
+	// nodes of a provisioner are balanced across zones over time. It's a preference, the pods' own zone constraints and
+	// the available offerings take precedence.
+	BalanceZones bool
+	// CrossZonePenalty if positive is added to the cost of launching a new node into a zone for each of its pods whose
+	// data lives in another zone, as named by their karpenter.sh/data-zone annotation, and the new node is narrowed to
+	// the zone with the lowest cost including the penalty. This nudges pods to launch alongside their data when it's
+	// worth the price difference. It's in the units of the CostFunc, or of the offering price if that's unset.
+	CrossZonePenalty float64
+}
+
+// PodOptions control which pods are scheduled and the constraints that are added to them
+type PodOptions struct {
+	// HoldAnnotation if set is an annotation key that holds the pods it's present on, like a scheduling gate. Held pods
+	// are left pending without being scheduled or reported as failing to schedule until the annotation is removed.
+	HoldAnnotation string
+	// ExcludedOwners if set exclude the pods owned by the controllers that they select, e.g. jobs or an operator's own
+	// bookkeeping pods, so that transient pods don't launch capacity. Excluded pods are left pending without being
+	// scheduled or reported as failing to schedule.
+	ExcludedOwners []OwnerSelector
+	// NamespaceProvisioners if set restrict the pods of the namespaces that they select to provisioning from the
+	// provisioners that they name, both when launching new nodes and when scheduling to existing nodes. The pods of a
+	// namespace that's selected more than once can provision from any of the provisioners named, while the pods of
+	// namespaces that aren't selected aren't restricted. Pods aren't scheduled if their namespace's labels are needed
+	// but it can't be read.
+	NamespaceProvisioners []NamespaceProvisioners
+	// NamespaceNodeSelectors if set lists the default node selectors of namespaces, e.g. those of the PodNodeSelector
+	// admission plugin, which are added to the requirements of the pods in the namespace during Solve for clusters
+	// where they aren't already reflected on the pods. The pod's own node selector takes precedence for the same key.
+	// The pods aren't modified.
+	NamespaceNodeSelectors NamespaceNodeSelectorLister
+	// ArchitectureResolver if set is used to infer the architecture of pods that don't constrain it from their container
+	// images, restricting the pod's new node to instance types of that architecture. Inference is disabled if unset.
+	ArchitectureResolver ArchitectureResolver
+	// DefaultTopologySpreadConstraints are the cluster-level default constraints, applied to the pods that don't declare
+	// any topology spread constraints of their own. As with the kube-scheduler, the pods are counted using the
+	// selectors of the services, replication controller, replica set or stateful set that the pod belongs to, so the
+	// constraints must not specify a label selector, and they aren't applied to pods that don't belong to any.
+	DefaultTopologySpreadConstraints []v1.TopologySpreadConstraint
+	// IgnoreDaemonSetAntiAffinity excludes daemonset pods from required pod anti-affinity, so a pod whose anti-affinity
+	// happens to select a daemonset's pods (e.g. app=logging) can still schedule even though every node runs them.
+	// This diverges from kube-scheduler, which honors the anti-affinity and will leave such a pod pending, so it should
+	// only be enabled when the daemonset pods are known not to be intended targets of anti-affinity.
+	IgnoreDaemonSetAntiAffinity bool
+}
+
+// ExistingNodeOptions control how pods are scheduled to existing nodes
+type ExistingNodeOptions struct {
+	// CordonLabels are label keys that mark an existing node as cordoned while they're present, in addition to the
+	// node's spec.unschedulable. Pods are only scheduled to cordoned nodes if they tolerate the unschedulable taint.
+	CordonLabels []string
+	// ScoreExistingNode if set orders the existing nodes for each pod, which is scheduled to the highest scoring
+	// existing node that it's compatible with. Existing nodes are tried in the order that they were listed if unset.
+	ScoreExistingNode ExistingNodeScorer
+	// StartupTaintGracePeriod if set is how long after an existing node is created that its startup taints are ignored,
+	// as they're expected to be removed once the node initializes. Startup taints that linger past it are treated like
+	// any other taint so that pods aren't scheduled to nodes that never finish initializing. Unset ignores them until
+	// the node is initialized.
+	StartupTaintGracePeriod time.Duration
+	// TerminatingPodGracePeriod if set is how long past its deletion timestamp that a terminating pod's requests are
+	// counted against the capacity of its existing node. Pods that are still terminating after it are assumed to be
+	// gone, so that pods can be scheduled to their capacity sooner at the risk of the node being briefly overcommitted.
+	// Unset counts a terminating pod's requests until it's gone. Daemonset pods are always counted.
+	TerminatingPodGracePeriod time.Duration
+	// LiveExistingNodeCapacity schedules to initialized existing nodes by their reported allocatable minus the requests
+	// of the pods bound to them, without reserving capacity for the provisioner's daemonsets whose pods aren't bound to
+	// them. An initialized node's daemon pods are already bound to it, and their requests can differ from what the
+	// daemonsets' templates estimate, e.g. if they're resized. Uninitialized nodes still reserve the daemonset overhead.
+	LiveExistingNodeCapacity bool
+}
+
+// ReportingOptions control how the outcome of each solve is recorded
+type ReportingOptions struct {
+	// VerboseDiagnosis records a Diagnosis of each pod's last placement attempt during Solve, including the reason that
+	// each existing node rejected the pod and whether it had room for the pod regardless. The diagnoses of pods that
+	// fail to schedule are logged at debug level, and are available from Diagnosis.
+	VerboseDiagnosis bool
+	// DecisionEventObject if set is the object, e.g. a provisioner, that an event summarizing the outcome of each solve
+	// is published on: the pods scheduled to existing and new nodes, the new nodes by provisioner and preferred instance
+	// type, and the pods that failed to schedule by reason. It isn't published in simulation mode.
+	DecisionEventObject runtime.Object
+	// AuditSink if set durably records the outcome of each solve that isn't a simulation, defaults to a NopAuditSink
+	AuditSink AuditSink
+	// InstanceTypeStability if set tracks the instance type preferred for the pods of each workload across the solves
+	// that aren't simulations, counting the changes to it in the karpenter_scheduling_preferred_instance_type_changes_total
+	// metric to detect thrashing
+	InstanceTypeStability *InstanceTypeStability
+}
+
+// InstanceTypeTransform returns the instance types that the provisioner's new nodes can be launched as, given those that
+// the cloud provider offers for it. The instance types are shared with the cloud provider, so they must be copied
+// rather than modified in place.
+type InstanceTypeTransform func(provisioner string, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType
+
+// ErrNoProvisioners is returned when there are no provisioners to launch nodes from. It's reported once rather than
+// as a scheduling failure for every pod, as it's typically a misconfiguration rather than a problem with the pods.
+var ErrNoProvisioners = errors.New("no provisioners configured; cannot provision capacity")
+
+// ErrMaxNewNodesExceeded is returned for pods that need a new node once a solve has created MaxNewNodes new nodes
+var ErrMaxNewNodesExceeded = errors.New("exceeded the maximum number of new nodes")
+
+// ErrMaxTopologyDomainsExceeded is returned for pods whose topologies have more domains than MaxTopologyDomains if
+// StrictTopologyDomains is set
+var ErrMaxTopologyDomainsExceeded = errors.New("exceeded the maximum number of topology domains")
+
+// ErrPodTooLarge is returned for pods whose requests exceed the largest instance type of every provisioner once overhead
+// is accounted for, so they'll fail to schedule every time they're solved until larger instance types are available
+var ErrPodTooLarge = errors.New("pod too large for any available instance type")
+
+// DefaultMaxTopologyDomains is large enough to track a hostname domain for every node of the largest supported
+// cluster sizes, while bounding the memory used by a topology key with unbounded cardinality
+const DefaultMaxTopologyDomains = 10000
+
 func NewScheduler(ctx context.Context, kubeClient client.Client, machines []*MachineTemplate,
 	provisioners []v1alpha5.Provisioner, cluster *state.Cluster, stateNodes []*state.Node, topology *Topology,
-	instanceTypes map[string][]*cloudprovider.InstanceType, daemonOverhead map[*MachineTemplate]v1.ResourceList,
+	instanceTypes map[string][]*cloudprovider.InstanceType, daemonOverhead map[*MachineTemplate]v1.ResourceList, daemonPods []*v1.Pod,
 	recorder events.Recorder, opts SchedulerOptions) *Scheduler {
 
 	// if any of the provisioners add a taint with a prefer no schedule effect, we add a toleration for the taint
@@ -55,18 +290,54 @@ func NewScheduler(ctx context.Context, kubeClient client.Client, machines []*Mac
 	}
 
 	s := &Scheduler{
-		ctx:                ctx,
-		kubeClient:         kubeClient,
-		machineTemplates:   machines,
-		topology:           topology,
-		cluster:            cluster,
-		instanceTypes:      instanceTypes,
-		daemonOverhead:     daemonOverhead,
-		recorder:           recorder,
-		opts:               opts,
-		preferences:        &Preferences{ToleratePreferNoSchedule: toleratePreferNoSchedule},
-		remainingResources: map[string]v1.ResourceList{},
+		ctx:                   ctx,
+		kubeClient:            kubeClient,
+		machineTemplates:      machines,
+		topology:              topology,
+		cluster:               cluster,
+		instanceTypes:         instanceTypes,
+		daemonOverhead:        daemonOverhead,
+		daemonPods:            daemonPods,
+		recorder:              recorder,
+		opts:                  opts,
+		preferences:           &Preferences{ToleratePreferNoSchedule: toleratePreferNoSchedule},
+		remainingResources:    map[string]v1.ResourceList{},
+		tolerationCache:       scheduling.NewTolerationCache(),
+		excludedZones:         sets.NewString(opts.Zones.ExcludedZones...),
+		failedInstanceTypes:   opts.InstanceTypes.LaunchFailures.InstanceTypes(),
+		daemonOverheadErrs:    map[string]error{},
+		granularity:           opts.Packing.Granularity,
+		unsatisfiable:         map[string][]*scheduling.Requirement{},
+		architectures:         newArchitectureInference(opts.Pods.ArchitectureResolver),
+		profiles:              map[string]SchedulingProfile{},
+		templateExcludedZones: map[string]sets.String{},
+		diagnoses:             map[*v1.Pod]*Diagnosis{},
+		provisioners:          map[string]*v1alpha5.Provisioner{},
+		exceededLimits:        map[string]error{},
+		namespaceProvisioners: map[string]sets.String{},
+		spreadGroups:          sets.NewString(),
+		namespaceSelectors:    namespaceNodeSelectors{},
+		packing:               &packing{defaultRequests: opts.Packing.DefaultPodRequests, byLimits: opts.Packing.PackByLimits, devices: map[*v1.Pod]v1.ResourceList{}},
+	}
+	for i := range provisioners {
+		s.provisioners[provisioners[i].Name] = &provisioners[i]
+	}
+	if s.granularity == nil {
+		s.granularity = resources.DefaultGranularity
 	}
+	if s.opts.Clock == nil {
+		s.opts.Clock = clock.RealClock{}
+	}
+	if s.opts.Reporting.AuditSink == nil {
+		s.opts.Reporting.AuditSink = NopAuditSink{}
+	}
+	if s.excludedZones.Len() > 0 && !opts.SimulationMode {
+		logging.FromContext(ctx).With("zones", s.excludedZones.List()).Infof("excluding zone(s) from scheduling")
+	}
+	if s.failedInstanceTypes.Len() > 0 && !opts.SimulationMode {
+		logging.FromContext(ctx).With("instance-types", s.failedInstanceTypes.List()).Debugf("avoiding instance type(s) that recently failed to launch")
+	}
+	s.excludeMachineTemplates(ctx)
 
 	namedNodeTemplates := lo.KeyBy(s.machineTemplates, func(nodeTemplate *MachineTemplate) string {
 		return nodeTemplate.Requirements.Get(v1alpha5.ProvisionerNameLabelKey).Values()[0]
@@ -78,34 +349,119 @@ func NewScheduler(ctx context.Context, kubeClient client.Client, machines []*Mac
 		}
 	}
 
+	s.resolveProfiles(ctx)
+	s.validateDaemonOverhead(ctx, provisioners)
+	s.validateOfferings(ctx)
+
 	s.calculateExistingMachines(namedNodeTemplates, stateNodes)
 	return s
 }
 
 type Scheduler struct {
-	ctx                context.Context
-	newNodes           []*Node
-	existingNodes      []*ExistingNode
-	machineTemplates   []*MachineTemplate
-	remainingResources map[string]v1.ResourceList // provisioner name -> remaining resources for that provisioner
-	instanceTypes      map[string][]*cloudprovider.InstanceType
-	daemonOverhead     map[*MachineTemplate]v1.ResourceList
-	preferences        *Preferences
-	topology           *Topology
-	cluster            *state.Cluster
-	recorder           events.Recorder
-	opts               SchedulerOptions
-	kubeClient         client.Client
+	ctx                   context.Context
+	newNodes              []*Node
+	existingNodes         []*ExistingNode
+	machineTemplates      []*MachineTemplate
+	remainingResources    map[string]v1.ResourceList // provisioner name -> remaining resources for that provisioner
+	instanceTypes         map[string][]*cloudprovider.InstanceType
+	daemonOverhead        map[*MachineTemplate]v1.ResourceList
+	daemonPods            []*v1.Pod // a pod for each daemonset, used to determine the daemonsets that run on existing nodes
+	preferences           *Preferences
+	topology              *Topology
+	cluster               *state.Cluster
+	recorder              events.Recorder
+	opts                  SchedulerOptions
+	kubeClient            client.Client
+	tolerationCache       *scheduling.TolerationCache // shared by all new nodes, the pods in a batch commonly tolerate identically
+	excludedZones         sets.String
+	failedInstanceTypes   sets.String      // instance types that recently failed to launch, which new nodes avoid
+	daemonOverheadErrs    map[string]error // provisioner name -> error if its daemonsets don't fit on any instance type
+	granularity           map[v1.ResourceName]resource.Scale
+	unsatisfiable         map[string][]*scheduling.Requirement // pod requirements -> the combination that no provisioner provides
+	architectures         *architectureInference               // nil unless architecture inference is enabled
+	profiles              map[string]SchedulingProfile         // provisioner name -> resolved scheduling profile
+	templateExcludedZones map[string]sets.String               // provisioner name -> zones excluded globally or by its profile
+	advertisedResources   sets.String                          // resources with capacity on any instance type, computed when first needed
+	diagnoses             map[*v1.Pod]*Diagnosis               // pod -> diagnosis of its last placement attempt, if verbose
+	provisioners          map[string]*v1alpha5.Provisioner     // provisioner name -> provisioner, the object that its events are published on
+	exceededLimits        map[string]error                     // provisioner name -> error if its limits rejected a new node
+	namespaceProvisioners map[string]sets.String               // namespace -> provisioners its pods can provision from, nil if unrestricted
+	spreadGroups          sets.String                          // spread-then-pack groups that have had a pod scheduled to a new node
+	packing               *packing                             // shared by all new and existing nodes
+	namespaceSelectors    namespaceNodeSelectors               // shared by all new and existing nodes, listed when solving
 }
 
-func (s *Scheduler) Solve(ctx context.Context, pods []*v1.Pod) ([]*Node, []*ExistingNode, error) {
+// Solve schedules the pods as a single batch. The pods of a batch are solved together against the existing nodes and
+// each other's new nodes, so the larger a batch the more densely its pods can be packed, while pods that are solved in
+// separate batches can't share new nodes. Pending pods should be accumulated into batches that are each solved once,
+// e.g. with a BatchQueue, rather than solved as they arrive. The scheduler tracks the new nodes and the capacity that a
+// batch consumes on existing nodes, so each batch should be solved by a scheduler constructed from the current cluster
+// state.
+func (s *Scheduler) Solve(ctx context.Context, pods []*v1.Pod) (newNodes []*Node, existingNodes []*ExistingNode, err error) {
+	ctx, span := tracer().Start(ctx, "Scheduler.Solve", trace.WithAttributes(attribute.Int(podsAttribute, len(pods))))
+	defer func() { endSpan(span, err) }()
+	if s.opts.Frozen {
+		s.recordFrozen(ctx, pods)
+		return nil, nil, nil
+	}
+	if len(s.machineTemplates) == 0 {
+		return nil, nil, ErrNoProvisioners
+	}
+	pods = s.releasedPods(ctx, pods)
+	pods = s.includedPods(ctx, pods)
+	s.listNamespaceNodeSelectors(ctx, pods)
+	s.resolveDeviceRequests(ctx, pods)
+	errors := map[*v1.Pod]error{}
+	relaxations := map[*v1.Pod]int{}
+	// The pods of each pod group are scheduled before the other pods, so that the capacity for a group that can't be
+	// scheduled in full is released before the other pods are scheduled
+	groups, ungrouped := podGroups(pods)
+	var failedToSchedule []*v1.Pod
+	for _, group := range groups {
+		failedToSchedule = append(failedToSchedule, s.solveGroup(ctx, group, errors, relaxations)...)
+	}
+	failedToSchedule = append(failedToSchedule, s.solveQueue(ctx, NewQueue(ungrouped...), errors, relaxations)...)
+
+	var counts map[string]map[string]int
+	for _, n := range s.newNodes {
+		avoidExpiringReservations(n, s.opts.Clock.Now(), s.opts.InstanceTypes.ReservationExpiryWindow)
+		reserveCapacity(n, s.opts.InstanceTypes.ConsolidationReserve)
+		if s.opts.Zones.CrossZonePenalty > 0 {
+			preferDataZone(n, s.opts.Zones.CrossZonePenalty, lo.Ternary(s.opts.InstanceTypes.CostFunc != nil, s.opts.InstanceTypes.CostFunc, offeringPrice))
+		}
+		if s.profiles[n.ProvisionerName].BalanceZones {
+			if counts == nil {
+				counts = zoneCounts(s.cluster)
+			}
+			balanceZone(n, counts)
+		}
+		n.FinalizeScheduling(s.profiles[n.ProvisionerName].InstanceTypePreferences, s.opts.InstanceTypes.CostFunc)
+	}
+	if s.opts.InstanceTypes.MaxDistinctInstanceTypes > 0 {
+		limitDistinctInstanceTypes(s.newNodes, s.opts.InstanceTypes.MaxDistinctInstanceTypes)
+	}
+	if !s.opts.SimulationMode {
+		for _, n := range s.newNodes {
+			instanceTypeOptionsHistogram.WithLabelValues(n.ProvisionerName).Observe(float64(len(n.InstanceTypeOptions)))
+		}
+	}
+	diversifyInstanceTypes(lo.Filter(s.newNodes, func(n *Node, _ int) bool { return s.profiles[n.ProvisionerName].DiversifyInstanceTypes }))
+	if !s.opts.SimulationMode {
+		s.recordSchedulingResults(ctx, pods, failedToSchedule, errors, relaxations)
+		s.recordProvisionerStats()
+		s.recordLimitUsage()
+	}
+	span.SetAttributes(attribute.Int(newNodesAttribute, len(s.newNodes)), attribute.Int(failedPodsAttribute, len(failedToSchedule)))
+	return s.newNodes, s.existingNodes, nil
+}
+
+// solveQueue schedules the pods in the queue and returns those that couldn't be scheduled
+func (s *Scheduler) solveQueue(ctx context.Context, q *Queue, errors map[*v1.Pod]error, relaxations map[*v1.Pod]int) []*v1.Pod {
 	// We loop trying to schedule unschedulable pods as long as we are making progress.  This solves a few
 	// issues including pods with affinity to another pod in the batch. We could topo-sort to solve this, but it wouldn't
 	// solve the problem of scheduling pods where a particular order is needed to prevent a max-skew violation. E.g. if we
 	// had 5xA pods and 5xB pods were they have a zonal topology spread, but A can only go in one zone and B in another.
 	// We need to schedule them alternating, A, B, A, B, .... and this solution also solves that as well.
-	errors := map[*v1.Pod]error{}
-	q := NewQueue(pods...)
 	for {
 		// Try the next pod
 		pod, ok := q.Pop()
@@ -114,39 +470,102 @@ func (s *Scheduler) Solve(ctx context.Context, pods []*v1.Pod) ([]*Node, []*Exis
 		}
 
 		// Schedule to existing nodes or create a new node
-		if errors[pod] = s.add(ctx, pod); errors[pod] == nil {
+		diagnosis := s.newDiagnosis(pod)
+		if errors[pod] = s.add(ctx, pod, diagnosis); errors[pod] == nil {
 			continue
 		}
 
 		// If unsuccessful, relax the pod and recompute topology
-		relaxed := s.preferences.Relax(ctx, pod)
+		kind, relaxed := s.preferences.Relax(ctx, pod)
 		q.Push(pod, relaxed)
 		if relaxed {
+			if diagnosis != nil {
+				diagnosis.Relaxations = append(diagnosis.Relaxations, kind)
+			}
+			// simulations relax the same pods repeatedly, so only preferences relaxed while provisioning are counted
+			if !s.opts.SimulationMode {
+				relaxationsCounter.WithLabelValues(kind).Inc()
+			}
+			relaxations[pod]++
 			if err := s.topology.Update(ctx, pod); err != nil {
 				logging.FromContext(ctx).Errorf("updating topology, %s", err)
 			}
 		}
 	}
 
-	for _, n := range s.newNodes {
-		n.FinalizeScheduling()
+	return q.List()
+}
+
+// recordFrozen reports a solve that left the pods pending since scheduling is frozen
+func (s *Scheduler) recordFrozen(ctx context.Context, pods []*v1.Pod) {
+	if s.opts.SimulationMode {
+		return
 	}
-	if !s.opts.SimulationMode {
-		s.recordSchedulingResults(ctx, pods, q.List(), errors)
+	logging.FromContext(ctx).With("pods", len(pods)).Infof("scheduling is frozen, leaving pod(s) pending")
+	if s.opts.Reporting.DecisionEventObject != nil {
+		s.recorder.Publish(events.SchedulingFrozen(s.opts.Reporting.DecisionEventObject, len(pods)))
 	}
-	return s.newNodes, s.existingNodes, nil
 }
 
-func (s *Scheduler) recordSchedulingResults(ctx context.Context, pods []*v1.Pod, failedToSchedule []*v1.Pod, errors map[*v1.Pod]error) {
-	// Report failures and nominations
+// releasedPods filters out the pods that are held by the hold annotation
+func (s *Scheduler) releasedPods(ctx context.Context, pods []*v1.Pod) []*v1.Pod {
+	if s.opts.Pods.HoldAnnotation == "" {
+		return pods
+	}
+	released := lo.Reject(pods, func(pod *v1.Pod, _ int) bool {
+		_, held := pod.Annotations[s.opts.Pods.HoldAnnotation]
+		return held
+	})
+	if len(released) != len(pods) && !s.opts.SimulationMode {
+		logging.FromContext(ctx).With("annotation", s.opts.Pods.HoldAnnotation).Debugf("holding %d pod(s)", len(pods)-len(released))
+	}
+	return released
+}
+
+func (s *Scheduler) recordSchedulingResults(ctx context.Context, pods []*v1.Pod, failedToSchedule []*v1.Pod, errors map[*v1.Pod]error,
+	relaxations map[*v1.Pod]int) {
+	// Report failures and nominations, the highest priority pods first so that their failures stand out
+	failedToSchedule = append([]*v1.Pod{}, failedToSchedule...)
+	sort.SliceStable(failedToSchedule, func(i, j int) bool {
+		return lo.FromPtr(failedToSchedule[i].Spec.Priority) > lo.FromPtr(failedToSchedule[j].Spec.Priority)
+	})
+	failureReasons := map[string]int{}
+	var auditFailures []AuditFailure
 	for _, pod := range failedToSchedule {
-		logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).Errorf("Could not schedule pod, %s", errors[pod])
-		s.recorder.Publish(events.PodFailedToSchedule(pod, errors[pod]))
+		failure := s.newSchedulingFailure(pod, relaxations[pod])
+		unknownResources, unsatisfiableRequirements, mutualConflict := s.unknownResourcesError(pod), s.unsatisfiableRequirementsError(pod), mutualConflictError(pod, failedToSchedule)
+		reason := failureReason(failure, unknownResources, unsatisfiableRequirements, mutualConflict, errors[pod])
+		failureReasons[reason]++
+		err := multierr.Combine(unknownResources, unsatisfiableRequirements, mutualConflict, errors[pod])
+		auditFailures = append(auditFailures, AuditFailure{
+			Pod:          client.ObjectKeyFromObject(pod).String(),
+			Provisioners: failure.provisioners,
+			Reason:       reason,
+			Error:        fmt.Sprint(err),
+		})
+		logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).With(failure.keysAndValues()...).Errorf("Could not schedule pod, %s", err)
+		if diagnosis := s.diagnoses[pod]; diagnosis != nil {
+			logging.FromContext(ctx).With("pod", client.ObjectKeyFromObject(pod)).Debugf("%s", diagnosis)
+		}
+		evt := events.PodFailedToSchedule(pod, err)
+		if isPodTooLarge(errors[pod]) {
+			evt = events.PodTooLargeToSchedule(pod, err)
+		}
+		evt.Annotations = failure.annotations()
+		s.recorder.Publish(evt)
+	}
+	s.recordDecision(failureReasons)
+	s.recordAudit(ctx, auditFailures)
+	s.recordInstanceTypeStability()
+	for provisionerName, err := range s.exceededLimits {
+		if provisioner, ok := s.provisioners[provisionerName]; ok {
+			s.recorder.Publish(events.ProvisionerLimitsExceeded(provisioner, err))
+		}
 	}
 
 	for _, node := range s.existingNodes {
 		if len(node.Pods) > 0 {
-			s.cluster.NominateNodeForPod(node.Node.Name)
+			s.cluster.NominateNodeForPod(node.Node.Name, node.Pods...)
 		}
 		for _, pod := range node.Pods {
 			s.recorder.Publish(events.NominatePod(pod, node.Node))
@@ -176,33 +595,194 @@ func (s *Scheduler) recordSchedulingResults(ctx context.Context, pods []*v1.Pod,
 	logging.FromContext(ctx).Infof("computed %d unready node(s) will fit %d pod(s)", inflightCount, existingCount)
 }
 
-func (s *Scheduler) add(ctx context.Context, pod *v1.Pod) error {
-	// first try to schedule against an in-flight real node
-	for _, node := range s.existingNodes {
-		if err := node.Add(ctx, pod); err == nil {
-			return nil
+// schedulingFailure holds the details of a pod that couldn't be scheduled which are reported as structured fields so
+// that failures can be correlated without parsing the error message
+type schedulingFailure struct {
+	// provisioners are the names of the provisioners that were considered for launching a new node
+	provisioners []string
+	// shortfall is the amount of each resource by which the pod's requests exceed what the largest instance type of
+	// each provisioner can offer. Provisioners with an instance type large enough for the pod aren't included.
+	shortfall map[string]v1.ResourceList
+	// relaxations is the number of preferences that were relaxed before we gave up on scheduling the pod
+	relaxations int
+	// priority and priorityClassName are the pod's, so that failures of important pods can be triaged first
+	priority          int32
+	priorityClassName string
+}
+
+func (s *Scheduler) newSchedulingFailure(pod *v1.Pod, relaxations int) schedulingFailure {
+	failure := schedulingFailure{
+		shortfall:         map[string]v1.ResourceList{},
+		relaxations:       relaxations,
+		priority:          lo.FromPtr(pod.Spec.Priority),
+		priorityClassName: pod.Spec.PriorityClassName,
+	}
+	requests := s.packing.requests(pod)
+	for _, nodeTemplate := range s.machineTemplates {
+		failure.provisioners = append(failure.provisioners, nodeTemplate.ProvisionerName)
+		if shortfall := s.resourceShortfall(nodeTemplate, requests); len(shortfall) > 0 {
+			failure.shortfall[nodeTemplate.ProvisionerName] = shortfall
 		}
 	}
+	return failure
+}
 
-	// Consider using https://pkg.go.dev/container/heap
-	sort.Slice(s.newNodes, func(a, b int) bool { return len(s.newNodes[a].Pods) < len(s.newNodes[b].Pods) })
+// resourceShortfall returns the resources by which the requests exceed the largest allocatable amount of the
+// provisioner's instance types once daemonset overhead is accounted for
+func (s *Scheduler) resourceShortfall(nodeTemplate *MachineTemplate, requests v1.ResourceList) v1.ResourceList {
+	var allocatable []v1.ResourceList
+	for _, it := range s.instanceTypes[nodeTemplate.ProvisionerName] {
+		allocatable = append(allocatable, resources.Subtract(it.Capacity, it.Overhead.Total()))
+	}
+	required := resources.Merge(requests, s.daemonOverhead[nodeTemplate])
+	shortfall := v1.ResourceList{}
+	for resourceName, quantity := range resources.Subtract(required, resources.MaxResources(allocatable...)) {
+		if quantity.Sign() > 0 {
+			shortfall[resourceName] = quantity
+		}
+	}
+	return shortfall
+}
 
-	// Pick existing node that we are about to create
-	for _, node := range s.newNodes {
-		if err := node.Add(ctx, pod); err == nil {
-			return nil
+// tooLargeError returns ErrPodTooLarge if the pod's requests exceed the largest instance type of every provisioner that
+// could launch a node, the provisioners whose daemonset overhead alone exceeds their instance types aren't considered.
+// Pods that request resources which no instance type advertises are reported as such instead.
+func (s *Scheduler) tooLargeError(pod *v1.Pod) error {
+	if s.unknownResourcesError(pod) != nil {
+		return nil
+	}
+	requests := s.packing.requests(pod)
+	nodeTemplates := lo.Reject(s.machineTemplates, func(nodeTemplate *MachineTemplate, _ int) bool {
+		_, ok := s.daemonOverheadErrs[nodeTemplate.ProvisionerName]
+		return ok
+	})
+	if len(nodeTemplates) == 0 || lo.ContainsBy(nodeTemplates, func(nodeTemplate *MachineTemplate) bool {
+		return len(s.resourceShortfall(nodeTemplate, requests)) == 0
+	}) {
+		return nil
+	}
+	return fmt.Errorf("%w, requested %s", ErrPodTooLarge, resources.String(requests))
+}
+
+func isPodTooLarge(err error) bool {
+	return errors.Is(err, ErrPodTooLarge)
+}
+
+func (f schedulingFailure) keysAndValues() []interface{} {
+	keysAndValues := []interface{}{"provisioners", f.provisioners, "relaxations", f.relaxations, "priority", f.priority}
+	if f.priorityClassName != "" {
+		keysAndValues = append(keysAndValues, "priorityClassName", f.priorityClassName)
+	}
+	if len(f.shortfall) > 0 {
+		shortfall := map[string]map[string]string{}
+		for provisionerName, resourceList := range f.shortfall {
+			shortfall[provisionerName] = resources.StringMap(resourceList)
+		}
+		keysAndValues = append(keysAndValues, "shortfall", shortfall)
+	}
+	return keysAndValues
+}
+
+func (f schedulingFailure) annotations() map[string]string {
+	annotations := map[string]string{
+		"provisioners": strings.Join(f.provisioners, ","),
+		"relaxations":  strconv.Itoa(f.relaxations),
+		"priority":     strconv.Itoa(int(f.priority)),
+	}
+	if f.priorityClassName != "" {
+		annotations["priorityClassName"] = f.priorityClassName
+	}
+	if len(f.shortfall) > 0 {
+		var shortfall []string
+		for _, provisionerName := range lo.Keys(f.shortfall) {
+			shortfall = append(shortfall, fmt.Sprintf("%s=%s", provisionerName, resources.String(f.shortfall[provisionerName])))
+		}
+		sort.Strings(shortfall)
+		annotations["shortfall"] = strings.Join(shortfall, ";")
+	}
+	return annotations
+}
+
+// add schedules the pod to an existing node, a node that will be launched, or a new node. The reason that each
+// candidate rejected the pod is recorded on the diagnosis if it's non-nil.
+func (s *Scheduler) add(ctx context.Context, pod *v1.Pod, diagnosis *Diagnosis) (err error) {
+	ctx, span := tracer().Start(ctx, "Scheduler.add", trace.WithAttributes(attribute.String(podAttribute, client.ObjectKeyFromObject(pod).String())))
+	defer func() { endSpan(span, err) }()
+	allowed, err := s.allowedProvisioners(ctx, pod)
+	if err != nil {
+		return err
+	}
+	// pods that require a dedicated node, and the first pod of each spread-then-pack group, skip straight to creating a
+	// new node
+	spreadGroup := podutils.SpreadThenPackGroup(pod)
+	spread := spreadGroup != "" && !s.spreadGroups.Has(spreadGroup)
+	if !podutils.HasDedicatedNode(pod) && !spread {
+		// first try to schedule against an in-flight real node
+		existingNodes := s.existingNodes
+		if s.opts.ExistingNodes.ScoreExistingNode != nil {
+			existingNodes = scoreExistingNodes(existingNodes, pod, s.opts.ExistingNodes.ScoreExistingNode)
+		}
+		// the node that the pod prefers is tried first, falling back to the others if it doesn't fit
+		existingNodes = preferredNodeFirst(existingNodes, pod)
+		for _, node := range existingNodes {
+			if err := namespaceAllows(allowed, pod.Namespace, node.Node.Labels[v1alpha5.ProvisionerNameLabelKey]); err != nil {
+				diagnosis.rejectExistingNode(node, pod, err)
+				continue
+			}
+			err := node.Add(ctx, pod)
+			if err == nil {
+				diagnosis.schedule(node.Node.Name)
+				return nil
+			}
+			diagnosis.rejectExistingNode(node, pod, err)
+		}
+
+		// Consider using https://pkg.go.dev/container/heap
+		sort.Slice(s.newNodes, func(a, b int) bool { return len(s.newNodes[a].Pods) < len(s.newNodes[b].Pods) })
+
+		// Pick existing node that we are about to create
+		for _, node := range s.newNodes {
+			if err := namespaceAllows(allowed, pod.Namespace, node.ProvisionerName); err != nil {
+				diagnosis.rejectNewNode(node.ProvisionerName, err)
+				continue
+			}
+			err := s.addToNewNode(ctx, node, pod)
+			if err == nil {
+				diagnosis.schedule(node.ProvisionerName)
+				return nil
+			}
+			diagnosis.rejectNewNode(node.ProvisionerName, err)
 		}
 	}
 
 	// Create new node
+	if err := s.tooLargeError(pod); err != nil {
+		return err
+	}
+	if s.opts.MaxNewNodes > 0 && len(s.newNodes) >= s.opts.MaxNewNodes {
+		return fmt.Errorf("%w, at most %d new node(s) can be created per batch", ErrMaxNewNodesExceeded, s.opts.MaxNewNodes)
+	}
 	var errs error
 	for _, nodeTemplate := range s.machineTemplates {
+		if err := namespaceAllows(allowed, pod.Namespace, nodeTemplate.ProvisionerName); err != nil {
+			diagnosis.rejectProvisioner(nodeTemplate.ProvisionerName, err)
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if err, ok := s.daemonOverheadErrs[nodeTemplate.ProvisionerName]; ok {
+			diagnosis.rejectProvisioner(nodeTemplate.ProvisionerName, err)
+			errs = multierr.Append(errs, err)
+			continue
+		}
 		instanceTypes := s.instanceTypes[nodeTemplate.ProvisionerName]
 		// if limits have been applied to the provisioner, ensure we filter instance types to avoid violating those limits
 		if remaining, ok := s.remainingResources[nodeTemplate.ProvisionerName]; ok {
-			instanceTypes = filterByRemainingResources(s.instanceTypes[nodeTemplate.ProvisionerName], remaining)
+			instanceTypes = filterByRemainingResources(s.instanceTypes[nodeTemplate.ProvisionerName], remaining, s.opts.InstanceTypes.ExclusiveLimits)
 			if len(instanceTypes) == 0 {
-				errs = multierr.Append(errs, fmt.Errorf("all available instance types exceed provisioner limits"))
+				err := rejectedBy(PredicateLimits, fmt.Errorf("all available instance types exceed provisioner limits"))
+				s.exceededLimits[nodeTemplate.ProvisionerName] = err
+				diagnosis.rejectProvisioner(nodeTemplate.ProvisionerName, err)
+				errs = multierr.Append(errs, err)
 				continue
 			} else if len(s.instanceTypes[nodeTemplate.ProvisionerName]) != len(instanceTypes) && !s.opts.SimulationMode {
 				logging.FromContext(ctx).Debugf("%d out of %d instance types were excluded because they would breach provisioner limits",
@@ -210,19 +790,121 @@ func (s *Scheduler) add(ctx context.Context, pod *v1.Pod) error {
 			}
 		}
 
-		node := NewNode(nodeTemplate, s.topology, s.daemonOverhead[nodeTemplate], instanceTypes)
-		if err := node.Add(ctx, pod); err != nil {
+		nodeCtx, nodeSpan := tracer().Start(ctx, "Scheduler.newNode", trace.WithAttributes(attribute.String(provisionerAttribute, nodeTemplate.ProvisionerName)))
+		node := NewNode(nodeTemplate, s.topology, s.daemonOverhead[nodeTemplate], instanceTypes, nodeOptions{
+			tolerationCache:     s.tolerationCache,
+			excludedZones:       s.templateExcludedZones[nodeTemplate.ProvisionerName],
+			failedInstanceTypes: s.failedInstanceTypes,
+			granularity:         s.granularity,
+			packing:             s.packing,
+			architectures:       s.architectures,
+			namespaceSelectors:  s.namespaceSelectors,
+		})
+		err := s.addToNewNode(nodeCtx, node, pod)
+		endSpan(nodeSpan, err)
+		if err != nil {
+			diagnosis.rejectProvisioner(nodeTemplate.ProvisionerName, err)
 			errs = multierr.Append(errs, fmt.Errorf("incompatible with provisioner %q, %w", nodeTemplate.ProvisionerName, err))
 			continue
 		}
 		// we will launch this node and need to track its maximum possible resource usage against our remaining resources
 		s.newNodes = append(s.newNodes, node)
-		s.remainingResources[nodeTemplate.ProvisionerName] = subtractMax(s.remainingResources[nodeTemplate.ProvisionerName], node.InstanceTypeOptions)
+		s.remainingResources[nodeTemplate.ProvisionerName] = subtractMax(s.remainingResources[nodeTemplate.ProvisionerName], node.InstanceTypeOptions, s.granularity)
+		if spreadGroup != "" {
+			s.spreadGroups.Insert(spreadGroup)
+		}
+		diagnosis.schedule(nodeTemplate.ProvisionerName)
 		return nil
 	}
 	return errs
 }
 
+// addToNewNode adds the pod to the new node and gives OnPlacement the chance to veto the placement, restoring the
+// node and the topology to their state before the pod was added if it does
+func (s *Scheduler) addToNewNode(ctx context.Context, node *Node, pod *v1.Pod) error {
+	if s.opts.OnPlacement == nil {
+		return node.Add(ctx, pod)
+	}
+	state := *node
+	state.hostPortUsage = node.hostPortUsage.DeepCopy()
+	restoreTopology := s.topology.checkpoint()
+	if err := node.Add(ctx, pod); err != nil {
+		return err
+	}
+	if err := s.opts.OnPlacement(pod, node); err != nil {
+		*node = state
+		restoreTopology()
+		return rejectedBy(PredicatePlacement, fmt.Errorf("placement vetoed, %w", err))
+	}
+	return nil
+}
+
+// validateDaemonOverhead identifies the provisioners whose daemonset overhead alone exceeds the capacity of all of
+// their instance types. No pod can be scheduled to a new node for these provisioners, so we report a dedicated error
+// instead of failing every pod with an instance type mismatch.
+func (s *Scheduler) validateDaemonOverhead(ctx context.Context, provisioners []v1alpha5.Provisioner) {
+	for _, nodeTemplate := range s.machineTemplates {
+		instanceTypes := s.instanceTypes[nodeTemplate.ProvisionerName]
+		overhead := s.daemonOverhead[nodeTemplate]
+		if len(instanceTypes) == 0 || lo.ContainsBy(instanceTypes, func(it *cloudprovider.InstanceType) bool { return fits(it, overhead, 0, s.granularity) }) {
+			continue
+		}
+		err := fmt.Errorf("daemonset overhead %s exceeds all instance types for provisioner %q", resources.String(overhead), nodeTemplate.ProvisionerName)
+		s.daemonOverheadErrs[nodeTemplate.ProvisionerName] = rejectedBy(PredicateDaemonOverhead, err)
+		if s.opts.SimulationMode {
+			continue
+		}
+		logging.FromContext(ctx).With("provisioner", nodeTemplate.ProvisionerName).Errorf("%s, reduce the daemonset requests or allow larger instance types", err)
+		for i := range provisioners {
+			if provisioners[i].Name == nodeTemplate.ProvisionerName {
+				s.recorder.Publish(events.ProvisionerDaemonSetOverheadExceeded(&provisioners[i], overhead))
+			}
+		}
+	}
+}
+
+// validateOfferings counts the instance types of each provisioner that have no available offerings. They can never be
+// launched, which usually means that the cloud provider is returning capacity that isn't available anywhere.
+func (s *Scheduler) validateOfferings(ctx context.Context) {
+	if s.opts.SimulationMode {
+		return
+	}
+	for _, nodeTemplate := range s.machineTemplates {
+		instanceTypes := s.instanceTypes[nodeTemplate.ProvisionerName]
+		unavailable := lo.Filter(instanceTypes, func(it *cloudprovider.InstanceType, _ int) bool {
+			return len(it.Offerings.Available()) == 0
+		})
+		unavailableInstanceTypesGauge.WithLabelValues(nodeTemplate.ProvisionerName).Set(float64(len(unavailable)))
+		if provisioner, ok := s.provisioners[nodeTemplate.ProvisionerName]; ok && len(unavailable) == len(instanceTypes) {
+			s.recorder.Publish(events.ProvisionerNoViableInstanceTypes(provisioner, len(instanceTypes)))
+		}
+		if len(unavailable) > 0 {
+			logging.FromContext(ctx).With("provisioner", nodeTemplate.ProvisionerName).Debugf("%d out of %d instance types have no available offerings, %s",
+				len(unavailable), len(instanceTypes), strings.Join(lo.Map(unavailable, func(it *cloudprovider.InstanceType, _ int) string { return it.Name }), ", "))
+		}
+	}
+}
+
+// excludeMachineTemplates removes the machine templates whose labels and requirements match the exclusion selector,
+// so that no new nodes are launched from them and their existing nodes aren't scheduled to
+func (s *Scheduler) excludeMachineTemplates(ctx context.Context) {
+	var excluded []string
+	s.machineTemplates = lo.Filter(s.machineTemplates, func(nodeTemplate *MachineTemplate, _ int) bool {
+		if s.isExcluded(lo.Assign(nodeTemplate.Labels, nodeTemplate.Requirements.Labels())) {
+			excluded = append(excluded, nodeTemplate.ProvisionerName)
+			return false
+		}
+		return true
+	})
+	if len(excluded) > 0 && !s.opts.SimulationMode {
+		logging.FromContext(ctx).With("provisioners", excluded, "selector", s.opts.ExclusionSelector.String()).Infof("excluding provisioner(s) from scheduling")
+	}
+}
+
+func (s *Scheduler) isExcluded(nodeLabels map[string]string) bool {
+	return s.opts.ExclusionSelector != nil && !s.opts.ExclusionSelector.Empty() && s.opts.ExclusionSelector.Matches(labels.Set(nodeLabels))
+}
+
 func (s *Scheduler) calculateExistingMachines(namedNodeTemplates map[string]*MachineTemplate, stateNodes []*state.Node) {
 	// create our existing nodes
 	for _, node := range stateNodes {
@@ -236,20 +918,77 @@ func (s *Scheduler) calculateExistingMachines(namedNodeTemplates map[string]*Mac
 			// ignoring this node as it wasn't launched by a provisioner that we recognize
 			continue
 		}
-		s.existingNodes = append(s.existingNodes, NewExistingNode(node, s.topology, nodeTemplate.StartupTaints, s.daemonOverhead[nodeTemplate]))
+		if !s.isExcluded(node.Node.Labels) {
+			// the capacity of pods that have been terminating for longer than the grace period is assumed to be free
+			if s.opts.ExistingNodes.TerminatingPodGracePeriod > 0 {
+				if requests := node.TerminatingPodRequests(s.opts.Clock.Now().Add(-s.opts.ExistingNodes.TerminatingPodGracePeriod)); len(requests) != 0 {
+					withoutTerminatingPods := *node
+					withoutTerminatingPods.Available = resources.Merge(node.Available, requests)
+					node = &withoutTerminatingPods
+				}
+			}
+			startupTaints := nodeTemplate.StartupTaints
+			// a node whose startup taints linger past the grace period may be stuck initializing
+			if s.opts.ExistingNodes.StartupTaintGracePeriod > 0 && s.opts.Clock.Since(node.Node.CreationTimestamp.Time) > s.opts.ExistingNodes.StartupTaintGracePeriod {
+				startupTaints = nil
+			}
+			daemonResources := s.existingNodeDaemonOverhead(node.Node, nodeTemplate)
+			// an initialized node's available capacity already accounts for the daemon pods that run on it
+			if s.opts.ExistingNodes.LiveExistingNodeCapacity && node.Node.Labels[v1alpha5.LabelNodeInitialized] == "true" {
+				daemonResources = nil
+			}
+			s.existingNodes = append(s.existingNodes, NewExistingNode(node, s.topology, startupTaints, daemonResources,
+				s.packing, s.namespaceSelectors, s.opts.ExistingNodes.CordonLabels))
+		}
 
 		// We don't use the status field and instead recompute the remaining resources to ensure we have a consistent view
 		// of the cluster during scheduling.  Depending on how node creation falls out, this will also work for cases where
 		// we don't create Node resources.
 		s.remainingResources[name] = resources.Subtract(s.remainingResources[name], node.Capacity)
 	}
+	// Capacity that is still being launched isn't tracked as a node yet, but it will count against the limits once it is
+	for name, reserved := range s.cluster.Reserved() {
+		if _, ok := s.remainingResources[name]; ok {
+			s.remainingResources[name] = resources.Subtract(s.remainingResources[name], reserved)
+		}
+	}
+}
+
+// existingNodeDaemonOverhead returns the requests of the daemonsets that are compatible with the node's current labels
+// and taints. These can diverge from the machine template that the node was launched from, e.g. if the node is
+// relabeled after it's created, in which case the template's daemon overhead would reserve capacity on the node for
+// daemonsets that won't run on it. The template's overhead is used if the daemonsets aren't known.
+func (s *Scheduler) existingNodeDaemonOverhead(node *v1.Node, nodeTemplate *MachineTemplate) v1.ResourceList {
+	if s.daemonPods == nil {
+		return s.daemonOverhead[nodeTemplate]
+	}
+	requirements := scheduling.NewLabelRequirements(node.Labels)
+	// startup and ephemeral taints are removed from the node, so the daemonsets that don't tolerate them still run on it
+	ignoredTaints := append(append([]v1.Taint{}, ephemeralTaints...), nodeTemplate.StartupTaints...)
+	taints := scheduling.Taints(lo.Reject(node.Spec.Taints, func(taint v1.Taint, _ int) bool {
+		return lo.ContainsBy(ignoredTaints, func(t v1.Taint) bool {
+			return t.Key == taint.Key && t.Value == taint.Value && t.Effect == taint.Effect
+		})
+	}))
+	var daemons []*v1.Pod
+	for _, p := range s.daemonPods {
+		if err := taints.Tolerates(p); err != nil {
+			continue
+		}
+		if err := requirements.Compatible(scheduling.NewPodRequirements(p)); err != nil {
+			continue
+		}
+		daemons = append(daemons, p)
+	}
+	return resources.RequestsForPods(daemons...)
 }
 
 // subtractMax returns the remaining resources after subtracting the max resource quantity per instance type. To avoid
 // overshooting out, we need to pessimistically assume that if e.g. we request a 2, 4 or 8 CPU instance type
 // that the 8 CPU instance type is all that will be available.  This could cause a batch of pods to take multiple rounds
-// to schedule.
-func subtractMax(remaining v1.ResourceList, instanceTypes []*cloudprovider.InstanceType) v1.ResourceList {
+// to schedule. The capacity counts against the limits like the requests do when checking if pods fit, so it's rounded
+// up to the granularity, while the remaining resources are left unrounded.
+func subtractMax(remaining v1.ResourceList, instanceTypes []*cloudprovider.InstanceType, granularity map[v1.ResourceName]resource.Scale) v1.ResourceList {
 	// shouldn't occur, but to be safe
 	if len(instanceTypes) == 0 {
 		return remaining
@@ -259,7 +998,7 @@ func subtractMax(remaining v1.ResourceList, instanceTypes []*cloudprovider.Insta
 		allInstanceResources = append(allInstanceResources, it.Capacity)
 	}
 	result := v1.ResourceList{}
-	itResources := resources.MaxResources(allInstanceResources...)
+	itResources := resources.RoundUp(resources.MaxResources(allInstanceResources...), granularity)
 	for k, v := range remaining {
 		cp := v.DeepCopy()
 		cp.Sub(itResources[k])
@@ -268,15 +1007,17 @@ func subtractMax(remaining v1.ResourceList, instanceTypes []*cloudprovider.Insta
 	return result
 }
 
-// filterByRemainingResources is used to filter out instance types that if launched would exceed the provisioner limits
-func filterByRemainingResources(instanceTypes []*cloudprovider.InstanceType, remaining v1.ResourceList) []*cloudprovider.InstanceType {
+// filterByRemainingResources is used to filter out instance types that if launched would exceed the provisioner limits.
+// If the limits are exclusive, instance types that would exactly meet them are also filtered out.
+func filterByRemainingResources(instanceTypes []*cloudprovider.InstanceType, remaining v1.ResourceList, exclusive bool) []*cloudprovider.InstanceType {
 	var filtered []*cloudprovider.InstanceType
 	for _, it := range instanceTypes {
 		itResources := it.Capacity
 		viableInstance := true
 		for resourceName, remainingQuantity := range remaining {
-			// if the instance capacity is greater than the remaining quantity for this resource
-			if resources.Cmp(itResources[resourceName], remainingQuantity) > 0 {
+			// if the instance capacity is greater than the remaining quantity for this resource, or equal to it if the
+			// limits are exclusive
+			if cmp := resources.Cmp(itResources[resourceName], remainingQuantity); cmp > 0 || (exclusive && cmp == 0) {
 				viableInstance = false
 			}
 		}
@@ -32,10 +32,12 @@ import (
 	"k8s.io/utils/clock"
 
 	"github.com/aws/karpenter-core/pkg/apis/config/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
 	"github.com/aws/karpenter-core/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter-core/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter-core/pkg/controllers/state"
+	pscheduling "github.com/aws/karpenter-core/pkg/scheduling"
 	"github.com/aws/karpenter-core/pkg/test"
 
 	"go.uber.org/zap"
@@ -73,6 +75,51 @@ func BenchmarkScheduling5000(b *testing.B) {
 	benchmarkScheduler(b, 400, 5000)
 }
 
+// BenchmarkSolveSynthetic measures Solve throughput against generated pods with a mix of topology spread constraints
+// and pod affinities
+func BenchmarkSolveSynthetic(b *testing.B) {
+	for _, podCount := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("%d pods", podCount), func(b *testing.B) {
+			benchmarkSolve(b, 400, scheduling.SyntheticPods(scheduling.SyntheticPodSpec{
+				Count:                     podCount,
+				TopologySpreadProbability: 0.3,
+				PodAffinityProbability:    0.2,
+				Seed:                      42,
+			}))
+		})
+	}
+}
+
+// BenchmarkExistingNodeStrategies compares the existing node scorers by scheduling the same workloads against the same
+// partially utilized existing nodes, reporting the new nodes launched and the utilization of the existing nodes
+// go test -tags=test_performance -run=^$ -bench=ExistingNodeStrategies
+func BenchmarkExistingNodeStrategies(b *testing.B) {
+	strategies := []struct {
+		name   string
+		scorer scheduling.ExistingNodeScorer
+	}{
+		{name: "first-fit"},
+		{name: "best-fit", scorer: scheduling.LeastFragmenting},
+		{name: "worst-fit", scorer: scheduling.LeastUtilized},
+	}
+	workloads := []struct {
+		name string
+		spec scheduling.SyntheticPodSpec
+	}{
+		{name: "small pods", spec: scheduling.SyntheticPodSpec{Count: 1000, Seed: 42}},
+		{name: "large pods", spec: scheduling.SyntheticPodSpec{Count: 200, Seed: 42, CPU: scheduling.QuantityRange{
+			Min: resource.MustParse("1"), Max: resource.MustParse("6"),
+		}}},
+	}
+	for _, workload := range workloads {
+		for _, strategy := range strategies {
+			b.Run(fmt.Sprintf("%s/%s", workload.name, strategy.name), func(b *testing.B) {
+				benchmarkExistingNodeStrategy(b, scheduling.SyntheticPods(workload.spec), strategy.scorer)
+			})
+		}
+	}
+}
+
 // TestSchedulingProfile is used to gather profiling metrics, benchmarking is primarily done with standard
 // Go benchmark functions
 // go test -tags=test_performance -run=SchedulingProfile
@@ -111,6 +158,10 @@ func TestSchedulingProfile(t *testing.T) {
 }
 
 func benchmarkScheduler(b *testing.B, instanceCount, podCount int) {
+	benchmarkSolve(b, instanceCount, makeDiversePods(podCount))
+}
+
+func benchmarkSolve(b *testing.B, instanceCount int, pods []*v1.Pod) {
 	// disable logging
 	ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
 	ctx = settings.ToContext(ctx, test.Settings())
@@ -121,12 +172,10 @@ func benchmarkScheduler(b *testing.B, instanceCount, podCount int) {
 	cloudProv.InstanceTypes = instanceTypes
 	scheduler := scheduling.NewScheduler(ctx, nil, []*scheduling.MachineTemplate{scheduling.NewMachineTemplate(provisioner)},
 		nil, state.NewCluster(ctx, &clock.RealClock{}, nil, cloudProv), nil, &scheduling.Topology{},
-		map[string][]*cloudprovider.InstanceType{provisioner.Name: instanceTypes}, map[*scheduling.MachineTemplate]v1.ResourceList{},
+		map[string][]*cloudprovider.InstanceType{provisioner.Name: instanceTypes}, map[*scheduling.MachineTemplate]v1.ResourceList{}, nil,
 		test.NewEventRecorder(),
 		scheduling.SchedulerOptions{})
 
-	pods := makeDiversePods(podCount)
-
 	b.ResetTimer()
 	// Pack benchmark
 	start := time.Now()
@@ -162,7 +211,7 @@ func benchmarkScheduler(b *testing.B, instanceCount, podCount int) {
 				variance /= float64(nodesInRound1)
 				stddev := math.Sqrt(variance)
 				fmt.Printf("%d instance types %d pods resulted in %d nodes with pods per node min=%d max=%d mean=%f stddev=%f\n",
-					instanceCount, podCount, nodesInRound1, minPods, maxPods, meanPodsPerNode, stddev)
+					instanceCount, len(pods), nodesInRound1, minPods, maxPods, meanPodsPerNode, stddev)
 			}
 		}
 	}
@@ -182,6 +231,92 @@ func benchmarkScheduler(b *testing.B, instanceCount, podCount int) {
 	}
 }
 
+func benchmarkExistingNodeStrategy(b *testing.B, pods []*v1.Pod, scorer scheduling.ExistingNodeScorer) {
+	// disable logging
+	ctx := logging.WithLogger(context.Background(), zap.NewNop().Sugar())
+	ctx = settings.ToContext(ctx, test.Settings())
+	provisioner = test.Provisioner(test.ProvisionerOptions{Limits: map[v1.ResourceName]resource.Quantity{}})
+
+	instanceTypes := fake.InstanceTypes(400)
+	cloudProv = fake.NewCloudProvider()
+	cloudProv.InstanceTypes = instanceTypes
+
+	var newNodes, existingPods int
+	var existingUtilization float64
+	for i := 0; i < b.N; i++ {
+		// existing nodes are modified as pods are scheduled to them, so each solve starts from the same fresh nodes
+		b.StopTimer()
+		scheduler := scheduling.NewScheduler(ctx, nil, []*scheduling.MachineTemplate{scheduling.NewMachineTemplate(provisioner)},
+			nil, state.NewCluster(ctx, &clock.RealClock{}, nil, cloudProv), makeExistingNodes(50, 42), &scheduling.Topology{},
+			map[string][]*cloudprovider.InstanceType{provisioner.Name: instanceTypes}, map[*scheduling.MachineTemplate]v1.ResourceList{}, nil,
+			test.NewEventRecorder(),
+			scheduling.SchedulerOptions{ExistingNodes: scheduling.ExistingNodeOptions{ScoreExistingNode: scorer}})
+		b.StartTimer()
+		nodes, existingNodes, err := scheduler.Solve(ctx, pods)
+		if err != nil {
+			b.FailNow()
+		}
+		if i == 0 {
+			newNodes = len(nodes)
+			for _, n := range existingNodes {
+				existingPods += len(n.Pods)
+				existingUtilization += meanUtilization(n.Allocatable(), n.Remaining())
+			}
+			existingUtilization /= float64(len(existingNodes))
+		}
+	}
+	b.ReportMetric(float64(newNodes), "nodes")
+	b.ReportMetric(float64(existingPods), "existing-pods")
+	b.ReportMetric(existingUtilization*100, "existing-util%")
+}
+
+// makeExistingNodes returns initialized nodes of varying sizes that are partially utilized, the same seed always
+// returns the same nodes
+func makeExistingNodes(count int, seed int64) []*state.Node {
+	//nolint:gosec
+	r := rand.New(rand.NewSource(seed))
+	var nodes []*state.Node
+	for i := 0; i < count; i++ {
+		cpu := []int64{4, 8, 16, 32}[r.Intn(4)]
+		allocatable := v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewQuantity(cpu, resource.DecimalSI),
+			v1.ResourceMemory: *resource.NewQuantity(cpu*4*1024*1024*1024, resource.BinarySI),
+			v1.ResourcePods:   resource.MustParse("110"),
+		}
+		// up to 80% of the CPU and memory of each node is already in use
+		used := r.Float64() * 0.8
+		available := v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(int64(float64(cpu*1000)*(1-used)), resource.DecimalSI),
+			v1.ResourceMemory: *resource.NewQuantity(int64(float64(cpu*4*1024*1024*1024)*(1-used)), resource.BinarySI),
+			v1.ResourcePods:   resource.MustParse("110"),
+		}
+		name := fmt.Sprintf("existing-node-%d", i)
+		nodes = append(nodes, &state.Node{
+			Node: test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+					v1.LabelHostname:                 name,
+				}},
+				Allocatable: allocatable,
+			}),
+			Capacity:      allocatable,
+			Allocatable:   allocatable,
+			Available:     available,
+			HostPortUsage: pscheduling.NewHostPortUsage(),
+			VolumeUsage:   pscheduling.NewVolumeLimits(nil),
+		})
+	}
+	return nodes
+}
+
+// meanUtilization returns the mean fraction of the allocatable CPU and memory that isn't remaining
+func meanUtilization(allocatable, remaining v1.ResourceList) float64 {
+	cpu := 1 - remaining.Cpu().AsApproximateFloat64()/allocatable.Cpu().AsApproximateFloat64()
+	memory := 1 - remaining.Memory().AsApproximateFloat64()/allocatable.Memory().AsApproximateFloat64()
+	return (cpu + memory) / 2
+}
+
 func makeDiversePods(count int) []*v1.Pod {
 	var pods []*v1.Pod
 	pods = append(pods, makeGenericPods(count/7)...)
@@ -0,0 +1,127 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// ExistingNodeScorer scores how good a candidate an existing node is for a pod, pods are scheduled to the highest
+// scoring existing node that they're compatible with
+type ExistingNodeScorer func(*ExistingNode, *v1.Pod) float64
+
+// scoredResources are the resources that the built-in scorers measure utilization across
+var scoredResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+// MostUtilized prefers the existing nodes with the least remaining capacity, packing pods onto fewer nodes
+func MostUtilized(n *ExistingNode, _ *v1.Pod) float64 {
+	return utilization(n.Allocatable(), n.Remaining())
+}
+
+// LeastUtilized prefers the existing nodes with the most remaining capacity, spreading pods across nodes
+func LeastUtilized(n *ExistingNode, _ *v1.Pod) float64 {
+	return 1 - utilization(n.Allocatable(), n.Remaining())
+}
+
+// LeastFragmenting prefers the existing nodes that the pod fills most closely, leaving the largest contiguous blocks
+// of capacity free on other nodes for larger pods
+func LeastFragmenting(n *ExistingNode, pod *v1.Pod) float64 {
+	requests := n.packing.requests(pod)
+	return utilization(n.Allocatable(), resources.Subtract(n.Remaining(), requests))
+}
+
+// PreferInstanceFamilies wraps the scorer so that existing nodes of the instance families that a pod prefers, listed in
+// its PreferredInstanceFamiliesPodAnnotationKey annotation, score above those of other families. Nodes that equally
+// match are ordered by the wrapped scorer, whose scores are expected to be within [0, 1] like those of the built-in
+// scorers, or kept in the order that they were listed if it's nil. A node's family is read from its familyLabelKey
+// label, or is its instance type name up to the first "." if the key is unset or the node doesn't have the label.
+func PreferInstanceFamilies(familyLabelKey string, scorer ExistingNodeScorer) ExistingNodeScorer {
+	return func(n *ExistingNode, pod *v1.Pod) float64 {
+		var score float64
+		if scorer != nil {
+			score = scorer(n, pod)
+		}
+		if lo.Contains(preferredInstanceFamilies(pod), instanceFamily(n.Node, familyLabelKey)) {
+			score++
+		}
+		return score
+	}
+}
+
+func preferredInstanceFamilies(pod *v1.Pod) []string {
+	value, ok := pod.Annotations[v1alpha5.PreferredInstanceFamiliesPodAnnotationKey]
+	if !ok {
+		return nil
+	}
+	return lo.Compact(lo.Map(strings.Split(value, ","), func(family string, _ int) string { return strings.TrimSpace(family) }))
+}
+
+func instanceFamily(node *v1.Node, familyLabelKey string) string {
+	if family, ok := node.Labels[familyLabelKey]; ok && familyLabelKey != "" {
+		return family
+	}
+	family, _, _ := strings.Cut(node.Labels[v1.LabelInstanceTypeStable], ".")
+	return family
+}
+
+// utilization returns the mean fraction of the allocatable CPU and memory that isn't remaining
+func utilization(allocatable, remaining v1.ResourceList) float64 {
+	var total float64
+	var count int
+	for _, resourceName := range scoredResources {
+		capacity := allocatable[resourceName]
+		if capacity.IsZero() {
+			continue
+		}
+		left := remaining[resourceName]
+		total += 1 - left.AsApproximateFloat64()/capacity.AsApproximateFloat64()
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// scoreExistingNodes returns the existing nodes ordered by descending score for the pod, nodes with the same score keep
+// their relative order
+func scoreExistingNodes(existingNodes []*ExistingNode, pod *v1.Pod, scorer ExistingNodeScorer) []*ExistingNode {
+	scores := lo.SliceToMap(existingNodes, func(n *ExistingNode) (*ExistingNode, float64) { return n, scorer(n, pod) })
+	ordered := append([]*ExistingNode{}, existingNodes...)
+	sort.SliceStable(ordered, func(i, j int) bool { return scores[ordered[i]] > scores[ordered[j]] })
+	return ordered
+}
+
+// preferredNodeFirst returns the existing nodes with the node that the pod's PreferredNodePodAnnotationKey annotation
+// names moved to the front, the other nodes keep their relative order. The nodes are returned unchanged if the pod
+// doesn't prefer a node or the node it prefers doesn't exist.
+func preferredNodeFirst(existingNodes []*ExistingNode, pod *v1.Pod) []*ExistingNode {
+	name := pod.Annotations[v1alpha5.PreferredNodePodAnnotationKey]
+	if name == "" {
+		return existingNodes
+	}
+	_, i, ok := lo.FindIndexOf(existingNodes, func(n *ExistingNode) bool { return n.Node.Name == name })
+	if !ok {
+		return existingNodes
+	}
+	return append(append([]*ExistingNode{existingNodes[i]}, existingNodes[:i]...), existingNodes[i+1:]...)
+}
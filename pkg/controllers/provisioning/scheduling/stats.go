@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// ProvisionerStat summarizes the new nodes that a solve launches from a provisioner
+type ProvisionerStat struct {
+	// Nodes is the number of new nodes
+	Nodes int
+	// Pods is the number of pods scheduled to the new nodes
+	Pods int
+	// Requests is the total resources requested on the new nodes, including daemonset overhead
+	Requests v1.ResourceList
+}
+
+// ProvisionerStats returns the new nodes, pods and requested resources of the last solve grouped by provisioner. Every
+// provisioner that the scheduler considered is included, even if no new nodes were launched from it.
+func (s *Scheduler) ProvisionerStats() map[string]ProvisionerStat {
+	stats := map[string]ProvisionerStat{}
+	for _, nodeTemplate := range s.machineTemplates {
+		stats[nodeTemplate.ProvisionerName] = ProvisionerStat{Requests: v1.ResourceList{}}
+	}
+	for _, n := range s.newNodes {
+		stat := stats[n.ProvisionerName]
+		stat.Nodes++
+		stat.Pods += len(n.Pods)
+		stat.Requests = resources.Merge(stat.Requests, n.Requests)
+		stats[n.ProvisionerName] = stat
+	}
+	return stats
+}
+
+// recordProvisionerStats exposes the provisioner stats of the last solve as metrics. The gauges are reset first so that
+// provisioners which the solve didn't consider, e.g. because they were deleted, aren't reported with stale stats.
+func (s *Scheduler) recordProvisionerStats() {
+	newNodesGauge.Reset()
+	newNodePodsGauge.Reset()
+	newNodeRequestsGauge.Reset()
+	for provisionerName, stat := range s.ProvisionerStats() {
+		newNodesGauge.WithLabelValues(provisionerName).Set(float64(stat.Nodes))
+		newNodePodsGauge.WithLabelValues(provisionerName).Set(float64(stat.Pods))
+		for resourceName, quantity := range stat.Requests {
+			newNodeRequestsGauge.WithLabelValues(provisionerName, string(resourceName)).Set(quantity.AsApproximateFloat64())
+		}
+	}
+}
+
+// recordLimitUsage exposes the percentage of each provisioner limit that's used after the last solve, by the existing
+// nodes and the new nodes at the capacity of their largest instance type option, so that operators can alert on a
+// provisioner that's approaching its limits. The limits that are zero aren't exposed, nor are provisioners without
+// limits.
+func (s *Scheduler) recordLimitUsage() {
+	limitUsagePctGauge.Reset()
+	for provisionerName, remaining := range s.remainingResources {
+		provisioner, ok := s.provisioners[provisionerName]
+		if !ok || provisioner.Spec.Limits == nil {
+			continue
+		}
+		for resourceName, limit := range provisioner.Spec.Limits.Resources {
+			if limit.IsZero() {
+				continue
+			}
+			used := limit.DeepCopy()
+			used.Sub(remaining[resourceName])
+			limitUsagePctGauge.WithLabelValues(provisionerName, string(resourceName)).Set(100 * used.AsApproximateFloat64() / limit.AsApproximateFloat64())
+		}
+	}
+}
@@ -15,29 +15,46 @@ limitations under the License.
 package scheduling_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	clock "k8s.io/utils/clock/testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/aws/karpenter-core/pkg/apis"
 	"github.com/aws/karpenter-core/pkg/apis/config/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha1"
 	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/cloudprovider"
 	"github.com/aws/karpenter-core/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter-core/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter-core/pkg/controllers/state"
+	"github.com/aws/karpenter-core/pkg/events"
 	"github.com/aws/karpenter-core/pkg/operator/controller"
 	"github.com/aws/karpenter-core/pkg/operator/scheme"
 	pscheduling "github.com/aws/karpenter-core/pkg/scheduling"
@@ -342,6 +359,43 @@ var _ = Describe("Custom Constraints", func() {
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
 		})
+		It("should filter instance types with node selectors", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(
+				test.PodOptions{NodeSelector: map[string]string{
+					v1.LabelTopologyZone:       "test-zone-2",
+					v1.LabelInstanceTypeStable: "small-instance-type",
+				}},
+			))[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small-instance-type"))
+		})
+		It("should filter instance types with node selectors the same as node affinity", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: "arm64"}}),
+				test.UnschedulablePod(test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm64"}},
+				}}),
+			)
+			for _, pod := range pods {
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
+			}
+		})
+		It("should not schedule pods with node selectors that conflict with node affinity", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(
+				test.PodOptions{
+					NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "arm-instance-type"},
+					NodeRequirements: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"default-instance-type"}},
+					},
+				},
+			))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
 	})
 	Context("Constraints Validation", func() {
 		It("should not schedule pods that have node selectors with restricted labels", func() {
@@ -728,6 +782,102 @@ var _ = Describe("Topology", func() {
 			)
 			ExpectSkew(ctx, env.Client, "default", &topology[0]).To(ConsistOf(1, 1, 2))
 		})
+		Context("Match Label Keys", func() {
+			// the new revision's pods are solved directly, as older API servers drop matchLabelKeys when the pods are persisted
+			newRevisionZones := func(matchLabelKeys []string) []string {
+				oldRevision := map[string]string{"test": "test", appsv1.DefaultDeploymentUniqueLabelKey: "old"}
+				newRevision := map[string]string{"test": "test", appsv1.DefaultDeploymentUniqueLabelKey: "new"}
+				ExpectApplied(ctx, env.Client, provisioner)
+				// the old revision is entirely in test-zone-1
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+					MakePods(3, test.PodOptions{
+						ObjectMeta:   metav1.ObjectMeta{Labels: oldRevision},
+						NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"},
+					})...) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+
+				pods := MakePods(3, test.PodOptions{
+					ObjectMeta: metav1.ObjectMeta{Labels: newRevision},
+					TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+						TopologyKey:       v1.LabelTopologyZone,
+						WhenUnsatisfiable: v1.DoNotSchedule,
+						LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+						MatchLabelKeys:    matchLabelKeys,
+						MaxSkew:           1,
+					}},
+				})
+				nodes := ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+				zones := sets.NewString()
+				for _, n := range nodes {
+					Expect(n.Pods).ToNot(BeEmpty())
+					zones.Insert(n.Requirements.Get(v1.LabelTopologyZone).Values()...)
+				}
+				return zones.List()
+			}
+			It("should compute skew separately for each revision", func() {
+				Expect(newRevisionZones([]string{appsv1.DefaultDeploymentUniqueLabelKey})).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+			})
+			It("should compute skew across revisions without match label keys", func() {
+				Expect(newRevisionZones(nil)).To(ConsistOf("test-zone-2", "test-zone-3"))
+			})
+			It("should ignore match label keys that the pod isn't labeled with", func() {
+				Expect(newRevisionZones([]string{"unknown"})).To(ConsistOf("test-zone-2", "test-zone-3"))
+			})
+		})
+		Context("Minimum Zones", func() {
+			minZones := func(count string) test.PodOptions {
+				return test.PodOptions{ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{v1alpha5.MinZonesPodAnnotationKey: count},
+				}}
+			}
+			zones := &v1.TopologySpreadConstraint{TopologyKey: v1.LabelTopologyZone, LabelSelector: &metav1.LabelSelector{MatchLabels: labels}}
+			It("should spread pods across the minimum number of zones", func() {
+				ExpectApplied(ctx, env.Client, provisioner)
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(3, minZones("3"))...) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				ExpectSkew(ctx, env.Client, "default", zones).To(ConsistOf(1, 1, 1))
+			})
+			It("should pack pods once the minimum number of zones are used", func() {
+				ExpectApplied(ctx, env.Client, provisioner)
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(6, minZones("2"))...) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				ExpectSkew(ctx, env.Client, "default", zones).To(HaveLen(2))
+				nodes := v1.NodeList{}
+				Expect(env.Client.List(ctx, &nodes)).To(Succeed())
+				Expect(nodes.Items).To(HaveLen(2))
+			})
+			It("should count the zones used by existing pods", func() {
+				ExpectApplied(ctx, env.Client, provisioner)
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+					test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"}}),
+					test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"}}),
+				) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				// only a single new zone is needed to meet the minimum
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(3, minZones("3"))...) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				ExpectSkew(ctx, env.Client, "default", zones).To(HaveLen(3))
+				ExpectSkew(ctx, env.Client, "default", zones).To(HaveKeyWithValue("test-zone-3", BeNumerically(">=", 1)))
+			})
+			It("should schedule pods if the minimum can't be met", func() {
+				ExpectApplied(ctx, env.Client, provisioner)
+				for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(5, minZones("5"))...) {
+					ExpectScheduled(ctx, env.Client, pod)
+				}
+				ExpectSkew(ctx, env.Client, "default", zones).To(HaveLen(3))
+			})
+			It("should not schedule pods with an invalid minimum", func() {
+				ExpectApplied(ctx, env.Client, provisioner)
+				pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(minZones("three")))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+		})
 		It("should respect provisioner zonal constraints", func() {
 			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
 				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}}}
@@ -1606,6 +1756,39 @@ var _ = Describe("Topology", func() {
 			ExpectSkew(ctx, env.Client, "default", &topology[0]).ToNot(ContainElements(BeNumerically(">", 11)))
 			ExpectSkew(ctx, env.Client, "default", &topology[1]).ToNot(ContainElements(BeNumerically(">", 7)))
 		})
+		It("should launch both spot and on-demand capacity in every zone", func() {
+			// ensure we've got an instance type for every zone/capacity-type pair
+			cloudProv.InstanceTypes = fake.InstanceTypesAssorted()
+			var pods []*v1.Pod
+			for _, zone := range []string{"test-zone-1", "test-zone-2", "test-zone-3"} {
+				zoneLabels := map[string]string{"app": "test-" + zone}
+				pods = append(pods, MakePods(2, test.PodOptions{
+					ObjectMeta:   metav1.ObjectMeta{Labels: zoneLabels},
+					NodeSelector: map[string]string{v1.LabelTopologyZone: zone},
+					TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+						TopologyKey:       v1alpha5.LabelCapacityType,
+						WhenUnsatisfiable: v1.DoNotSchedule,
+						LabelSelector:     &metav1.LabelSelector{MatchLabels: zoneLabels},
+						MaxSkew:           1,
+					}},
+				})...)
+			}
+			ExpectApplied(ctx, env.Client, provisioner)
+			for _, pod := range ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...) {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+			nodes := v1.NodeList{}
+			Expect(env.Client.List(ctx, &nodes)).To(Succeed())
+			capacityTypes := map[string]sets.String{}
+			for _, node := range nodes.Items {
+				zone := node.Labels[v1.LabelTopologyZone]
+				capacityTypes[zone] = capacityTypes[zone].Union(sets.NewString(node.Labels[v1alpha5.LabelCapacityType]))
+			}
+			Expect(capacityTypes).To(HaveLen(3))
+			for _, types := range capacityTypes {
+				Expect(types.List()).To(ConsistOf(v1alpha5.CapacityTypeSpot, v1alpha5.CapacityTypeOnDemand))
+			}
+		})
 	})
 
 	Context("Combined Hostname, Zonal, and Capacity Type Topology", func() {
@@ -2619,6 +2802,92 @@ var _ = Describe("Topology", func() {
 			top := &v1.TopologySpreadConstraint{TopologyKey: v1.LabelTopologyZone}
 			ExpectSkew(ctx, env.Client, "default", top).To(ConsistOf(11))
 		})
+		It("should support pod affinity with a custom topology key (constrained target)", func() {
+			affLabels := map[string]string{"security": "s2"}
+			provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{
+				Key: "rack", Operator: v1.NodeSelectorOpIn, Values: []string{"r1", "r2", "r3"},
+			})
+			// the pod that the other has an affinity to is limited to a single rack
+			targetPod := test.UnschedulablePod(test.PodOptions{
+				ObjectMeta:   metav1.ObjectMeta{Labels: affLabels},
+				NodeSelector: map[string]string{"rack": "r2"},
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+				},
+			})
+			affPod := test.UnschedulablePod(test.PodOptions{
+				PodRequirements: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: affLabels},
+					TopologyKey:   "rack",
+				}},
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+				},
+			})
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, targetPod, affPod)
+			n1 := ExpectScheduled(ctx, env.Client, targetPod)
+			n2 := ExpectScheduled(ctx, env.Client, affPod)
+			Expect(n1.Labels).To(HaveKeyWithValue("rack", "r2"))
+			Expect(n2.Labels).To(HaveKeyWithValue("rack", "r2"))
+		})
+		It("should support self pod affinity with a custom topology key from provisioner labels", func() {
+			affLabels := map[string]string{"security": "s2"}
+			rack1Provisioner := test.Provisioner(test.ProvisionerOptions{Labels: map[string]string{"rack": "r1"}})
+			rack2Provisioner := test.Provisioner(test.ProvisionerOptions{Labels: map[string]string{"rack": "r2"}})
+			// the racks are only known from the provisioner labels, so they need to be registered as domains for the
+			// first pod to pick one
+			pods := MakePods(2, test.PodOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: affLabels},
+				PodRequirements: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: affLabels},
+					TopologyKey:   "rack",
+				}},
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+				},
+			})
+			ExpectApplied(ctx, env.Client, rack1Provisioner, rack2Provisioner)
+			ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+			n1 := ExpectScheduled(ctx, env.Client, pods[0])
+			n2 := ExpectScheduled(ctx, env.Client, pods[1])
+			Expect(n1.Name).ToNot(Equal(n2.Name))
+			Expect(n1.Labels["rack"]).ToNot(BeEmpty())
+			Expect(n2.Labels["rack"]).To(Equal(n1.Labels["rack"]))
+		})
+		It("should support pod affinity with a custom topology key to a pod on an existing node", func() {
+			affLabels := map[string]string{"security": "s2"}
+			provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{
+				Key: "rack", Operator: v1.NodeSelectorOpIn, Values: []string{"r1", "r2", "r3"},
+			})
+			node := test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"rack": "r3"}},
+				Allocatable: v1.ResourceList{
+					v1.ResourceCPU:  resource.MustParse("1"),
+					v1.ResourcePods: resource.MustParse("10"),
+				},
+			})
+			targetPod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: affLabels}})
+			ExpectApplied(ctx, env.Client, provisioner, node, targetPod)
+			ExpectManualBinding(ctx, env.Client, targetPod, node)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+			ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(targetPod))
+
+			// the pod doesn't fit on the existing node, so it needs a new node in the same rack
+			affPod := test.UnschedulablePod(test.PodOptions{
+				PodRequirements: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: affLabels},
+					TopologyKey:   "rack",
+				}},
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+				},
+			})
+			ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, affPod)
+			n := ExpectScheduled(ctx, env.Client, affPod)
+			Expect(n.Name).ToNot(Equal(node.Name))
+			Expect(n.Labels).To(HaveKeyWithValue("rack", "r3"))
+		})
 		It("should handle multiple dependent affinities", func() {
 			dbLabels := map[string]string{"type": "db", "spread": "spread"}
 			webLabels := map[string]string{"type": "web", "spread": "spread"}
@@ -2813,6 +3082,88 @@ var _ = Describe("Topology", func() {
 			ExpectSkew(ctx, env.Client, "default", &topology).To(ConsistOf(3, 3, 4))
 		})
 	})
+	Context("DaemonSet Anti-Affinity", func() {
+		var node *v1.Node
+		var dsPod *v1.Pod
+		BeforeEach(func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node = ExpectScheduled(ctx, env.Client, initialPod[0])
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+			ds := test.DaemonSet()
+			ExpectApplied(ctx, env.Client, ds)
+			dsPod = test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "logging"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion:         "apps/v1",
+					Kind:               "DaemonSet",
+					Name:               ds.Name,
+					UID:                ds.UID,
+					Controller:         ptr.Bool(true),
+					BlockOwnerDeletion: ptr.Bool(true),
+				}},
+			}})
+		})
+		bindDaemonSetPod := func() {
+			ExpectApplied(ctx, env.Client, dsPod)
+			ExpectManualBinding(ctx, env.Client, dsPod, node)
+			ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(dsPod))
+		}
+		// solve returns the number of pods that were scheduled to new or existing nodes
+		solve := func(ignoreDaemonSetAntiAffinity bool, pod *v1.Pod) int {
+			stateNodes := StateNodes()
+			newNodes, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{IgnoreDaemonSetAntiAffinity: ignoreDaemonSetAntiAffinity}}, stateNodes, pod)
+			scheduled := 0
+			for _, n := range newNodes {
+				scheduled += len(n.Pods)
+			}
+			for _, n := range existingNodes {
+				scheduled += len(n.Pods)
+			}
+			return scheduled
+		}
+		// antiAffinityPod has anti-affinity to the daemonset pods in the zone of the node that they're running on
+		antiAffinityPod := func() *v1.Pod {
+			return test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1.LabelTopologyZone: node.Labels[v1.LabelTopologyZone]},
+				PodAntiRequirements: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "logging"}},
+					TopologyKey:   v1.LabelTopologyZone,
+				}},
+			})
+		}
+		It("should honor anti-affinity to daemonset pods by default", func() {
+			bindDaemonSetPod()
+			Expect(solve(false, antiAffinityPod())).To(BeZero())
+		})
+		It("should ignore anti-affinity to daemonset pods", func() {
+			bindDaemonSetPod()
+			Expect(solve(true, antiAffinityPod())).To(Equal(1))
+		})
+		It("should ignore the anti-affinity of daemonset pods", func() {
+			dsPod.Spec.Affinity = &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					TopologyKey:   v1.LabelTopologyZone,
+				}},
+			}}
+			bindDaemonSetPod()
+			pod := func() *v1.Pod {
+				return test.UnschedulablePod(test.PodOptions{
+					ObjectMeta:   metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					NodeSelector: map[string]string{v1.LabelTopologyZone: node.Labels[v1.LabelTopologyZone]},
+				})
+			}
+			Expect(solve(false, pod())).To(BeZero())
+			Expect(solve(true, pod())).To(Equal(1))
+		})
+		It("should honor anti-affinity to pods that aren't owned by a daemonset", func() {
+			dsPod.OwnerReferences = nil
+			bindDaemonSetPod()
+			Expect(solve(true, antiAffinityPod())).To(BeZero())
+		})
+	})
 })
 
 func ExpectDeleteAllUnscheduledPods(ctx2 context.Context, c client.Client) {
@@ -2858,6 +3209,36 @@ var _ = Describe("Taints", func() {
 			ExpectNotScheduled(ctx, env.Client, pod)
 		}
 	})
+	It("should schedule pods that don't tolerate the taints of a provisioner that injects tolerations", func() {
+		provisioner.Annotations = map[string]string{v1alpha5.InjectTolerationsProvisionerAnnotationKey: "true"}
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		// the node is still tainted, the pods are expected to tolerate the taint once they've been mutated
+		Expect(node.Spec.Taints).To(ContainElement(provisioner.Spec.Taints[0]))
+	})
+	It("should only inject tolerations for provisioners that opt in", func() {
+		injecting := test.Provisioner(test.ProvisionerOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.InjectTolerationsProvisionerAnnotationKey: "true"}},
+			Taints:     []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}},
+			Weight:     ptr.Int32(10),
+		})
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		provisioner.Spec.Weight = ptr.Int32(100)
+		ExpectApplied(ctx, env.Client, provisioner, injecting)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		// the higher weighted provisioner is skipped as the pod doesn't tolerate its taints
+		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(injecting.Name))
+	})
+	It("should not inject tolerations unless enabled", func() {
+		provisioner.Annotations = map[string]string{v1alpha5.InjectTolerationsProvisionerAnnotationKey: "false"}
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+	})
 	It("should provision nodes with taints and schedule pods if the taint is only a startup taint", func() {
 		provisioner.Spec.StartupTaints = []v1.Taint{{Key: "ignore-me", Value: "nothing-to-see-here", Effect: v1.TaintEffectNoSchedule}}
 
@@ -2873,6 +3254,22 @@ var _ = Describe("Taints", func() {
 		node := ExpectScheduled(ctx, env.Client, pod)
 		Expect(node.Spec.Taints).To(HaveLen(1)) // Expect no taints generated beyond the default
 	})
+	It("should schedule pods that tolerate NoExecute provisioner taints indefinitely", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoExecute}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(
+			test.PodOptions{Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute}}},
+		))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+	It("should not schedule pods that only tolerate NoExecute provisioner taints for a bounded time", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoExecute}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(
+			test.PodOptions{Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)}}},
+		))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+	})
 })
 
 var _ = Describe("Instance Type Compatibility", func() {
@@ -3063,6 +3460,70 @@ var _ = Describe("Instance Type Compatibility", func() {
 			}))
 		ExpectNotScheduled(ctx, env.Client, pods[0])
 	})
+	Context("Operating Systems", func() {
+		// podWithOS returns a pod that declares the operating system it runs on
+		podWithOS := func(os v1.OSName) *v1.Pod {
+			pod := test.UnschedulablePod()
+			pod.Spec.OS = &v1.PodOS{Name: os}
+			return pod
+		}
+		// machineOperatingSystems returns the operating systems that the launched machine is constrained to
+		machineOperatingSystems := func(machine *v1alpha1.Machine) []string {
+			return pscheduling.NewNodeSelectorRequirements(machine.Spec.Requirements...).Get(v1.LabelOSStable).Values()
+		}
+		It("should only launch windows pods on instance types that support windows", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, podWithOS(v1.Windows))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(cloudProv.CreateCalls).To(HaveLen(1))
+			Expect(machineOperatingSystems(cloudProv.CreateCalls[0])).To(ConsistOf(string(v1.Windows)))
+			ExpectInstancesWithLabel(supportedInstanceTypes(cloudProv.CreateCalls[0]), v1.LabelOSStable, string(v1.Windows))
+		})
+		It("should launch windows and linux pods on different instances", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				podWithOS(v1.Windows),
+				podWithOS(v1.Linux),
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: string(v1.Windows)}}),
+			)
+			nodeNames := sets.NewString()
+			for _, pod := range pods {
+				nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+			}
+			// the windows pods share a node whether they declare their operating system or select it
+			Expect(nodeNames.Len()).To(Equal(2))
+			Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).To(Equal(ExpectScheduled(ctx, env.Client, pods[2]).Name))
+			Expect(lo.Map(cloudProv.CreateCalls, func(m *v1alpha1.Machine, _ int) []string { return machineOperatingSystems(m) })).To(ConsistOf(
+				ConsistOf(string(v1.Windows)),
+				ConsistOf(string(v1.Linux)),
+			))
+		})
+		It("should not schedule windows pods if no instance type supports windows", func() {
+			cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "linux-instance-type", OperatingSystems: sets.NewString(string(v1.Linux))}),
+			}
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, podWithOS(v1.Windows), podWithOS(v1.Linux))
+			ExpectNotScheduled(ctx, env.Client, pods[0])
+			ExpectScheduled(ctx, env.Client, pods[1])
+		})
+		It("should not schedule windows pods to existing linux nodes", func() {
+			node := test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+					v1.LabelOSStable:                 string(v1.Linux),
+				}},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+			})
+			ExpectApplied(ctx, env.Client, provisioner, node)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, podWithOS(v1.Windows), podWithOS(v1.Linux))
+			Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).ToNot(Equal(node.Name))
+			Expect(ExpectScheduled(ctx, env.Client, pods[1]).Name).To(Equal(node.Name))
+		})
+	})
 	Context("Provider Specific Labels", func() {
 		It("should filter instance types that match labels", func() {
 			cloudProv.InstanceTypes = fake.InstanceTypes(5)
@@ -3731,6 +4192,50 @@ var _ = Describe("In-Flight Nodes", func() {
 			// we prefer to launch new newNodes to satisfy the topology spread even though we could technnically schedule against existingNodes
 			ExpectSkew(ctx, env.Client, "default", &topology[0]).To(ConsistOf(1, 1, 1, 1, 1, 1, 1, 1, 1))
 		})
+		It("should co-locate a pod with a pod running on an existing node (zone)", func() {
+			affLabels := map[string]string{"security": "s2"}
+			ExpectApplied(ctx, env.Client, provisioner)
+			targetPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{
+					ObjectMeta:   metav1.ObjectMeta{Labels: affLabels},
+					NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"},
+				}))[0]
+			node1 := ExpectScheduled(ctx, env.Client, targetPod)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+			ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(targetPod))
+
+			// the existing node isn't compatible, so the pod needs a new node which must be in the same zone as the
+			// pod that is already running
+			affPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{
+					NodeSelector: map[string]string{v1.LabelArchStable: "arm64"},
+					PodRequirements: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: affLabels},
+						TopologyKey:   v1.LabelTopologyZone,
+					}},
+				}))[0]
+			node2 := ExpectScheduled(ctx, env.Client, affPod)
+			Expect(node2.Name).ToNot(Equal(node1.Name))
+			Expect(node2.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+		})
+		It("should co-locate a pod with a pod running on an existing node (hostname)", func() {
+			affLabels := map[string]string{"security": "s2"}
+			ExpectApplied(ctx, env.Client, provisioner)
+			targetPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: affLabels}}))[0]
+			node1 := ExpectScheduled(ctx, env.Client, targetPod)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+			ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(targetPod))
+
+			affPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{
+					PodRequirements: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: affLabels},
+						TopologyKey:   v1.LabelHostname,
+					}},
+				}))[0]
+			Expect(ExpectScheduled(ctx, env.Client, affPod).Name).To(Equal(node1.Name))
+		})
 	})
 	Context("Taints", func() {
 		It("should assume pod will schedule to a tainted node with no taints", func() {
@@ -3857,51 +4362,280 @@ var _ = Describe("In-Flight Nodes", func() {
 
 			Expect(node1.Name).To(Equal(node2.Name))
 		})
-	})
-	Context("Daemonsets", func() {
-		It("should track daemonset usage separately so we know how many DS resources are remaining to be scheduled", func() {
-			ds := test.DaemonSet(
-				test.DaemonSetOptions{PodOptions: test.PodOptions{
-					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{
-						v1.ResourceCPU:    resource.MustParse("1"),
-						v1.ResourceMemory: resource.MustParse("1Gi")}},
-				}},
-			)
-			ExpectApplied(ctx, env.Client, provisioner, ds)
-			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
-
-			opts := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
-				Limits: map[v1.ResourceName]resource.Quantity{
-					v1.ResourceCPU: resource.MustParse("8"),
+		It("should schedule pods with the default tolerations to a NotReady in-flight node with NoExecute taints", func() {
+			opts := test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: map[v1.ResourceName]resource.Quantity{v1.ResourceCPU: resource.MustParse("10m")},
 				},
-			}}
-			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))
-			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+				// the tolerations added to every pod by the DefaultTolerationSeconds admission plugin
+				Tolerations: []v1.Toleration{
+					{Key: v1.TaintNodeNotReady, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+					{Key: v1.TaintNodeUnreachable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+				},
+			}
+			ExpectApplied(ctx, env.Client, provisioner)
 
-			// create our daemonset pod and manually bind it to the node
-			dsPod := test.UnschedulablePod(test.PodOptions{
-				ResourceRequirements: v1.ResourceRequirements{
-					Requests: map[v1.ResourceName]resource.Quantity{
-						v1.ResourceCPU:    resource.MustParse("1"),
-						v1.ResourceMemory: resource.MustParse("2Gi"),
-					}},
-			})
-			dsPod.OwnerReferences = append(dsPod.OwnerReferences, metav1.OwnerReference{
-				APIVersion:         "apps/v1",
-				Kind:               "DaemonSet",
-				Name:               ds.Name,
-				UID:                ds.UID,
-				Controller:         ptr.Bool(true),
-				BlockOwnerDeletion: ptr.Bool(true),
-			})
+			// Schedule to New Node
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))[0]
+			node1 := ExpectScheduled(ctx, env.Client, pod)
+			node1.Spec.Taints = []v1.Taint{
+				{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoSchedule},
+				{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoExecute},
+			}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
 
+			// Schedule to In Flight Node
+			pod = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).To(Equal(node2.Name))
+		})
+	})
+	Context("Node Pressure", func() {
+		var node1 *v1.Node
+		BeforeEach(func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 = ExpectScheduled(ctx, env.Client, initialPod[0])
 			// delete the pod so that the node is empty
 			ExpectDeleted(ctx, env.Client, initialPod[0])
+			node1.Spec.Taints = nil
+		})
+		It("should not assume pod will schedule to a node with a disk pressure taint", func() {
+			node1.Spec.Taints = []v1.Taint{{Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule}}
+			ExpectApplied(ctx, env.Client, node1)
 			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
 
-			ExpectApplied(ctx, env.Client, provisioner, dsPod)
-			cluster.ForEachNode(func(f *state.Node) bool {
-				Expect(f.DaemonSetRequested.Cpu().AsApproximateFloat64()).To(BeNumerically("~", 0))
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).ToNot(Equal(node2.Name))
+		})
+		It("should not assume pod will schedule to a node reporting disk pressure before it's tainted", func() {
+			node1.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeDiskPressure, Status: v1.ConditionTrue}}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).ToNot(Equal(node2.Name))
+		})
+		It("should assume pod will schedule to a node with a disk pressure taint that it tolerates", func() {
+			node1.Spec.Taints = []v1.Taint{{Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule}}
+			node1.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeDiskPressure, Status: v1.ConditionTrue}}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				Tolerations: []v1.Toleration{{Key: v1.TaintNodeDiskPressure, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+			}))[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).To(Equal(node2.Name))
+		})
+		It("should assume pod will schedule to a node once the pressure is resolved", func() {
+			node1.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeDiskPressure, Status: v1.ConditionFalse}}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).To(Equal(node2.Name))
+		})
+	})
+	Context("Cordoned Nodes", func() {
+		var node1 *v1.Node
+		BeforeEach(func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 = ExpectScheduled(ctx, env.Client, initialPod[0])
+			// delete the pod so that the node is empty
+			ExpectDeleted(ctx, env.Client, initialPod[0])
+			node1.Spec.Taints = nil
+		})
+		solve := func(cordonLabels ...string) []*scheduling.ExistingNode {
+			stateNodes := StateNodes()
+			pods := []*v1.Pod{test.UnschedulablePod()}
+			_, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{ExistingNodes: scheduling.ExistingNodeOptions{CordonLabels: cordonLabels}}, stateNodes, pods...)
+			return existingNodes
+		}
+		It("should not assume pod will schedule to a cordoned node before it's tainted", func() {
+			node1.Spec.Unschedulable = true
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).ToNot(Equal(node2.Name))
+		})
+		It("should assume pod will schedule to a cordoned node if it tolerates the unschedulable taint", func() {
+			node1.Spec.Unschedulable = true
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				Tolerations: []v1.Toleration{{Key: v1.TaintNodeUnschedulable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+			}))[0]
+			node2 := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node1.Name).To(Equal(node2.Name))
+		})
+		It("should not assume pod will schedule to a node with a cordon label", func() {
+			node1.Labels["example.com/maintenance"] = "true"
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			existingNodes := solve("example.com/maintenance")
+			Expect(existingNodes).To(HaveLen(1))
+			Expect(existingNodes[0].Pods).To(BeEmpty())
+		})
+		It("should assume pod will schedule to a node without a cordon label", func() {
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			existingNodes := solve("example.com/maintenance")
+			Expect(existingNodes).To(HaveLen(1))
+			Expect(existingNodes[0].Pods).To(HaveLen(1))
+		})
+	})
+	Context("Startup Taint Grace Period", func() {
+		const gracePeriod = 10 * time.Minute
+		var node1 *v1.Node
+		var now time.Time
+		BeforeEach(func() {
+			now = fakeClock.Now()
+			startupTaint := v1.Taint{Key: "example.com/initializing", Effect: v1.TaintEffectNoSchedule}
+			provisioner.Spec.StartupTaints = []v1.Taint{startupTaint}
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 = ExpectScheduled(ctx, env.Client, initialPod[0])
+			// delete the pod so that the node is empty, leaving the startup taint on the node
+			ExpectDeleted(ctx, env.Client, initialPod[0])
+			node1.Spec.Taints = []v1.Taint{startupTaint}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+		})
+		AfterEach(func() {
+			fakeClock.SetTime(now)
+		})
+		solve := func(gracePeriod time.Duration) []*scheduling.ExistingNode {
+			stateNodes := StateNodes()
+			pods := []*v1.Pod{test.UnschedulablePod()}
+			_, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{Clock: fakeClock, ExistingNodes: scheduling.ExistingNodeOptions{StartupTaintGracePeriod: gracePeriod}}, stateNodes, pods...)
+			return existingNodes
+		}
+		It("should assume pod will schedule to a node with a startup taint within the grace period", func() {
+			fakeClock.SetTime(node1.CreationTimestamp.Add(gracePeriod / 2))
+			existingNodes := solve(gracePeriod)
+			Expect(existingNodes).To(HaveLen(1))
+			Expect(existingNodes[0].Pods).To(HaveLen(1))
+		})
+		It("should not assume pod will schedule to a node with a startup taint that lingers past the grace period", func() {
+			fakeClock.SetTime(node1.CreationTimestamp.Add(gracePeriod * 2))
+			existingNodes := solve(gracePeriod)
+			Expect(existingNodes).To(HaveLen(1))
+			Expect(existingNodes[0].Pods).To(BeEmpty())
+		})
+		It("should ignore startup taints indefinitely without a grace period", func() {
+			fakeClock.SetTime(node1.CreationTimestamp.Add(24 * time.Hour))
+			existingNodes := solve(0)
+			Expect(existingNodes).To(HaveLen(1))
+			Expect(existingNodes[0].Pods).To(HaveLen(1))
+		})
+	})
+	Context("Terminating Pods", func() {
+		const gracePeriod = 10 * time.Minute
+		var terminating *v1.Pod
+		var now time.Time
+		BeforeEach(func() {
+			now = fakeClock.Now()
+			ExpectApplied(ctx, env.Client, provisioner)
+			node := test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+				}},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("100")},
+			})
+			terminating = test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+			})
+			ExpectApplied(ctx, env.Client, node, terminating)
+			ExpectManualBinding(ctx, env.Client, terminating, node)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+			// a bound pod is deleted gracefully, so it's left terminating without a kubelet to stop it
+			Expect(env.Client.Delete(ctx, terminating)).To(Succeed())
+			terminating = ExpectPodExists(ctx, env.Client, terminating.Name, terminating.Namespace)
+			Expect(terminating.DeletionTimestamp.IsZero()).To(BeFalse())
+			ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(terminating))
+		})
+		AfterEach(func() {
+			fakeClock.SetTime(now)
+		})
+		// solve returns the existing node after scheduling a pod that only fits if the terminating pod's capacity is free
+		solve := func(gracePeriod time.Duration) *scheduling.ExistingNode {
+			stateNodes := StateNodes()
+			pods := []*v1.Pod{test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+			})}
+			_, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{Clock: fakeClock, ExistingNodes: scheduling.ExistingNodeOptions{TerminatingPodGracePeriod: gracePeriod}}, stateNodes, pods...)
+			Expect(existingNodes).To(HaveLen(1))
+			return existingNodes[0]
+		}
+		It("should count the capacity of terminating pods without a grace period", func() {
+			fakeClock.SetTime(terminating.DeletionTimestamp.Add(24 * time.Hour))
+			Expect(solve(0).Pods).To(BeEmpty())
+		})
+		It("should count the capacity of terminating pods within the grace period", func() {
+			fakeClock.SetTime(terminating.DeletionTimestamp.Add(gracePeriod / 2))
+			Expect(solve(gracePeriod).Pods).To(BeEmpty())
+		})
+		It("should ignore the capacity of pods that are terminating past the grace period", func() {
+			fakeClock.SetTime(terminating.DeletionTimestamp.Add(gracePeriod * 2))
+			Expect(solve(gracePeriod).Pods).To(HaveLen(1))
+		})
+	})
+	Context("Daemonsets", func() {
+		It("should track daemonset usage separately so we know how many DS resources are remaining to be scheduled", func() {
+			ds := test.DaemonSet(
+				test.DaemonSetOptions{PodOptions: test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse("1"),
+						v1.ResourceMemory: resource.MustParse("1Gi")}},
+				}},
+			)
+			ExpectApplied(ctx, env.Client, provisioner, ds)
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
+
+			opts := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+				Limits: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("8"),
+				},
+			}}
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))
+			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+
+			// create our daemonset pod and manually bind it to the node
+			dsPod := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{
+					Requests: map[v1.ResourceName]resource.Quantity{
+						v1.ResourceCPU:    resource.MustParse("1"),
+						v1.ResourceMemory: resource.MustParse("2Gi"),
+					}},
+			})
+			dsPod.OwnerReferences = append(dsPod.OwnerReferences, metav1.OwnerReference{
+				APIVersion:         "apps/v1",
+				Kind:               "DaemonSet",
+				Name:               ds.Name,
+				UID:                ds.UID,
+				Controller:         ptr.Bool(true),
+				BlockOwnerDeletion: ptr.Bool(true),
+			})
+
+			// delete the pod so that the node is empty
+			ExpectDeleted(ctx, env.Client, initialPod[0])
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			ExpectApplied(ctx, env.Client, provisioner, dsPod)
+			cluster.ForEachNode(func(f *state.Node) bool {
+				Expect(f.DaemonSetRequested.Cpu().AsApproximateFloat64()).To(BeNumerically("~", 0))
 				// no pods so we have the full 16 CPU
 				Expect(f.Available.Cpu().AsApproximateFloat64()).To(BeNumerically("~", 16))
 				return true
@@ -4012,7 +4746,119 @@ var _ = Describe("In-Flight Nodes", func() {
 			// must create a new node
 			Expect(node1.Name).ToNot(Equal(node2.Name))
 		})
+		It("should not reserve capacity for daemonsets that no longer match the node's labels", func() {
+			provisioner.Spec.Labels = map[string]string{"team": "a"}
+			ds := test.DaemonSet(test.DaemonSetOptions{PodOptions: test.PodOptions{
+				NodeSelector:         map[string]string{"team": "a"},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+			}})
+			ExpectApplied(ctx, env.Client, provisioner, ds)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+			}))
+			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+			// the node is moved to another team after it's created
+			node1.Labels["team"] = "b"
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectDeleted(ctx, env.Client, initialPod[0])
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			// the daemonset won't run on the node, so the pod can use all of its 16 CPUs
+			secondPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("15")}},
+			}))
+			node2 := ExpectScheduled(ctx, env.Client, secondPod[0])
+			Expect(node2.Name).To(Equal(node1.Name))
+		})
+		It("should reserve capacity for daemonsets that match the node's new labels", func() {
+			ds := test.DaemonSet(test.DaemonSetOptions{PodOptions: test.PodOptions{
+				NodeSelector:         map[string]string{"team": "a"},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+			}})
+			ExpectApplied(ctx, env.Client, provisioner, ds)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}},
+			}))
+			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+			// the node is assigned to a team after it's created, so the daemonset will run on it
+			node1.Labels["team"] = "a"
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectDeleted(ctx, env.Client, initialPod[0])
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			secondPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("15")}},
+			}))
+			node2 := ExpectScheduled(ctx, env.Client, secondPod[0])
+			Expect(node2.Name).ToNot(Equal(node1.Name))
+		})
+	})
+	Context("Relabeled Nodes", func() {
+		It("should schedule to a node whose labels were changed to match the pod", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+			node1.Labels["team"] = "a"
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			// the provisioner can't launch nodes with the label, but the existing node now has it
+			secondPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{"team": "a"},
+			}))
+			node2 := ExpectScheduled(ctx, env.Client, secondPod[0])
+			Expect(node2.Name).To(Equal(node1.Name))
+		})
+		It("should not schedule to a node whose labels were changed to no longer match the pod", func() {
+			provisioner.Spec.Labels = map[string]string{"team": "a"}
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 := ExpectScheduled(ctx, env.Client, initialPod[0])
+			Expect(node1.Labels).To(HaveKeyWithValue("team", "a"))
+			node1.Labels["team"] = "b"
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			secondPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{"team": "a"},
+			}))
+			node2 := ExpectScheduled(ctx, env.Client, secondPod[0])
+			Expect(node2.Name).ToNot(Equal(node1.Name))
+			Expect(node2.Labels).To(HaveKeyWithValue("team", "a"))
+		})
+	})
+	Context("Unrecognized Instance Types", func() {
+		var node1 *v1.Node
+		BeforeEach(func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node1 = ExpectScheduled(ctx, env.Client, initialPod[0])
+			// the node's instance type is deprecated and no longer offered by the cloud provider
+			cloudProv.InstanceTypes = lo.Reject(cloudProv.InstanceTypes, func(it *cloudprovider.InstanceType, _ int) bool {
+				return it.Name == node1.Labels[v1.LabelInstanceTypeStable]
+			})
+		})
+		It("should not assume pods will schedule to a node that hasn't reported its capacity", func() {
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+			secondPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+			node2 := ExpectScheduled(ctx, env.Client, secondPod[0])
+			Expect(node2.Name).ToNot(Equal(node1.Name))
+		})
+		It("should schedule to a node within the capacity that it reported", func() {
+			node1.Status.Allocatable = v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourcePods: resource.MustParse("10")}
+			ExpectApplied(ctx, env.Client, node1)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
 
+			fits := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+			}))
+			Expect(ExpectScheduled(ctx, env.Client, fits[0]).Name).To(Equal(node1.Name))
+			doesntFit := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1.5")}},
+			}))
+			Expect(ExpectScheduled(ctx, env.Client, doesntFit[0]).Name).ToNot(Equal(node1.Name))
+		})
 	})
 	// nolint:gosec
 	It("should pack in-flight newNodes before launching new newNodes", func() {
@@ -4184,337 +5030,4807 @@ var _ = Describe("No Pre-Binding", func() {
 	})
 })
 
-var _ = Describe("Volumes", func() {
-	It("should launch multiple newNodes if required due to volume limits", func() {
-		const csiProvider = "fake.csi.provider"
-		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
-			fake.NewInstanceType(
-				fake.InstanceTypeOptions{
-					Name: "instance-type",
-					Resources: map[v1.ResourceName]resource.Quantity{
-						v1.ResourceCPU:  resource.MustParse("1024"),
-						v1.ResourcePods: resource.MustParse("1024"),
-					},
-				}),
+var _ = Describe("Excluded Zones", func() {
+	It("should launch nodes in the remaining zones", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := MakePods(6, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "test"}},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"test": "test"}},
+			}},
+		})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{ExcludedZones: []string{"test-zone-1"}}}, pods...)
+
+		podsPerZone := map[string]int{}
+		for _, node := range nodes {
+			zones := node.Requirements.Get(v1.LabelTopologyZone)
+			Expect(zones.Has("test-zone-1")).To(BeFalse())
+			Expect(zones.Len()).To(Equal(1))
+			podsPerZone[zones.Values()[0]] += len(node.Pods)
 		}
+		// the excluded zone isn't a topology domain, so the pods spread evenly across the remaining zones
+		Expect(podsPerZone).To(Equal(map[string]int{"test-zone-2": 3, "test-zone-3": 3}))
+	})
+	It("should not launch nodes into an excluded zone for pods without zonal constraints", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{ExcludedZones: []string{"test-zone-1", "test-zone-2"}}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-1")).To(BeFalse())
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-2")).To(BeFalse())
+	})
+	It("should fail to schedule pods pinned to an excluded zone", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"}})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{ExcludedZones: []string{"test-zone-1"}}}, pod)
+		Expect(nodes).To(BeEmpty())
+		failures := 0
+		recorder.ForEachEvent(func(evt events.Event) {
+			if evt.InvolvedObject == pod && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+				failures++
+			}
+		})
+		Expect(failures).To(Equal(1))
+	})
+})
 
-		provisioner.Spec.Limits = nil
+var _ = Describe("Topology Domain Limits", func() {
+	zonalSpreadPods := func(count int) []*v1.Pod {
+		labels := map[string]string{"test": "test"}
+		return test.Pods(count, test.UnscheduleablePodOptions(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+				MaxSkew:           1,
+			}},
+		}))
+	}
+	solve := func(pods []*v1.Pod, maxTopologyDomains int) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{MaxTopologyDomains: maxTopologyDomains}, pods...)
+	}
+	It("should enforce topology spread if the domains are within the limit", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
-		node := ExpectScheduled(ctx, env.Client, initialPods[0])
-		csiNode := &storagev1.CSINode{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: node.Name,
-			},
-			Spec: storagev1.CSINodeSpec{
-				Drivers: []storagev1.CSINodeDriver{
-					{
-						Name:   csiProvider,
-						NodeID: "fake-node-id",
-						Allocatable: &storagev1.VolumeNodeResources{
-							Count: ptr.Int32(10),
-						},
-					},
-				},
-			},
+		nodes := solve(zonalSpreadPods(3), 3)
+		Expect(nodes).To(HaveLen(3))
+		zones := sets.NewString()
+		for _, node := range nodes {
+			zones.Insert(node.Requirements.Get(v1.LabelTopologyZone).Values()...)
 		}
-		ExpectApplied(ctx, env.Client, csiNode)
-		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		Expect(zones.List()).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should treat topology spread as best-effort if the domains exceed the limit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(zonalSpreadPods(3), 2)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(3))
+	})
+	It("should enforce topology spread with the default limit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(zonalSpreadPods(3), 0)).To(HaveLen(3))
+	})
+	It("should not limit the domains if the cap is disabled", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(zonalSpreadPods(3), -1)).To(HaveLen(3))
+	})
+	It("should fail the pods if the domains exceed a strict limit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := zonalSpreadPods(3)
+		Expect(ExpectSolved(scheduling.SchedulerOptions{MaxTopologyDomains: 2, StrictTopologyDomains: true}, pods...)).To(BeEmpty())
+		messages := FailureMessages()
+		Expect(messages).To(HaveLen(3))
+		for _, pod := range pods {
+			Expect(messages).To(HaveKeyWithValue(pod.Name, ContainSubstring("exceeded the maximum number of topology domains")))
+		}
+	})
+	It("should fail the remaining pods once the domains registered by new nodes exceed a strict limit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		labels := map[string]string{"test": "test"}
+		pods := test.Pods(3, test.UnscheduleablePodOptions(test.PodOptions{
+			ObjectMeta:          metav1.ObjectMeta{Labels: labels},
+			PodAntiRequirements: []v1.PodAffinityTerm{{LabelSelector: &metav1.LabelSelector{MatchLabels: labels}, TopologyKey: v1.LabelHostname}},
+		}))
+		nodes := ExpectSolved(scheduling.SchedulerOptions{MaxTopologyDomains: 2, StrictTopologyDomains: true}, pods...)
+		Expect(nodes).To(HaveLen(2))
 
-		sc := test.StorageClass(test.StorageClassOptions{
-			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
-			Provisioner: ptr.String(csiProvider),
-			Zones:       []string{"test-zone-1"}})
-		ExpectApplied(ctx, env.Client, sc)
+		scheduled := lo.FlatMap(nodes, func(n *scheduling.Node, _ int) []*v1.Pod { return n.Pods })
+		remaining, _ := lo.Difference(pods, scheduled)
+		Expect(remaining).To(HaveLen(1))
+		messages := FailureMessages()
+		Expect(messages).To(HaveLen(1))
+		Expect(messages).To(HaveKeyWithValue(remaining[0].Name, ContainSubstring("exceeded the maximum number of topology domains")))
+	})
+})
 
-		var pods []*v1.Pod
-		for i := 0; i < 6; i++ {
-			pvcA := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
-				StorageClassName: ptr.String("my-storage-class"),
-				ObjectMeta:       metav1.ObjectMeta{Name: fmt.Sprintf("my-claim-a-%d", i)},
+var _ = Describe("Scheduling Failures", func() {
+	ExpectFailedSchedulingAnnotations := func(pod *v1.Pod) map[string]string {
+		var annotations map[string]string
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1.Pod); ok && p.Name == pod.Name && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+				annotations = evt.Annotations
+			}
+		})
+		Expect(annotations).ToNot(BeNil())
+		return annotations
+	}
+	ExpectFailedSchedulingMessage := func(pod *v1.Pod) string {
+		var message string
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1.Pod); ok && p.Name == pod.Name && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+				message = evt.Message
+			}
+		})
+		Expect(message).ToNot(BeEmpty())
+		return message
+	}
+	ExpectNoFailedSchedulingEvents := func(pods ...*v1.Pod) {
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1.Pod); ok && evt.Reason == events.PodFailedToSchedule(p, fmt.Errorf("")).Reason {
+				Expect(pods).ToNot(ContainElement(HaveField("UID", p.UID)))
+			}
+		})
+	}
+	It("should report the provisioners and the resource shortfall", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		annotations := ExpectFailedSchedulingAnnotations(pod)
+		Expect(annotations).To(HaveKeyWithValue("provisioners", provisioner.Name))
+		Expect(annotations).To(HaveKeyWithValue("relaxations", "0"))
+		Expect(annotations).To(HaveKeyWithValue("shortfall", ContainSubstring(provisioner.Name+"=")))
+		Expect(annotations).To(HaveKeyWithValue("shortfall", ContainSubstring("cpu")))
+	})
+	It("should not report a shortfall if an instance type is large enough", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		annotations := ExpectFailedSchedulingAnnotations(pod)
+		Expect(annotations).To(HaveKeyWithValue("provisioners", provisioner.Name))
+		Expect(annotations).ToNot(HaveKey("shortfall"))
+	})
+	It("should report the number of relaxed preferences", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			NodePreferences: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+			},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(ExpectFailedSchedulingAnnotations(pod)).To(HaveKeyWithValue("relaxations", "1"))
+	})
+	It("should fail fast without per-pod events if there are no provisioners", func() {
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(3, test.PodOptions{})...)
+		for _, pod := range pods {
+			ExpectNotScheduled(ctx, env.Client, pod)
+		}
+		ExpectNoFailedSchedulingEvents(pods...)
+
+		_, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{})
+		Expect(err).To(MatchError(scheduling.ErrNoProvisioners))
+	})
+	It("should fail to solve if the scheduler has no provisioners", func() {
+		pods := MakePods(3, test.PodOptions{})
+		s := scheduling.NewScheduler(ctx, env.Client, nil, nil, cluster, nil, nil, nil, nil, nil, recorder, scheduling.SchedulerOptions{})
+		_, _, err := s.Solve(ctx, pods)
+		Expect(err).To(MatchError(scheduling.ErrNoProvisioners))
+		ExpectNoFailedSchedulingEvents(pods...)
+	})
+	Context("Priority", func() {
+		// the API server rejects pods with a priority class that doesn't exist, so these pods are only solved in memory
+		// and need a UID for the queue to tell them apart
+		unschedulablePod := func(priorityClassName string, priority int32) *v1.Pod {
+			pod := test.UnschedulablePod(test.PodOptions{
+				PriorityClassName:    priorityClassName,
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			})
+			pod.UID = uuid.NewUUID()
+			pod.Spec.Priority = ptr.Int32(priority)
+			return pod
+		}
+		solve := func(pods ...*v1.Pod) {
+			ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+		}
+		failedToSchedule := func() []events.Event {
+			var failures []events.Event
+			recorder.ForEachEvent(func(evt events.Event) {
+				if p, ok := evt.InvolvedObject.(*v1.Pod); ok && evt.Reason == events.PodFailedToSchedule(p, fmt.Errorf("")).Reason {
+					failures = append(failures, evt)
+				}
+			})
+			return failures
+		}
+		It("should report the priority and priority class of the pod", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := unschedulablePod("high-priority", 1000)
+			solve(pod)
+			annotations := ExpectFailedSchedulingAnnotations(pod)
+			Expect(annotations).To(HaveKeyWithValue("priority", "1000"))
+			Expect(annotations).To(HaveKeyWithValue("priorityClassName", "high-priority"))
+			Expect(failedToSchedule()).To(ConsistOf(HaveField("Message", HavePrefix("Failed to schedule pod with priority class high-priority, "))))
+		})
+		It("should not report a priority class for pods without one", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := unschedulablePod("", 0)
+			solve(pod)
+			annotations := ExpectFailedSchedulingAnnotations(pod)
+			Expect(annotations).To(HaveKeyWithValue("priority", "0"))
+			Expect(annotations).ToNot(HaveKey("priorityClassName"))
+			Expect(failedToSchedule()).To(ConsistOf(HaveField("Message", HavePrefix("Failed to schedule pod, "))))
+		})
+		It("should report the failures of higher priority pods first", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			low := unschedulablePod("low-priority", -10)
+			medium := unschedulablePod("", 0)
+			high := unschedulablePod("high-priority", 1000)
+			solve(low, medium, high)
+			Expect(lo.Map(failedToSchedule(), func(evt events.Event, _ int) string {
+				return evt.InvolvedObject.(*v1.Pod).Name
+			})).To(Equal([]string{high.Name, medium.Name, low.Name}))
+		})
+	})
+	Context("Oversized Pods", func() {
+		ExpectFailedSchedulingEvent := func(pod *v1.Pod) events.Event {
+			var failed []events.Event
+			recorder.ForEachEvent(func(evt events.Event) {
+				if p, ok := evt.InvolvedObject.(*v1.Pod); ok && p.Name == pod.Name && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+					failed = append(failed, evt)
+				}
+			})
+			Expect(failed).ToNot(BeEmpty())
+			return failed[len(failed)-1]
+		}
+		oversizedPod := func() *v1.Pod {
+			return test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			})
+		}
+		It("should report a pod that is too large for any instance type", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, oversizedPod())[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			evt := ExpectFailedSchedulingEvent(pod)
+			Expect(evt.Message).To(ContainSubstring(scheduling.ErrPodTooLarge.Error()))
+			Expect(evt.DedupeTimeout).To(Equal(events.PodTooLargeToSchedule(pod, scheduling.ErrPodTooLarge).DedupeTimeout))
+		})
+		It("should report a pod that is too large identically each time it's solved so that the events are deduped", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, oversizedPod())[0]
+			first := ExpectFailedSchedulingEvent(pod)
+			ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pod)
+			second := ExpectFailedSchedulingEvent(pod)
+			Expect(second.Message).To(Equal(first.Message))
+			Expect(second.DedupeValues).To(Equal(first.DedupeValues))
+		})
+		It("should count a pod that is too large as having insufficient capacity", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := oversizedPod()
+			ExpectSolved(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, pod)
+			var failureReasons []string
+			recorder.ForEachEvent(func(evt events.Event) {
+				if evt.Reason == "ProvisioningDecision" && evt.InvolvedObject == provisioner {
+					failureReasons = append(failureReasons, evt.Annotations["failureReasons"])
+				}
+			})
+			Expect(failureReasons).To(ConsistOf(scheduling.FailureReasonInsufficientCapacity + "=1"))
+		})
+		It("should not report a pod as too large if another provisioner has a large enough instance type", func() {
+			small := test.Provisioner(test.ProvisionerOptions{
+				Weight: ptr.Int32(100),
+				Requirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small-instance-type"}},
+				},
+			})
+			ExpectApplied(ctx, env.Client, provisioner, small)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+			}))[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.ProvisionerNameLabelKey, provisioner.Name))
+		})
+		It("should report the shortfall on the largest instance type that's compatible with the pod", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "small-instance-type"},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("2900m"),
+					v1.ResourceMemory: resource.MustParse("3062Mi"),
+				}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			evt := ExpectFailedSchedulingEvent(pod)
+			Expect(evt.Message).To(ContainSubstring("short by 1 cpu and 1Gi memory on the largest available instance type small-instance-type"))
+		})
+		It("should not report pods that fail to schedule for other reasons as too large", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			evt := ExpectFailedSchedulingEvent(pod)
+			Expect(evt.Message).ToNot(ContainSubstring(scheduling.ErrPodTooLarge.Error()))
+			Expect(evt.DedupeTimeout).To(BeZero())
+		})
+		It("should not report a pod that requests a resource that no instance type advertises as too large", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"nvidia.com/gpuu": resource.MustParse("1")}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingEvent(pod).Message).ToNot(ContainSubstring(scheduling.ErrPodTooLarge.Error()))
+		})
+	})
+	Context("Unknown Resources", func() {
+		It("should report a resource that no instance type advertises", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{"nvidia.com/gpuu": resource.MustParse("1")}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).To(ContainSubstring("no instance type in any provisioner advertises resource nvidia.com/gpuu; is it a typo?"))
+		})
+		It("should report every resource that no instance type advertises", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{
+					"nvidia.com/gpuu":       resource.MustParse("1"),
+					fake.ResourceGPUVendorA: resource.MustParse("1"),
+					"example.com/unknown":   resource.MustParse("1"),
+				}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).To(ContainSubstring("advertises resources example.com/unknown, nvidia.com/gpuu; are they typos?"))
+		})
+		It("should report hugepages that no instance type preallocates rather than suggest a typo", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			hugePages := v1.ResourceList{v1.ResourceHugePagesPrefix + "1Gi": resource.MustParse("2Gi")}
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: hugePages, Limits: hugePages},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			message := ExpectFailedSchedulingMessage(pod)
+			Expect(message).To(ContainSubstring("no instance type in any provisioner advertises hugepages-1Gi; hugepages must be preallocated by the instance types that provide them"))
+			Expect(message).ToNot(ContainSubstring("typo"))
+		})
+		It("should not report resources that an instance type advertises", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).ToNot(ContainSubstring("advertises"))
+		})
+	})
+	Context("Mutually Conflicting Affinities", func() {
+		It("should report pods whose required affinities depend on each other", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "a"}},
+					PodRequirements: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "b"}},
+						TopologyKey:   v1.LabelHostname,
+					}},
+				}),
+				test.UnschedulablePod(test.PodOptions{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "b"}},
+					PodRequirements: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+						TopologyKey:   v1.LabelHostname,
+					}},
+				}),
+				test.UnschedulablePod(),
+			)
+			ExpectNotScheduled(ctx, env.Client, pods[0])
+			ExpectNotScheduled(ctx, env.Client, pods[1])
+			ExpectScheduled(ctx, env.Client, pods[2])
+			Expect(ExpectFailedSchedulingMessage(pods[0])).To(ContainSubstring(
+				fmt.Sprintf("mutually conflicting required pod affinities with pod(s) %s in the same batch", client.ObjectKeyFromObject(pods[1]))))
+			Expect(ExpectFailedSchedulingMessage(pods[1])).To(ContainSubstring(
+				fmt.Sprintf("mutually conflicting required pod affinities with pod(s) %s in the same batch", client.ObjectKeyFromObject(pods[0]))))
+		})
+		It("should not report a pod whose required affinity only selects a pod that doesn't select it", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "a"}},
+					PodRequirements: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "b"}},
+						TopologyKey:   v1.LabelHostname,
+					}},
+				}),
+				test.UnschedulablePod(test.PodOptions{
+					ObjectMeta:   metav1.ObjectMeta{Labels: map[string]string{"app": "b"}},
+					NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"},
+				}),
+			)
+			ExpectNotScheduled(ctx, env.Client, pods[0])
+			Expect(ExpectFailedSchedulingMessage(pods[0])).ToNot(ContainSubstring("mutually conflicting"))
+		})
+	})
+	Context("Unsatisfiable Requirements", func() {
+		It("should report the requirements that no provisioner provides in combination", func() {
+			// each provisioner provides one of the pod's requirements, but neither provides both
+			amd64Provisioner := test.Provisioner(test.ProvisionerOptions{
+				Labels:       map[string]string{"team": "a"},
+				Requirements: []v1.NodeSelectorRequirement{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureAmd64}}},
+			})
+			arm64Provisioner := test.Provisioner(test.ProvisionerOptions{
+				Labels:       map[string]string{"team": "b"},
+				Requirements: []v1.NodeSelectorRequirement{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureArm64}}},
+			})
+			ExpectApplied(ctx, env.Client, amd64Provisioner, arm64Provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{"team": "a", v1.LabelArchStable: v1alpha5.ArchitectureArm64},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).To(ContainSubstring(`no provisioner provides kubernetes.io/arch In [arm64] AND team In [a]`))
+		})
+		It("should report the smallest combination of requirements that no provisioner provides", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "unknown", v1.LabelTopologyZone: "test-zone-1"},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			message := ExpectFailedSchedulingMessage(pod)
+			Expect(message).To(ContainSubstring(`no provisioner provides node.kubernetes.io/instance-type In [unknown];`))
+			Expect(message).ToNot(ContainSubstring(" AND "))
+		})
+		It("should report an operating system that no provisioner provides", func() {
+			cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{Name: "linux-instance-type", OperatingSystems: sets.NewString(string(v1.Linux))}),
+			}
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := test.UnschedulablePod()
+			pod.Spec.OS = &v1.PodOS{Name: v1.Windows}
+			pod = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pod)[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).To(ContainSubstring("no provisioner provides operating system windows;"))
+		})
+		It("should not report requirements if the pod fails to schedule for another reason", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				NodeSelector:         map[string]string{v1.LabelTopologyZone: "test-zone-1"},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}},
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).ToNot(ContainSubstring("no provisioner provides"))
+		})
+	})
+	Context("Topology Spread Skew", func() {
+		labels := map[string]string{"test": "test"}
+		topology := []v1.TopologySpreadConstraint{{
+			TopologyKey:       v1.LabelTopologyZone,
+			WhenUnsatisfiable: v1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+			MaxSkew:           1,
+		}}
+		It("should report the skew and the domain that exceeds it", func() {
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}}
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, TopologySpreadConstraints: topology}),
+				test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, TopologySpreadConstraints: topology}),
+			)
+			scheduled, failed := pods[0], pods[1]
+			if scheduled.Spec.NodeName == "" {
+				scheduled, failed = failed, scheduled
+			}
+			ExpectScheduled(ctx, env.Client, scheduled)
+			ExpectNotScheduled(ctx, env.Client, failed)
+			Expect(ExpectFailedSchedulingMessage(failed)).To(ContainSubstring(
+				"unsatisfiable topology constraint for topology spread, key=topology.kubernetes.io/zone, scheduling to test-zone-1 would result in a skew of 2 which exceeds the max skew of 1"))
+		})
+		It("should report the node domain with the least skew", func() {
+			firstNode := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-1"}}})
+			secondNode := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1.LabelTopologyZone: "test-zone-2"}}})
+			provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}}}
+			ExpectApplied(ctx, env.Client, provisioner, firstNode, secondNode)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeName: firstNode.Name}),
+				test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeName: firstNode.Name}),
+				test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeName: firstNode.Name}),
+				test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeName: secondNode.Name}),
+				test.Pod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, NodeName: secondNode.Name}),
+				test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, TopologySpreadConstraints: topology}),
+			)[5]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(ExpectFailedSchedulingMessage(pod)).To(ContainSubstring("scheduling to test-zone-2 would result in a skew of 3 which exceeds the max skew of 1"))
+		})
+	})
+})
+
+var _ = Describe("Unavailable Instance Types", func() {
+	unavailableInstanceTypes := func(provisionerName string) (float64, bool) {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "karpenter_scheduling_unavailable_instance_types" {
+				continue
+			}
+			for _, m := range family.Metric {
+				for _, label := range m.Label {
+					if label.GetName() == "provisioner" && label.GetValue() == provisionerName {
+						return m.GetGauge().GetValue(), true
+					}
+				}
+			}
+		}
+		return 0, false
+	}
+	It("should count the instance types without available offerings", func() {
+		// the fake instance type defaults the offerings if none are provided
+		noOfferings := fake.NewInstanceType(fake.InstanceTypeOptions{Name: "no-offerings-instance-type"})
+		noOfferings.Offerings = nil
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "available-instance-type"}),
+			noOfferings,
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name: "unavailable-instance-type",
+				Offerings: []cloudprovider.Offering{
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1.0, Available: false},
+				},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		_, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, nil, scheduling.SchedulerOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		count, ok := unavailableInstanceTypes(provisioner.Name)
+		Expect(ok).To(BeTrue())
+		Expect(count).To(BeNumerically("==", 2))
+	})
+	It("should report zero if all instance types have available offerings", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		_, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, nil, scheduling.SchedulerOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		count, ok := unavailableInstanceTypes(provisioner.Name)
+		Expect(ok).To(BeTrue())
+		Expect(count).To(BeZero())
+	})
+	It("should not count instance types when simulating", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		_, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, nil, scheduling.SchedulerOptions{SimulationMode: true})
+		Expect(err).ToNot(HaveOccurred())
+		_, ok := unavailableInstanceTypes(provisioner.Name)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Provisioner Stats", func() {
+	gauge := func(name string, provisionerName string) (float64, bool) {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+			for _, m := range family.Metric {
+				for _, label := range m.Label {
+					if label.GetName() == "provisioner" && label.GetValue() == provisionerName {
+						return m.GetGauge().GetValue(), true
+					}
+				}
+			}
+		}
+		return 0, false
+	}
+	podsFor := func(provisioner *v1alpha5.Provisioner, count int, cpu string) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+			NodeSelector:         map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	requestedCPU := func(stat scheduling.ProvisionerStat) float64 {
+		requests := stat.Requests
+		return requests.Cpu().AsApproximateFloat64()
+	}
+	It("should group the new nodes and pods of a solve by provisioner", func() {
+		other := test.Provisioner()
+		idle := test.Provisioner()
+		ExpectApplied(ctx, env.Client, provisioner, other, idle)
+		pods := append(podsFor(provisioner, 2, "1"), podsFor(other, 3, "500m")...)
+		s, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+
+		stats := s.ProvisionerStats()
+		Expect(stats).To(HaveLen(3))
+		Expect(stats[provisioner.Name].Nodes).To(Equal(2))
+		Expect(stats[provisioner.Name].Pods).To(Equal(2))
+		Expect(requestedCPU(stats[provisioner.Name])).To(BeNumerically("~", 2))
+		Expect(stats[other.Name].Nodes).To(Equal(3))
+		Expect(stats[other.Name].Pods).To(Equal(3))
+		Expect(requestedCPU(stats[other.Name])).To(BeNumerically("~", 1.5))
+		Expect(stats[idle.Name].Nodes).To(BeZero())
+		Expect(stats[idle.Name].Pods).To(BeZero())
+		Expect(stats[idle.Name].Requests).To(BeEmpty())
+	})
+	It("should expose the stats as metrics", func() {
+		other := test.Provisioner()
+		ExpectApplied(ctx, env.Client, provisioner, other)
+		pods := append(podsFor(provisioner, 2, "1"), podsFor(other, 1, "1")...)
+		ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+
+		nodes, ok := gauge("karpenter_scheduling_new_nodes", provisioner.Name)
+		Expect(ok).To(BeTrue())
+		Expect(nodes).To(BeNumerically("==", 2))
+		nodes, ok = gauge("karpenter_scheduling_new_nodes", other.Name)
+		Expect(ok).To(BeTrue())
+		Expect(nodes).To(BeNumerically("==", 1))
+		scheduled, ok := gauge("karpenter_scheduling_new_node_pods", provisioner.Name)
+		Expect(ok).To(BeTrue())
+		Expect(scheduled).To(BeNumerically("==", 2))
+	})
+	It("should stop exposing the stats of provisioners that the last solve didn't consider", func() {
+		other := test.Provisioner()
+		ExpectApplied(ctx, env.Client, provisioner, other)
+		ExpectSolved(scheduling.SchedulerOptions{}, append(podsFor(provisioner, 1, "1"), podsFor(other, 1, "1")...)...)
+		_, ok := gauge("karpenter_scheduling_new_nodes", other.Name)
+		Expect(ok).To(BeTrue())
+
+		ExpectDeleted(ctx, env.Client, other)
+		ExpectSolved(scheduling.SchedulerOptions{}, podsFor(provisioner, 1, "1")...)
+		_, ok = gauge("karpenter_scheduling_new_nodes", other.Name)
+		Expect(ok).To(BeFalse())
+		_, ok = gauge("karpenter_scheduling_new_node_pods", other.Name)
+		Expect(ok).To(BeFalse())
+		_, ok = gauge("karpenter_scheduling_new_nodes", provisioner.Name)
+		Expect(ok).To(BeTrue())
+	})
+	It("should not expose the stats of simulations as metrics", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := podsFor(provisioner, 1, "1")
+		s, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{SimulationMode: true})
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.ProvisionerStats()[provisioner.Name].Nodes).To(Equal(1))
+		_, ok := gauge("karpenter_scheduling_new_nodes", provisioner.Name)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Instance Type Option Metrics", func() {
+	// instanceTypeOptions returns the number of observations of the provisioner's new nodes and the cumulative count of
+	// them by bucket upper bound
+	instanceTypeOptions := func(provisionerName string) (uint64, map[float64]uint64) {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "karpenter_scheduling_new_node_instance_type_options" {
+				continue
+			}
+			for _, m := range family.Metric {
+				for _, label := range m.Label {
+					if label.GetName() == "provisioner" && label.GetValue() == provisionerName {
+						buckets := map[float64]uint64{}
+						for _, bucket := range m.GetHistogram().GetBucket() {
+							buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+						}
+						return m.GetHistogram().GetSampleCount(), buckets
+					}
+				}
+			}
+		}
+		return 0, nil
+	}
+	podRequesting := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourcePods: resource.MustParse("10")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium-instance-type", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-instance-type", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourcePods: resource.MustParse("10")}}),
+		}
+	})
+	It("should observe the number of instance type options of each new node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		// the pods fit on three, two and one of the instance types
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, podRequesting("1"), podRequesting("3"), podRequesting("6"))
+		Expect(lo.Map(nodes, func(n *scheduling.Node, _ int) int { return len(n.InstanceTypeOptions) })).To(ConsistOf(3, 2, 1))
+
+		count, buckets := instanceTypeOptions(provisioner.Name)
+		Expect(count).To(BeNumerically("==", 3))
+		Expect(buckets[1]).To(BeNumerically("==", 1))
+		Expect(buckets[2]).To(BeNumerically("==", 2))
+		Expect(buckets[3]).To(BeNumerically("==", 3))
+	})
+	It("should observe the instance type options that remain after the provisioner's requirements", func() {
+		provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{{
+			Key:      v1.LabelInstanceTypeStable,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{"large-instance-type"},
+		}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{}, podRequesting("1"), podRequesting("1"))
+
+		count, buckets := instanceTypeOptions(provisioner.Name)
+		Expect(count).To(BeNumerically("==", 2))
+		Expect(buckets[1]).To(BeNumerically("==", 2))
+	})
+	It("should not observe the new nodes of simulations", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(ExpectSolved(scheduling.SchedulerOptions{SimulationMode: true}, podRequesting("1"))).To(HaveLen(1))
+
+		count, _ := instanceTypeOptions(provisioner.Name)
+		Expect(count).To(BeZero())
+	})
+})
+
+var _ = Describe("Relaxation Metrics", func() {
+	relaxations := func(kind string) float64 {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "karpenter_scheduling_relaxations_total" {
+				continue
+			}
+			for _, m := range family.Metric {
+				for _, label := range m.Label {
+					if label.GetName() == "type" && label.GetValue() == kind {
+						return m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return 0
+	}
+	It("should count relaxed node affinity preferences", func() {
+		before := relaxations(scheduling.RelaxationNodeAffinity)
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			NodePreferences: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}}},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		Expect(relaxations(scheduling.RelaxationNodeAffinity) - before).To(BeNumerically("==", 1))
+	})
+	It("should count relaxed pod affinity preferences", func() {
+		before := relaxations(scheduling.RelaxationPodAffinity)
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			PodPreferences: []v1.WeightedPodAffinityTerm{{
+				Weight: 50,
+				PodAffinityTerm: v1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"security": "s2"}},
+					TopologyKey:   v1.LabelHostname,
+				},
+			}},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		Expect(relaxations(scheduling.RelaxationPodAffinity) - before).To(BeNumerically("==", 1))
+	})
+	It("should count relaxed topology spread constraints", func() {
+		before := relaxations(scheduling.RelaxationTopologySpread)
+		labels := map[string]string{"test": "test"}
+		provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeSpot}}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, MakePods(2, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       v1alpha5.LabelCapacityType,
+				WhenUnsatisfiable: v1.ScheduleAnyway,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+				MaxSkew:           1,
+			}},
+		})...)
+		for _, pod := range pods {
+			ExpectScheduled(ctx, env.Client, pod)
+		}
+		// only spot capacity can be launched, so the second pod has to violate the max skew
+		Expect(relaxations(scheduling.RelaxationTopologySpread) - before).To(BeNumerically("==", 1))
+	})
+	It("should not count relaxations while simulating", func() {
+		before := relaxations(scheduling.RelaxationNodeAffinity)
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := []*v1.Pod{test.UnschedulablePod(test.PodOptions{
+			NodePreferences: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"invalid"}}},
+		})}
+		nodes := ExpectSolved(scheduling.SchedulerOptions{SimulationMode: true}, pods...)
+		Expect(nodes).To(HaveLen(1))
+		Expect(relaxations(scheduling.RelaxationNodeAffinity)).To(Equal(before))
+	})
+})
+
+var _ = Describe("Resource Granularity", func() {
+	fractionalCPUPods := func(count int, cpu string) []*v1.Pod {
+		return test.Pods(count, test.PodOptions{
+			Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Reason: v1.PodReasonUnschedulable, Status: v1.ConditionFalse}},
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+			},
+		})
+	}
+	BeforeEach(func() {
+		// 1.5005 CPU of capacity with 100m of overhead leaves 1400.5m for pods
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "fractional-cpu",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500500u")},
+			}),
+		}
+	})
+	It("should pack requests that sum to fractional millicores within capacity that isn't a whole millicore", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		// 1399.4m of requests rounds up to 1400m which fits within the 1400.5m available after overhead
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, fractionalCPUPods(2, "699700u")...)
+		nodeNames := sets.NewString()
+		for _, pod := range pods {
+			nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodeNames).To(HaveLen(1))
+	})
+	It("should not round capacity that isn't a whole millicore up", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		// 1400.6m of requests rounds up to 1401m which exceeds the 1400.5m available after overhead, and would only fit
+		// if the capacity were rounded up to 1501m as well
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, fractionalCPUPods(2, "700300u")...)
+		nodeNames := sets.NewString()
+		for _, pod := range pods {
+			nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodeNames).To(HaveLen(2))
+	})
+	It("should round requests that sum to fractional millicores up", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "single-cpu",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		// 900.6m of requests rounds up to 901m which exceeds the 900m available after overhead
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, fractionalCPUPods(2, "450300u")...)
+		nodeNames := sets.NewString()
+		for _, pod := range pods {
+			nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodeNames).To(HaveLen(2))
+	})
+	It("should compare exactly if no granularity is configured", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := fractionalCPUPods(2, "700300u")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{Granularity: map[v1.ResourceName]resource.Scale{}}}, pods...)
+		Expect(nodes).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Synthetic Pods", func() {
+	It("should generate pods with requests in the configured ranges", func() {
+		pods := scheduling.SyntheticPods(scheduling.SyntheticPodSpec{
+			Count:  100,
+			CPU:    scheduling.QuantityRange{Min: resource.MustParse("250m"), Max: resource.MustParse("500m")},
+			Memory: scheduling.QuantityRange{Min: resource.MustParse("1Gi"), Max: resource.MustParse("2Gi")},
+		})
+		Expect(pods).To(HaveLen(100))
+		for _, pod := range pods {
+			requests := pod.Spec.Containers[0].Resources.Requests
+			Expect(requests.Cpu().Cmp(resource.MustParse("250m"))).To(BeNumerically(">=", 0))
+			Expect(requests.Cpu().Cmp(resource.MustParse("500m"))).To(BeNumerically("<=", 0))
+			Expect(requests.Memory().Cmp(resource.MustParse("1Gi"))).To(BeNumerically(">=", 0))
+			Expect(requests.Memory().Cmp(resource.MustParse("2Gi"))).To(BeNumerically("<=", 0))
+			Expect(pod.Spec.Affinity).To(BeNil())
+			Expect(pod.Spec.TopologySpreadConstraints).To(BeEmpty())
+		}
+	})
+	It("should generate the same pods for the same seed", func() {
+		spec := scheduling.SyntheticPodSpec{Count: 20, TopologySpreadProbability: 0.5, PodAffinityProbability: 0.5, Seed: 7}
+		Expect(scheduling.SyntheticPods(spec)).To(Equal(scheduling.SyntheticPods(spec)))
+	})
+	It("should add constraints according to their probability", func() {
+		pods := scheduling.SyntheticPods(scheduling.SyntheticPodSpec{
+			Count:                      10,
+			TopologySpreadProbability:  1,
+			PodAntiAffinityProbability: 1,
+			TopologyKeys:               []string{v1.LabelTopologyZone},
+		})
+		for _, pod := range pods {
+			Expect(pod.Spec.TopologySpreadConstraints).To(HaveLen(1))
+			Expect(pod.Spec.TopologySpreadConstraints[0].TopologyKey).To(Equal(v1.LabelTopologyZone))
+			Expect(pod.Spec.Affinity.PodAffinity).To(BeNil())
+			Expect(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+		}
+	})
+	It("should generate pods that can be scheduled", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := scheduling.SyntheticPods(scheduling.SyntheticPodSpec{Count: 50, TopologySpreadProbability: 0.5})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{SimulationMode: true}, pods...)
+		scheduled := 0
+		for _, node := range nodes {
+			scheduled += len(node.Pods)
+		}
+		Expect(scheduled).To(Equal(len(pods)))
+	})
+})
+
+var _ = Describe("Minimum Viable Instance Type", func() {
+	var instanceTypes []*cloudprovider.InstanceType
+	var machineTemplate *scheduling.MachineTemplate
+	cpuPod := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+		}})
+	}
+	BeforeEach(func() {
+		instanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "large",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourceMemory: resource.MustParse("16Gi")},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "small",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("4Gi")},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "medium",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+			}),
+		}
+		machineTemplate = scheduling.NewMachineTemplate(provisioner)
+	})
+	It("should return the smallest instance type that fits the pod", func() {
+		for cpu, expected := range map[string]string{"100m": "small", "1800m": "small", "3": "medium", "7": "large"} {
+			it, err := scheduling.MinimumViableInstanceType(cpuPod(cpu), machineTemplate, instanceTypes, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(it.Name).To(Equal(expected), fmt.Sprintf("pod requesting %s cpu", cpu))
+		}
+	})
+	It("should account for the daemon overhead", func() {
+		it, err := scheduling.MinimumViableInstanceType(cpuPod("1500m"), machineTemplate, instanceTypes, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(it.Name).To(Equal("medium"))
+	})
+	It("should return an error if the pod doesn't fit on any instance type", func() {
+		_, err := scheduling.MinimumViableInstanceType(cpuPod("9"), machineTemplate, instanceTypes, nil)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should report the shortfall on the largest instance type", func() {
+		pod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10900m"), v1.ResourceMemory: resource.MustParse("20470Mi")},
+		}})
+		_, err := scheduling.MinimumViableInstanceType(pod, machineTemplate, instanceTypes, nil)
+		Expect(err).To(MatchError(ContainSubstring("short by 3 cpu and 4Gi memory on the largest available instance type large")))
+	})
+	It("should only report the resources that the largest instance type is short of", func() {
+		_, err := scheduling.MinimumViableInstanceType(cpuPod("9"), machineTemplate, instanceTypes, nil)
+		Expect(err).To(MatchError(ContainSubstring("short by 1100m cpu on the largest available instance type large")))
+	})
+	It("should include the daemon overhead in the shortfall", func() {
+		_, err := scheduling.MinimumViableInstanceType(cpuPod("7"), machineTemplate, instanceTypes, v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+		Expect(err).To(MatchError(ContainSubstring("short by 1100m cpu on the largest available instance type large")))
+	})
+	It("should report the shortfall on the largest instance type that's compatible with the pod", func() {
+		pod := cpuPod("5")
+		pod.Spec.NodeSelector = map[string]string{v1.LabelInstanceTypeStable: "medium"}
+		_, err := scheduling.MinimumViableInstanceType(pod, machineTemplate, instanceTypes, nil)
+		Expect(err).To(MatchError(ContainSubstring("short by 1100m cpu on the largest available instance type medium")))
+	})
+	It("should return an error if the pod isn't compatible with the machine template", func() {
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}})
+		_, err := scheduling.MinimumViableInstanceType(pod, machineTemplate, instanceTypes, nil)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should return an error if the pod doesn't tolerate the machine template taints", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "foo", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+		_, err := scheduling.MinimumViableInstanceType(cpuPod("100m"), scheduling.NewMachineTemplate(provisioner), instanceTypes, nil)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should only consider instance types with an offering that's compatible with the pod", func() {
+		instanceTypes[1].Offerings = []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-2", Price: 1, Available: true}}
+		pod := cpuPod("100m")
+		pod.Spec.NodeSelector = map[string]string{v1.LabelTopologyZone: "test-zone-1"}
+		it, err := scheduling.MinimumViableInstanceType(pod, machineTemplate, instanceTypes, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(it.Name).To(Equal("medium"))
+	})
+})
+
+var _ = Describe("Usable Instance Types", func() {
+	var instanceTypes []*cloudprovider.InstanceType
+	usable := func() []string {
+		return lo.Map(scheduling.UsableInstanceTypes(scheduling.NewMachineTemplate(provisioner), instanceTypes), func(it *cloudprovider.InstanceType, _ int) string {
+			return it.Name
+		})
+	}
+	BeforeEach(func() {
+		instanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "amd64"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "arm64", Architecture: v1alpha5.ArchitectureArm64}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "on-demand-zone-3",
+				Offerings: []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-3", Price: 1, Available: true}},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "unavailable",
+				Offerings: []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1, Available: false}},
+			}),
+		}
+	})
+	It("should return every instance type with an available offering for a provisioner without requirements", func() {
+		Expect(usable()).To(ConsistOf("amd64", "arm64", "on-demand-zone-3"))
+	})
+	It("should only return instance types that are compatible with the provisioner's requirements", func() {
+		provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+			{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureArm64}},
+		}
+		Expect(usable()).To(ConsistOf("arm64"))
+	})
+	It("should only return instance types with an offering that's compatible with the provisioner's requirements", func() {
+		provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeSpot}},
+		}
+		Expect(usable()).To(ConsistOf("amd64", "arm64"))
+	})
+	It("should honor the provisioner's labels", func() {
+		provisioner.Spec.Labels = map[string]string{v1.LabelTopologyZone: "test-zone-3"}
+		Expect(usable()).To(ConsistOf("amd64", "arm64", "on-demand-zone-3"))
+		provisioner.Spec.Labels = map[string]string{v1.LabelTopologyZone: "test-zone-1"}
+		Expect(usable()).To(ConsistOf("amd64", "arm64"))
+	})
+	It("should return no instance types if the requirements can't be satisfied", func() {
+		provisioner.Spec.Requirements = []v1.NodeSelectorRequirement{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown-zone"}},
+		}
+		Expect(usable()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Volumes", func() {
+	It("should launch multiple newNodes if required due to volume limits", func() {
+		const csiProvider = "fake.csi.provider"
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(
+				fake.InstanceTypeOptions{
+					Name: "instance-type",
+					Resources: map[v1.ResourceName]resource.Quantity{
+						v1.ResourceCPU:  resource.MustParse("1024"),
+						v1.ResourcePods: resource.MustParse("1024"),
+					},
+				}),
+		}
+
+		provisioner.Spec.Limits = nil
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+		node := ExpectScheduled(ctx, env.Client, initialPods[0])
+		csiNode := &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: node.Name,
+			},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{
+					{
+						Name:   csiProvider,
+						NodeID: "fake-node-id",
+						Allocatable: &storagev1.VolumeNodeResources{
+							Count: ptr.Int32(10),
+						},
+					},
+				},
+			},
+		}
+		ExpectApplied(ctx, env.Client, csiNode)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+		sc := test.StorageClass(test.StorageClassOptions{
+			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
+			Provisioner: ptr.String(csiProvider),
+			Zones:       []string{"test-zone-1"}})
+		ExpectApplied(ctx, env.Client, sc)
+
+		var pods []*v1.Pod
+		for i := 0; i < 6; i++ {
+			pvcA := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				StorageClassName: ptr.String("my-storage-class"),
+				ObjectMeta:       metav1.ObjectMeta{Name: fmt.Sprintf("my-claim-a-%d", i)},
+			})
+			pvcB := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+				StorageClassName: ptr.String("my-storage-class"),
+				ObjectMeta:       metav1.ObjectMeta{Name: fmt.Sprintf("my-claim-b-%d", i)},
+			})
+			ExpectApplied(ctx, env.Client, pvcA, pvcB)
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: []string{pvcA.Name, pvcB.Name},
+			}))
+		}
+		ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+		var nodeList v1.NodeList
+		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
+		// we need to create a new node as the in-flight one can only contain 5 pods due to the CSINode volume limit
+		Expect(nodeList.Items).To(HaveLen(2))
+	})
+	It("should launch a single node if all pods use the same PVC", func() {
+		const csiProvider = "fake.csi.provider"
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(
+				fake.InstanceTypeOptions{
+					Name: "instance-type",
+					Resources: map[v1.ResourceName]resource.Quantity{
+						v1.ResourceCPU:  resource.MustParse("1024"),
+						v1.ResourcePods: resource.MustParse("1024"),
+					},
+				}),
+		}
+
+		provisioner.Spec.Limits = nil
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+		node := ExpectScheduled(ctx, env.Client, initialPods[0])
+		csiNode := &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: node.Name,
+			},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{
+					{
+						Name:   csiProvider,
+						NodeID: "fake-node-id",
+						Allocatable: &storagev1.VolumeNodeResources{
+							Count: ptr.Int32(10),
+						},
+					},
+				},
+			},
+		}
+		ExpectApplied(ctx, env.Client, csiNode)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+		sc := test.StorageClass(test.StorageClassOptions{
+			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
+			Provisioner: ptr.String(csiProvider),
+			Zones:       []string{"test-zone-1"}})
+		ExpectApplied(ctx, env.Client, sc)
+
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-volume"},
+			Zones:      []string{"test-zone-1"}})
+
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
+			StorageClassName: ptr.String("my-storage-class"),
+			VolumeName:       pv.Name,
+		})
+		ExpectApplied(ctx, env.Client, pv, pvc)
+
+		var pods []*v1.Pod
+		for i := 0; i < 100; i++ {
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
+			}))
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+		var nodeList v1.NodeList
+		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
+		// 100 of the same PVC should all be schedulable on the same node
+		Expect(nodeList.Items).To(HaveLen(1))
+	})
+	It("should not fail for non-dynamic PVCs", func() {
+		const csiProvider = "fake.csi.provider"
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(
+				fake.InstanceTypeOptions{
+					Name: "instance-type",
+					Resources: map[v1.ResourceName]resource.Quantity{
+						v1.ResourceCPU:  resource.MustParse("1024"),
+						v1.ResourcePods: resource.MustParse("1024"),
+					},
+				}),
+		}
+
+		provisioner.Spec.Limits = nil
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+		node := ExpectScheduled(ctx, env.Client, initialPods[0])
+		csiNode := &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: node.Name,
+			},
+			Spec: storagev1.CSINodeSpec{
+				Drivers: []storagev1.CSINodeDriver{
+					{
+						Name:   csiProvider,
+						NodeID: "fake-node-id",
+						Allocatable: &storagev1.VolumeNodeResources{
+							Count: ptr.Int32(10),
+						},
+					},
+				},
+			},
+		}
+		ExpectApplied(ctx, env.Client, csiNode)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+		sc := test.StorageClass(test.StorageClassOptions{
+			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
+			Provisioner: ptr.String(csiProvider),
+			Zones:       []string{"test-zone-1"}})
+		ExpectApplied(ctx, env.Client, sc)
+
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-volume"},
+			Driver:     csiProvider,
+			Zones:      []string{"test-zone-1"}})
+
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
+			VolumeName:       pv.Name,
+			StorageClassName: ptr.String(""),
+		})
+		ExpectApplied(ctx, env.Client, pv, pvc)
+
+		var pods []*v1.Pod
+		for i := 0; i < 5; i++ {
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
+			}))
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+
+		var nodeList v1.NodeList
+		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
+		// 5 of the same PVC should all be schedulable on the same node
+		Expect(nodeList.Items).To(HaveLen(1))
+	})
+	It("should not fail for NFS volumes", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(
+				fake.InstanceTypeOptions{
+					Name: "instance-type",
+					Resources: map[v1.ResourceName]resource.Quantity{
+						v1.ResourceCPU:  resource.MustParse("1024"),
+						v1.ResourcePods: resource.MustParse("1024"),
+					},
+				}),
+		}
+
+		provisioner.Spec.Limits = nil
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+		node := ExpectScheduled(ctx, env.Client, initialPods[0])
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+		pv := test.PersistentVolume(test.PersistentVolumeOptions{
+			ObjectMeta:       metav1.ObjectMeta{Name: "my-volume"},
+			StorageClassName: "nfs",
+			Zones:            []string{"test-zone-1"}})
+		pv.Spec.NFS = &v1.NFSVolumeSource{
+			Server: "fake.server",
+			Path:   "/some/path",
+		}
+		pv.Spec.CSI = nil
+
+		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
+			VolumeName:       pv.Name,
+			StorageClassName: ptr.String(""),
+		})
+		ExpectApplied(ctx, env.Client, pv, pvc)
+
+		var pods []*v1.Pod
+		for i := 0; i < 5; i++ {
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
+			}))
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+
+		var nodeList v1.NodeList
+		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
+		// 5 of the same PVC should all be schedulable on the same node
+		Expect(nodeList.Items).To(HaveLen(1))
+	})
+	It("should not launch nodes for pods with ephemeral volume using a non-existent storage classes", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name: "tmp-ephemeral",
+			VolumeSource: v1.VolumeSource{
+				Ephemeral: &v1.EphemeralVolumeSource{
+					VolumeClaimTemplate: &v1.PersistentVolumeClaimTemplate{
+						Spec: v1.PersistentVolumeClaimSpec{
+							StorageClassName: ptr.String("non-existent"),
+							AccessModes: []v1.PersistentVolumeAccessMode{
+								v1.ReadWriteOnce,
+							},
+							Resources: v1.ResourceRequirements{
+								Requests: v1.ResourceList{
+									v1.ResourceStorage: resource.MustParse("1Gi"),
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		ExpectApplied(ctx, env.Client, provisioner)
+		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pod)
+
+		var nodeList v1.NodeList
+		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
+		// no nodes should be created as the storage class doesn't eixst
+		Expect(nodeList.Items).To(HaveLen(0))
+	})
+	Context("Attachable Volumes", func() {
+		// claims creates persistent volume claims, returning their names
+		claims := func(prefix string, count int) []string {
+			var names []string
+			for i := 0; i < count; i++ {
+				pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
+					StorageClassName: ptr.String("my-storage-class"),
+					ObjectMeta:       metav1.ObjectMeta{Name: fmt.Sprintf("%s-%d", prefix, i)},
+				})
+				ExpectApplied(ctx, env.Client, pvc)
+				names = append(names, pvc.Name)
+			}
+			return names
+		}
+		BeforeEach(func() {
+			cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{
+					Name:              "limited-25",
+					Resources:         v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("32")},
+					AttachableVolumes: 25,
+				}),
+				fake.NewInstanceType(fake.InstanceTypeOptions{
+					Name:              "limited-40",
+					Resources:         v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourcePods: resource.MustParse("32")},
+					AttachableVolumes: 40,
+				}),
+			}
+			ExpectApplied(ctx, env.Client, test.StorageClass(test.StorageClassOptions{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-storage-class"},
+				Zones:      []string{"test-zone-1"},
+			}))
+		})
+		It("should exclude instance types that can't attach all of a pod's volumes", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: claims("my-claim", 30),
+			}))[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "limited-40"))
+		})
+		It("should launch the cheaper instance type if it can attach all of a pod's volumes", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: claims("my-claim", 20),
+			}))[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "limited-25"))
+		})
+		It("should not schedule pods that need more volumes than any instance type can attach", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+				PersistentVolumeClaims: claims("my-claim", 41),
+			}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+		It("should count the volumes of all of the pods on a node", func() {
+			cloudProv.InstanceTypes = cloudProv.InstanceTypes[:1]
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: claims("my-claim-a", 15)}),
+				test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: claims("my-claim-b", 15)}),
+			)
+			// the pods need 30 volumes between them, so they can't share a node
+			Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).ToNot(Equal(ExpectScheduled(ctx, env.Client, pods[1]).Name))
+		})
+		It("should count a claim that's shared by pods once", func() {
+			cloudProv.InstanceTypes = cloudProv.InstanceTypes[:1]
+			ExpectApplied(ctx, env.Client, provisioner)
+			shared := claims("my-claim", 15)
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+				test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: shared}),
+				test.UnschedulablePod(test.PodOptions{PersistentVolumeClaims: shared}),
+			)
+			Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).To(Equal(ExpectScheduled(ctx, env.Client, pods[1]).Name))
+		})
+	})
+})
+
+func MakePods(count int, options test.PodOptions) (pods []*v1.Pod) {
+	for i := 0; i < count; i++ {
+		pods = append(pods, test.UnschedulablePod(options))
+	}
+	return pods
+}
+
+// ExpectSolved solves the pods with a new scheduler that's configured with the options and returns the new nodes
+func ExpectSolved(opts scheduling.SchedulerOptions, pods ...*v1.Pod) []*scheduling.Node {
+	nodes, _ := expectSolvedOntoWithOffset(1, opts, nil, pods...)
+	return nodes
+}
+
+// ExpectSolvedOnto solves the pods with a new scheduler that's configured with the options and considers the state
+// nodes, returning the new nodes and the existing nodes
+func ExpectSolvedOnto(opts scheduling.SchedulerOptions, stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+	return expectSolvedOntoWithOffset(1, opts, stateNodes, pods...)
+}
+
+func expectSolvedOntoWithOffset(offset int, opts scheduling.SchedulerOptions, stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+	s, err := prov.NewScheduler(ctx, pods, stateNodes, opts)
+	ExpectWithOffset(offset+1, err).ToNot(HaveOccurred())
+	nodes, existingNodes, err := s.Solve(ctx, pods)
+	ExpectWithOffset(offset+1, err).ToNot(HaveOccurred())
+	return nodes, existingNodes
+}
+
+// FailureMessages returns the messages of the events recorded for pods that failed to schedule, keyed by pod name
+func FailureMessages() map[string]string {
+	messages := map[string]string{}
+	recorder.ForEachEvent(func(evt events.Event) {
+		if pod, ok := evt.InvolvedObject.(*v1.Pod); ok && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+			messages[pod.Name] = evt.Message
+		}
+	})
+	return messages
+}
+
+// StateNodes returns copies of the nodes tracked by the cluster state
+func StateNodes() []*state.Node {
+	var stateNodes []*state.Node
+	cluster.ForEachNode(func(n *state.Node) bool {
+		stateNodes = append(stateNodes, n.DeepCopy())
+		return true
+	})
+	return stateNodes
+}
+
+// nolint:gocyclo
+func ExpectMaxSkew(ctx context.Context, c client.Client, namespace string, constraint *v1.TopologySpreadConstraint) Assertion {
+	nodes := &v1.NodeList{}
+	Expect(c.List(ctx, nodes)).To(Succeed())
+	pods := &v1.PodList{}
+	Expect(c.List(ctx, pods, scheduling.TopologyListOptions(namespace, constraint.LabelSelector))).To(Succeed())
+	skew := map[string]int{}
+
+	nodeMap := map[string]*v1.Node{}
+	for i, node := range nodes.Items {
+		nodeMap[node.Name] = &nodes.Items[i]
+	}
+
+	for i, pod := range pods.Items {
+		if scheduling.IgnoredForTopology(&pods.Items[i]) {
+			continue
+		}
+		node := nodeMap[pod.Spec.NodeName]
+		if pod.Spec.NodeName == node.Name {
+			if constraint.TopologyKey == v1.LabelHostname {
+				skew[node.Name]++ // Check node name since hostname labels aren't applied
+			}
+			if constraint.TopologyKey == v1.LabelTopologyZone {
+				if key, ok := node.Labels[constraint.TopologyKey]; ok {
+					skew[key]++
+				}
+			}
+			if constraint.TopologyKey == v1alpha5.LabelCapacityType {
+				if key, ok := node.Labels[constraint.TopologyKey]; ok {
+					skew[key]++
+				}
+			}
+		}
+	}
+
+	var minCount = math.MaxInt
+	var maxCount = math.MinInt
+	for _, count := range skew {
+		if count < minCount {
+			minCount = count
+		}
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	return Expect(maxCount - minCount)
+}
+
+var _ = Describe("Instance Type Preferences", func() {
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		var names []string
+		for _, it := range node.InstanceTypeOptions {
+			names = append(names, it.Name)
+		}
+		return names
+	}
+	solve := func(preferences scheduling.InstanceTypePreferences, pod *v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{InstanceTypePreferences: preferences}}, pod)
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m4.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m6.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "c5.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m5.large"}),
+		}
+	})
+	It("should not reorder the instance types without preferences", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m4.large", "m6.large", "c5.large", "m5.large"}))
+	})
+	It("should list newer generations first when they all fit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{PreferNewerGenerations: true}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m6.large", "c5.large", "m5.large", "m4.large"}))
+	})
+	It("should list preferred families first", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{Families: []string{"c5", "m4"}}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"c5.large", "m4.large", "m6.large", "m5.large"}))
+	})
+	It("should order by generation within the preferred families", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{Families: []string{"m4", "m5", "m6"}, PreferNewerGenerations: true}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		// families take precedence over generations
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m4.large", "m5.large", "m6.large", "c5.large"}))
+	})
+	It("should read the generation from the instance type requirements", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{PreferNewerGenerations: true, GenerationLabelKey: fake.IntegerInstanceLabelKey}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"large", "medium", "small"}))
+	})
+	It("should fall back to older generations if newer generations don't fit", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m4.xlarge", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m6.large"}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(scheduling.InstanceTypePreferences{PreferNewerGenerations: true}, test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("6")}},
+		}))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m4.xlarge"}))
+	})
+	Context("Preferred Node Affinity", func() {
+		// the heaviest preferred term is enforced while scheduling, so every pod prefers a zone that all of the
+		// instance types are offered in before its lighter instance type preferences
+		preferringPod := func(requirements []v1.NodeSelectorRequirement, preferences ...v1.PreferredSchedulingTerm) *v1.Pod {
+			pod := test.UnschedulablePod(test.PodOptions{NodeRequirements: requirements})
+			if pod.Spec.Affinity == nil {
+				pod.Spec.Affinity = &v1.Affinity{}
+			}
+			if pod.Spec.Affinity.NodeAffinity == nil {
+				pod.Spec.Affinity.NodeAffinity = &v1.NodeAffinity{}
+			}
+			pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append([]v1.PreferredSchedulingTerm{{
+				Weight: 50,
+				Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+				}},
+			}}, preferences...)
+			return pod
+		}
+		preferInstanceTypes := func(weight int32, names ...string) v1.PreferredSchedulingTerm {
+			return v1.PreferredSchedulingTerm{
+				Weight: weight,
+				Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: names},
+				}},
+			}
+		}
+		It("should list the instance type that better matches the preferred affinity first", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := solve(scheduling.InstanceTypePreferences{}, preferringPod(
+				[]v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m4.large", "m5.large"}}},
+				preferInstanceTypes(10, "m5.large"),
+			))
+			Expect(nodes).To(HaveLen(1))
+			Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m5.large", "m4.large"}))
+		})
+		It("should order by the aggregate weight of the matching preferred terms", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := solve(scheduling.InstanceTypePreferences{}, preferringPod(nil,
+				preferInstanceTypes(10, "m4.large", "c5.large"),
+				preferInstanceTypes(15, "m5.large", "c5.large"),
+			))
+			Expect(nodes).To(HaveLen(1))
+			Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"c5.large", "m5.large", "m4.large", "m6.large"}))
+		})
+		It("should take precedence over the instance type preferences", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := solve(scheduling.InstanceTypePreferences{Families: []string{"m5", "c5"}}, preferringPod(nil,
+				preferInstanceTypes(10, "m4.large"),
+			))
+			Expect(nodes).To(HaveLen(1))
+			// instance types that are equally preferred by the pod are ordered by the instance type preferences
+			Expect(instanceTypeNames(nodes[0])).To(Equal([]string{"m4.large", "m5.large", "c5.large", "m6.large"}))
+		})
+	})
+})
+
+var _ = Describe("Instance Type Diversity", func() {
+	// dedicated pods each require their own node
+	dedicatedPods := func(count int) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})
+	}
+	preferredInstanceTypes := func(nodes []*scheduling.Node) []string {
+		return lo.Map(nodes, func(n *scheduling.Node, _ int) string { return n.InstanceTypeOptions[0].Name })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m5.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "c5.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "r5.large"}),
+		}
+	})
+	It("should prefer the same instance type for every node by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, dedicatedPods(3)...)
+		Expect(nodes).To(HaveLen(3))
+		Expect(preferredInstanceTypes(nodes)).To(Equal([]string{"m5.large", "m5.large", "m5.large"}))
+	})
+	It("should prefer a different instance type for each node in a batch", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{DiversifyInstanceTypes: true}}, dedicatedPods(3)...)
+		Expect(nodes).To(HaveLen(3))
+		Expect(preferredInstanceTypes(nodes)).To(ConsistOf("m5.large", "c5.large", "r5.large"))
+	})
+	It("should spread nodes evenly across the instance types when there are more nodes than instance types", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{DiversifyInstanceTypes: true}}, dedicatedPods(6)...)
+		Expect(nodes).To(HaveLen(6))
+		Expect(lo.CountValues(preferredInstanceTypes(nodes))).To(Equal(map[string]int{"m5.large": 2, "c5.large": 2, "r5.large": 2}))
+	})
+	It("should not change the instance type options of each node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{DiversifyInstanceTypes: true}}, dedicatedPods(3)...)
+		Expect(nodes).To(HaveLen(3))
+		for _, n := range nodes {
+			Expect(lo.Map(n.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })).To(ConsistOf("m5.large", "c5.large", "r5.large"))
+		}
+	})
+	It("should rotate the preferred instance types after ordering them", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{
+			InstanceTypes: scheduling.InstanceTypeSelectionOptions{
+				DiversifyInstanceTypes:  true,
+				InstanceTypePreferences: scheduling.InstanceTypePreferences{Families: []string{"r5", "c5", "m5"}},
+			},
+		}, dedicatedPods(2)...)
+		Expect(nodes).To(HaveLen(2))
+		Expect(lo.Map(nodes, func(n *scheduling.Node, _ int) []string {
+			return lo.Map(n.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+		})).To(ConsistOf(
+			[]string{"r5.large", "c5.large", "m5.large"},
+			[]string{"c5.large", "m5.large", "r5.large"},
+		))
+	})
+})
+
+var _ = Describe("Scheduling Profiles", func() {
+	profiles := []scheduling.SchedulingProfile{
+		{Name: "newer", InstanceTypePreferences: scheduling.InstanceTypePreferences{PreferNewerGenerations: true}},
+		{Name: "zonal", ExcludedZones: []string{"test-zone-1", "test-zone-2"}},
+	}
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	profileProvisioner := func(profile string) *v1alpha5.Provisioner {
+		return test.Provisioner(test.ProvisionerOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.SchedulingProfileLabelKey: profile}},
+		})
+	}
+	podFor := func(provisioner *v1alpha5.Provisioner) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}})
+	}
+	solve := func(opts scheduling.SchedulerOptions, pods ...*v1.Pod) map[string]*scheduling.Node {
+		nodes := ExpectSolved(opts, pods...)
+		return lo.KeyBy(nodes, func(n *scheduling.Node) string { return n.ProvisionerName })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m4.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m6.large"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "m5.large"}),
+		}
+	})
+	It("should schedule the nodes of each provisioner with its own profile in the same solve", func() {
+		newer := profileProvisioner("newer")
+		zonal := profileProvisioner("zonal")
+		ExpectApplied(ctx, env.Client, newer, zonal)
+		nodes := solve(scheduling.SchedulerOptions{Profiles: profiles}, podFor(newer), podFor(zonal))
+		Expect(nodes).To(HaveLen(2))
+
+		Expect(instanceTypeNames(nodes[newer.Name])).To(Equal([]string{"m6.large", "m5.large", "m4.large"}))
+		Expect(nodes[newer.Name].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-1")).To(BeTrue())
+
+		Expect(instanceTypeNames(nodes[zonal.Name])).To(Equal([]string{"m4.large", "m6.large", "m5.large"}))
+		Expect(nodes[zonal.Name].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-1")).To(BeFalse())
+		Expect(nodes[zonal.Name].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-2")).To(BeFalse())
+	})
+	It("should fail to schedule pods pinned to a zone that their provisioner's profile excludes", func() {
+		zonal := profileProvisioner("zonal")
+		ExpectApplied(ctx, env.Client, zonal)
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: zonal.Name,
+			v1.LabelTopologyZone:             "test-zone-1",
+		}})
+		Expect(solve(scheduling.SchedulerOptions{Profiles: profiles}, pod)).To(BeEmpty())
+	})
+	It("should use the scheduler options for provisioners without a profile", func() {
+		newer := profileProvisioner("newer")
+		ExpectApplied(ctx, env.Client, newer, provisioner)
+		nodes := solve(scheduling.SchedulerOptions{
+			Profiles: profiles,
+			InstanceTypes: scheduling.InstanceTypeSelectionOptions{
+				InstanceTypePreferences: scheduling.InstanceTypePreferences{Families: []string{"m5"}},
+			},
+		}, podFor(newer), podFor(provisioner))
+		Expect(nodes).To(HaveLen(2))
+		Expect(instanceTypeNames(nodes[newer.Name])).To(Equal([]string{"m6.large", "m5.large", "m4.large"}))
+		Expect(instanceTypeNames(nodes[provisioner.Name])).To(Equal([]string{"m5.large", "m4.large", "m6.large"}))
+	})
+	It("should use the scheduler options for provisioners that select an unknown profile", func() {
+		unknown := profileProvisioner("unknown")
+		ExpectApplied(ctx, env.Client, unknown)
+		nodes := solve(scheduling.SchedulerOptions{
+			Profiles: profiles,
+			InstanceTypes: scheduling.InstanceTypeSelectionOptions{
+				InstanceTypePreferences: scheduling.InstanceTypePreferences{Families: []string{"m5"}},
+			},
+		}, podFor(unknown))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[unknown.Name])).To(Equal([]string{"m5.large", "m4.large", "m6.large"}))
+	})
+	It("should only diversify the instance types of provisioners whose profile enables it", func() {
+		diverse := profileProvisioner("diverse")
+		uniform := profileProvisioner("uniform")
+		ExpectApplied(ctx, env.Client, diverse, uniform)
+		dedicatedPods := func(provisioner *v1alpha5.Provisioner) []*v1.Pod {
+			return MakePods(3, test.PodOptions{
+				ObjectMeta:   metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+				NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			})
+		}
+		pods := append(dedicatedPods(diverse), dedicatedPods(uniform)...)
+		s, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{Profiles: []scheduling.SchedulingProfile{
+			{Name: "diverse", DiversifyInstanceTypes: true},
+			{Name: "uniform"},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		nodes, _, err := s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(6))
+		preferred := map[string][]string{}
+		for _, n := range nodes {
+			preferred[n.ProvisionerName] = append(preferred[n.ProvisionerName], n.InstanceTypeOptions[0].Name)
+		}
+		Expect(preferred[diverse.Name]).To(ConsistOf("m4.large", "m6.large", "m5.large"))
+		Expect(preferred[uniform.Name]).To(Equal([]string{"m4.large", "m4.large", "m4.large"}))
+	})
+})
+
+var _ = Describe("Max Instance Resources", func() {
+	solve := func(pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+	}
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	cpuPod := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "huge", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("96")}}),
+		}
+	})
+	It("should not launch instance types above the maximum for a small pod", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxInstanceResources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPod("100m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small", "medium"))
+	})
+	It("should consider every instance type without a maximum", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPod("100m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small", "medium", "huge"))
+	})
+	It("should launch instance types above the maximum for a pod that requires them", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxInstanceResources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPod("20"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("huge"))
+	})
+	It("should launch more nodes rather than exceed the maximum", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxInstanceResources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPod("5"), cpuPod("5"))
+		Expect(nodes).To(HaveLen(2))
+		for _, node := range nodes {
+			Expect(instanceTypeNames(node)).To(ConsistOf("medium"))
+		}
+	})
+	It("should not cap resources that the maximum doesn't specify", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxInstanceResources: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Ti")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPod("100m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small", "medium", "huge"))
+	})
+})
+
+var _ = Describe("Max Node Requests", func() {
+	solve := func(pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+	}
+	cpuPods := func(count int, cpu string) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourcePods: resource.MustParse("100")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "huge", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("96"), v1.ResourcePods: resource.MustParse("100")}}),
+		}
+	})
+	It("should pack a batch onto a single node without a maximum", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(cpuPods(8, "4")...)).To(HaveLen(1))
+	})
+	It("should spread a batch across more nodes rather than exceed the maximum", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxNodeRequests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("16")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(cpuPods(8, "4")...)
+		Expect(nodes).To(HaveLen(2))
+		for _, node := range nodes {
+			Expect(node.Pods).To(HaveLen(4))
+			Expect(node.Requests.Cpu().Cmp(resource.MustParse("16"))).To(BeNumerically("<=", 0))
+		}
+	})
+	It("should schedule a pod that exceeds the maximum on its own to a node of its own", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxNodeRequests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(append(cpuPods(1, "20"), cpuPods(1, "1")...)...)
+		Expect(nodes).To(HaveLen(2))
+		for _, node := range nodes {
+			Expect(node.Pods).To(HaveLen(1))
+		}
+	})
+	It("should not cap resources that the maximum doesn't specify", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{MaxNodeRequests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(cpuPods(8, "4")...)).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Cost Estimation", func() {
+	estimate := func(pods ...*v1.Pod) map[string]float64 {
+		s, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		costs, err := s.EstimateCost(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		return costs
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "small-instance-type",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+				Offerings: []cloudprovider.Offering{
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1.0, Available: true},
+					{CapacityType: v1alpha5.CapacityTypeSpot, Zone: "test-zone-1", Price: 0.4, Available: true},
+					{CapacityType: v1alpha5.CapacityTypeSpot, Zone: "test-zone-2", Price: 0.1, Available: false},
+				},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "large-instance-type",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")},
+				Offerings: []cloudprovider.Offering{
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 3.0, Available: true},
+					{CapacityType: v1alpha5.CapacityTypeSpot, Zone: "test-zone-1", Price: 0.9, Available: true},
+				},
+			}),
+		}
+	})
+	It("should price each node at the cheapest available offering", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		costs := estimate(MakePods(2, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})...)
+		Expect(costs).To(HaveLen(2))
+		Expect(costs[provisioner.Name]).To(BeNumerically("~", 0.8, 0.001))
+		Expect(costs[scheduling.TotalCost]).To(BeNumerically("~", 0.8, 0.001))
+	})
+	It("should only price offerings that are compatible with the node's requirements", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		costs := estimate(
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeOnDemand}}),
+			test.UnschedulablePod(test.PodOptions{
+				NodeSelector:         map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeOnDemand},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("6")}},
+			}),
+		)
+		// the pods pack onto a single on-demand node, which only the large instance type can fit
+		Expect(costs[scheduling.TotalCost]).To(BeNumerically("~", 3.0, 0.001))
+	})
+	It("should estimate the cost for each provisioner", func() {
+		onDemand := test.Provisioner(test.ProvisionerOptions{Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeOnDemand}},
+		}})
+		ExpectApplied(ctx, env.Client, provisioner, onDemand)
+		costs := estimate(
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}}),
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: onDemand.Name}}),
+		)
+		Expect(costs).To(HaveLen(3))
+		Expect(costs[provisioner.Name]).To(BeNumerically("~", 0.4, 0.001))
+		Expect(costs[onDemand.Name]).To(BeNumerically("~", 1.0, 0.001))
+		Expect(costs[scheduling.TotalCost]).To(BeNumerically("~", 1.4, 0.001))
+	})
+	It("should estimate no cost without any pods", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(estimate()).To(Equal(map[string]float64{scheduling.TotalCost: 0}))
+	})
+	It("should not nominate the pods", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		estimate(pod)
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1.Pod); ok {
+				Expect(p.Name).ToNot(Equal(pod.Name))
+			}
+		})
+	})
+	Context("Custom Cost", func() {
+		// discounts the on-demand offerings of the large instance type, e.g. for a negotiated rate
+		discounted := func(it *cloudprovider.InstanceType, offering cloudprovider.Offering) float64 {
+			if it.Name == "large-instance-type" && offering.CapacityType == v1alpha5.CapacityTypeOnDemand {
+				return 0.1
+			}
+			return offering.Price
+		}
+		It("should estimate the cost with the cost function", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			pods := []*v1.Pod{test.UnschedulablePod()}
+			s, err := prov.NewScheduler(ctx, pods, nil, scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{CostFunc: discounted}})
+			Expect(err).ToNot(HaveOccurred())
+			costs, err := s.EstimateCost(ctx, pods)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(costs[scheduling.TotalCost]).To(BeNumerically("~", 0.1, 0.001))
+		})
+		It("should order the instance types by the cost function", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{CostFunc: discounted}}, test.UnschedulablePod())
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].InstanceTypeOptions[0].Name).To(Equal("large-instance-type"))
+		})
+		It("should order the instance types by the offering prices without a cost function", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].InstanceTypeOptions[0].Name).To(Equal("small-instance-type"))
+		})
+		It("should only cost offerings that are compatible with the node's requirements", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			// the discount only applies to on-demand, so the small instance type's spot offering is still the cheapest
+			nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{CostFunc: discounted}},
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeSpot}}))
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].InstanceTypeOptions[0].Name).To(Equal("small-instance-type"))
+		})
+	})
+})
+
+var _ = Describe("Dedicated Nodes", func() {
+	dedicatedPod := func() *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})
+	}
+	It("should launch a node for each pod that requires a dedicated node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, dedicatedPod(), dedicatedPod(), dedicatedPod())
+		nodeNames := sets.NewString()
+		for _, pod := range pods {
+			nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+		}
+		Expect(nodeNames).To(HaveLen(3))
+	})
+	It("should not pack other pods onto a dedicated node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		dedicated := dedicatedPod()
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, dedicated, test.UnschedulablePod(), test.UnschedulablePod())
+		dedicatedNode := ExpectScheduled(ctx, env.Client, dedicated)
+		sharedNode := ExpectScheduled(ctx, env.Client, pods[1])
+		Expect(ExpectScheduled(ctx, env.Client, pods[2]).Name).To(Equal(sharedNode.Name))
+		Expect(dedicatedNode.Name).ToNot(Equal(sharedNode.Name))
+	})
+	It("should not schedule a pod that requires a dedicated node to an in-flight node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		node1 := ExpectScheduled(ctx, env.Client, initialPod)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, dedicatedPod())[0]
+		node2 := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node2.Name).ToNot(Equal(node1.Name))
+	})
+	It("should schedule pods without the annotation to an in-flight node", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		node1 := ExpectScheduled(ctx, env.Client, initialPod)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node1))
+
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "false"}},
+		}))[0]
+		node2 := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node2.Name).To(Equal(node1.Name))
+	})
+	It("should respect provisioner limits when launching dedicated nodes", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, dedicatedPod(), dedicatedPod())
+		var scheduled int
+		for _, pod := range pods {
+			if ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace).Spec.NodeName != "" {
+				scheduled++
+			}
+		}
+		// the limits only allow for a single node, and the second pod can't share it
+		Expect(scheduled).To(Equal(1))
+	})
+})
+
+var _ = Describe("Default Pod Requests", func() {
+	solve := func(defaultPodRequests v1.ResourceList, stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+		return ExpectSolvedOnto(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DefaultPodRequests: defaultPodRequests}}, stateNodes, pods...)
+	}
+	BeforeEach(func() {
+		// a single CPU with 100m of overhead leaves 900m for pods
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name: "single-cpu",
+				Resources: v1.ResourceList{
+					v1.ResourceCPU:  resource.MustParse("1"),
+					v1.ResourcePods: resource.MustParse("100"),
+				},
+			}),
+		}
+	})
+	It("should pack pods without requests onto a single node by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes, _ := solve(nil, nil, MakePods(20, test.PodOptions{})...)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(20))
+	})
+	It("should use the default requests for pods without requests", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := MakePods(20, test.PodOptions{})
+		nodes, _ := solve(v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}, nil, pods...)
+		// 9 pods with the nominal 100m request fit on each node
+		Expect(nodes).To(HaveLen(3))
+		for _, node := range nodes {
+			Expect(len(node.Pods)).To(BeNumerically("<=", 9))
+		}
+		// the pods themselves aren't modified
+		for _, pod := range pods {
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEmpty())
+		}
+	})
+	It("should not use the default requests for resources that pods request", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes, _ := solve(v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}, nil, MakePods(18, test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("50m")}},
+		})...)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(18))
+	})
+	It("should use the default requests when scheduling to in-flight nodes", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+				v1.LabelInstanceTypeStable:       "single-cpu",
+			}},
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:  resource.MustParse("1"),
+				v1.ResourcePods: resource.MustParse("100"),
+			},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		stateNodes := StateNodes()
+		nodes, existingNodes := solve(v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")}, stateNodes, MakePods(6, test.PodOptions{})...)
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(HaveLen(4))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Exclusion Selector", func() {
+	solve := func(selector labels.Selector, stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode, error) {
+		s, err := prov.NewScheduler(ctx, pods, stateNodes, scheduling.SchedulerOptions{ExclusionSelector: selector})
+		Expect(err).ToNot(HaveOccurred())
+		return s.Solve(ctx, pods)
+	}
+	provisionerSelector := func(provisioner *v1alpha5.Provisioner) labels.Selector {
+		return labels.SelectorFromSet(labels.Set{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
+	}
+	It("should not launch nodes from an excluded provisioner", func() {
+		deprecated := test.Provisioner(test.ProvisionerOptions{Weight: ptr.Int32(100)})
+		ExpectApplied(ctx, env.Client, provisioner, deprecated)
+		nodes, _, err := solve(provisionerSelector(deprecated), nil, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(provisioner.Name))
+	})
+	It("should launch nodes from the provisioner once it's no longer excluded", func() {
+		deprecated := test.Provisioner(test.ProvisionerOptions{Weight: ptr.Int32(100)})
+		ExpectApplied(ctx, env.Client, provisioner, deprecated)
+		nodes, _, err := solve(labels.Everything(), nil, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(deprecated.Name))
+	})
+	It("should match the requirements of the provisioner", func() {
+		deprecated := test.Provisioner(test.ProvisionerOptions{
+			Weight:       ptr.Int32(100),
+			Requirements: []v1.NodeSelectorRequirement{{Key: "team", Operator: v1.NodeSelectorOpIn, Values: []string{"legacy"}}},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, deprecated)
+		nodes, _, err := solve(labels.SelectorFromSet(labels.Set{"team": "legacy"}), nil, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(provisioner.Name))
+	})
+	It("should fail to solve if every provisioner is excluded", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		_, _, err := solve(provisionerSelector(provisioner), nil, test.UnschedulablePod())
+		Expect(err).To(MatchError(scheduling.ErrNoProvisioners))
+	})
+	It("should not schedule to existing nodes that match the selector", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+				"deprecated":                     "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10"), v1.ResourcePods: resource.MustParse("100")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		stateNodes := StateNodes()
+
+		nodes, existingNodes, err := solve(labels.SelectorFromSet(labels.Set{"deprecated": "true"}), stateNodes, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(existingNodes).To(BeEmpty())
+		Expect(nodes).To(HaveLen(1))
+
+		nodes, existingNodes, err = solve(nil, stateNodes, test.UnschedulablePod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(HaveLen(1))
+		Expect(nodes).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Why Unschedulable", func() {
+	var node *v1.Node
+	BeforeEach(func() {
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+				v1.LabelTopologyZone:             "test-zone-1",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourcePods: resource.MustParse("10")},
+		})
+	})
+	diagnose := func(pod *v1.Pod) *scheduling.Diagnosis {
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		stateNodes := StateNodes()
+		s, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, stateNodes, scheduling.SchedulerOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		diagnosis, err := s.WhyUnschedulable(ctx, pod)
+		Expect(err).ToNot(HaveOccurred())
+		return diagnosis
+	}
+	It("should report the taints that the pod doesn't tolerate", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "team", Value: "a", Effect: v1.TaintEffectNoSchedule}}
+		node.Spec.Taints = []v1.Taint{{Key: "team", Value: "b", Effect: v1.TaintEffectNoSchedule}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		diagnosis := diagnose(test.UnschedulablePod())
+		Expect(diagnosis.Schedulable()).To(BeFalse())
+		Expect(diagnosis.ExistingNodes).To(HaveLen(1))
+		Expect(diagnosis.ExistingNodes[0].Name).To(Equal(node.Name))
+		Expect(diagnosis.ExistingNodes[0].Predicate).To(Equal(scheduling.PredicateTaints))
+		Expect(diagnosis.Provisioners).To(HaveLen(1))
+		Expect(diagnosis.Provisioners[0].Name).To(Equal(provisioner.Name))
+		Expect(diagnosis.Provisioners[0].Predicate).To(Equal(scheduling.PredicateTaints))
+	})
+	It("should report the candidates without enough resources", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		diagnosis := diagnose(test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("200")}},
+		}))
+		Expect(diagnosis.Schedulable()).To(BeFalse())
+		Expect(diagnosis.ExistingNodes).To(HaveLen(1))
+		Expect(diagnosis.ExistingNodes[0].Predicate).To(Equal(scheduling.PredicateResources))
+		Expect(diagnosis.ExistingNodes[0].HadCapacity).To(BeFalse())
+		Expect(diagnosis.Provisioners).To(HaveLen(1))
+		Expect(diagnosis.Provisioners[0].Predicate).To(Equal(scheduling.PredicateInstanceTypes))
+	})
+	It("should report the existing nodes with incompatible node affinity", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		diagnosis := diagnose(test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}},
+		}))
+		Expect(diagnosis.ExistingNodes).To(HaveLen(1))
+		Expect(diagnosis.ExistingNodes[0].Predicate).To(Equal(scheduling.PredicateNodeAffinity))
+		Expect(diagnosis.ExistingNodes[0].HadCapacity).To(BeTrue())
+		// a new node can still be launched into the zone
+		Expect(diagnosis.Schedulable()).To(BeTrue())
+		Expect(diagnosis.ScheduledTo).To(Equal(provisioner.Name))
+		Expect(diagnosis.Provisioners).To(BeEmpty())
+	})
+	It("should report the preferences that were relaxed to schedule the pod", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		diagnosis := diagnose(test.UnschedulablePod(test.PodOptions{
+			NodePreferences: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown-zone"}}},
+		}))
+		Expect(diagnosis.Schedulable()).To(BeTrue())
+		Expect(diagnosis.ScheduledTo).To(Equal(node.Name))
+		Expect(diagnosis.Relaxations).To(ConsistOf(scheduling.RelaxationNodeAffinity))
+	})
+	It("should not modify the pod", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{
+			NodePreferences: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown-zone"}}},
+		})
+		diagnose(pod)
+		Expect(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+	})
+	Context("Verbose", func() {
+		solve := func(verbose bool, pods ...*v1.Pod) *scheduling.Scheduler {
+			ExpectApplied(ctx, env.Client, provisioner, node)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+			stateNodes := StateNodes()
+			s, err := prov.NewScheduler(ctx, pods, stateNodes, scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{VerboseDiagnosis: verbose}})
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = s.Solve(ctx, pods)
+			Expect(err).ToNot(HaveOccurred())
+			return s
+		}
+		exclusivePod := func() *v1.Pod {
+			labels := map[string]string{"app": "exclusive"}
+			return test.UnschedulablePod(test.PodOptions{
+				ObjectMeta:           metav1.ObjectMeta{Labels: labels},
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}},
+				PodAntiRequirements: []v1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+					TopologyKey:   v1.LabelHostname,
+				}},
+			})
+		}
+		It("should report existing nodes that had capacity but rejected the pod", func() {
+			first, second := exclusivePod(), exclusivePod()
+			s := solve(true, first, second)
+			// only one of the pods fits on the existing node, the other is rejected by the anti-affinity
+			scheduled, diagnosis := s.Diagnosis(first), s.Diagnosis(second)
+			if scheduled.ScheduledTo != node.Name {
+				scheduled, diagnosis = diagnosis, scheduled
+			}
+			Expect(scheduled.ScheduledTo).To(Equal(node.Name))
+			Expect(diagnosis.ScheduledTo).To(Equal(provisioner.Name))
+			Expect(diagnosis.ExistingNodes).To(HaveLen(1))
+			Expect(diagnosis.ExistingNodes[0].Name).To(Equal(node.Name))
+			Expect(diagnosis.ExistingNodes[0].Predicate).To(Equal(scheduling.PredicateTopology))
+			Expect(diagnosis.ExistingNodes[0].HadCapacity).To(BeTrue())
+			Expect(diagnosis.String()).To(ContainSubstring(fmt.Sprintf("%s: had capacity but Topology", node.Name)))
+		})
+		It("should report existing nodes that rejected the pod for lack of capacity", func() {
+			pod := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+			})
+			diagnosis := solve(true, pod).Diagnosis(pod)
+			Expect(diagnosis.ExistingNodes).To(HaveLen(1))
+			Expect(diagnosis.ExistingNodes[0].Predicate).To(Equal(scheduling.PredicateResources))
+			Expect(diagnosis.ExistingNodes[0].HadCapacity).To(BeFalse())
+		})
+		It("should not record diagnoses by default", func() {
+			pod := exclusivePod()
+			Expect(solve(false, pod).Diagnosis(pod)).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Hold Annotation", func() {
+	const holdAnnotation = "example.com/hold"
+	solve := func(pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{HoldAnnotation: holdAnnotation}}, pods...)
+	}
+	It("should not schedule held pods", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		held := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{holdAnnotation: ""}}})
+		released := test.UnschedulablePod()
+		nodes := solve(held, released)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(released))
+	})
+	It("should schedule pods once the annotation is removed", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{holdAnnotation: "true"}}})
+		Expect(solve(pod)).To(BeEmpty())
+
+		delete(pod.Annotations, holdAnnotation)
+		nodes := solve(pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(pod))
+	})
+	It("should not report held pods as failing to schedule", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{Annotations: map[string]string{holdAnnotation: "true"}},
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"},
+		})
+		Expect(solve(pod)).To(BeEmpty())
+		Expect(recorder.Calls(events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason)).To(BeZero())
+	})
+	It("should not hold pods if the annotation isn't configured", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{holdAnnotation: "true"}}})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, pod)
+		Expect(nodes).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Excluded Owners", func() {
+	jobs := scheduling.OwnerSelector{APIVersion: "batch/v1", Kind: "Job"}
+	ownedPod := func(apiVersion, kind, name string, options ...test.PodOptions) *v1.Pod {
+		pod := test.UnschedulablePod(options...)
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, UID: uuid.NewUUID()}}
+		return pod
+	}
+	solve := func(excluded []scheduling.OwnerSelector, pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{ExcludedOwners: excluded}}, pods...)
+	}
+	It("should not schedule pods owned by an excluded owner", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		job := ownedPod("batch/v1", "Job", "migration")
+		deployment := ownedPod("apps/v1", "ReplicaSet", "web-5d8f7c")
+		nodes := solve([]scheduling.OwnerSelector{jobs}, job, deployment)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(deployment))
+	})
+	It("should only exclude the pods of the named owner", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		migration := ownedPod("batch/v1", "Job", "migration")
+		report := ownedPod("batch/v1", "Job", "report")
+		nodes := solve([]scheduling.OwnerSelector{{APIVersion: "batch/v1", Kind: "Job", Name: "migration"}}, migration, report)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(report))
+	})
+	It("should not exclude pods owned by an owner of another API version", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ownedPod("example.com/v1", "Job", "migration")
+		nodes := solve([]scheduling.OwnerSelector{jobs}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(pod))
+	})
+	It("should not report excluded pods as failing to schedule", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ownedPod("batch/v1", "Job", "migration", test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"}})
+		Expect(solve([]scheduling.OwnerSelector{jobs}, pod)).To(BeEmpty())
+		Expect(recorder.Calls(events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason)).To(BeZero())
+	})
+	It("should not exclude pods if no owners are configured", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ownedPod("batch/v1", "Job", "migration")
+		Expect(solve(nil, pod)).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Max New Nodes", func() {
+	dedicatedPods := func(count int) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})
+	}
+	It("should stop creating new nodes once the maximum is reached", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := dedicatedPods(3)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{MaxNewNodes: 2}, pods...)
+		Expect(nodes).To(HaveLen(2))
+
+		scheduled := lo.FlatMap(nodes, func(n *scheduling.Node, _ int) []*v1.Pod { return n.Pods })
+		remaining, _ := lo.Difference(pods, scheduled)
+		Expect(remaining).To(HaveLen(1))
+		messages := FailureMessages()
+		Expect(messages).To(HaveLen(1))
+		Expect(messages).To(HaveKeyWithValue(remaining[0].Name, ContainSubstring("exceeded the maximum number of new nodes")))
+	})
+	It("should keep scheduling pods to the new nodes once the maximum is reached", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{MaxNewNodes: 1}, MakePods(3, test.PodOptions{})...)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(3))
+		Expect(FailureMessages()).To(BeEmpty())
+	})
+	It("should count the pods that exceeded the maximum in the provisioning decision", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{MaxNewNodes: 1, Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, dedicatedPods(3)...)
+		var failureReasons []string
+		recorder.ForEachEvent(func(evt events.Event) {
+			if evt.Reason == "ProvisioningDecision" && evt.InvolvedObject == provisioner {
+				failureReasons = append(failureReasons, evt.Annotations["failureReasons"])
+			}
+		})
+		Expect(failureReasons).To(ConsistOf(scheduling.FailureReasonMaxNewNodes + "=2"))
+	})
+	It("should not limit new nodes by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(ExpectSolved(scheduling.SchedulerOptions{}, dedicatedPods(3)...)).To(HaveLen(3))
+		Expect(FailureMessages()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Zone Balance", func() {
+	existingNode := func(provisionerName, zone string) {
+		node := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: provisionerName,
+			v1.LabelTopologyZone:             zone,
+		}}})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+	}
+	zones := func(nodes []*scheduling.Node) []string {
+		return lo.Map(nodes, func(n *scheduling.Node, _ int) string {
+			Expect(n.Requirements.Get(v1.LabelTopologyZone).Len()).To(Equal(1))
+			return n.Requirements.Get(v1.LabelTopologyZone).Any()
+		})
+	}
+	It("should prefer the zone with the fewest nodes of the provisioner", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		existingNode(provisioner.Name, "test-zone-2")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, test.UnschedulablePod())
+		Expect(zones(nodes)).To(ConsistOf("test-zone-3"))
+		for _, it := range nodes[0].InstanceTypeOptions {
+			Expect(lo.ContainsBy(it.Offerings.Available(), func(o cloudprovider.Offering) bool { return o.Zone == "test-zone-3" })).To(BeTrue())
+		}
+	})
+	It("should balance the new nodes of a batch across zones", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, MakePods(4, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})...)
+		Expect(zones(nodes)).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-2", "test-zone-3"))
+	})
+	It("should not count the nodes of other provisioners", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode("other-provisioner", "test-zone-1")
+		existingNode(provisioner.Name, "test-zone-2")
+		Expect(zones(ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, test.UnschedulablePod()))).To(ConsistOf("test-zone-1"))
+	})
+	It("should not count nodes that are marked for deletion", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-2")
+		existingNode(provisioner.Name, "test-zone-3")
+		node := test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+			v1.LabelTopologyZone:             "test-zone-1",
+		}}})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		cluster.MarkForDeletion(node.Name)
+		Expect(zones(ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, test.UnschedulablePod()))).To(ConsistOf("test-zone-1"))
+	})
+	It("should not override the zone constraints of the pods", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1"},
+		}))
+		Expect(zones(nodes)).To(ConsistOf("test-zone-1"))
+	})
+	It("should only prefer zones with an available offering", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name: "zonal-instance-type",
+				Offerings: []cloudprovider.Offering{
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1, Available: true},
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-2", Price: 1, Available: true},
+					{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-3", Price: 1, Available: false},
+				},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		Expect(zones(ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{BalanceZones: true}}, test.UnschedulablePod()))).To(ConsistOf("test-zone-2"))
+	})
+	It("should balance the zones of provisioners whose profile enables it", func() {
+		provisioner.Labels = lo.Assign(provisioner.Labels, map[string]string{v1alpha5.SchedulingProfileLabelKey: "balanced"})
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Profiles: []scheduling.SchedulingProfile{{Name: "balanced", BalanceZones: true}}}, test.UnschedulablePod())
+		Expect(zones(nodes)).To(ConsistOf("test-zone-2"))
+	})
+	It("should not balance zones by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		existingNode(provisioner.Name, "test-zone-1")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Len()).To(BeNumerically(">", 1))
+	})
+})
+
+var _ = Describe("Provisioner Events", func() {
+	provisionerEvents := func(reason string) []events.Event {
+		var provisionerEvents []events.Event
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1alpha5.Provisioner); ok && p.Name == provisioner.Name && evt.Reason == reason {
+				provisionerEvents = append(provisionerEvents, evt)
+			}
+		})
+		return provisionerEvents
+	}
+	limitsExceeded := events.ProvisionerLimitsExceeded(&v1alpha5.Provisioner{}, fmt.Errorf("")).Reason
+	noViableInstanceTypes := events.ProvisionerNoViableInstanceTypes(&v1alpha5.Provisioner{}, 0).Reason
+	It("should publish an event when the provisioner's limits reject new nodes", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1m")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{}, MakePods(3, test.PodOptions{})...)
+		evts := provisionerEvents(limitsExceeded)
+		Expect(evts).To(HaveLen(1))
+		Expect(evts[0].Type).To(Equal(v1.EventTypeWarning))
+		Expect(evts[0].Message).To(ContainSubstring("all available instance types exceed provisioner limits"))
+	})
+	It("should publish an event when none of the provisioner's instance types have an available offering", func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "unavailable",
+				Offerings: []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1, Available: false}},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		evts := provisionerEvents(noViableInstanceTypes)
+		Expect(evts).To(HaveLen(1))
+		Expect(evts[0].Message).To(ContainSubstring("1 instance type(s) have an available offering"))
+	})
+	It("should not publish warnings when the provisioner can launch nodes", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(provisionerEvents(limitsExceeded)).To(BeEmpty())
+		Expect(provisionerEvents(noViableInstanceTypes)).To(BeEmpty())
+	})
+	It("should not publish events in simulation mode", func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1m")}}
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "unavailable",
+				Offerings: []cloudprovider.Offering{{CapacityType: v1alpha5.CapacityTypeOnDemand, Zone: "test-zone-1", Price: 1, Available: false}},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectSolved(scheduling.SchedulerOptions{SimulationMode: true}, test.UnschedulablePod())
+		Expect(provisionerEvents(limitsExceeded)).To(BeEmpty())
+		Expect(provisionerEvents(noViableInstanceTypes)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Scheduling Freeze", func() {
+	solve := func(opts scheduling.SchedulerOptions, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+		return ExpectSolvedOnto(opts, nil, pods...)
+	}
+	It("should not schedule pods while frozen", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes, existingNodes := solve(scheduling.SchedulerOptions{Frozen: true}, MakePods(3, test.PodOptions{})...)
+		Expect(nodes).To(BeEmpty())
+		Expect(existingNodes).To(BeEmpty())
+	})
+	It("should not report pods as failing to schedule while frozen", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"}})
+		solve(scheduling.SchedulerOptions{Frozen: true}, pod)
+		Expect(recorder.Calls(events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason)).To(BeZero())
+	})
+	It("should publish a freeze event instead of the provisioning decision", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		solve(scheduling.SchedulerOptions{Frozen: true, Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, MakePods(2, test.PodOptions{})...)
+		var reasons []string
+		recorder.ForEachEvent(func(evt events.Event) {
+			if evt.InvolvedObject == provisioner {
+				reasons = append(reasons, evt.Reason)
+				Expect(evt.Message).To(Equal("Scheduling is frozen, 2 pod(s) were left pending"))
+			}
+		})
+		Expect(reasons).To(ConsistOf(events.SchedulingFrozen(provisioner, 0).Reason))
+	})
+	It("should not publish a freeze event in simulation mode", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		solve(scheduling.SchedulerOptions{Frozen: true, SimulationMode: true, Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, test.UnschedulablePod())
+		Expect(recorder.Calls(events.SchedulingFrozen(provisioner, 0).Reason)).To(BeZero())
+	})
+	It("should schedule pods once unfrozen", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		nodes, _ := solve(scheduling.SchedulerOptions{Frozen: true}, pod)
+		Expect(nodes).To(BeEmpty())
+		nodes, _ = solve(scheduling.SchedulerOptions{}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(pod))
+	})
+})
+
+var _ = Describe("Default Topology Spread", func() {
+	defaults := []v1.TopologySpreadConstraint{{
+		TopologyKey:       v1.LabelTopologyZone,
+		MaxSkew:           1,
+		WhenUnsatisfiable: v1.DoNotSchedule,
+	}}
+	replicaSetPods := func(rs *appsv1.ReplicaSet, count int, opts test.PodOptions) []*v1.Pod {
+		opts.ObjectMeta = metav1.ObjectMeta{
+			Labels: rs.Spec.Selector.MatchLabels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Name:       rs.Name,
+				UID:        rs.UID,
+				Controller: ptr.Bool(true),
+			}},
+		}
+		return MakePods(count, opts)
+	}
+	solve := func(pods []*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{DefaultTopologySpreadConstraints: defaults}}, pods...)
+	}
+	zones := func(nodes []*scheduling.Node) []string {
+		zones := sets.NewString()
+		for _, node := range nodes {
+			zones.Insert(node.Requirements.Get(v1.LabelTopologyZone).Values()...)
+		}
+		return zones.List()
+	}
+	It("should spread the pods of a replica set that declare no constraints", func() {
+		rs := test.ReplicaSet()
+		ExpectApplied(ctx, env.Client, provisioner, rs)
+		nodes := solve(replicaSetPods(rs, 3, test.PodOptions{}))
+		Expect(nodes).To(HaveLen(3))
+		Expect(zones(nodes)).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should spread the pods selected by a service that declare no constraints", func() {
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: v1.ServiceSpec{
+				Selector: map[string]string{"app": "web"},
+				Ports:    []v1.ServicePort{{Port: 80}},
+			},
+		}
+		ExpectApplied(ctx, env.Client, provisioner, service)
+		nodes := solve(MakePods(3, test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}))
+		Expect(nodes).To(HaveLen(3))
+		Expect(zones(nodes)).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should not apply the defaults to pods that don't belong to a service or controller", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(MakePods(3, test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}))
+		Expect(nodes).To(HaveLen(1))
+	})
+	It("should not apply the defaults to pods that declare their own constraints", func() {
+		rs := test.ReplicaSet()
+		ExpectApplied(ctx, env.Client, provisioner, rs)
+		pods := replicaSetPods(rs, 3, test.PodOptions{
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				TopologyKey:       v1.LabelHostname,
+				MaxSkew:           3,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     rs.Spec.Selector,
+			}},
+		})
+		nodes := solve(pods)
+		Expect(nodes).To(HaveLen(1))
+		Expect(pods[0].Spec.TopologySpreadConstraints).To(HaveLen(1))
+	})
+	It("should not apply the defaults if none are configured", func() {
+		rs := test.ReplicaSet()
+		ExpectApplied(ctx, env.Client, provisioner, rs)
+		pods := replicaSetPods(rs, 3, test.PodOptions{})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, pods...)
+		Expect(nodes).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Instance Type Transform", func() {
+	solve := func(transform scheduling.InstanceTypeTransform, pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{InstanceTypeTransform: transform}}, pods...)
+	}
+	names := func(instanceTypes []*cloudprovider.InstanceType) []string {
+		return lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	It("should be called with the instance types of each provisioner", func() {
+		other := test.Provisioner()
+		ExpectApplied(ctx, env.Client, provisioner, other)
+		called := map[string][]string{}
+		solve(func(provisionerName string, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+			called[provisionerName] = names(instanceTypes)
+			return instanceTypes
+		}, test.UnschedulablePod())
+		Expect(called).To(HaveLen(2))
+		Expect(called).To(HaveKeyWithValue(provisioner.Name, ConsistOf(names(cloudProv.InstanceTypes))))
+		Expect(called).To(HaveKeyWithValue(other.Name, ConsistOf(names(cloudProv.InstanceTypes))))
+	})
+	It("should only launch the instance types that the transform keeps", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(func(_ string, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+			return lo.Filter(instanceTypes, func(it *cloudprovider.InstanceType, _ int) bool { return it.Name == "arm-instance-type" })
+		}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(names(nodes[0].InstanceTypeOptions)).To(ConsistOf("arm-instance-type"))
+	})
+	It("should fail to schedule if the transform removes every instance type", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(func(string, []*cloudprovider.InstanceType) []*cloudprovider.InstanceType { return nil }, test.UnschedulablePod())
+		Expect(nodes).To(BeEmpty())
+	})
+	It("should launch the instance types that the transform annotates", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := solve(func(_ string, instanceTypes []*cloudprovider.InstanceType) []*cloudprovider.InstanceType {
+			return lo.Map(instanceTypes, func(it *cloudprovider.InstanceType, _ int) *cloudprovider.InstanceType {
+				annotated := *it
+				annotated.Requirements = pscheduling.NewRequirements(lo.Values(it.Requirements)...)
+				annotated.Requirements.Add(pscheduling.NewRequirement("example.com/preferred", v1.NodeSelectorOpIn, fmt.Sprint(it.Name == "small-instance-type")))
+				// reserve more CPU than the instance type has so that it can't fit any pods
+				if it.Name == "default-instance-type" {
+					annotated.Overhead = &cloudprovider.InstanceTypeOverhead{KubeReserved: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}}
+				}
+				return &annotated
 			})
-			pvcB := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
-				StorageClassName: ptr.String("my-storage-class"),
-				ObjectMeta:       metav1.ObjectMeta{Name: fmt.Sprintf("my-claim-b-%d", i)},
+		}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(names(nodes[0].InstanceTypeOptions)).ToNot(ContainElement("default-instance-type"))
+		for _, it := range nodes[0].InstanceTypeOptions {
+			Expect(it.Requirements.Get("example.com/preferred").Values()).To(ConsistOf(fmt.Sprint(it.Name == "small-instance-type")))
+		}
+		// the cloud provider's instance types aren't modified
+		for _, it := range cloudProv.InstanceTypes {
+			Expect(it.Requirements.Has("example.com/preferred")).To(BeFalse())
+		}
+	})
+})
+
+var _ = Describe("Namespace Provisioners", func() {
+	const tenant = "tenant"
+	var tenantProvisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		// the shared provisioner is preferred, so pods only launch from the tenant's provisioner if they're restricted
+		provisioner.Spec.Weight = ptr.Int32(100)
+		tenantProvisioner = test.Provisioner()
+	})
+	solve := func(namespaceProvisioners []scheduling.NamespaceProvisioners, stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+		return ExpectSolvedOnto(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{NamespaceProvisioners: namespaceProvisioners}}, stateNodes, pods...)
+	}
+	tenantPod := func() *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: tenant}})
+	}
+	It("should only launch nodes from the provisioners of the pod's namespace", func() {
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner)
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{{Namespaces: []string{tenant}, Provisioners: []string{tenantProvisioner.Name}}}, nil, tenantPod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(tenantProvisioner.Name))
+	})
+	It("should select namespaces by their labels", func() {
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner, test.Namespace(test.NamespaceOptions{ObjectMeta: metav1.ObjectMeta{
+			Name:   tenant,
+			Labels: map[string]string{"team": "a"},
+		}}))
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{{
+			Selector:     labels.SelectorFromSet(map[string]string{"team": "a"}),
+			Provisioners: []string{tenantProvisioner.Name},
+		}}, nil, tenantPod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(tenantProvisioner.Name))
+	})
+	It("should allow the provisioners of every mapping that selects the namespace", func() {
+		other := test.Provisioner(test.ProvisionerOptions{Weight: ptr.Int32(50)})
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner, other)
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{
+			{Namespaces: []string{tenant}, Provisioners: []string{tenantProvisioner.Name}},
+			{Namespaces: []string{tenant}, Provisioners: []string{other.Name}},
+		}, nil, tenantPod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(other.Name))
+	})
+	It("should not restrict the pods of namespaces that aren't selected", func() {
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner)
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{{Namespaces: []string{tenant}, Provisioners: []string{tenantProvisioner.Name}}}, nil, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(provisioner.Name))
+	})
+	It("should not schedule pods of a namespace to the new nodes of another namespace's provisioner", func() {
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner)
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{
+			{Namespaces: []string{tenant}, Provisioners: []string{tenantProvisioner.Name}},
+			{Namespaces: []string{"default"}, Provisioners: []string{provisioner.Name}},
+		}, nil, test.UnschedulablePod(), tenantPod())
+		Expect(nodes).To(HaveLen(2))
+		for _, node := range nodes {
+			Expect(node.Pods).To(HaveLen(1))
+			Expect(node.Pods[0].Namespace == tenant).To(Equal(node.ProvisionerName == tenantProvisioner.Name))
+		}
+	})
+	It("should not schedule pods to the existing nodes of disallowed provisioners", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, tenantProvisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		stateNodes := StateNodes()
+		pod := tenantPod()
+		nodes, existingNodes := solve([]scheduling.NamespaceProvisioners{{Namespaces: []string{tenant}, Provisioners: []string{tenantProvisioner.Name}}}, stateNodes, pod)
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(BeEmpty())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(tenantProvisioner.Name))
+	})
+	It("should fail to schedule pods if their namespace can't provision from any provisioner", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := tenantPod()
+		nodes, _ := solve([]scheduling.NamespaceProvisioners{{Namespaces: []string{tenant}, Provisioners: []string{"missing"}}}, nil, pod)
+		Expect(nodes).To(BeEmpty())
+		var message string
+		recorder.ForEachEvent(func(evt events.Event) {
+			if p, ok := evt.InvolvedObject.(*v1.Pod); ok && p.Name == pod.Name && evt.Reason == events.PodFailedToSchedule(pod, fmt.Errorf("")).Reason {
+				message = evt.Message
+			}
+		})
+		Expect(message).To(ContainSubstring(fmt.Sprintf("namespace %q can't provision from provisioner %q", tenant, provisioner.Name)))
+	})
+})
+
+var _ = Describe("Launch Failures", func() {
+	var launchFailures *scheduling.LaunchFailures
+	solve := func(pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{LaunchFailures: launchFailures}}, pods...)
+	}
+	options := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	BeforeEach(func() {
+		launchFailures = scheduling.NewLaunchFailures(fakeClock)
+		ExpectApplied(ctx, env.Client, provisioner)
+	})
+	It("should not launch new nodes as an instance type that recently failed to launch", func() {
+		launchFailures.Record("default-instance-type", fakeClock.Now().Add(time.Minute))
+		nodes := solve(test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(options(nodes[0])).ToNot(ContainElement("default-instance-type"))
+		Expect(options(nodes[0])).ToNot(BeEmpty())
+	})
+	It("should launch new nodes as the instance type again once its failure expires", func() {
+		launchFailures.Record("default-instance-type", fakeClock.Now().Add(time.Minute))
+		fakeClock.Step(time.Minute)
+		nodes := solve(test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(options(nodes[0])).To(ContainElement("default-instance-type"))
+		Expect(launchFailures.InstanceTypes().UnsortedList()).To(BeEmpty())
+	})
+	It("should fail to schedule pods whose only compatible instance type recently failed to launch", func() {
+		launchFailures.Record("arm-instance-type", fakeClock.Now().Add(time.Minute))
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "arm-instance-type"}})
+		Expect(solve(pod)).To(BeEmpty())
+		fakeClock.Step(2 * time.Minute)
+		nodes := solve(pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(options(nodes[0])).To(ConsistOf("arm-instance-type"))
+	})
+	It("should extend the expiry of a failure that's recorded again, but never shorten it", func() {
+		launchFailures.Record("default-instance-type", fakeClock.Now().Add(time.Minute))
+		launchFailures.Record("default-instance-type", fakeClock.Now().Add(10*time.Minute))
+		launchFailures.Record("default-instance-type", fakeClock.Now().Add(2*time.Minute))
+		fakeClock.Step(5 * time.Minute)
+		Expect(launchFailures.InstanceTypes().UnsortedList()).To(ConsistOf("default-instance-type"))
+		fakeClock.Step(5 * time.Minute)
+		Expect(launchFailures.InstanceTypes().UnsortedList()).To(BeEmpty())
+	})
+	It("should not avoid any instance types if there are no launch failures", func() {
+		launchFailures = nil
+		nodes := solve(test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(options(nodes[0])).To(ContainElement("default-instance-type"))
+	})
+})
+
+var _ = Describe("Pack By Limits", func() {
+	solve := func(packByLimits bool, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+		return ExpectSolvedOnto(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{PackByLimits: packByLimits}}, StateNodes(), pods...)
+	}
+	makePods := func(requests, limits v1.ResourceList) []*v1.Pod {
+		var pods []*v1.Pod
+		for i := 0; i < 4; i++ {
+			pods = append(pods, test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: requests, Limits: limits}}))
+		}
+		return pods
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "instance-type",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+			}),
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+	})
+	It("should pack pods more densely by their cpu requests than by their cpu limits", func() {
+		pods := makePods(v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")}, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500m")})
+		byRequests, _ := solve(false, pods...)
+		Expect(byRequests).To(HaveLen(1))
+		byLimits, _ := solve(true, pods...)
+		Expect(byLimits).To(HaveLen(2))
+		for _, node := range byLimits {
+			Expect(node.Pods).To(HaveLen(2))
+		}
+	})
+	It("should pack pods by their memory limits", func() {
+		pods := makePods(v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}, v1.ResourceList{v1.ResourceMemory: resource.MustParse("3Gi")})
+		byRequests, _ := solve(false, pods...)
+		Expect(byRequests).To(HaveLen(1))
+		byLimits, _ := solve(true, pods...)
+		Expect(byLimits).To(HaveLen(2))
+	})
+	It("should pack containers without limits by their requests", func() {
+		pods := makePods(v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")}, nil)
+		byLimits, _ := solve(true, pods...)
+		Expect(byLimits).To(HaveLen(1))
+	})
+	It("should pack the greater of each container's request and limit", func() {
+		pod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")},
+			Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+		}})
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "sidecar", Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+		}})
+		byLimits, _ := solve(true, pod)
+		Expect(byLimits).To(HaveLen(1))
+		Expect(byLimits[0].Requests.Cpu().String()).To(Equal("3"))
+	})
+	It("should pack pods onto existing nodes by their limits", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		pods := makePods(v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")}, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500m")})
+
+		newNodes, existingNodes := solve(false, pods...)
+		Expect(newNodes).To(BeEmpty())
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(HaveLen(4))
+
+		newNodes, existingNodes = solve(true, pods...)
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(HaveLen(2))
+		Expect(newNodes).To(HaveLen(1))
+		Expect(newNodes[0].Pods).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Live Existing Node Capacity", func() {
+	var ds *appsv1.DaemonSet
+	var node *v1.Node
+	// solve returns whether the pod was scheduled to the existing node
+	solve := func(live bool) bool {
+		stateNodes := StateNodes()
+		pods := []*v1.Pod{test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3500m")},
+		}})}
+		newNodes, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{ExistingNodes: scheduling.ExistingNodeOptions{LiveExistingNodeCapacity: live}}, stateNodes, pods...)
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(len(newNodes) + len(existingNodes[0].Pods)).To(Equal(1))
+		return len(existingNodes[0].Pods) == 1
+	}
+	bindDaemonPod := func(cpu string) {
+		dsPod := test.Pod(test.PodOptions{
+			NodeName:             node.Name,
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+		dsPod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+			Name:       ds.Name,
+			UID:        ds.UID,
+			Controller: ptr.Bool(true),
+		}}
+		ExpectApplied(ctx, env.Client, dsPod)
+		ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(dsPod))
+	}
+	BeforeEach(func() {
+		ds = test.DaemonSet(test.DaemonSetOptions{PodOptions: test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		}})
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, ds, node)
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+	})
+	It("should reserve the daemonset overhead on existing nodes by default", func() {
+		Expect(solve(false)).To(BeFalse())
+	})
+	It("should not reserve capacity for daemonsets whose pods aren't bound to an initialized node", func() {
+		Expect(solve(true)).To(BeTrue())
+	})
+	It("should use the requests of the daemon pods bound to the node rather than the daemonset's template", func() {
+		// the daemon pod was resized after it was created, so it requests less than its daemonset's template
+		bindDaemonPod("200m")
+		Expect(solve(false)).To(BeFalse())
+		Expect(solve(true)).To(BeTrue())
+	})
+	It("should not schedule to a node whose live capacity is exhausted by its daemon pods", func() {
+		// the daemon pod was resized to request more than its daemonset's template
+		bindDaemonPod("1")
+		Expect(solve(true)).To(BeFalse())
+	})
+	It("should reserve the daemonset overhead on uninitialized nodes", func() {
+		delete(node.Labels, v1alpha5.LabelNodeInitialized)
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		Expect(solve(true)).To(BeFalse())
+	})
+})
+
+type fakeAuditSink struct {
+	records []scheduling.AuditRecord
+}
+
+func (f *fakeAuditSink) Write(_ context.Context, record scheduling.AuditRecord) error {
+	f.records = append(f.records, record)
+	return nil
+}
+
+var _ = Describe("Audit Sink", func() {
+	var sink *fakeAuditSink
+	solve := func(opts scheduling.SchedulerOptions, pods ...*v1.Pod) {
+		ExpectSolvedOnto(opts, StateNodes(), pods...)
+	}
+	BeforeEach(func() {
+		sink = &fakeAuditSink{}
+		ExpectApplied(ctx, env.Client, provisioner)
+	})
+	It("should record the new nodes that pods are scheduled to", func() {
+		pod := test.UnschedulablePod()
+		solve(scheduling.SchedulerOptions{Clock: fakeClock, Reporting: scheduling.ReportingOptions{AuditSink: sink}}, pod)
+		Expect(sink.records).To(HaveLen(1))
+		record := sink.records[0]
+		Expect(record.Time).To(Equal(fakeClock.Now()))
+		Expect(record.Failures).To(BeEmpty())
+		Expect(record.NewNodes).To(HaveLen(1))
+		Expect(record.NewNodes[0].Provisioner).To(Equal(provisioner.Name))
+		Expect(record.NewNodes[0].InstanceTypes).ToNot(BeEmpty())
+		Expect(record.NewNodes[0].Pods).To(ConsistOf(client.ObjectKeyFromObject(pod).String()))
+	})
+	It("should record the existing nodes that pods are scheduled to", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		pod := test.UnschedulablePod()
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{AuditSink: sink}}, pod)
+		Expect(sink.records).To(HaveLen(1))
+		Expect(sink.records[0].NewNodes).To(BeEmpty())
+		Expect(sink.records[0].ExistingNodes).To(ConsistOf(scheduling.AuditExistingNode{
+			Provisioner: provisioner.Name,
+			Node:        node.Name,
+			Pods:        []string{client.ObjectKeyFromObject(pod).String()},
+		}))
+	})
+	It("should record the pods that fail to schedule", func() {
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"}})
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{AuditSink: sink}}, pod)
+		Expect(sink.records).To(HaveLen(1))
+		Expect(sink.records[0].NewNodes).To(BeEmpty())
+		Expect(sink.records[0].Failures).To(HaveLen(1))
+		failure := sink.records[0].Failures[0]
+		Expect(failure.Pod).To(Equal(client.ObjectKeyFromObject(pod).String()))
+		Expect(failure.Provisioners).To(ConsistOf(provisioner.Name))
+		Expect(failure.Reason).To(Equal(scheduling.FailureReasonUnsatisfiableRequirements))
+		Expect(failure.Error).ToNot(BeEmpty())
+	})
+	It("should record pods that are scheduled and pods that fail to schedule in the same record", func() {
+		scheduled := test.UnschedulablePod()
+		failed := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"}})
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{AuditSink: sink}}, scheduled, failed)
+		Expect(sink.records).To(HaveLen(1))
+		Expect(sink.records[0].NewNodes).To(HaveLen(1))
+		Expect(sink.records[0].Failures).To(HaveLen(1))
+	})
+	It("should not record simulations", func() {
+		solve(scheduling.SchedulerOptions{SimulationMode: true, Reporting: scheduling.ReportingOptions{AuditSink: sink}}, test.UnschedulablePod())
+		Expect(sink.records).To(BeEmpty())
+	})
+	It("should write each record as a line of JSON", func() {
+		var buf bytes.Buffer
+		jsonSink := scheduling.NewJSONLinesAuditSink(&buf)
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{AuditSink: jsonSink}}, test.UnschedulablePod())
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{AuditSink: jsonSink}}, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown"},
+		}))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		var records []scheduling.AuditRecord
+		for _, line := range lines {
+			var record scheduling.AuditRecord
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			records = append(records, record)
+		}
+		Expect(records[0].NewNodes).To(HaveLen(1))
+		Expect(records[0].NewNodes[0].Provisioner).To(Equal(provisioner.Name))
+		Expect(records[1].Failures).To(HaveLen(1))
+		Expect(records[1].Failures[0].Reason).To(Equal(scheduling.FailureReasonUnsatisfiableRequirements))
+	})
+})
+
+var _ = Describe("Provisioner Relaxations", func() {
+	var zonal, spot *v1alpha5.Provisioner
+	relaxations := func(pod *v1.Pod) []scheduling.ProvisionerRelaxation {
+		s, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, nil, scheduling.SchedulerOptions{SimulationMode: true})
+		Expect(err).ToNot(HaveOccurred())
+		return s.ProvisionerRelaxations(pod)
+	}
+	BeforeEach(func() {
+		zonal = test.Provisioner(test.ProvisionerOptions{Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+		}})
+		spot = test.Provisioner(test.ProvisionerOptions{Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small-instance-type"}},
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeSpot}},
+		}})
+	})
+	It("should suggest allowing the zone that a pod requires", func() {
+		ExpectApplied(ctx, env.Client, zonal)
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-3"}}))).To(ConsistOf(
+			scheduling.ProvisionerRelaxation{Provisioner: zonal.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+			}},
+		))
+	})
+	It("should suggest the capacity types that are offered in the zone that a pod requires", func() {
+		ExpectApplied(ctx, env.Client, spot)
+		// spot isn't offered in test-zone-3
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-3"}}))).To(ConsistOf(
+			scheduling.ProvisionerRelaxation{Provisioner: spot.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeOnDemand}},
+			}},
+		))
+	})
+	It("should suggest allowing the instance types that satisfy a pod", func() {
+		ExpectApplied(ctx, env.Client, spot)
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}}))).To(ConsistOf(
+			scheduling.ProvisionerRelaxation{Provisioner: spot.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm-instance-type"}},
+			}},
+		))
+	})
+	It("should suggest adding a custom label that a pod requires", func() {
+		ExpectApplied(ctx, env.Client, zonal)
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{"team": "a"}}))).To(ConsistOf(
+			scheduling.ProvisionerRelaxation{Provisioner: zonal.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: "team", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}},
+			}},
+		))
+	})
+	It("should order the relaxations by the number of requirements relaxed", func() {
+		ExpectApplied(ctx, env.Client, zonal, spot)
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{
+			v1.LabelTopologyZone: "test-zone-3",
+			v1.LabelArchStable:   v1alpha5.ArchitectureArm64,
+		}}))).To(Equal([]scheduling.ProvisionerRelaxation{
+			{Provisioner: zonal.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+			}},
+			{Provisioner: spot.Name, Requirements: []v1.NodeSelectorRequirement{
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.CapacityTypeOnDemand}},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm-instance-type"}},
+			}},
+		}))
+	})
+	It("should not suggest relaxations if a provisioner already admits a pod's requirements", func() {
+		ExpectApplied(ctx, env.Client, zonal)
+		Expect(relaxations(test.UnschedulablePod())).To(BeEmpty())
+	})
+	It("should not suggest relaxing a provisioner that a pod doesn't select", func() {
+		ExpectApplied(ctx, env.Client, zonal)
+		Expect(relaxations(test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: "other",
+			v1.LabelTopologyZone:             "test-zone-3",
+		}}))).To(BeEmpty())
+	})
+	It("should describe the relaxation", func() {
+		relaxation := scheduling.ProvisionerRelaxation{Provisioner: "default", Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+		}}
+		Expect(relaxation.String()).To(Equal(`provisioner "default" would admit the pod requiring topology.kubernetes.io/zone In [test-zone-3]`))
+	})
+})
+
+var _ = Describe("Cross Zone Penalty", func() {
+	dataPod := func(zone string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DataZonePodAnnotationKey: zone}}})
+	}
+	// zone2Discount makes offerings in test-zone-2 cheaper by the discount
+	zone2Discount := func(discount float64) scheduling.CostFunc {
+		return func(_ *cloudprovider.InstanceType, offering cloudprovider.Offering) float64 {
+			return offering.Price - lo.Ternary(offering.Zone == "test-zone-2", discount, 0)
+		}
+	}
+	It("should launch the node into the pod's data zone", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{CrossZonePenalty: 1}}, dataPod("test-zone-3"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Values()).To(ConsistOf("test-zone-3"))
+		for _, it := range nodes[0].InstanceTypeOptions {
+			Expect(lo.ContainsBy(it.Offerings.Available(), func(o cloudprovider.Offering) bool { return o.Zone == "test-zone-3" })).To(BeTrue())
+		}
+	})
+	It("should prefer the data zone over a cheaper zone if the penalty outweighs the saving", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{CostFunc: zone2Discount(0.001)}, Zones: scheduling.ZoneOptions{CrossZonePenalty: 1}}, dataPod("test-zone-1"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Values()).To(ConsistOf("test-zone-1"))
+	})
+	It("should prefer a cheaper zone if the saving outweighs the penalty", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{CostFunc: zone2Discount(1)}, Zones: scheduling.ZoneOptions{CrossZonePenalty: 0.001}}, dataPod("test-zone-1"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Values()).To(ConsistOf("test-zone-2"))
+	})
+	It("should penalize a zone for each pod whose data is elsewhere", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{CrossZonePenalty: 1}}, dataPod("test-zone-1"), dataPod("test-zone-2"), dataPod("test-zone-2"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Values()).To(ConsistOf("test-zone-2"))
+	})
+	It("should not narrow the zone past the pod's zone requirements", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := dataPod("test-zone-3")
+		pod.Spec.NodeSelector = map[string]string{v1alpha5.LabelCapacityType: v1alpha5.CapacityTypeSpot}
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{CrossZonePenalty: 1}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Has("test-zone-3")).To(BeFalse())
+	})
+	It("should not narrow the zone of pods without a data zone", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Zones: scheduling.ZoneOptions{CrossZonePenalty: 1}}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Len()).ToNot(Equal(1))
+	})
+	It("should not narrow the zone by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, dataPod("test-zone-3"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelTopologyZone).Len()).ToNot(Equal(1))
+	})
+})
+
+// fakeDeviceClaimResolver returns the device claims of each pod by its name
+type fakeDeviceClaimResolver map[string]map[string]int64
+
+func (r fakeDeviceClaimResolver) DeviceClaims(_ context.Context, pod *v1.Pod) (map[string]int64, error) {
+	if pod.Name == "unresolvable" {
+		return nil, fmt.Errorf("resource claim template not found")
+	}
+	return r[pod.Name], nil
+}
+
+var _ = Describe("Device Claims", func() {
+	deviceClassResources := map[string]v1.ResourceName{"gpu.example.com": fake.ResourceGPUVendorA}
+	// solve returns the instance type options of the new node for the pod
+	solve := func(opts scheduling.SchedulerOptions, pod *v1.Pod) []string {
+		nodes := ExpectSolved(opts, pod)
+		Expect(nodes).To(HaveLen(1))
+		return lo.Map(nodes[0].InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "no-gpu"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "one-gpu", Resources: v1.ResourceList{fake.ResourceGPUVendorA: resource.MustParse("1")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "two-gpu", Resources: v1.ResourceList{fake.ResourceGPUVendorA: resource.MustParse("2")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "four-gpu", Resources: v1.ResourceList{fake.ResourceGPUVendorA: resource.MustParse("4")}}),
+		}
+	})
+	It("should only keep the instance types with enough devices of the claimed device class", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "claimant"}})
+		resolver := fakeDeviceClaimResolver{"claimant": {"gpu.example.com": 2}}
+		Expect(solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)).
+			To(ConsistOf("two-gpu", "four-gpu"))
+	})
+	It("should not modify the pod's requests", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "claimant"}})
+		expected := pod.DeepCopy()
+		resolver := fakeDeviceClaimResolver{"claimant": {"gpu.example.com": 2}}
+		solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)
+		Expect(pod).To(Equal(expected))
+	})
+	It("should count the same devices each time the pod is solved", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "claimant"}})
+		resolver := fakeDeviceClaimResolver{"claimant": {"gpu.example.com": 2}}
+		for i := 0; i < 3; i++ {
+			Expect(solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)).
+				To(ConsistOf("two-gpu", "four-gpu"))
+		}
+	})
+	It("should add the claimed devices to the devices that the pod requests directly", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Name: "claimant"},
+			ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{fake.ResourceGPUVendorA: resource.MustParse("1")}},
+		})
+		resolver := fakeDeviceClaimResolver{"claimant": {"gpu.example.com": 2}}
+		Expect(solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)).
+			To(ConsistOf("four-gpu"))
+	})
+	It("should not count the devices of device classes without a resource", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "claimant"}})
+		resolver := fakeDeviceClaimResolver{"claimant": {"fpga.example.com": 2}}
+		Expect(solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)).
+			To(ConsistOf("no-gpu", "one-gpu", "two-gpu", "four-gpu"))
+	})
+	It("should not count the devices of pods whose claims can't be resolved", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "unresolvable"}})
+		resolver := fakeDeviceClaimResolver{}
+		Expect(solve(scheduling.SchedulerOptions{Packing: scheduling.PackingOptions{DeviceClaims: resolver, DeviceClassResources: deviceClassResources}}, pod)).
+			To(ConsistOf("no-gpu", "one-gpu", "two-gpu", "four-gpu"))
+	})
+	It("should not count devices by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Name: "claimant"}})
+		Expect(solve(scheduling.SchedulerOptions{}, pod)).To(ConsistOf("no-gpu", "one-gpu", "two-gpu", "four-gpu"))
+		Expect(pod.Spec.Containers[0].Resources.Requests).ToNot(HaveKey(fake.ResourceGPUVendorA))
+	})
+})
+
+var _ = Describe("Spread Then Pack", func() {
+	// solve returns the new nodes and the pods scheduled to the existing node
+	solve := func(pods ...*v1.Pod) ([]*scheduling.Node, []*v1.Pod) {
+		stateNodes := StateNodes()
+		newNodes, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{}, stateNodes, pods...)
+		Expect(existingNodes).To(HaveLen(1))
+		return newNodes, existingNodes[0].Pods
+	}
+	replicas := func(count int, group string) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.SpreadThenPackPodAnnotationKey: group}},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}},
+		})
+	}
+	BeforeEach(func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+	})
+	It("should schedule the first replica to a new node and pack the rest onto existing capacity", func() {
+		newNodes, existingPods := solve(replicas(3, "web")...)
+		Expect(newNodes).To(HaveLen(1))
+		Expect(newNodes[0].Pods).To(HaveLen(1))
+		Expect(existingPods).To(HaveLen(2))
+	})
+	It("should spread the first replica of each group", func() {
+		newNodes, existingPods := solve(append(replicas(2, "web"), replicas(2, "api")...)...)
+		Expect(newNodes).To(HaveLen(2))
+		for _, n := range newNodes {
+			Expect(n.Pods).To(HaveLen(1))
+		}
+		Expect(existingPods).To(HaveLen(2))
+	})
+	It("should track groups by namespace", func() {
+		pods := replicas(2, "web")
+		pods[1].Namespace = test.RandomName()
+		newNodes, existingPods := solve(pods...)
+		Expect(newNodes).To(HaveLen(2))
+		Expect(existingPods).To(BeEmpty())
+	})
+	It("should pack every replica without the annotation", func() {
+		pods := replicas(3, "")
+		newNodes, existingPods := solve(pods...)
+		Expect(newNodes).To(BeEmpty())
+		Expect(existingPods).To(HaveLen(3))
+	})
+})
+
+var _ = Describe("Instance Type Stability", func() {
+	var stability *scheduling.InstanceTypeStability
+	changes := func() float64 {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "karpenter_scheduling_preferred_instance_type_changes_total" {
+				continue
+			}
+			for _, m := range family.Metric {
+				for _, label := range m.Label {
+					if label.GetName() == "provisioner" && label.GetValue() == provisioner.Name {
+						return m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return 0
+	}
+	// solve schedules a replica of the workload with the instance type, returning the change in the count of
+	// preferred instance type changes
+	solve := func(opts scheduling.SchedulerOptions, instanceType string, owned bool) float64 {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{fake.NewInstanceType(fake.InstanceTypeOptions{Name: instanceType})}
+		pod := test.UnschedulablePod()
+		if owned {
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", UID: "web", Controller: ptr.Bool(true)}}
+		}
+		before := changes()
+		nodes := ExpectSolved(opts, pod)
+		Expect(nodes).To(HaveLen(1))
+		return changes() - before
+	}
+	BeforeEach(func() {
+		stability = scheduling.NewInstanceTypeStability()
+		ExpectApplied(ctx, env.Client, provisioner)
+	})
+	It("should not count a stable instance type", func() {
+		opts := scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}
+		Expect(solve(opts, "type-a", true)).To(BeZero())
+		Expect(solve(opts, "type-a", true)).To(BeZero())
+		Expect(solve(opts, "type-a", true)).To(BeZero())
+	})
+	It("should count each change to a flapping instance type", func() {
+		opts := scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}
+		Expect(solve(opts, "type-a", true)).To(BeZero())
+		Expect(solve(opts, "type-b", true)).To(BeNumerically("==", 1))
+		Expect(solve(opts, "type-a", true)).To(BeNumerically("==", 1))
+		Expect(solve(opts, "type-a", true)).To(BeZero())
+	})
+	It("should not observe pods without a controller", func() {
+		opts := scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}
+		Expect(solve(opts, "type-a", false)).To(BeZero())
+		Expect(solve(opts, "type-b", false)).To(BeZero())
+	})
+	It("should not observe simulations", func() {
+		Expect(solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}, "type-a", true)).To(BeZero())
+		Expect(solve(scheduling.SchedulerOptions{SimulationMode: true, Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}, "type-b", true)).To(BeZero())
+		Expect(solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{InstanceTypeStability: stability}}, "type-a", true)).To(BeZero())
+	})
+	It("should report whether the preferred instance type changed", func() {
+		Expect(stability.Observe("default/ReplicaSet/web", "type-a")).To(BeFalse())
+		Expect(stability.Observe("default/ReplicaSet/web", "type-a")).To(BeFalse())
+		Expect(stability.Observe("default/ReplicaSet/web", "type-b")).To(BeTrue())
+		Expect(stability.Observe("default/ReplicaSet/api", "type-a")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Max Distinct Instance Types", func() {
+	// dedicatedPod requires a node of its own of one of the instance types
+	dedicatedPod := func(instanceTypes ...string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:       metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: instanceTypes}},
+		})
+	}
+	distinct := func(nodes []*scheduling.Node) sets.String {
+		instanceTypes := sets.NewString()
+		for _, n := range nodes {
+			for _, it := range n.InstanceTypeOptions {
+				instanceTypes.Insert(it.Name)
+			}
+		}
+		return instanceTypes
+	}
+	It("should collapse the batch to a single shared instance type", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := []*v1.Pod{
+			dedicatedPod("default-instance-type", "small-instance-type"),
+			dedicatedPod("small-instance-type", "arm-instance-type"),
+			dedicatedPod("single-pod-instance-type", "small-instance-type"),
+		}
+		Expect(distinct(ExpectSolved(scheduling.SchedulerOptions{}, pods...)).Len()).To(BeNumerically(">", 1))
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{MaxDistinctInstanceTypes: 1}}, pods...)
+		Expect(nodes).To(HaveLen(3))
+		Expect(distinct(nodes).List()).To(ConsistOf("small-instance-type"))
+	})
+	It("should collapse the batch to K instance types", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{MaxDistinctInstanceTypes: 2}},
+			dedicatedPod("default-instance-type"),
+			dedicatedPod("small-instance-type"),
+			dedicatedPod("default-instance-type", "small-instance-type", "arm-instance-type"),
+			dedicatedPod("arm-instance-type", "small-instance-type"),
+		)
+		Expect(nodes).To(HaveLen(4))
+		Expect(distinct(nodes).List()).To(ConsistOf("default-instance-type", "small-instance-type"))
+	})
+	It("should keep the options of nodes that can't be covered", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{MaxDistinctInstanceTypes: 1}},
+			dedicatedPod("small-instance-type"),
+			dedicatedPod("small-instance-type"),
+			dedicatedPod("default-instance-type", "arm-instance-type"),
+		)
+		Expect(nodes).To(HaveLen(3))
+		Expect(distinct(nodes).List()).To(ConsistOf("small-instance-type", "default-instance-type", "arm-instance-type"))
+	})
+	It("should not limit the instance types by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(len(nodes[0].InstanceTypeOptions)).To(BeNumerically(">", 1))
+	})
+})
+
+var _ = Describe("Preferred Node", func() {
+	var roomy, full *v1.Node
+	// solve returns the name of the existing node that the pod was scheduled to, or empty if it wasn't
+	solve := func(pod *v1.Pod) string {
+		stateNodes := StateNodes()
+		_, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{}, stateNodes, pod)
+		for _, n := range existingNodes {
+			if len(n.Pods) > 0 {
+				return n.Node.Name
+			}
+		}
+		return ""
+	}
+	preferring := func(name string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.PreferredNodePodAnnotationKey: name}},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		})
+	}
+	BeforeEach(func() {
+		existingNode := func(cpu string) *v1.Node {
+			node := test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+				}},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu), v1.ResourcePods: resource.MustParse("10")},
 			})
-			ExpectApplied(ctx, env.Client, pvcA, pvcB)
-			pods = append(pods, test.UnschedulablePod(test.PodOptions{
-				PersistentVolumeClaims: []string{pvcA.Name, pvcB.Name},
-			}))
+			ExpectApplied(ctx, env.Client, node)
+			ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+			return node
 		}
-		ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
-		var nodeList v1.NodeList
-		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
-		// we need to create a new node as the in-flight one can only contain 5 pods due to the CSINode volume limit
-		Expect(nodeList.Items).To(HaveLen(2))
+		ExpectApplied(ctx, env.Client, provisioner)
+		roomy = existingNode("4")
+		full = existingNode("500m")
 	})
-	It("should launch a single node if all pods use the same PVC", func() {
-		const csiProvider = "fake.csi.provider"
+	It("should schedule to the preferred node if it has room", func() {
+		Expect(solve(preferring(roomy.Name))).To(Equal(roomy.Name))
+	})
+	It("should try the preferred node before the others", func() {
+		other := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, other)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(other))
+		Expect(solve(preferring(other.Name))).To(Equal(other.Name))
+		Expect(solve(preferring(roomy.Name))).To(Equal(roomy.Name))
+	})
+	It("should fall back to the other existing nodes if the preferred node is full", func() {
+		Expect(solve(preferring(full.Name))).To(Equal(roomy.Name))
+	})
+	It("should fall back to the other existing nodes if the preferred node doesn't exist", func() {
+		Expect(solve(preferring("missing"))).To(Equal(roomy.Name))
+	})
+})
+
+var _ = Describe("Limit Usage Metrics", func() {
+	limitUsage := func(provisionerName string, resourceName v1.ResourceName) (float64, bool) {
+		families, err := crmetrics.Registry.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "karpenter_scheduling_limit_usage_pct" {
+				continue
+			}
+			for _, m := range family.Metric {
+				labels := map[string]string{}
+				for _, label := range m.Label {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["provisioner"] == provisionerName && labels["resource_type"] == string(resourceName) {
+					return m.GetGauge().GetValue(), true
+				}
+			}
+		}
+		return 0, false
+	}
+	solve := func(opts scheduling.SchedulerOptions, pods ...*v1.Pod) {
+		ExpectSolvedOnto(opts, StateNodes(), pods...)
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{fake.NewInstanceType(fake.InstanceTypeOptions{Name: "four-cpu"})}
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("20")}}
+	})
+	It("should expose the share of the limits used by the new nodes", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		solve(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(limitUsage(provisioner.Name, v1.ResourceCPU)).To(BeNumerically("==", 20))
+	})
+	It("should include the capacity of the existing nodes", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}},
+			Capacity:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourcePods: resource.MustParse("1")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		// one pod fits on the existing node, the other launches a new node
+		solve(scheduling.SchedulerOptions{}, test.UnschedulablePod(), test.UnschedulablePod())
+		Expect(limitUsage(provisioner.Name, v1.ResourceCPU)).To(BeNumerically("==", 60))
+	})
+	It("should not expose unset limits", func() {
+		other := test.Provisioner()
+		other.Spec.Limits = nil
+		ExpectApplied(ctx, env.Client, provisioner, other)
+		solve(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		_, ok := limitUsage(provisioner.Name, v1.ResourceMemory)
+		Expect(ok).To(BeFalse())
+		_, ok = limitUsage(other.Name, v1.ResourceCPU)
+		Expect(ok).To(BeFalse())
+	})
+	It("should not be updated by simulations", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		solve(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		solve(scheduling.SchedulerOptions{SimulationMode: true}, test.UnschedulablePod(), test.UnschedulablePod())
+		Expect(limitUsage(provisioner.Name, v1.ResourceCPU)).To(BeNumerically("==", 20))
+	})
+})
+
+var _ = Describe("Hugepages", func() {
+	// solve returns the instance type options of the new node for the pod
+	solve := func(pod *v1.Pod) []string {
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, pod)
+		if len(nodes) == 0 {
+			return nil
+		}
+		return lo.Map(nodes[0].InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	requesting := func(resources v1.ResourceList) *v1.Pod {
+		// hugepages can't be overcommitted, so their requests equal their limits
+		return test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: resources, Limits: resources}})
+	}
+	BeforeEach(func() {
 		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
-			fake.NewInstanceType(
-				fake.InstanceTypeOptions{
-					Name: "instance-type",
-					Resources: map[v1.ResourceName]resource.Quantity{
-						v1.ResourceCPU:  resource.MustParse("1024"),
-						v1.ResourcePods: resource.MustParse("1024"),
-					},
-				}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "no-hugepages"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-hugepages", Resources: v1.ResourceList{
+				v1.ResourceHugePagesPrefix + "2Mi": resource.MustParse("512Mi"),
+			}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-hugepages", Resources: v1.ResourceList{
+				v1.ResourceHugePagesPrefix + "2Mi": resource.MustParse("2Gi"),
+			}}),
 		}
+	})
+	It("should only launch instance types that advertise enough hugepages", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(requesting(v1.ResourceList{v1.ResourceHugePagesPrefix + "2Mi": resource.MustParse("256Mi")}))).
+			To(ConsistOf("small-hugepages", "large-hugepages"))
+		Expect(solve(requesting(v1.ResourceList{v1.ResourceHugePagesPrefix + "2Mi": resource.MustParse("1Gi")}))).
+			To(ConsistOf("large-hugepages"))
+	})
+	It("should launch any instance type for pods without hugepages", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(test.UnschedulablePod())).To(ConsistOf("no-hugepages", "small-hugepages", "large-hugepages"))
+	})
+	It("should not schedule pods that request more hugepages than any instance type provides", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(requesting(v1.ResourceList{v1.ResourceHugePagesPrefix + "2Mi": resource.MustParse("4Gi")}))).To(BeEmpty())
+	})
+})
 
-		provisioner.Spec.Limits = nil
+var _ = Describe("On Placement", func() {
+	// vetoing vetoes the placements on nodes that could launch as the instance type
+	vetoing := func(instanceType string) func(*v1.Pod, *scheduling.Node) error {
+		return func(_ *v1.Pod, node *scheduling.Node) error {
+			if lo.ContainsBy(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType) bool { return it.Name == instanceType }) {
+				return fmt.Errorf("instance type %s isn't allowed", instanceType)
+			}
+			return nil
+		}
+	}
+	requiring := func(instanceTypes ...string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{NodeRequirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: instanceTypes},
+		}})
+	}
+	It("should fail pods whose only placements are vetoed", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
-		node := ExpectScheduled(ctx, env.Client, initialPods[0])
-		csiNode := &storagev1.CSINode{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: node.Name,
-			},
-			Spec: storagev1.CSINodeSpec{
-				Drivers: []storagev1.CSINodeDriver{
-					{
-						Name:   csiProvider,
-						NodeID: "fake-node-id",
-						Allocatable: &storagev1.VolumeNodeResources{
-							Count: ptr.Int32(10),
-						},
-					},
-				},
+		allowed, vetoed := requiring("small-instance-type"), requiring("default-instance-type")
+		nodes := ExpectSolved(scheduling.SchedulerOptions{OnPlacement: vetoing("default-instance-type")}, allowed, vetoed)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(allowed))
+	})
+	It("should report the veto as the reason that the pod failed to schedule", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := requiring("default-instance-type")
+		s, err := prov.NewScheduler(ctx, []*v1.Pod{pod}, nil, scheduling.SchedulerOptions{
+			OnPlacement: vetoing("default-instance-type"),
+			Reporting: scheduling.ReportingOptions{
+				VerboseDiagnosis: true,
 			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = s.Solve(ctx, []*v1.Pod{pod})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Diagnosis(pod).String()).To(ContainSubstring("placement vetoed, instance type default-instance-type isn't allowed"))
+	})
+	It("should try the next candidate after a veto", func() {
+		small := test.Provisioner(test.ProvisionerOptions{Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small-instance-type"}},
+		}})
+		ExpectApplied(ctx, env.Client, provisioner, small)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{OnPlacement: vetoing("default-instance-type")}, requiring("default-instance-type", "small-instance-type"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].ProvisionerName).To(Equal(small.Name))
+		Expect(lo.Map(nodes[0].InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })).To(ConsistOf("small-instance-type"))
+	})
+	It("should restore the node when a pod joining it is vetoed", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{OnPlacement: func(_ *v1.Pod, node *scheduling.Node) error {
+			if len(node.Pods) > 1 {
+				return fmt.Errorf("one pod per node")
+			}
+			return nil
+		}}, test.UnschedulablePod(), test.UnschedulablePod(), test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(3))
+		for _, n := range nodes {
+			Expect(n.Pods).To(HaveLen(1))
 		}
-		ExpectApplied(ctx, env.Client, csiNode)
-		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
-
-		sc := test.StorageClass(test.StorageClassOptions{
-			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
-			Provisioner: ptr.String(csiProvider),
-			Zones:       []string{"test-zone-1"}})
-		ExpectApplied(ctx, env.Client, sc)
-
-		pv := test.PersistentVolume(test.PersistentVolumeOptions{
-			ObjectMeta: metav1.ObjectMeta{Name: "my-volume"},
-			Zones:      []string{"test-zone-1"}})
+	})
+	It("should allow every placement by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod(), test.UnschedulablePod())).To(HaveLen(1))
+	})
+})
 
-		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
-			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
-			StorageClassName: ptr.String("my-storage-class"),
-			VolumeName:       pv.Name,
+var _ = Describe("Scheduler Dump", func() {
+	var node *v1.Node
+	labels := map[string]string{"app": "web"}
+	spreadPods := func(count int) []*v1.Pod {
+		return MakePods(count, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+			}},
 		})
-		ExpectApplied(ctx, env.Client, pv, pvc)
-
-		var pods []*v1.Pod
-		for i := 0; i < 100; i++ {
-			pods = append(pods, test.UnschedulablePod(test.PodOptions{
-				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
-			}))
+	}
+	newScheduler := func(opts scheduling.SchedulerOptions, pods []*v1.Pod) *scheduling.Scheduler {
+		stateNodes := StateNodes()
+		s, err := prov.NewScheduler(ctx, pods, stateNodes, opts)
+		Expect(err).ToNot(HaveOccurred())
+		return s
+	}
+	BeforeEach(func() {
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}}
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+				v1.LabelTopologyZone:             "test-zone-1",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("1")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+	})
+	It("should capture the state of a solve", func() {
+		pods := spreadPods(3)
+		s := newScheduler(scheduling.SchedulerOptions{}, pods)
+		newNodes, _, err := s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		dump := s.Dump()
+
+		Expect(dump.NewNodes).To(HaveLen(len(newNodes)))
+		scheduled := 0
+		for _, n := range dump.NewNodes {
+			Expect(n.Provisioner).To(Equal(provisioner.Name))
+			Expect(n.InstanceTypes).ToNot(BeEmpty())
+			Expect(n.Requests).To(HaveKeyWithValue(string(v1.ResourcePods), "1"))
+			Expect(n.Requirements).To(ContainElement(HaveField("Key", v1.LabelTopologyZone)))
+			scheduled += len(n.Pods)
 		}
-		ExpectApplied(ctx, env.Client, provisioner)
-		ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
-		var nodeList v1.NodeList
-		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
-		// 100 of the same PVC should all be schedulable on the same node
-		Expect(nodeList.Items).To(HaveLen(1))
+		Expect(dump.ExistingNodes).To(HaveLen(1))
+		Expect(dump.ExistingNodes[0].Node).To(Equal(node.Name))
+		Expect(dump.ExistingNodes[0].Provisioner).To(Equal(provisioner.Name))
+		Expect(dump.ExistingNodes[0].Available).To(HaveKeyWithValue(string(v1.ResourceCPU), "4"))
+		Expect(scheduled + len(dump.ExistingNodes[0].Pods)).To(Equal(3))
+
+		Expect(dump.RemainingResources).To(HaveKey(provisioner.Name))
+		Expect(dump.RemainingResources[provisioner.Name]).To(HaveKey(string(v1.ResourceCPU)))
+
+		spread, ok := lo.Find(dump.Topologies, func(t scheduling.TopologyState) bool { return t.Key == v1.LabelTopologyZone })
+		Expect(ok).To(BeTrue())
+		Expect(spread.Type).To(Equal("topology spread"))
+		Expect(spread.Selector).To(Equal("app=web"))
+		Expect(lo.Sum(lo.Values(spread.Domains))).To(BeNumerically("==", 3))
 	})
-	It("should not fail for non-dynamic PVCs", func() {
-		const csiProvider = "fake.csi.provider"
-		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
-			fake.NewInstanceType(
-				fake.InstanceTypeOptions{
-					Name: "instance-type",
-					Resources: map[v1.ResourceName]resource.Quantity{
-						v1.ResourceCPU:  resource.MustParse("1024"),
-						v1.ResourcePods: resource.MustParse("1024"),
-					},
-				}),
+	It("should marshal to JSON", func() {
+		pods := spreadPods(2)
+		s := newScheduler(scheduling.SchedulerOptions{}, pods)
+		_, _, err := s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		raw, err := json.Marshal(s.Dump())
+		Expect(err).ToNot(HaveOccurred())
+		var decoded scheduling.SchedulerState
+		Expect(json.Unmarshal(raw, &decoded)).To(Succeed())
+		Expect(decoded.NewNodes).ToNot(BeEmpty())
+		roundTripped, err := json.Marshal(decoded)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(roundTripped).To(MatchJSON(raw))
+	})
+	It("should snapshot the state during a solve", func() {
+		pods := MakePods(3, test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}}})
+		var s *scheduling.Scheduler
+		var dumps []*scheduling.SchedulerState
+		s = newScheduler(scheduling.SchedulerOptions{OnPlacement: func(*v1.Pod, *scheduling.Node) error {
+			dumps = append(dumps, s.Dump())
+			return nil
+		}}, pods)
+		_, _, err := s.Solve(ctx, pods)
+		Expect(err).ToNot(HaveOccurred())
+		// a placement is dumped before its new node is added to the scheduler's new nodes, and later placements don't
+		// change the earlier dumps
+		Expect(dumps).To(HaveLen(3))
+		for i, dump := range dumps {
+			Expect(dump.NewNodes).To(HaveLen(i))
 		}
+		Expect(s.Dump().NewNodes).To(HaveLen(3))
+	})
+})
 
-		provisioner.Spec.Limits = nil
+var _ = Describe("Existing Node Scoring", func() {
+	var full, packed, snug, empty *v1.Node
+	// existingNode creates an initialized node with the allocatable CPU and a pod bound to it requesting the used CPU
+	existingNode := func(allocatable, used string, labels ...map[string]string) *v1.Node {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: lo.Assign(append([]map[string]string{{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}}, labels...)...)},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(allocatable), v1.ResourcePods: resource.MustParse("100")},
+		})
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(used)}},
+		})
+		ExpectApplied(ctx, env.Client, node, pod)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+		ExpectReconcileSucceeded(ctx, podStateController, client.ObjectKeyFromObject(pod))
+		return node
+	}
+	BeforeEach(func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
-		node := ExpectScheduled(ctx, env.Client, initialPods[0])
-		csiNode := &storagev1.CSINode{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: node.Name,
-			},
-			Spec: storagev1.CSINodeSpec{
-				Drivers: []storagev1.CSINodeDriver{
-					{
-						Name:   csiProvider,
-						NodeID: "fake-node-id",
-						Allocatable: &storagev1.VolumeNodeResources{
-							Count: ptr.Int32(10),
-						},
-					},
-				},
-			},
+		full = existingNode("4", "3.5")   // 87.5% utilized, 0.5 CPU remaining
+		packed = existingNode("16", "13") // 81.25% utilized, 3 CPU remaining
+		snug = existingNode("2", "1")     // 50% utilized, 1 CPU remaining
+		empty = existingNode("16", "4")   // 25% utilized, 12 CPU remaining
+	})
+	// scheduleAnnotated returns the name of the existing node that a pod with the annotations requesting 1 CPU is
+	// scheduled to
+	scheduleAnnotated := func(scorer scheduling.ExistingNodeScorer, annotations map[string]string) string {
+		stateNodes := StateNodes()
+		pods := []*v1.Pod{test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Annotations: annotations},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		})}
+		nodes, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{ExistingNodes: scheduling.ExistingNodeOptions{ScoreExistingNode: scorer}}, stateNodes, pods...)
+		Expect(nodes).To(BeEmpty())
+		for _, n := range existingNodes {
+			if len(n.Pods) > 0 {
+				return n.Node.Name
+			}
 		}
-		ExpectApplied(ctx, env.Client, csiNode)
+		Fail("pod wasn't scheduled to an existing node")
+		return ""
+	}
+	// schedule returns the name of the existing node that a pod requesting 1 CPU is scheduled to
+	schedule := func(scorer scheduling.ExistingNodeScorer) string {
+		return scheduleAnnotated(scorer, nil)
+	}
+	It("should pack pods onto the most utilized node that they fit on", func() {
+		Expect(schedule(scheduling.MostUtilized)).To(Equal(packed.Name))
+	})
+	It("should spread pods onto the least utilized node", func() {
+		Expect(schedule(scheduling.LeastUtilized)).To(Equal(empty.Name))
+	})
+	It("should schedule pods to the node that they fill most closely", func() {
+		Expect(schedule(scheduling.LeastFragmenting)).To(Equal(snug.Name))
+	})
+	It("should use a custom scorer", func() {
+		Expect(schedule(func(n *scheduling.ExistingNode, _ *v1.Pod) float64 {
+			return lo.Ternary(n.Node.Name == full.Name || n.Node.Name == empty.Name, 1.0, 0.0)
+		})).To(Equal(empty.Name))
+	})
+	Context("Instance Families", func() {
+		var m5, c5 *v1.Node
+		BeforeEach(func() {
+			// equally utilized nodes that only differ by their instance family
+			m5 = existingNode("4", "1", map[string]string{v1.LabelInstanceTypeStable: "m5.large", "example.com/family": "general"})
+			c5 = existingNode("4", "1", map[string]string{v1.LabelInstanceTypeStable: "c5.large", "example.com/family": "compute"})
+		})
+		preferring := func(families string) map[string]string {
+			return map[string]string{v1alpha5.PreferredInstanceFamiliesPodAnnotationKey: families}
+		}
+		It("should prefer nodes of the pod's instance family over equally fitting nodes of other families", func() {
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.LeastFragmenting), preferring("m5"))).To(Equal(m5.Name))
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.LeastFragmenting), preferring("c5"))).To(Equal(c5.Name))
+		})
+		It("should prefer nodes of the pod's instance family over better scoring nodes", func() {
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.MostUtilized), preferring("c5"))).To(Equal(c5.Name))
+		})
+		It("should prefer nodes of any of the pod's instance families", func() {
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.MostUtilized), preferring("r5, c5"))).To(Equal(c5.Name))
+		})
+		It("should order nodes by the wrapped scorer if the pod doesn't prefer an instance family", func() {
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.MostUtilized), nil)).To(Equal(packed.Name))
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.MostUtilized), preferring("r5"))).To(Equal(packed.Name))
+		})
+		It("should read the instance family from the label", func() {
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("example.com/family", scheduling.MostUtilized), preferring("compute"))).To(Equal(c5.Name))
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("example.com/family", scheduling.MostUtilized), preferring("c5"))).To(Equal(packed.Name))
+		})
+		It("should only be a preference", func() {
+			// the preferred node doesn't have room for the pod
+			r5 := existingNode("4", "3.5", map[string]string{v1.LabelInstanceTypeStable: "r5.large"})
+			Expect(scheduleAnnotated(scheduling.PreferInstanceFamilies("", scheduling.MostUtilized), preferring("r5"))).ToNot(Equal(r5.Name))
+		})
+	})
+})
+
+var _ = Describe("Existing Node CanAdd", func() {
+	var node *v1.Node
+	BeforeEach(func() {
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Taints:      []v1.Taint{{Key: "example.com/dedicated", Effect: v1.TaintEffectNoSchedule}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("100")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
 		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+	})
+	// existingNode returns the existing node for the node with a topology that tracks the pods
+	existingNode := func(pods ...*v1.Pod) *scheduling.ExistingNode {
+		topology, err := scheduling.NewTopology(ctx, env.Client, cluster, map[string]sets.String{}, pods, scheduling.DefaultMaxTopologyDomains, false, false)
+		Expect(err).ToNot(HaveOccurred())
+		var stateNode *state.Node
+		cluster.ForEachNode(func(n *state.Node) bool {
+			if n.Node.Name == node.Name {
+				stateNode = n.DeepCopy()
+			}
+			return true
+		})
+		Expect(stateNode).ToNot(BeNil())
+		return scheduling.NewExistingNode(stateNode, topology, nil, nil, nil, nil, nil)
+	}
+	tolerating := func(opts test.PodOptions) *v1.Pod {
+		opts.Tolerations = []v1.Toleration{{Key: "example.com/dedicated", Operator: v1.TolerationOpExists}}
+		return test.UnschedulablePod(opts)
+	}
+	It("should not modify the node when called repeatedly", func() {
+		pod := tolerating(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+		})
+		n := existingNode(pod)
+		remaining := n.Remaining()
+		for i := 0; i < 3; i++ {
+			Expect(n.CanAdd(ctx, pod)).To(Succeed())
+		}
+		Expect(n.Pods).To(BeEmpty())
+		Expect(n.Remaining()).To(Equal(remaining))
+
+		// the node only fits the pod once it's added
+		Expect(n.Add(ctx, pod)).To(Succeed())
+		err := n.CanAdd(ctx, pod.DeepCopy())
+		Expect(err).To(HaveOccurred())
+		Expect(scheduling.PredicateOf(err)).To(Equal(scheduling.PredicateResources))
+	})
+	It("should not record the pod against the topology", func() {
+		labels := map[string]string{"app": "exclusive"}
+		antiAffinity := []v1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+			TopologyKey:   v1.LabelHostname,
+		}}
+		first := tolerating(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, PodAntiRequirements: antiAffinity})
+		second := tolerating(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Labels: labels}, PodAntiRequirements: antiAffinity})
+		n := existingNode(first, second)
+		Expect(n.CanAdd(ctx, first)).To(Succeed())
+		Expect(n.CanAdd(ctx, second)).To(Succeed())
+
+		Expect(n.Add(ctx, first)).To(Succeed())
+		err := n.CanAdd(ctx, second)
+		Expect(err).To(HaveOccurred())
+		Expect(scheduling.PredicateOf(err)).To(Equal(scheduling.PredicateTopology))
+	})
+	It("should return the same errors as Add", func() {
+		pod := test.UnschedulablePod()
+		n := existingNode(pod)
+		canAddErr := n.CanAdd(ctx, pod)
+		Expect(canAddErr).To(HaveOccurred())
+		Expect(scheduling.PredicateOf(canAddErr)).To(Equal(scheduling.PredicateTaints))
+		Expect(n.Add(ctx, pod)).To(MatchError(canAddErr.Error()))
+		Expect(n.Pods).To(BeEmpty())
+	})
+})
 
-		sc := test.StorageClass(test.StorageClassOptions{
-			ObjectMeta:  metav1.ObjectMeta{Name: "my-storage-class"},
-			Provisioner: ptr.String(csiProvider),
-			Zones:       []string{"test-zone-1"}})
-		ExpectApplied(ctx, env.Client, sc)
+var _ = Describe("Tracing", func() {
+	var exporter *tracetest.InMemoryExporter
+	var previous oteltrace.TracerProvider
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	})
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+	spansNamed := func(name string) tracetest.SpanStubs {
+		return lo.Filter(exporter.GetSpans(), func(span tracetest.SpanStub, _ int) bool { return span.Name == name })
+	}
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		return lo.SliceToMap(span.Attributes, func(kv attribute.KeyValue) (attribute.Key, attribute.Value) { return kv.Key, kv.Value })
+	}
+	It("should record a span for the solve with the pod, new node and failure counts", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := []*v1.Pod{
+			test.UnschedulablePod(),
+			test.UnschedulablePod(),
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"}}),
+		}
+		ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
 
-		pv := test.PersistentVolume(test.PersistentVolumeOptions{
-			ObjectMeta: metav1.ObjectMeta{Name: "my-volume"},
-			Driver:     csiProvider,
-			Zones:      []string{"test-zone-1"}})
+		spans := spansNamed("Scheduler.Solve")
+		Expect(spans).To(HaveLen(1))
+		Expect(attributes(spans[0])).To(HaveKeyWithValue(attribute.Key("karpenter.pods"), attribute.IntValue(3)))
+		Expect(attributes(spans[0])).To(HaveKeyWithValue(attribute.Key("karpenter.new_nodes"), attribute.IntValue(1)))
+		Expect(attributes(spans[0])).To(HaveKeyWithValue(attribute.Key("karpenter.failed_pods"), attribute.IntValue(1)))
+	})
+	It("should record spans for adding pods and creating nodes within the solve", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		ExpectScheduled(ctx, env.Client, pod)
 
-		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
-			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
-			VolumeName:       pv.Name,
-			StorageClassName: ptr.String(""),
-		})
-		ExpectApplied(ctx, env.Client, pv, pvc)
+		solve := spansNamed("Scheduler.Solve")
+		Expect(solve).To(HaveLen(1))
+		add := spansNamed("Scheduler.add")
+		Expect(add).To(HaveLen(1))
+		Expect(add[0].Parent.SpanID()).To(Equal(solve[0].SpanContext.SpanID()))
+		Expect(attributes(add[0])).To(HaveKeyWithValue(attribute.Key("karpenter.pod"), attribute.StringValue(client.ObjectKeyFromObject(pod).String())))
+		newNode := spansNamed("Scheduler.newNode")
+		Expect(newNode).To(HaveLen(1))
+		Expect(newNode[0].Parent.SpanID()).To(Equal(add[0].SpanContext.SpanID()))
+		Expect(attributes(newNode[0])).To(HaveKeyWithValue(attribute.Key("karpenter.provisioner"), attribute.StringValue(provisioner.Name)))
+	})
+	It("should record the error of a pod that fails to schedule", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
 
-		var pods []*v1.Pod
-		for i := 0; i < 5; i++ {
-			pods = append(pods, test.UnschedulablePod(test.PodOptions{
-				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
-			}))
+		add := spansNamed("Scheduler.add")
+		Expect(add).ToNot(BeEmpty())
+		Expect(add[0].Status.Code).To(Equal(codes.Error))
+		Expect(spansNamed("Scheduler.newNode")[0].Status.Code).To(Equal(codes.Error))
+	})
+})
+
+var _ = Describe("Capacity Reservation Expiry", func() {
+	const window = 24 * time.Hour
+	instanceType := func(name string, price float64, reservationExpiry time.Time) *cloudprovider.InstanceType {
+		return fake.NewInstanceType(fake.InstanceTypeOptions{
+			Name: name,
+			Offerings: []cloudprovider.Offering{{
+				CapacityType:      v1alpha5.CapacityTypeOnDemand,
+				Zone:              "test-zone-1",
+				Price:             price,
+				Available:         true,
+				ReservationExpiry: reservationExpiry,
+			}},
+		})
+	}
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			instanceType("expiring-reservation", 0.5, fakeClock.Now().Add(time.Hour)),
+			instanceType("on-demand", 1.0, time.Time{}),
 		}
+	})
+	It("should deprioritize a soon to expire reservation relative to on-demand for long-lived pods", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
-
-		var nodeList v1.NodeList
-		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
-		// 5 of the same PVC should all be schedulable on the same node
-		Expect(nodeList.Items).To(HaveLen(1))
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Clock: fakeClock, InstanceTypes: scheduling.InstanceTypeSelectionOptions{ReservationExpiryWindow: window}}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("on-demand"))
 	})
-	It("should not fail for NFS volumes", func() {
+	It("should use a soon to expire reservation if there's no other instance type", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Clock: fakeClock, InstanceTypes: scheduling.InstanceTypeSelectionOptions{ReservationExpiryWindow: window}}, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "expiring-reservation"},
+		}))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("expiring-reservation"))
+	})
+	It("should not deprioritize reservations that expire after the window", func() {
+		cloudProv.InstanceTypes[0] = instanceType("expiring-reservation", 0.5, fakeClock.Now().Add(2*window))
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Clock: fakeClock, InstanceTypes: scheduling.InstanceTypeSelectionOptions{ReservationExpiryWindow: window}}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("expiring-reservation", "on-demand"))
+	})
+	It("should not deprioritize soon to expire reservations for pods run by jobs", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		pod.OwnerReferences = append(pod.OwnerReferences, metav1.OwnerReference{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       "job",
+			UID:        "job-uid",
+		})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Clock: fakeClock, InstanceTypes: scheduling.InstanceTypeSelectionOptions{ReservationExpiryWindow: window}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("expiring-reservation", "on-demand"))
+	})
+	It("should not deprioritize soon to expire reservations for pods with an active deadline within the window", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod()
+		pod.Spec.ActiveDeadlineSeconds = lo.ToPtr(int64(time.Hour.Seconds()))
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Clock: fakeClock, InstanceTypes: scheduling.InstanceTypeSelectionOptions{ReservationExpiryWindow: window}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("expiring-reservation", "on-demand"))
+	})
+	It("should not deprioritize soon to expire reservations by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		nodes := ExpectSolved(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("expiring-reservation", "on-demand"))
+	})
+})
+
+var _ = Describe("Consolidation Reserve", func() {
+	solve := func(reserve int, pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{ConsolidationReserve: reserve}}, pods...)
+	}
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	cpuPod := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+		}})
+	}
+	BeforeEach(func() {
 		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
-			fake.NewInstanceType(
-				fake.InstanceTypeOptions{
-					Name: "instance-type",
-					Resources: map[v1.ResourceName]resource.Quantity{
-						v1.ResourceCPU:  resource.MustParse("1024"),
-						v1.ResourcePods: resource.MustParse("1024"),
-					},
-				}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}),
 		}
+		ExpectApplied(ctx, env.Client, provisioner)
+	})
+	It("should not reserve capacity by default", func() {
+		nodes := solve(0, cpuPod("1500m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small", "medium", "large"))
+	})
+	It("should prefer larger instance types with room for the reserve", func() {
+		nodes := solve(50, cpuPod("1500m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("medium", "large"))
+	})
+	It("should reserve capacity for all of the pods on the node", func() {
+		nodes := solve(100, cpuPod("1"), cpuPod("1"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(2))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("large"))
+	})
+	It("should reserve capacity for the default requests of each pod without requests", func() {
+		nodes := ExpectSolved(scheduling.SchedulerOptions{
+			Packing:       scheduling.PackingOptions{DefaultPodRequests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+			InstanceTypes: scheduling.InstanceTypeSelectionOptions{ConsolidationReserve: 100},
+		}, test.UnschedulablePod(), test.UnschedulablePod())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(HaveLen(2))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("large"))
+	})
+	It("should keep the instance types if none have room for the reserve", func() {
+		nodes := solve(1000, cpuPod("1500m"))
+		Expect(nodes).To(HaveLen(1))
+		Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small", "medium", "large"))
+	})
+})
 
-		provisioner.Spec.Limits = nil
+var _ = Describe("Pod Groups", func() {
+	groupPod := func(group string, opts test.PodOptions) *v1.Pod {
+		opts.ObjectMeta.Annotations = lo.Assign(opts.ObjectMeta.Annotations, map[string]string{v1alpha5.PodGroupPodAnnotationKey: group})
+		return test.UnschedulablePod(opts)
+	}
+	cpuRequests := func(cpu string) v1.ResourceRequirements {
+		return v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+	solve := func(stateNodes []*state.Node, pods ...*v1.Pod) ([]*scheduling.Node, []*scheduling.ExistingNode) {
+		return ExpectSolvedOnto(scheduling.SchedulerOptions{}, stateNodes, pods...)
+	}
+	scheduledPods := func(nodes []*scheduling.Node) []*v1.Pod {
+		return lo.FlatMap(nodes, func(n *scheduling.Node, _ int) []*v1.Pod { return n.Pods })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "instance-type", Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}),
+		}
+	})
+	It("should schedule a pod group that fits", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		initialPods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
-		node := ExpectScheduled(ctx, env.Client, initialPods[0])
+		pods := []*v1.Pod{
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+		}
+		nodes, _ := solve(nil, pods...)
+		Expect(nodes).To(HaveLen(3))
+		Expect(scheduledPods(nodes)).To(ConsistOf(pods))
+	})
+	It("should not create capacity for a pod group that doesn't fit", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := []*v1.Pod{
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3"), NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"}}),
+		}
+		nodes, _ := solve(nil, pods...)
+		Expect(nodes).To(BeEmpty())
+		Expect(recorder.Calls(events.PodFailedToSchedule(pods[0], fmt.Errorf("")).Reason)).To(Equal(3))
+	})
+	It("should release the capacity of a pod group that doesn't fit to other pods", func() {
+		// the limits allow two nodes, so only two of the pods in the group can be scheduled
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		pods := []*v1.Pod{
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+		}
+		ungrouped := []*v1.Pod{
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: cpuRequests("3")}),
+		}
+		nodes, _ := solve(nil, append(pods, ungrouped...)...)
+		Expect(nodes).To(HaveLen(2))
+		Expect(scheduledPods(nodes)).To(ConsistOf(ungrouped))
+	})
+	It("should not schedule a pod group that doesn't fit to existing nodes", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
 		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
-
-		pv := test.PersistentVolume(test.PersistentVolumeOptions{
-			ObjectMeta:       metav1.ObjectMeta{Name: "my-volume"},
-			StorageClassName: "nfs",
-			Zones:            []string{"test-zone-1"}})
-		pv.Spec.NFS = &v1.NFSVolumeSource{
-			Server: "fake.server",
-			Path:   "/some/path",
+		stateNodes := StateNodes()
+		pods := []*v1.Pod{
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("1")}),
+			// too large for the existing node or any instance type
+			groupPod("job", test.PodOptions{ResourceRequirements: cpuRequests("8")}),
 		}
-		pv.Spec.CSI = nil
+		nodes, existingNodes := solve(stateNodes, pods...)
+		Expect(nodes).To(BeEmpty())
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(BeEmpty())
+		remaining := existingNodes[0].Remaining()
+		Expect(remaining.Cpu().String()).To(Equal("4"))
+	})
+	It("should schedule pod groups of the same name in different namespaces independently", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		scheduled := groupPod("job", test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}, ResourceRequirements: cpuRequests("1")})
+		unschedulable := groupPod("job", test.PodOptions{
+			ObjectMeta:           metav1.ObjectMeta{Namespace: "team-b"},
+			ResourceRequirements: cpuRequests("1"),
+			NodeSelector:         map[string]string{v1.LabelTopologyZone: "unknown-zone"},
+		})
+		nodes, _ := solve(nil, scheduled, unschedulable)
+		Expect(scheduledPods(nodes)).To(ConsistOf(scheduled))
+	})
+})
 
-		pvc := test.PersistentVolumeClaim(test.PersistentVolumeClaimOptions{
-			ObjectMeta:       metav1.ObjectMeta{Name: "my-claim"},
-			VolumeName:       pv.Name,
-			StorageClassName: ptr.String(""),
+var _ = Describe("Limit Boundaries", func() {
+	solve := func(exclusive bool, pods ...*v1.Pod) []*scheduling.Node {
+		return ExpectSolved(scheduling.SchedulerOptions{InstanceTypes: scheduling.InstanceTypeSelectionOptions{ExclusiveLimits: exclusive}}, pods...)
+	}
+	podRequesting := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
 		})
-		ExpectApplied(ctx, env.Client, pv, pvc)
-
-		var pods []*v1.Pod
-		for i := 0; i < 5; i++ {
-			pods = append(pods, test.UnschedulablePod(test.PodOptions{
-				PersistentVolumeClaims: []string{pvc.Name, pvc.Name},
-			}))
+	}
+	instanceTypeNames := func(node *scheduling.Node) []string {
+		return lo.Map(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "small",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourcePods: resource.MustParse("100")},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "large",
+				Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("16"), v1.ResourcePods: resource.MustParse("100")},
+			}),
 		}
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("16")}}
+	})
+	Context("Inclusive", func() {
+		It("should launch an instance type that exactly meets the limits", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := solve(false, podRequesting("10"))
+			Expect(nodes).To(HaveLen(1))
+			Expect(instanceTypeNames(nodes[0])).To(ConsistOf("large"))
+		})
+		It("should launch nodes until the limits are exactly met", func() {
+			cloudProv.InstanceTypes = cloudProv.InstanceTypes[:1]
+			ExpectApplied(ctx, env.Client, provisioner)
+			Expect(solve(false, podRequesting("6"), podRequesting("6"), podRequesting("6"))).To(HaveLen(2))
+		})
+	})
+	Context("Exclusive", func() {
+		It("should not launch an instance type that exactly meets the limits", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			Expect(solve(true, podRequesting("10"))).To(BeEmpty())
+		})
+		It("should launch instance types below the limits", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			nodes := solve(true, podRequesting("4"))
+			Expect(nodes).To(HaveLen(1))
+			Expect(instanceTypeNames(nodes[0])).To(ConsistOf("small"))
+		})
+		It("should stop launching nodes before the limits are met", func() {
+			cloudProv.InstanceTypes = cloudProv.InstanceTypes[:1]
+			ExpectApplied(ctx, env.Client, provisioner)
+			Expect(solve(true, podRequesting("6"), podRequesting("6"), podRequesting("6"))).To(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("Provisioning Decision Event", func() {
+	ExpectDecisionEvent := func(obj runtime.Object) events.Event {
+		var decisions []events.Event
+		recorder.ForEachEvent(func(evt events.Event) {
+			if evt.Reason == "ProvisioningDecision" && evt.InvolvedObject == obj {
+				decisions = append(decisions, evt)
+			}
+		})
+		ExpectWithOffset(1, decisions).To(HaveLen(1))
+		return decisions[0]
+	}
+	solve := func(opts scheduling.SchedulerOptions, pods ...*v1.Pod) {
+		ExpectSolvedOnto(opts, StateNodes(), pods...)
+	}
+	It("should summarize the solve in a single event", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pods...)
+		initialPod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())
+		node := ExpectScheduled(ctx, env.Client, initialPod[0])
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
 
-		var nodeList v1.NodeList
-		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
-		// 5 of the same PVC should all be schedulable on the same node
-		Expect(nodeList.Items).To(HaveLen(1))
+		pods := MakePods(2, test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}},
+		})
+		pods = append(pods, test.UnschedulablePod(), test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10000")}},
+		}))
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, pods...)
+
+		evt := ExpectDecisionEvent(provisioner)
+		Expect(evt.Type).To(Equal(v1.EventTypeNormal))
+		Expect(evt.Annotations).To(HaveKeyWithValue("scheduledToExistingNodes", "1"))
+		Expect(evt.Annotations).To(HaveKeyWithValue("scheduledToNewNodes", "2"))
+		Expect(evt.Annotations).To(HaveKeyWithValue("newNodes", fmt.Sprintf("%s/default-instance-type=2", provisioner.Name)))
+		Expect(evt.Annotations).To(HaveKeyWithValue("failedToSchedule", "1"))
+		Expect(evt.Annotations).To(HaveKeyWithValue("failureReasons", scheduling.FailureReasonInsufficientCapacity+"=1"))
+		Expect(evt.Message).To(ContainSubstring("Scheduled 1 pod(s) to existing nodes and 2 pod(s) to 2 new node(s)"))
+		Expect(evt.Message).To(ContainSubstring("1 pod(s) failed to schedule"))
 	})
-	It("should not launch nodes for pods with ephemeral volume using a non-existent storage classes", func() {
+	It("should count the pods that failed to schedule by reason", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		pod := test.UnschedulablePod(test.PodOptions{})
-		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
-			Name: "tmp-ephemeral",
-			VolumeSource: v1.VolumeSource{
-				Ephemeral: &v1.EphemeralVolumeSource{
-					VolumeClaimTemplate: &v1.PersistentVolumeClaimTemplate{
-						Spec: v1.PersistentVolumeClaimSpec{
-							StorageClassName: ptr.String("non-existent"),
-							AccessModes: []v1.PersistentVolumeAccessMode{
-								v1.ReadWriteOnce,
-							},
-							Resources: v1.ResourceRequirements{
-								Requests: v1.ResourceList{
-									v1.ResourceStorage: resource.MustParse("1Gi"),
-								},
-							},
-						},
-					},
-				},
-			},
-		})
+		solve(scheduling.SchedulerOptions{Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}},
+			test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{"example.com/unknown": resource.MustParse("1")}},
+			}),
+			test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "unknown-instance-type"}}),
+		)
+
+		evt := ExpectDecisionEvent(provisioner)
+		Expect(evt.Annotations).To(HaveKeyWithValue("failedToSchedule", "2"))
+		Expect(evt.Annotations).ToNot(HaveKey("newNodes"))
+		Expect(evt.Annotations).To(HaveKeyWithValue("failureReasons",
+			fmt.Sprintf("%s=1,%s=1", scheduling.FailureReasonUnknownResources, scheduling.FailureReasonUnsatisfiableRequirements)))
+	})
+	It("should not publish the event without an object", func() {
 		ExpectApplied(ctx, env.Client, provisioner)
-		_ = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, pod)
-
-		var nodeList v1.NodeList
-		Expect(env.Client.List(ctx, &nodeList)).To(Succeed())
-		// no nodes should be created as the storage class doesn't eixst
-		Expect(nodeList.Items).To(HaveLen(0))
+		published := recorder.Calls("ProvisioningDecision")
+		solve(scheduling.SchedulerOptions{}, test.UnschedulablePod())
+		Expect(recorder.Calls("ProvisioningDecision")).To(Equal(published))
+	})
+	It("should not publish the event in simulation mode", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		solve(scheduling.SchedulerOptions{SimulationMode: true, Reporting: scheduling.ReportingOptions{DecisionEventObject: provisioner}}, test.UnschedulablePod())
+		recorder.ForEachEvent(func(evt events.Event) {
+			Expect(evt.InvolvedObject).ToNot(BeIdenticalTo(provisioner))
+		})
 	})
 })
 
-func MakePods(count int, options test.PodOptions) (pods []*v1.Pod) {
-	for i := 0; i < count; i++ {
-		pods = append(pods, test.UnschedulablePod(options))
+// fakeArchitectureResolver resolves the architectures of the images that it contains
+type fakeArchitectureResolver map[string][]string
+
+func (r fakeArchitectureResolver) Architectures(_ context.Context, image string) ([]string, error) {
+	architectures, ok := r[image]
+	if !ok {
+		return nil, fmt.Errorf("image %q not found", image)
 	}
-	return pods
+	return architectures, nil
 }
 
-// nolint:gocyclo
-func ExpectMaxSkew(ctx context.Context, c client.Client, namespace string, constraint *v1.TopologySpreadConstraint) Assertion {
-	nodes := &v1.NodeList{}
-	Expect(c.List(ctx, nodes)).To(Succeed())
-	pods := &v1.PodList{}
-	Expect(c.List(ctx, pods, scheduling.TopologyListOptions(namespace, constraint.LabelSelector))).To(Succeed())
-	skew := map[string]int{}
-
-	nodeMap := map[string]*v1.Node{}
-	for i, node := range nodes.Items {
-		nodeMap[node.Name] = &nodes.Items[i]
+var _ = Describe("Architecture Inference", func() {
+	resolver := fakeArchitectureResolver{
+		"amd64-image":       {v1alpha5.ArchitectureAmd64},
+		"arm64-image":       {v1alpha5.ArchitectureArm64},
+		"multi-arch-image":  {v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64},
+		"empty-image-index": {},
+	}
+	// solve returns the architectures of the instance type options of the new node for the pod
+	solve := func(resolver scheduling.ArchitectureResolver, pod *v1.Pod) []string {
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{ArchitectureResolver: resolver}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		architectures := sets.NewString()
+		for _, it := range nodes[0].InstanceTypeOptions {
+			architectures.Insert(it.Requirements.Get(v1.LabelArchStable).Values()...)
+		}
+		return architectures.List()
 	}
+	BeforeEach(func() {
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "amd64-instance-type", Architecture: v1alpha5.ArchitectureAmd64}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "arm64-instance-type", Architecture: v1alpha5.ArchitectureArm64}),
+		}
+	})
+	It("should be disabled by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(nil, test.UnschedulablePod(test.PodOptions{Image: "arm64-image"}))).To(ConsistOf(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64))
+	})
+	It("should restrict the instance types to the architecture of a single architecture image", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "arm64-image"}))).To(ConsistOf(v1alpha5.ArchitectureArm64))
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "amd64-image"}))).To(ConsistOf(v1alpha5.ArchitectureAmd64))
+	})
+	It("should constrain the new node to the inferred architecture", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{Image: "arm64-image"})
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{ArchitectureResolver: resolver}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Requirements.Get(v1.LabelArchStable).Values()).To(ConsistOf(v1alpha5.ArchitectureArm64))
+		// the pod itself isn't modified
+		Expect(pod.Spec.Affinity).To(BeNil())
+		Expect(pod.Spec.NodeSelector).To(BeEmpty())
+	})
+	It("should not restrict the instance types of multi-architecture images", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "multi-arch-image"}))).To(ConsistOf(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64))
+	})
+	It("should infer the architecture common to all of the pod's images", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "multi-arch-image", InitImage: "arm64-image"}))).To(ConsistOf(v1alpha5.ArchitectureArm64))
+	})
+	It("should not infer an architecture if any of the pod's images can't be resolved", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "arm64-image", InitImage: "unknown-image"}))).To(ConsistOf(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64))
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{Image: "empty-image-index"}))).To(ConsistOf(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64))
+	})
+	It("should not override an architecture that the pod selects", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{
+			Image:        "arm64-image",
+			NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureAmd64},
+		}))).To(ConsistOf(v1alpha5.ArchitectureAmd64))
+		Expect(solve(resolver, test.UnschedulablePod(test.PodOptions{
+			Image: "arm64-image",
+			NodeRequirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64}},
+			},
+		}))).To(ConsistOf(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64))
+	})
+})
 
-	for i, pod := range pods.Items {
-		if scheduling.IgnoredForTopology(&pods.Items[i]) {
-			continue
+// fakeNamespaceNodeSelectorLister lists the node selectors of the namespaces that it contains
+type fakeNamespaceNodeSelectorLister map[string]map[string]string
+
+func (l fakeNamespaceNodeSelectorLister) NodeSelector(_ context.Context, namespace string) (map[string]string, error) {
+	return l[namespace], nil
+}
+
+var _ = Describe("Namespace Node Selectors", func() {
+	var restricted string
+	// solve returns the instance type options of the new node for the pod
+	solve := func(lister scheduling.NamespaceNodeSelectorLister, pod *v1.Pod) []string {
+		nodes := ExpectSolved(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{NamespaceNodeSelectors: lister}}, pod)
+		Expect(nodes).To(HaveLen(1))
+		return lo.Map(nodes[0].InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) string { return it.Name })
+	}
+	BeforeEach(func() {
+		restricted = test.RandomName()
+		cloudProv.InstanceTypes = []*cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-instance-type"}),
 		}
-		node := nodeMap[pod.Spec.NodeName]
-		if pod.Spec.NodeName == node.Name {
-			if constraint.TopologyKey == v1.LabelHostname {
-				skew[node.Name]++ // Check node name since hostname labels aren't applied
-			}
-			if constraint.TopologyKey == v1.LabelTopologyZone {
-				if key, ok := node.Labels[constraint.TopologyKey]; ok {
-					skew[key]++
-				}
+	})
+	It("should be disabled by default", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+		Expect(solve(nil, pod)).To(ConsistOf("small-instance-type", "large-instance-type"))
+	})
+	It("should restrict the instance types to the namespace's default node selector", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		lister := fakeNamespaceNodeSelectorLister{restricted: {v1.LabelInstanceTypeStable: "small-instance-type"}}
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+		Expect(solve(lister, pod)).To(ConsistOf("small-instance-type"))
+		Expect(pod.Spec.NodeSelector).To(BeEmpty())
+		// pods in other namespaces aren't restricted
+		Expect(solve(lister, test.UnschedulablePod())).To(ConsistOf("small-instance-type", "large-instance-type"))
+	})
+	It("should combine the namespace's default node selector with the pod's", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		lister := fakeNamespaceNodeSelectorLister{restricted: {v1.LabelInstanceTypeStable: "small-instance-type"}}
+		pod := test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{Namespace: restricted},
+			NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureAmd64},
+		})
+		Expect(solve(lister, pod)).To(ConsistOf("small-instance-type"))
+		Expect(pod.Spec.NodeSelector).To(Equal(map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureAmd64}))
+	})
+	It("should prefer the pod's node selector for the same key", func() {
+		ExpectApplied(ctx, env.Client, provisioner)
+		lister := fakeNamespaceNodeSelectorLister{restricted: {v1.LabelInstanceTypeStable: "small-instance-type"}}
+		pod := test.UnschedulablePod(test.PodOptions{
+			ObjectMeta:   metav1.ObjectMeta{Namespace: restricted},
+			NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "large-instance-type"},
+		})
+		Expect(solve(lister, pod)).To(ConsistOf("large-instance-type"))
+	})
+	It("should not schedule pods to existing nodes that don't match the namespace's default node selector", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1alpha5.LabelNodeInitialized:    "true",
+				v1.LabelInstanceTypeStable:       "large-instance-type",
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+		})
+		ExpectApplied(ctx, env.Client, provisioner, node)
+		ExpectReconcileSucceeded(ctx, nodeStateController, client.ObjectKeyFromObject(node))
+
+		lister := fakeNamespaceNodeSelectorLister{restricted: {v1.LabelInstanceTypeStable: "small-instance-type"}}
+		pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+		stateNodes := StateNodes()
+		nodes, existingNodes := ExpectSolvedOnto(scheduling.SchedulerOptions{Pods: scheduling.PodOptions{NamespaceNodeSelectors: lister}}, stateNodes, pod)
+		Expect(existingNodes).To(HaveLen(1))
+		Expect(existingNodes[0].Pods).To(BeEmpty())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Pods).To(ConsistOf(pod))
+	})
+	Context("Namespace Annotation", func() {
+		It("should list the node selector from the namespace's annotation", func() {
+			ExpectApplied(ctx, env.Client, provisioner, test.Namespace(test.NamespaceOptions{ObjectMeta: metav1.ObjectMeta{
+				Name:        restricted,
+				Annotations: map[string]string{scheduling.NamespaceNodeSelectorAnnotationKey: v1.LabelInstanceTypeStable + "=small-instance-type"},
+			}}))
+			lister := scheduling.NamespaceAnnotationNodeSelectorLister{KubeClient: env.Client}
+			pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+			Expect(solve(lister, pod)).To(ConsistOf("small-instance-type"))
+		})
+		It("should list the cluster default for namespaces without the annotation", func() {
+			ExpectApplied(ctx, env.Client, provisioner, test.Namespace(test.NamespaceOptions{ObjectMeta: metav1.ObjectMeta{Name: restricted}}))
+			lister := scheduling.NamespaceAnnotationNodeSelectorLister{
+				KubeClient:     env.Client,
+				ClusterDefault: map[string]string{v1.LabelInstanceTypeStable: "large-instance-type"},
 			}
-			if constraint.TopologyKey == v1alpha5.LabelCapacityType {
-				if key, ok := node.Labels[constraint.TopologyKey]; ok {
-					skew[key]++
-				}
+			pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+			Expect(solve(lister, pod)).To(ConsistOf("large-instance-type"))
+		})
+		It("should not restrict pods in namespaces that can't be listed", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			lister := scheduling.NamespaceAnnotationNodeSelectorLister{
+				KubeClient:     env.Client,
+				ClusterDefault: map[string]string{v1.LabelInstanceTypeStable: "large-instance-type"},
 			}
-		}
-	}
+			pod := test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Namespace: restricted}})
+			Expect(solve(lister, pod)).To(ConsistOf("small-instance-type", "large-instance-type"))
+		})
+	})
+})
 
-	var minCount = math.MaxInt
-	var maxCount = math.MinInt
-	for _, count := range skew {
-		if count < minCount {
-			minCount = count
-		}
-		if count > maxCount {
-			maxCount = count
+var _ = Describe("Batch Queue", func() {
+	var clk *clock.FakeClock
+	BeforeEach(func() {
+		clk = clock.NewFakeClock(time.Now())
+	})
+	pendingPods := func(count int) []*v1.Pod {
+		var pods []*v1.Pod
+		for i := 0; i < count; i++ {
+			pod := test.UnschedulablePod()
+			pod.UID = uuid.NewUUID()
+			pods = append(pods, pod)
 		}
+		return pods
 	}
-	return Expect(maxCount - minCount)
-}
+	It("should be ready once the oldest pod has waited for the window", func() {
+		q := scheduling.NewBatchQueue(clk, 10*time.Second, 0)
+		Expect(q.Ready()).To(BeFalse())
+		pods := pendingPods(2)
+		q.Add(pods[0])
+		clk.Step(5 * time.Second)
+		q.Add(pods[1])
+		Expect(q.Ready()).To(BeFalse())
+		clk.Step(5 * time.Second)
+		Expect(q.Ready()).To(BeTrue())
+		Expect(q.Pop()).To(Equal(pods))
+		Expect(q.Len()).To(BeZero())
+		Expect(q.Ready()).To(BeFalse())
+	})
+	It("should be ready as soon as it reaches the maximum size", func() {
+		q := scheduling.NewBatchQueue(clk, 10*time.Second, 2)
+		pods := pendingPods(3)
+		q.Add(pods[0])
+		Expect(q.Ready()).To(BeFalse())
+		q.Add(pods[1], pods[2])
+		Expect(q.Ready()).To(BeTrue())
+		Expect(q.Pop()).To(Equal(pods[:2]))
+		// the remaining pod keeps its place rather than starting a new window
+		Expect(q.Len()).To(Equal(1))
+		Expect(q.Ready()).To(BeFalse())
+		clk.Step(10 * time.Second)
+		Expect(q.Ready()).To(BeTrue())
+		Expect(q.Pop()).To(Equal(pods[2:]))
+	})
+	It("should not queue a pod more than once", func() {
+		q := scheduling.NewBatchQueue(clk, 10*time.Second, 0)
+		pods := pendingPods(1)
+		q.Add(pods[0])
+		clk.Step(10 * time.Second)
+		q.Add(pods[0])
+		Expect(q.Len()).To(Equal(1))
+		Expect(q.Ready()).To(BeTrue())
+	})
+	Context("Streams", func() {
+		var stream chan *v1.Pod
+		var batches chan []*v1.Pod
+		BeforeEach(func() {
+			stream = make(chan *v1.Pod)
+			batches = make(chan []*v1.Pod, 1)
+		})
+		next := func(ctx context.Context, q *scheduling.BatchQueue) {
+			go func() {
+				defer GinkgoRecover()
+				batches <- q.Next(ctx, stream)
+			}()
+		}
+		It("should form a batch from the pods received within the window after the first", func() {
+			q := scheduling.NewBatchQueue(clk, 10*time.Second, 0)
+			next(ctx, q)
+			pods := pendingPods(3)
+			stream <- pods[0]
+			// the window starts with the first pod
+			Eventually(clk.HasWaiters).Should(BeTrue())
+			clk.Step(9 * time.Second)
+			stream <- pods[1]
+			stream <- pods[2]
+			Consistently(batches).ShouldNot(Receive())
+			clk.Step(time.Second)
+			Eventually(batches).Should(Receive(Equal(pods)))
+		})
+		It("should form a batch as soon as it reaches the maximum size", func() {
+			q := scheduling.NewBatchQueue(clk, 10*time.Second, 2)
+			next(ctx, q)
+			pods := pendingPods(2)
+			stream <- pods[0]
+			stream <- pods[1]
+			Eventually(batches).Should(Receive(Equal(pods)))
+		})
+		It("should return the queued pods once the stream is closed", func() {
+			q := scheduling.NewBatchQueue(clk, 10*time.Second, 0)
+			next(ctx, q)
+			pods := pendingPods(1)
+			stream <- pods[0]
+			close(stream)
+			Eventually(batches).Should(Receive(Equal(pods)))
+			next(ctx, q)
+			Eventually(batches).Should(Receive(BeNil()))
+		})
+		It("should keep the queued pods if the context is canceled", func() {
+			q := scheduling.NewBatchQueue(clk, 10*time.Second, 0)
+			canceled, cancel := context.WithCancel(ctx)
+			next(canceled, q)
+			pods := pendingPods(1)
+			stream <- pods[0]
+			cancel()
+			Eventually(batches).Should(Receive(BeNil()))
+			Expect(q.Len()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,154 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"math/rand"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	syntheticLabelKey         = "karpenter.sh/synthetic-group"
+	syntheticAffinityLabelKey = "karpenter.sh/synthetic-affinity-group"
+)
+
+// QuantityRange is an inclusive range that resource quantities are drawn uniformly from
+type QuantityRange struct {
+	Min resource.Quantity
+	Max resource.Quantity
+}
+
+// SyntheticPodSpec controls the distribution of the pods produced by SyntheticPods
+type SyntheticPodSpec struct {
+	// Count is the number of pods to generate
+	Count int
+	// CPU is the range of the pod CPU requests, defaults to 100m-1500m
+	CPU QuantityRange
+	// Memory is the range of the pod memory requests, defaults to 100Mi-4Gi
+	Memory QuantityRange
+	// TopologySpreadProbability is the probability that a pod has a topology spread constraint
+	TopologySpreadProbability float64
+	// PodAffinityProbability is the probability that a pod has a required pod affinity
+	PodAffinityProbability float64
+	// PodAntiAffinityProbability is the probability that a pod has a required pod anti-affinity
+	PodAntiAffinityProbability float64
+	// TopologyKeys are the keys that topology spread constraints and pod affinities are spread across, defaults to
+	// the zone and hostname
+	TopologyKeys []string
+	// Groups is the number of distinct label values the pods are divided into. Topology spread constraints and pod
+	// affinities select pods from a random group, defaults to 7.
+	Groups int
+	// Seed is the seed for the random source so that the same spec always generates the same pods
+	Seed int64
+}
+
+// SyntheticPods generates pending pods according to the spec. It's intended for benchmarking and load testing the
+// scheduler, e.g. by passing the pods to Solve.
+func SyntheticPods(spec SyntheticPodSpec) []*v1.Pod {
+	spec = spec.withDefaults()
+	//nolint:gosec
+	r := rand.New(rand.NewSource(spec.Seed))
+	pods := make([]*v1.Pod, 0, spec.Count)
+	for i := 0; i < spec.Count; i++ {
+		name := fmt.Sprintf("synthetic-%d-%d", spec.Seed, i)
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				UID:       types.UID(name),
+				Labels: map[string]string{
+					syntheticLabelKey:         randomGroup(r, spec.Groups),
+					syntheticAffinityLabelKey: randomGroup(r, spec.Groups),
+				},
+			},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{
+					Name:  "synthetic",
+					Image: "public.ecr.aws/eks-distro/kubernetes/pause:3.2",
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceCPU:    *resource.NewMilliQuantity(randomInt64(r, spec.CPU.Min.MilliValue(), spec.CPU.Max.MilliValue()), resource.DecimalSI),
+							v1.ResourceMemory: *resource.NewQuantity(randomInt64(r, spec.Memory.Min.Value(), spec.Memory.Max.Value()), resource.BinarySI),
+						},
+					},
+				}},
+			},
+			Status: v1.PodStatus{
+				Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Reason: v1.PodReasonUnschedulable, Status: v1.ConditionFalse}},
+			},
+		}
+		if r.Float64() < spec.TopologySpreadProbability {
+			pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       spec.TopologyKeys[r.Intn(len(spec.TopologyKeys))],
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{syntheticLabelKey: randomGroup(r, spec.Groups)}},
+			}}
+		}
+		if r.Float64() < spec.PodAffinityProbability {
+			if pod.Spec.Affinity == nil {
+				pod.Spec.Affinity = &v1.Affinity{}
+			}
+			pod.Spec.Affinity.PodAffinity = &v1.PodAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+				TopologyKey:   spec.TopologyKeys[r.Intn(len(spec.TopologyKeys))],
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{syntheticAffinityLabelKey: randomGroup(r, spec.Groups)}},
+			}}}
+		}
+		if r.Float64() < spec.PodAntiAffinityProbability {
+			if pod.Spec.Affinity == nil {
+				pod.Spec.Affinity = &v1.Affinity{}
+			}
+			pod.Spec.Affinity.PodAntiAffinity = &v1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+				TopologyKey:   spec.TopologyKeys[r.Intn(len(spec.TopologyKeys))],
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{syntheticAffinityLabelKey: randomGroup(r, spec.Groups)}},
+			}}}
+		}
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func (s SyntheticPodSpec) withDefaults() SyntheticPodSpec {
+	if s.CPU.Max.IsZero() {
+		s.CPU = QuantityRange{Min: resource.MustParse("100m"), Max: resource.MustParse("1500m")}
+	}
+	if s.Memory.Max.IsZero() {
+		s.Memory = QuantityRange{Min: resource.MustParse("100Mi"), Max: resource.MustParse("4Gi")}
+	}
+	if len(s.TopologyKeys) == 0 {
+		s.TopologyKeys = []string{v1.LabelTopologyZone, v1.LabelHostname}
+	}
+	if s.Groups <= 0 {
+		s.Groups = 7
+	}
+	return s
+}
+
+func randomGroup(r *rand.Rand, groups int) string {
+	return fmt.Sprintf("group-%d", r.Intn(groups))
+}
+
+// randomInt64 returns a uniformly distributed value in [min, max]
+func randomInt64(r *rand.Rand, min, max int64) int64 {
+	if max <= min {
+		return min
+	}
+	return min + r.Int63n(max-min+1)
+}
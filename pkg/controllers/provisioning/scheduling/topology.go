@@ -23,6 +23,7 @@ import (
 	"github.com/aws/karpenter-core/pkg/scheduling"
 
 	"go.uber.org/multierr"
+	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -30,6 +31,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	utilsets "k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter-core/pkg/utils/pod"
@@ -52,16 +54,30 @@ type Topology struct {
 	// moving pods to prevent them from being double counted.
 	excludedPods utilsets.String
 	cluster      *state.Cluster
+	// maxDomains is the maximum number of domains tracked per topology key, topologies that exceed it are treated as
+	// best-effort so that a key with unbounded cardinality can't exhaust memory. A value <= 0 is unlimited.
+	maxDomains int
+	// strictDomains fails the pods whose topologies exceed maxDomains rather than treating the topologies as best-effort
+	strictDomains bool
+	// ignoreDaemonSetAntiAffinity excludes daemonset pods from pod anti-affinity, both as the pods that an anti-affinity
+	// term selects and as pods with anti-affinity terms of their own
+	ignoreDaemonSetAntiAffinity bool
+	logger                      *zap.SugaredLogger
 }
 
-func NewTopology(ctx context.Context, kubeClient client.Client, cluster *state.Cluster, domains map[string]utilsets.String, pods []*v1.Pod) (*Topology, error) {
+func NewTopology(ctx context.Context, kubeClient client.Client, cluster *state.Cluster, domains map[string]utilsets.String, pods []*v1.Pod,
+	maxDomains int, strictDomains bool, ignoreDaemonSetAntiAffinity bool) (*Topology, error) {
 	t := &Topology{
-		kubeClient:        kubeClient,
-		cluster:           cluster,
-		domains:           domains,
-		topologies:        map[uint64]*TopologyGroup{},
-		inverseTopologies: map[uint64]*TopologyGroup{},
-		excludedPods:      utilsets.NewString(),
+		kubeClient:                  kubeClient,
+		cluster:                     cluster,
+		domains:                     domains,
+		topologies:                  map[uint64]*TopologyGroup{},
+		inverseTopologies:           map[uint64]*TopologyGroup{},
+		excludedPods:                utilsets.NewString(),
+		maxDomains:                  maxDomains,
+		strictDomains:               strictDomains,
+		ignoreDaemonSetAntiAffinity: ignoreDaemonSetAntiAffinity,
+		logger:                      logging.FromContext(ctx),
 	}
 
 	// these are the pods that we intend to schedule, so if they are currently in the cluster we shouldn't count them for
@@ -143,6 +159,30 @@ func (t *Topology) Record(p *v1.Pod, requirements scheduling.Requirements) {
 	}
 }
 
+// checkpoint returns a function that restores the domain counts of the topology groups to their current values
+func (t *Topology) checkpoint() func() {
+	type groupState struct {
+		domains  map[string]int32
+		exceeded bool
+	}
+	states := map[*TopologyGroup]groupState{}
+	for _, topologies := range []map[uint64]*TopologyGroup{t.topologies, t.inverseTopologies} {
+		for _, tg := range topologies {
+			domains := make(map[string]int32, len(tg.domains))
+			for domain, count := range tg.domains {
+				domains[domain] = count
+			}
+			states[tg] = groupState{domains: domains, exceeded: tg.exceeded}
+		}
+	}
+	return func() {
+		for tg, state := range states {
+			tg.domains = state.domains
+			tg.exceeded = state.exceeded
+		}
+	}
+}
+
 // AddRequirements tightens the input requirements by adding additional requirements that are being enforced by topology spreads
 // affinities, anti-affinities or inverse anti-affinities.  The nodeHostname is the hostname that we are currently considering
 // placing the pod on.  It returns these newly tightened requirements, or an error in the case of a set of requirements that
@@ -150,6 +190,17 @@ func (t *Topology) Record(p *v1.Pod, requirements scheduling.Requirements) {
 func (t *Topology) AddRequirements(podRequirements, nodeRequirements scheduling.Requirements, p *v1.Pod) (scheduling.Requirements, error) {
 	requirements := scheduling.NewRequirements(nodeRequirements.Values()...)
 	for _, topology := range t.getMatchingTopologies(p, nodeRequirements) {
+		if topology.Exceeded() {
+			if t.strictDomains {
+				return nil, fmt.Errorf("%w, %s has more than %d domains for key %s", ErrMaxTopologyDomainsExceeded, topology.Type, t.maxDomains, topology.Key)
+			}
+			if !topology.warned {
+				topology.warned = true
+				t.logger.With("pod", client.ObjectKeyFromObject(p), "topology-key", topology.Key, "max-domains", t.maxDomains).
+					Warnf("%s has more domains than can be tracked, treating it as best-effort", topology.Type)
+			}
+			continue
+		}
 		podDomains := scheduling.NewRequirement(topology.Key, v1.NodeSelectorOpExists)
 		if podRequirements.Has(topology.Key) {
 			podDomains = podRequirements.Get(topology.Key)
@@ -160,6 +211,9 @@ func (t *Topology) AddRequirements(podRequirements, nodeRequirements scheduling.
 		}
 		domains := topology.Get(p, podDomains, nodeDomains)
 		if domains.Len() == 0 {
+			if err := topology.skewError(p, podDomains, nodeDomains); err != nil {
+				return nil, err
+			}
 			return nil, fmt.Errorf("unsatisfiable topology constraint for %s, key=%s", topology.Type, topology.Key)
 		}
 		requirements.Add(domains)
@@ -185,12 +239,15 @@ func (t *Topology) Register(topologyKey string, domain string) {
 // have to look at every pod in the cluster as there is no way to query for a pod with anti-affinity terms.
 func (t *Topology) updateInverseAffinities(ctx context.Context) error {
 	var errs error
-	t.cluster.ForPodsWithAntiAffinity(func(pod *v1.Pod, node *v1.Node) bool {
+	t.cluster.ForPodsWithAntiAffinity(func(p *v1.Pod, node *v1.Node) bool {
 		// don't count the pod we are excluding
-		if t.excludedPods.Has(string(pod.UID)) {
+		if t.excludedPods.Has(string(p.UID)) {
+			return true
+		}
+		if t.ignoreDaemonSetAntiAffinity && pod.IsOwnedByDaemonSet(p) {
 			return true
 		}
-		if err := t.updateInverseAntiAffinity(ctx, pod, node.Labels); err != nil {
+		if err := t.updateInverseAntiAffinity(ctx, p, node.Labels); err != nil {
 			errs = multierr.Append(errs, fmt.Errorf("tracking existing pod anti-affinity, %w", err))
 		}
 		return true
@@ -211,7 +268,7 @@ func (t *Topology) updateInverseAntiAffinity(ctx context.Context, pod *v1.Pod, d
 			return err
 		}
 
-		tg := NewTopologyGroup(TopologyTypePodAntiAffinity, term.TopologyKey, pod, namespaces, term.LabelSelector, math.MaxInt32, t.domains[term.TopologyKey])
+		tg := NewTopologyGroup(TopologyTypePodAntiAffinity, term.TopologyKey, pod, namespaces, term.LabelSelector, math.MaxInt32, t.domains[term.TopologyKey], t.maxDomains)
 
 		hash := tg.Hash()
 		if existing, ok := t.inverseTopologies[hash]; !ok {
@@ -250,6 +307,10 @@ func (t *Topology) countDomains(ctx context.Context, tg *TopologyGroup) error {
 		if t.excludedPods.Has(string(p.UID)) {
 			continue
 		}
+		// daemonset pods run on every node, so anti-affinity to them would prevent scheduling anywhere
+		if t.ignoreDaemonSetAntiAffinity && tg.Type == TopologyTypePodAntiAffinity && pod.IsOwnedByDaemonSet(&pods[i]) {
+			continue
+		}
 		node := &v1.Node{}
 		if err := t.kubeClient.Get(ctx, types.NamespacedName{Name: p.Spec.NodeName}, node); err != nil {
 			return fmt.Errorf("getting node %s, %w", p.Spec.NodeName, err)
@@ -279,11 +340,40 @@ func (t *Topology) countDomains(ctx context.Context, tg *TopologyGroup) error {
 func (t *Topology) newForTopologies(p *v1.Pod) []*TopologyGroup {
 	var topologyGroups []*TopologyGroup
 	for _, cs := range p.Spec.TopologySpreadConstraints {
-		topologyGroups = append(topologyGroups, NewTopologyGroup(TopologyTypeSpread, cs.TopologyKey, p, utilsets.NewString(p.Namespace), cs.LabelSelector, cs.MaxSkew, t.domains[cs.TopologyKey]))
+		topologyGroups = append(topologyGroups, NewTopologyGroup(TopologyTypeSpread, cs.TopologyKey, p, utilsets.NewString(p.Namespace), spreadLabelSelector(p, cs), cs.MaxSkew, t.domains[cs.TopologyKey], t.maxDomains))
+	}
+	// The pods in the same namespace with the pod's labels, e.g. the replicas of a replica set, must be spread across
+	// a minimum number of zones
+	if minZones := pod.MinZones(p); minZones > 0 {
+		tg := NewTopologyGroup(TopologyTypeMinDomains, v1.LabelTopologyZone, p, utilsets.NewString(p.Namespace), &metav1.LabelSelector{MatchLabels: p.Labels},
+			math.MaxInt32, t.domains[v1.LabelTopologyZone], t.maxDomains)
+		tg.minDomains = minZones
+		topologyGroups = append(topologyGroups, tg)
 	}
 	return topologyGroups
 }
 
+// spreadLabelSelector returns the label selector used to count pods for the topology spread constraint. The pod's
+// values for any of the constraint's matchLabelKeys (e.g. pod-template-hash) are added to the selector so that skew
+// is computed separately for each revision of a rollout. As with the kube-scheduler, matchLabelKeys are ignored if
+// the constraint has no label selector, and keys that the pod isn't labeled with are skipped.
+func spreadLabelSelector(p *v1.Pod, cs v1.TopologySpreadConstraint) *metav1.LabelSelector {
+	if cs.LabelSelector == nil || len(cs.MatchLabelKeys) == 0 {
+		return cs.LabelSelector
+	}
+	selector := cs.LabelSelector.DeepCopy()
+	for _, key := range cs.MatchLabelKeys {
+		if value, ok := p.Labels[key]; ok {
+			selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      key,
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{value},
+			})
+		}
+	}
+	return selector
+}
+
 // newForAffinities returns a list of topology groups that have been constructed based on the input pod and required/preferred affinity terms
 func (t *Topology) newForAffinities(ctx context.Context, p *v1.Pod) ([]*TopologyGroup, error) {
 	var topologyGroups []*TopologyGroup
@@ -316,7 +406,7 @@ func (t *Topology) newForAffinities(ctx context.Context, p *v1.Pod) ([]*Topology
 			if err != nil {
 				return nil, err
 			}
-			topologyGroups = append(topologyGroups, NewTopologyGroup(topologyType, term.TopologyKey, p, namespaces, term.LabelSelector, math.MaxInt32, t.domains[term.TopologyKey]))
+			topologyGroups = append(topologyGroups, NewTopologyGroup(topologyType, term.TopologyKey, p, namespaces, term.LabelSelector, math.MaxInt32, t.domains[term.TopologyKey], t.maxDomains))
 		}
 	}
 	return topologyGroups, nil
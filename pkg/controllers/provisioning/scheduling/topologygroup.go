@@ -17,6 +17,7 @@ package scheduling
 import (
 	"fmt"
 	"math"
+	"sort"
 
 	"github.com/mitchellh/hashstructure/v2"
 	v1 "k8s.io/api/core/v1"
@@ -35,6 +36,7 @@ const (
 	TopologyTypeSpread TopologyType = iota
 	TopologyTypePodAffinity
 	TopologyTypePodAntiAffinity
+	TopologyTypeMinDomains
 )
 
 func (t TopologyType) String() string {
@@ -45,6 +47,8 @@ func (t TopologyType) String() string {
 		return "pod affinity"
 	case TopologyTypePodAntiAffinity:
 		return "pod anti-affinity"
+	case TopologyTypeMinDomains:
+		return "minimum domains"
 	}
 	return ""
 }
@@ -55,34 +59,38 @@ type TopologyGroup struct {
 	Key        string
 	Type       TopologyType
 	maxSkew    int32
+	minDomains int32
 	namespaces utilsets.String
 	selector   *metav1.LabelSelector
 	nodeFilter TopologyNodeFilter
 	// Index
 	owners  map[types.UID]struct{} // Pods that have this topology as a scheduling rule
 	domains map[string]int32       // TODO(ellistarn) explore replacing with a minheap
+	// maxDomains caps the number of domains that are tracked, if it's exceeded the topology is no longer enforced
+	maxDomains int
+	exceeded   bool
+	warned     bool
 }
 
-func NewTopologyGroup(topologyType TopologyType, topologyKey string, pod *v1.Pod, namespaces utilsets.String, labelSelector *metav1.LabelSelector, maxSkew int32, domains utilsets.String) *TopologyGroup {
-	domainCounts := map[string]int32{}
-	for domain := range domains {
-		domainCounts[domain] = 0
-	}
+func NewTopologyGroup(topologyType TopologyType, topologyKey string, pod *v1.Pod, namespaces utilsets.String, labelSelector *metav1.LabelSelector, maxSkew int32, domains utilsets.String, maxDomains int) *TopologyGroup {
 	// the nil *TopologyNodeFilter always passes which is what we need for affinity/anti-affinity
 	var nodeSelector TopologyNodeFilter
 	if topologyType == TopologyTypeSpread {
 		nodeSelector = MakeTopologyNodeFilter(pod)
 	}
-	return &TopologyGroup{
+	tg := &TopologyGroup{
 		Type:       topologyType,
 		Key:        topologyKey,
 		namespaces: namespaces,
 		selector:   labelSelector,
 		nodeFilter: nodeSelector,
 		maxSkew:    maxSkew,
-		domains:    domainCounts,
+		domains:    map[string]int32{},
 		owners:     map[types.UID]struct{}{},
+		maxDomains: maxDomains,
 	}
+	tg.Register(domains.UnsortedList()...)
+	return tg
 }
 
 func (t *TopologyGroup) Get(pod *v1.Pod, podDomains, nodeDomains *scheduling.Requirement) *scheduling.Requirement {
@@ -93,6 +101,8 @@ func (t *TopologyGroup) Get(pod *v1.Pod, podDomains, nodeDomains *scheduling.Req
 		return t.nextDomainAffinity(pod, podDomains, nodeDomains)
 	case TopologyTypePodAntiAffinity:
 		return t.nextDomainAntiAffinity(podDomains)
+	case TopologyTypeMinDomains:
+		return t.nextDomainMinDomains(podDomains, nodeDomains)
 	default:
 		panic(fmt.Sprintf("Unrecognized topology group type: %s", t.Type))
 	}
@@ -100,7 +110,9 @@ func (t *TopologyGroup) Get(pod *v1.Pod, podDomains, nodeDomains *scheduling.Req
 
 func (t *TopologyGroup) Record(domains ...string) {
 	for _, domain := range domains {
-		t.domains[domain]++
+		if t.addDomain(domain) {
+			t.domains[domain]++
+		}
 	}
 }
 
@@ -113,10 +125,27 @@ func (t *TopologyGroup) Counts(pod *v1.Pod, requirements scheduling.Requirements
 // Register ensures that the topology is aware of the given domain names.
 func (t *TopologyGroup) Register(domains ...string) {
 	for _, domain := range domains {
-		if _, ok := t.domains[domain]; !ok {
-			t.domains[domain] = 0
-		}
+		t.addDomain(domain)
+	}
+}
+
+// Exceeded returns true if more domains than the maximum were registered. The domain counts are incomplete at that
+// point, so the topology can only be treated as best-effort.
+func (t *TopologyGroup) Exceeded() bool {
+	return t.exceeded
+}
+
+// addDomain starts tracking the domain if it isn't already tracked and returns true if the domain is tracked
+func (t *TopologyGroup) addDomain(domain string) bool {
+	if _, ok := t.domains[domain]; ok {
+		return true
 	}
+	if t.maxDomains > 0 && len(t.domains) >= t.maxDomains {
+		t.exceeded = true
+		return false
+	}
+	t.domains[domain] = 0
+	return true
 }
 
 func (t *TopologyGroup) AddOwner(key types.UID) {
@@ -141,6 +170,7 @@ func (t *TopologyGroup) Hash() uint64 {
 		Namespaces    utilsets.String
 		LabelSelector *metav1.LabelSelector
 		MaxSkew       int32
+		MinDomains    int32
 		NodeFilter    TopologyNodeFilter
 	}{
 		TopologyKey:   t.Key,
@@ -148,6 +178,7 @@ func (t *TopologyGroup) Hash() uint64 {
 		Namespaces:    t.namespaces,
 		LabelSelector: t.selector,
 		MaxSkew:       t.maxSkew,
+		MinDomains:    t.minDomains,
 		NodeFilter:    t.nodeFilter,
 	}, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
 	runtime.Must(err)
@@ -183,6 +214,46 @@ func (t *TopologyGroup) nextDomainTopologySpread(pod *v1.Pod, podDomains, nodeDo
 	return scheduling.NewRequirement(podDomains.Key, v1.NodeSelectorOpIn, minDomain)
 }
 
+// TopologySkewError is returned when a pod can't be scheduled to any of a node's domains without exceeding the max skew
+// of a topology spread constraint. It reports the node domain that comes closest to satisfying the constraint.
+type TopologySkewError struct {
+	Key     string
+	Domain  string
+	Skew    int32
+	MaxSkew int32
+}
+
+func (e *TopologySkewError) Error() string {
+	return fmt.Sprintf("unsatisfiable topology constraint for %s, key=%s, scheduling to %s would result in a skew of %d which exceeds the max skew of %d",
+		TopologyTypeSpread, e.Key, e.Domain, e.Skew, e.MaxSkew)
+}
+
+// skewError explains why nextDomainTopologySpread found no domain for the pod, returning nil if it wasn't due to the
+// max skew, e.g. because none of the node domains are known
+func (t *TopologyGroup) skewError(pod *v1.Pod, podDomains, nodeDomains *scheduling.Requirement) error {
+	if t.Type != TopologyTypeSpread {
+		return nil
+	}
+	min := t.domainMinCount(podDomains)
+	var skewErr *TopologySkewError
+	for domain, count := range t.domains {
+		if !nodeDomains.Has(domain) {
+			continue
+		}
+		if t.selects(pod) {
+			count++
+		}
+		skew := count - min
+		if skewErr == nil || skew < skewErr.Skew || (skew == skewErr.Skew && domain < skewErr.Domain) {
+			skewErr = &TopologySkewError{Key: t.Key, Domain: domain, Skew: skew, MaxSkew: t.maxSkew}
+		}
+	}
+	if skewErr == nil || skewErr.Skew <= t.maxSkew {
+		return nil
+	}
+	return skewErr
+}
+
 func (t *TopologyGroup) domainMinCount(domains *scheduling.Requirement) int32 {
 	// hostname based topologies always have a min pod count of zero since we can create one
 	if t.Key == v1.LabelHostname {
@@ -244,6 +315,31 @@ func (t *TopologyGroup) nextDomainAntiAffinity(domains *scheduling.Requirement)
 	return options
 }
 
+// nextDomainMinDomains restricts the pod to a domain that none of the selected pods are in until they're spread across
+// the minimum number of domains, preferring a domain that the node is already in. If there's no such domain that the
+// pod can schedule to, the minimum can't be met and the pod isn't restricted.
+func (t *TopologyGroup) nextDomainMinDomains(podDomains, nodeDomains *scheduling.Requirement) *scheduling.Requirement {
+	used := int32(0)
+	var unused []string
+	for domain, count := range t.domains {
+		if count > 0 {
+			used++
+		} else if podDomains.Has(domain) {
+			unused = append(unused, domain)
+		}
+	}
+	if used >= t.minDomains || len(unused) == 0 {
+		return scheduling.NewRequirement(podDomains.Key, v1.NodeSelectorOpExists)
+	}
+	sort.Strings(unused)
+	for _, domain := range unused {
+		if nodeDomains.Has(domain) {
+			return scheduling.NewRequirement(podDomains.Key, v1.NodeSelectorOpIn, domain)
+		}
+	}
+	return scheduling.NewRequirement(podDomains.Key, v1.NodeSelectorOpIn, unused[0])
+}
+
 // selects returns true if the given pod is selected by this topology
 func (t *TopologyGroup) selects(pod *v1.Pod) bool {
 	selector, err := metav1.LabelSelectorAsSelector(t.selector)
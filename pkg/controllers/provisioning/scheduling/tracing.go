@@ -0,0 +1,47 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/aws/karpenter-core/pkg/controllers/provisioning/scheduling"
+
+// Span attribute keys
+const (
+	podsAttribute        = "karpenter.pods"
+	newNodesAttribute    = "karpenter.new_nodes"
+	failedPodsAttribute  = "karpenter.failed_pods"
+	podAttribute         = "karpenter.pod"
+	provisionerAttribute = "karpenter.provisioner"
+)
+
+// tracer returns the tracer of the globally configured tracer provider, which is a no-op unless one is configured. It's
+// looked up for each solve rather than once so that a tracer provider configured later is picked up.
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// endSpan records the error, if any, on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
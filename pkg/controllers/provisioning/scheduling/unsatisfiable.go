@@ -0,0 +1,201 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// maxUnsatisfiableRequirements bounds the size of the combinations of pod requirements that are searched when
+// diagnosing why no provisioner can satisfy a pod. The number of combinations grows exponentially with it.
+const maxUnsatisfiableRequirements = 3
+
+// unsatisfiableRequirementsError returns an error naming the smallest combination of the pod's requirements that no
+// provisioner can provide, or nil if the pod's requirements aren't the reason it can't schedule.
+func (s *Scheduler) unsatisfiableRequirementsError(pod *v1.Pod) error {
+	unsatisfiable := s.unsatisfiableRequirements(s.namespaceSelectors.podRequirements(pod))
+	if len(unsatisfiable) == 0 {
+		return nil
+	}
+	// an operating system that no instance type supports is called out, as it's a property of the pod's images that
+	// can't be relaxed
+	if len(unsatisfiable) == 1 && unsatisfiable[0].Key == v1.LabelOSStable && unsatisfiable[0].Operator() == v1.NodeSelectorOpIn {
+		return fmt.Errorf("no provisioner provides operating system %s", strings.Join(sets.NewString(unsatisfiable[0].Values()...).List(), " or "))
+	}
+	return fmt.Errorf("no provisioner provides %s", strings.Join(lo.Map(unsatisfiable, func(r *scheduling.Requirement, _ int) string {
+		return r.String()
+	}), " AND "))
+}
+
+// unsatisfiableRequirements searches combinations of the requirements in increasing size for one that none of the
+// provisioners can provide. Each provisioner may satisfy some of a pod's requirements, so reporting the combination
+// that is unsatisfiable across all of them tells the user exactly which requirements need to change. Results are
+// memoized as the pods in a batch that fail to schedule commonly share their requirements.
+func (s *Scheduler) unsatisfiableRequirements(requirements scheduling.Requirements) []*scheduling.Requirement {
+	values := requirements.Values()
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	key := strings.Join(lo.Map(values, func(r *scheduling.Requirement, _ int) string { return r.String() }), ";")
+	if unsatisfiable, ok := s.unsatisfiable[key]; ok {
+		return unsatisfiable
+	}
+
+	var unsatisfiable []*scheduling.Requirement
+	for size := 1; size <= lo.Min([]int{len(values), maxUnsatisfiableRequirements}) && unsatisfiable == nil; size++ {
+		forEachCombination(len(values), size, func(indices []int) bool {
+			candidate := lo.Map(indices, func(i int, _ int) *scheduling.Requirement { return values[i] })
+			if lo.ContainsBy(s.machineTemplates, func(nodeTemplate *MachineTemplate) bool {
+				return s.provides(nodeTemplate, scheduling.NewRequirements(candidate...))
+			}) {
+				return true
+			}
+			unsatisfiable = candidate
+			return false
+		})
+	}
+	s.unsatisfiable[key] = unsatisfiable
+	return unsatisfiable
+}
+
+// provides returns true if a node launched from the template could satisfy the requirements
+func (s *Scheduler) provides(nodeTemplate *MachineTemplate, requirements scheduling.Requirements) bool {
+	if err := nodeTemplate.Requirements.Compatible(requirements); err != nil {
+		return false
+	}
+	combined := scheduling.NewRequirements(nodeTemplate.Requirements.Values()...)
+	combined.Add(requirements.Values()...)
+	return lo.ContainsBy(s.instanceTypes[nodeTemplate.ProvisionerName], func(it *cloudprovider.InstanceType) bool {
+		return compatible(it, combined) && hasOffering(it, combined, s.templateExcludedZones[nodeTemplate.ProvisionerName])
+	})
+}
+
+// unknownResourcesError returns an error naming the resources that the pod requests but that no instance type of any
+// provisioner advertises, which is commonly a typo in the resource name, or nil if every requested resource is known.
+// Hugepages are reported on their own, as they're only advertised by instance types that preallocate them.
+func (s *Scheduler) unknownResourcesError(pod *v1.Pod) error {
+	if s.advertisedResources == nil {
+		s.advertisedResources = sets.NewString()
+		for _, nodeTemplate := range s.machineTemplates {
+			for _, it := range s.instanceTypes[nodeTemplate.ProvisionerName] {
+				for resourceName, quantity := range it.Capacity {
+					if !quantity.IsZero() {
+						s.advertisedResources.Insert(string(resourceName))
+					}
+				}
+			}
+		}
+	}
+	unknown := sets.NewString()
+	for resourceName, quantity := range resources.Merge(resources.RequestsForPods(pod), s.packing.devices[pod]) {
+		if !quantity.IsZero() && !s.advertisedResources.Has(string(resourceName)) {
+			unknown.Insert(string(resourceName))
+		}
+	}
+	hugePages := lo.Filter(unknown.List(), func(resourceName string, _ int) bool {
+		return strings.HasPrefix(resourceName, v1.ResourceHugePagesPrefix)
+	})
+	if len(hugePages) > 0 {
+		return fmt.Errorf("no instance type in any provisioner advertises %s; hugepages must be preallocated by the instance types that provide them",
+			strings.Join(hugePages, ", "))
+	}
+	switch unknown.Len() {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("no instance type in any provisioner advertises resource %s; is it a typo?", unknown.List()[0])
+	default:
+		return fmt.Errorf("no instance type in any provisioner advertises resources %s; are they typos?", strings.Join(unknown.List(), ", "))
+	}
+}
+
+// mutualConflictError returns an error naming the other pods that failed to schedule in the same batch whose required
+// pod affinities select the pod while its own required pod affinities select them. Neither pod can be placed until the
+// other one is, so the batch stops making progress for both.
+func mutualConflictError(pod *v1.Pod, failedToSchedule []*v1.Pod) error {
+	conflicts := lo.Filter(failedToSchedule, func(other *v1.Pod, _ int) bool {
+		return other != pod && requiredAffinitySelects(pod, other) && requiredAffinitySelects(other, pod)
+	})
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("mutually conflicting required pod affinities with pod(s) %s in the same batch", strings.Join(lo.Map(conflicts, func(other *v1.Pod, _ int) string {
+		return client.ObjectKeyFromObject(other).String()
+	}), ", "))
+}
+
+// requiredAffinitySelects returns true if any of the pod's required pod affinity or anti-affinity terms select the
+// other pod. Terms with a non-empty namespace selector aren't evaluated, as that requires listing the namespaces.
+func requiredAffinitySelects(pod, other *v1.Pod) bool {
+	if pod.Spec.Affinity == nil {
+		return false
+	}
+	var terms []v1.PodAffinityTerm
+	if pod.Spec.Affinity.PodAffinity != nil {
+		terms = append(terms, pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if pod.Spec.Affinity.PodAntiAffinity != nil {
+		terms = append(terms, pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+	}
+	return lo.ContainsBy(terms, func(term v1.PodAffinityTerm) bool {
+		switch {
+		case term.NamespaceSelector != nil:
+			if len(term.NamespaceSelector.MatchLabels) != 0 || len(term.NamespaceSelector.MatchExpressions) != 0 {
+				return false
+			}
+		case len(term.Namespaces) != 0:
+			if !lo.Contains(term.Namespaces, other.Namespace) {
+				return false
+			}
+		default:
+			if pod.Namespace != other.Namespace {
+				return false
+			}
+		}
+		selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+		return err == nil && selector.Matches(labels.Set(other.Labels))
+	})
+}
+
+// forEachCombination calls f with the indices of each combination of size k from n elements in lexicographic order
+// until f returns false
+func forEachCombination(n, k int, f func(indices []int) bool) {
+	indices := make([]int, k)
+	var visit func(start, depth int) bool
+	visit = func(start, depth int) bool {
+		if depth == k {
+			return f(indices)
+		}
+		for i := start; i <= n-(k-depth); i++ {
+			indices[depth] = i
+			if !visit(i+1, depth+1) {
+				return false
+			}
+		}
+		return true
+	}
+	visit(0, 0)
+}
@@ -0,0 +1,75 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+	"github.com/aws/karpenter-core/pkg/controllers/state"
+	"github.com/aws/karpenter-core/pkg/scheduling"
+)
+
+// zoneCounts returns the number of nodes of each provisioner in each zone. Nodes that are marked for deletion are
+// expected to leave their zone, so they aren't counted.
+func zoneCounts(cluster *state.Cluster) map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	cluster.ForEachNode(func(n *state.Node) bool {
+		provisionerName, zone := n.Node.Labels[v1alpha5.ProvisionerNameLabelKey], n.Node.Labels[v1.LabelTopologyZone]
+		if provisionerName == "" || zone == "" || n.MarkedForDeletion {
+			return true
+		}
+		if counts[provisionerName] == nil {
+			counts[provisionerName] = map[string]int{}
+		}
+		counts[provisionerName][zone]++
+		return true
+	})
+	return counts
+}
+
+// balanceZone narrows the node to the zone with the fewest nodes of its provisioner among the zones that the node can
+// launch into, so that a provisioner's nodes are balanced across zones over time. It's a preference and not a
+// constraint, a node is only narrowed to a zone that one of its instance types has an available offering in, and a node
+// that can only launch into a single zone is counted but unchanged. Ties are broken by the zone name. The counts are
+// updated with the node's zone, so that the nodes of a batch are balanced between each other as well.
+func balanceZone(node *Node, counts map[string]map[string]int) {
+	zones := sets.NewString()
+	for _, it := range node.InstanceTypeOptions {
+		for _, offering := range it.Offerings.Available().Requirements(node.Requirements) {
+			if !node.excludedZones.Has(offering.Zone) {
+				zones.Insert(offering.Zone)
+			}
+		}
+	}
+	if zones.Len() == 0 {
+		return
+	}
+	if counts[node.ProvisionerName] == nil {
+		counts[node.ProvisionerName] = map[string]int{}
+	}
+	zone := lo.MinBy(zones.List(), func(a, b string) bool { return counts[node.ProvisionerName][a] < counts[node.ProvisionerName][b] })
+	counts[node.ProvisionerName][zone]++
+	if zones.Len() == 1 {
+		return
+	}
+	node.Requirements.Add(scheduling.NewRequirement(v1.LabelTopologyZone, v1.NodeSelectorOpIn, zone))
+	node.InstanceTypeOptions = lo.Filter(node.InstanceTypeOptions, func(it *cloudprovider.InstanceType, _ int) bool {
+		return hasOffering(it, node.Requirements, node.excludedZones)
+	})
+}
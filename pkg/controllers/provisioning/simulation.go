@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	scheduler "github.com/aws/karpenter-core/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter-core/pkg/controllers/state"
+	"github.com/aws/karpenter-core/pkg/utils/node"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
+)
+
+// NodeRemovalSimulation is the result of simulating the removal of a node and rescheduling its pods against the rest
+// of the cluster
+type NodeRemovalSimulation struct {
+	// Pods are the pods on the removed node that need to be rescheduled
+	Pods []*v1.Pod
+	// FreedCapacity is the allocatable capacity of the removed node
+	FreedCapacity v1.ResourceList
+	// NewNodes are the nodes that would need to be launched to schedule the pods
+	NewNodes []*scheduler.Node
+	// ExistingNodes are the remaining nodes in the cluster along with the pods that would schedule to them
+	ExistingNodes []*scheduler.ExistingNode
+	// AllPodsScheduled is true if every pod from the removed node could be scheduled
+	AllPodsScheduled bool
+}
+
+// RepackingSimulation is the result of simulating the removal of a set of nodes and rescheduling all of their pods
+// together against the rest of the cluster
+type RepackingSimulation struct {
+	NodeRemovalSimulation
+	// NodeDelta is the net change in the number of nodes in the cluster, the number of new nodes less the number of
+	// removed nodes. It's negative if the pods can be packed onto fewer nodes.
+	NodeDelta int
+}
+
+// PodPlacement is where a displaced pod would reschedule, exactly one of NewNode and ExistingNode is set
+type PodPlacement struct {
+	// NewNode is the node that would need to be launched for the pod
+	NewNode *scheduler.Node
+	// ExistingNode is the remaining node in the cluster that the pod would schedule to
+	ExistingNode *scheduler.ExistingNode
+}
+
+// DisplacedPodsSimulation is the result of simulating the removal of a node along with where each of its pods would
+// reschedule
+type DisplacedPodsSimulation struct {
+	NodeRemovalSimulation
+	// Placements maps the pods on the removed node that could be rescheduled to where they would schedule
+	Placements map[*v1.Pod]PodPlacement
+	// Unschedulable are the pods on the removed node that couldn't be rescheduled
+	Unschedulable []*v1.Pod
+}
+
+// SimulateNodeRemoval determines where the pods on the node would schedule if the node were removed from the cluster.
+// Nodes that are marked for deletion aren't considered as scheduling targets. No events are recorded and no capacity
+// is launched.
+func (p *Provisioner) SimulateNodeRemoval(ctx context.Context, nodeName string) (NodeRemovalSimulation, error) {
+	return p.simulateNodesRemoval(ctx, nodeName)
+}
+
+// SimulateRepacking determines where the pods on the nodes would schedule if all of the nodes were removed from the
+// cluster at once. The pods are solved together, so they can be packed onto the remaining nodes and any new nodes
+// more densely than they could be by removing the nodes one at a time. No events are recorded and no capacity is
+// launched.
+func (p *Provisioner) SimulateRepacking(ctx context.Context, nodeNames ...string) (RepackingSimulation, error) {
+	simulation, err := p.simulateNodesRemoval(ctx, nodeNames...)
+	if err != nil {
+		return RepackingSimulation{}, err
+	}
+	return RepackingSimulation{
+		NodeRemovalSimulation: simulation,
+		NodeDelta:             len(simulation.NewNodes) - len(sets.NewString(nodeNames...)),
+	}, nil
+}
+
+// SimulateDisplacedPods determines where each of the pods on the node would schedule if the node were removed from the
+// cluster, along with the pods that couldn't be scheduled anywhere. No events are recorded and no capacity is launched.
+func (p *Provisioner) SimulateDisplacedPods(ctx context.Context, nodeName string) (DisplacedPodsSimulation, error) {
+	simulation, err := p.simulateNodesRemoval(ctx, nodeName)
+	if err != nil {
+		return DisplacedPodsSimulation{}, err
+	}
+	displaced := DisplacedPodsSimulation{NodeRemovalSimulation: simulation, Placements: map[*v1.Pod]PodPlacement{}}
+	for _, n := range simulation.NewNodes {
+		for _, pod := range n.Pods {
+			displaced.Placements[pod] = PodPlacement{NewNode: n}
+		}
+	}
+	for _, n := range simulation.ExistingNodes {
+		for _, pod := range n.Pods {
+			displaced.Placements[pod] = PodPlacement{ExistingNode: n}
+		}
+	}
+	for _, pod := range simulation.Pods {
+		if _, ok := displaced.Placements[pod]; !ok {
+			displaced.Unschedulable = append(displaced.Unschedulable, pod)
+		}
+	}
+	return displaced, nil
+}
+
+func (p *Provisioner) simulateNodesRemoval(ctx context.Context, nodeNames ...string) (NodeRemovalSimulation, error) {
+	removed := sets.NewString(nodeNames...)
+	var targets []*state.Node
+	var stateNodes []*state.Node
+	p.cluster.ForEachNode(func(n *state.Node) bool {
+		if removed.Has(n.Node.Name) {
+			targets = append(targets, n.DeepCopy())
+		} else if !n.MarkedForDeletion {
+			stateNodes = append(stateNodes, n.DeepCopy())
+		}
+		return true
+	})
+	if len(targets) != removed.Len() {
+		return NodeRemovalSimulation{}, fmt.Errorf("nodes %s are not tracked in cluster state", removed.Difference(sets.NewString(lo.Map(targets, func(n *state.Node, _ int) string {
+			return n.Node.Name
+		})...)).List())
+	}
+
+	simulation := NodeRemovalSimulation{FreedCapacity: v1.ResourceList{}}
+	for _, target := range targets {
+		pods, err := node.GetNodePods(ctx, p.kubeClient, target.Node)
+		if err != nil {
+			return NodeRemovalSimulation{}, fmt.Errorf("getting pods from node %s, %w", target.Node.Name, err)
+		}
+		simulation.Pods = append(simulation.Pods, pods...)
+		simulation.FreedCapacity = resources.Merge(simulation.FreedCapacity, target.Allocatable)
+	}
+	if len(simulation.Pods) == 0 {
+		simulation.AllPodsScheduled = true
+		return simulation, nil
+	}
+
+	s, err := p.NewScheduler(ctx, simulation.Pods, stateNodes, scheduler.SchedulerOptions{SimulationMode: true})
+	if err != nil {
+		return NodeRemovalSimulation{}, fmt.Errorf("creating scheduler, %w", err)
+	}
+	simulation.NewNodes, simulation.ExistingNodes, err = s.Solve(ctx, simulation.Pods)
+	if err != nil {
+		return NodeRemovalSimulation{}, fmt.Errorf("simulating scheduling, %w", err)
+	}
+
+	scheduled := 0
+	for _, n := range simulation.NewNodes {
+		scheduled += len(n.Pods)
+	}
+	for _, n := range simulation.ExistingNodes {
+		scheduled += len(n.Pods)
+	}
+	simulation.AllPodsScheduled = scheduled == len(simulation.Pods)
+	return simulation, nil
+}
@@ -17,6 +17,7 @@ package provisioning_test
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,6 +40,7 @@ import (
 	"github.com/aws/karpenter-core/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter-core/pkg/controllers/provisioning"
 	"github.com/aws/karpenter-core/pkg/controllers/state"
+	"github.com/aws/karpenter-core/pkg/events"
 	"github.com/aws/karpenter-core/pkg/operator/controller"
 	"github.com/aws/karpenter-core/pkg/operator/scheme"
 	"github.com/aws/karpenter-core/pkg/test"
@@ -232,6 +234,30 @@ var _ = Describe("Provisioning", func() {
 			Expect(n.Name).ToNot(Equal(node.Name))
 		})
 	})
+	Context("Preemption", func() {
+		nominatedPod := func(policy *v1.PreemptionPolicy) *v1.Pod {
+			pod := test.UnschedulablePod()
+			pod.Spec.PreemptionPolicy = policy
+			pod.Status.NominatedNodeName = "preempting-node"
+			return pod
+		}
+		It("should not provision nodes for pods that are preempting", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner())
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, nominatedPod(nil))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(cloudProvider.CreateCalls).To(BeEmpty())
+		})
+		It("should provision nodes for nominated pods that never preempt", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner())
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, nominatedPod(lo.ToPtr(v1.PreemptNever)))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+		})
+		It("should not provision nodes for nominated pods that preempt lower priority pods", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner())
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, nominatedPod(lo.ToPtr(v1.PreemptLowerPriority)))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+		})
+	})
 	Context("Resource Limits", func() {
 		It("should not schedule when limits are exceeded", func() {
 			ExpectApplied(ctx, env.Client, test.Provisioner(test.ProvisionerOptions{
@@ -330,6 +356,84 @@ var _ = Describe("Provisioning", func() {
 			ExpectNotScheduled(ctx, env.Client, pod)
 		})
 	})
+	Context("Capacity Reservations", func() {
+		It("should count capacity that is still being launched against the limits across solves", func() {
+			provisioner := test.Provisioner(test.ProvisionerOptions{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+			})
+			ExpectApplied(ctx, env.Client, provisioner)
+			opts := test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1.75")}}}
+
+			// a node from a previous solve is still launching, so there's no room for another 2 CPU node
+			reservation := cluster.Reserve(provisioner.Name, v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+
+			// once the node is tracked, its reservation is released and the next solve can launch
+			cluster.Release(reservation)
+			pod = ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(opts))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+		})
+		It("should not count capacity reserved by other provisioners", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner(test.ProvisionerOptions{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+			}))
+			cluster.Reserve("other", v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(
+				test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1.75")}}}))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+		})
+		It("should release the reservation once the node is launched", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner())
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			Expect(cluster.Reserved()).To(BeEmpty())
+		})
+		It("should release the reservation if the launch fails", func() {
+			ExpectApplied(ctx, env.Client, test.Provisioner())
+			cloudProvider.AllowedCreateCalls = 0
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(cluster.Reserved()).To(BeEmpty())
+		})
+	})
+	Context("Provisioner Events", func() {
+		provisionerEvents := func(provisioner *v1alpha5.Provisioner, reason string) []events.Event {
+			var provisionerEvents []events.Event
+			recorder.ForEachEvent(func(evt events.Event) {
+				if p, ok := evt.InvolvedObject.(*v1alpha5.Provisioner); ok && p.Name == provisioner.Name && evt.Reason == reason {
+					provisionerEvents = append(provisionerEvents, evt)
+				}
+			})
+			return provisionerEvents
+		}
+		It("should report the nodes that were provisioned", func() {
+			provisioner := test.Provisioner()
+			ExpectApplied(ctx, env.Client, provisioner)
+			dedicated := test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha5.DedicatedNodePodAnnotationKey: "true"}}}
+			pods := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(dedicated), test.UnschedulablePod(dedicated))
+			nodeNames := lo.Map(pods, func(pod *v1.Pod, _ int) string { return ExpectScheduled(ctx, env.Client, pod).Name })
+			evts := provisionerEvents(provisioner, "ProvisionedNodes")
+			Expect(evts).To(HaveLen(1))
+			Expect(evts[0].Message).To(HavePrefix("Provisioned 2 node(s)"))
+			for _, nodeName := range nodeNames {
+				Expect(evts[0].Message).To(ContainSubstring(nodeName))
+			}
+		})
+		It("should report when the limits are exceeded at launch", func() {
+			provisioner := test.Provisioner(test.ProvisionerOptions{
+				Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("20")},
+				Status: v1alpha5.ProvisionerStatus{
+					Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")},
+				},
+			})
+			ExpectApplied(ctx, env.Client, provisioner)
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			Expect(provisionerEvents(provisioner, "LimitsExceeded")).To(HaveLen(1))
+			Expect(provisionerEvents(provisioner, "ProvisionedNodes")).To(BeEmpty())
+		})
+	})
 	Context("Daemonsets and Node Overhead", func() {
 		It("should account for overhead", func() {
 			ExpectApplied(ctx, env.Client, test.Provisioner(), test.DaemonSet(
@@ -378,6 +482,38 @@ var _ = Describe("Provisioning", func() {
 			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{}))[0]
 			ExpectNotScheduled(ctx, env.Client, pod)
 		})
+		It("should report when the daemonset overhead exceeds all instance types", func() {
+			provisioner := test.Provisioner()
+			ExpectApplied(ctx, env.Client, provisioner, test.DaemonSet(
+				test.DaemonSetOptions{PodOptions: test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10000")}},
+				}},
+			))
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{}))[0]
+			ExpectNotScheduled(ctx, env.Client, pod)
+			reported := 0
+			recorder.ForEachEvent(func(evt events.Event) {
+				if p, ok := evt.InvolvedObject.(*v1alpha5.Provisioner); ok && p.Name == provisioner.Name && evt.Reason == "DaemonSetOverheadExceeded" {
+					reported++
+				}
+			})
+			Expect(reported).To(Equal(1))
+		})
+		It("should not report the daemonset overhead when it fits on an instance type", func() {
+			provisioner := test.Provisioner()
+			ExpectApplied(ctx, env.Client, provisioner, test.DaemonSet(
+				test.DaemonSetOptions{PodOptions: test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+				}},
+			))
+			pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod(test.PodOptions{}))[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			recorder.ForEachEvent(func(evt events.Event) {
+				if p, ok := evt.InvolvedObject.(*v1alpha5.Provisioner); ok && p.Name == provisioner.Name {
+					Expect(evt.Reason).ToNot(Equal("DaemonSetOverheadExceeded"))
+				}
+			})
+		})
 		It("should not schedule if resource requests are not defined and limits (requests) are too large", func() {
 			ExpectApplied(ctx, env.Client, test.Provisioner(), test.DaemonSet(
 				test.DaemonSetOptions{PodOptions: test.PodOptions{
@@ -1156,6 +1292,251 @@ var _ = Describe("Multiple Provisioners", func() {
 	})
 })
 
+var _ = Describe("Provisioning Pod Annotations", func() {
+	BeforeEach(func() {
+		ctx = settings.ToContext(ctx, test.Settings(test.SettingsOptions{AnnotateProvisioningPods: true}))
+	})
+	It("should annotate pods with the provisioner and instance types being launched for them", func() {
+		provisioner := test.Provisioner()
+		ExpectApplied(ctx, env.Client, provisioner)
+		pod := ExpectProvisionedNoBinding(ctx, env.Client, provisioningController, prov, test.UnschedulablePod())[0]
+		Expect(pod.Annotations).To(HaveKeyWithValue(v1alpha5.ProvisioningProvisionerPodAnnotationKey, provisioner.Name))
+		Expect(pod.Annotations).To(HaveKey(v1alpha5.ProvisioningInstanceTypesPodAnnotationKey))
+		instanceTypeNames := strings.Split(pod.Annotations[v1alpha5.ProvisioningInstanceTypesPodAnnotationKey], ",")
+		Expect(instanceTypeNames).ToNot(BeEmpty())
+		for _, name := range instanceTypeNames {
+			Expect(instanceTypeMap).To(HaveKey(name))
+		}
+	})
+	It("should clear the annotations once the pod is bound", func() {
+		ExpectApplied(ctx, env.Client, test.Provisioner())
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov, test.UnschedulablePod())[0]
+		ExpectScheduled(ctx, env.Client, pod)
+		Expect(pod.Annotations).To(HaveKey(v1alpha5.ProvisioningProvisionerPodAnnotationKey))
+
+		ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(pod))
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha5.ProvisioningProvisionerPodAnnotationKey))
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha5.ProvisioningInstanceTypesPodAnnotationKey))
+	})
+	It("should preserve other annotations when clearing", func() {
+		ExpectApplied(ctx, env.Client, test.Provisioner())
+		pod := ExpectProvisioned(ctx, env.Client, cluster, recorder, provisioningController, prov,
+			test.UnschedulablePod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"foo": "bar"}}}))[0]
+		Expect(pod.Annotations).To(HaveKey(v1alpha5.ProvisioningProvisionerPodAnnotationKey))
+
+		ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(pod))
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+		Expect(pod.Annotations).To(Equal(map[string]string{"foo": "bar"}))
+	})
+	It("should not annotate pods when disabled", func() {
+		ctx = settings.ToContext(ctx, test.Settings())
+		ExpectApplied(ctx, env.Client, test.Provisioner())
+		pod := ExpectProvisionedNoBinding(ctx, env.Client, provisioningController, prov, test.UnschedulablePod())[0]
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha5.ProvisioningProvisionerPodAnnotationKey))
+		Expect(pod.Annotations).ToNot(HaveKey(v1alpha5.ProvisioningInstanceTypesPodAnnotationKey))
+	})
+})
+
+var _ = Describe("Node Removal Simulation", func() {
+	var provisioner *v1alpha5.Provisioner
+	var nodes []*v1.Node
+	BeforeEach(func() {
+		provisioner = test.Provisioner()
+		nodes = nil
+		for i := 0; i < 3; i++ {
+			nodes = append(nodes, test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+					v1.LabelInstanceTypeStable:       "default-instance-type",
+				}},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")},
+			}))
+		}
+	})
+	// ExpectBoundPods binds pods requesting the given amount of CPU to the node and syncs the cluster state
+	ExpectBoundPods := func(node *v1.Node, count int, cpu string) []*v1.Pod {
+		var pods []*v1.Pod
+		for i := 0; i < count; i++ {
+			pod := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+			})
+			ExpectApplied(ctx, env.Client, pod)
+			ExpectManualBinding(ctx, env.Client, pod, node)
+			pods = append(pods, pod)
+		}
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		return pods
+	}
+	It("should reschedule the pods onto the remaining nodes", func() {
+		ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1])
+		pods := ExpectBoundPods(nodes[0], 2, "1")
+		ExpectBoundPods(nodes[1], 1, "1")
+
+		simulation, err := prov.SimulateNodeRemoval(ctx, nodes[0].Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(simulation.AllPodsScheduled).To(BeTrue())
+		Expect(simulation.Pods).To(HaveLen(len(pods)))
+		Expect(simulation.NewNodes).To(BeEmpty())
+		Expect(simulation.ExistingNodes).To(HaveLen(1))
+		Expect(simulation.ExistingNodes[0].Node.Name).To(Equal(nodes[1].Name))
+		Expect(simulation.ExistingNodes[0].Pods).To(HaveLen(2))
+		Expect(simulation.FreedCapacity.Cpu().String()).To(Equal("4"))
+		// simulations don't nominate nodes
+		Expect(cluster.IsNodeNominated(nodes[1].Name)).To(BeFalse())
+	})
+	It("should launch new nodes for pods that don't fit on the remaining nodes", func() {
+		ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1])
+		ExpectBoundPods(nodes[0], 1, "3")
+		ExpectBoundPods(nodes[1], 1, "3")
+
+		simulation, err := prov.SimulateNodeRemoval(ctx, nodes[0].Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(simulation.AllPodsScheduled).To(BeTrue())
+		Expect(simulation.NewNodes).To(HaveLen(1))
+		Expect(simulation.ExistingNodes[0].Pods).To(BeEmpty())
+	})
+	It("should report pods that can't be scheduled", func() {
+		// the limits are consumed by the remaining node, so no new capacity can be launched
+		provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}
+		ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1])
+		ExpectBoundPods(nodes[0], 1, "3")
+		ExpectBoundPods(nodes[1], 1, "3")
+
+		simulation, err := prov.SimulateNodeRemoval(ctx, nodes[0].Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(simulation.AllPodsScheduled).To(BeFalse())
+		Expect(simulation.NewNodes).To(BeEmpty())
+	})
+	It("should succeed for a node without pods", func() {
+		ExpectApplied(ctx, env.Client, provisioner, nodes[0])
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[0]))
+
+		simulation, err := prov.SimulateNodeRemoval(ctx, nodes[0].Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(simulation.AllPodsScheduled).To(BeTrue())
+		Expect(simulation.Pods).To(BeEmpty())
+	})
+	It("should fail for a node that isn't tracked", func() {
+		_, err := prov.SimulateNodeRemoval(ctx, "unknown")
+		Expect(err).To(HaveOccurred())
+	})
+	Context("Repacking", func() {
+		It("should consolidate three half-full nodes into two", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+			ExpectBoundPods(nodes[0], 1, "2")
+			ExpectBoundPods(nodes[1], 1, "2")
+			ExpectBoundPods(nodes[2], 1, "2")
+
+			simulation, err := prov.SimulateRepacking(ctx, nodes[0].Name, nodes[1].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.AllPodsScheduled).To(BeTrue())
+			Expect(simulation.Pods).To(HaveLen(2))
+			Expect(simulation.FreedCapacity.Cpu().String()).To(Equal("8"))
+			// one pod fills the remaining node and the other needs a new node
+			Expect(simulation.ExistingNodes).To(HaveLen(1))
+			Expect(simulation.ExistingNodes[0].Node.Name).To(Equal(nodes[2].Name))
+			Expect(simulation.ExistingNodes[0].Pods).To(HaveLen(1))
+			Expect(simulation.NewNodes).To(HaveLen(1))
+			Expect(simulation.NodeDelta).To(Equal(-1))
+		})
+		It("should pack the pods onto the remaining nodes without launching new nodes", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+			ExpectBoundPods(nodes[0], 1, "1")
+			ExpectBoundPods(nodes[1], 1, "1")
+			ExpectBoundPods(nodes[2], 1, "1")
+
+			simulation, err := prov.SimulateRepacking(ctx, nodes[0].Name, nodes[1].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.AllPodsScheduled).To(BeTrue())
+			Expect(simulation.NewNodes).To(BeEmpty())
+			Expect(simulation.ExistingNodes[0].Pods).To(HaveLen(2))
+			Expect(simulation.NodeDelta).To(Equal(-2))
+		})
+		It("should solve the pods from all of the nodes together", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1], nodes[2])
+			ExpectBoundPods(nodes[0], 1, "2")
+			ExpectBoundPods(nodes[1], 1, "2")
+			ExpectBoundPods(nodes[2], 1, "2")
+
+			simulation, err := prov.SimulateRepacking(ctx, nodes[0].Name, nodes[1].Name, nodes[2].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.AllPodsScheduled).To(BeTrue())
+			Expect(simulation.Pods).To(HaveLen(3))
+			Expect(simulation.ExistingNodes).To(BeEmpty())
+			Expect(len(simulation.NewNodes)).To(BeNumerically("<", 3))
+			Expect(simulation.NodeDelta).To(Equal(len(simulation.NewNodes) - 3))
+		})
+		It("should fail if any of the nodes isn't tracked", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0])
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[0]))
+
+			_, err := prov.SimulateRepacking(ctx, nodes[0].Name, "unknown")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Displaced Pods", func() {
+		// ExpectPlacement returns the placement of the displaced pod with the same name as the given pod
+		ExpectPlacement := func(simulation provisioning.DisplacedPodsSimulation, pod *v1.Pod) provisioning.PodPlacement {
+			displaced, ok := lo.FindKeyBy(simulation.Placements, func(p *v1.Pod, _ provisioning.PodPlacement) bool { return p.Name == pod.Name })
+			ExpectWithOffset(1, ok).To(BeTrue())
+			return simulation.Placements[displaced]
+		}
+		It("should map the pods to the nodes they would reschedule to", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1])
+			pods := ExpectBoundPods(nodes[0], 2, "3")
+			ExpectBoundPods(nodes[1], 1, "1")
+
+			simulation, err := prov.SimulateDisplacedPods(ctx, nodes[0].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.AllPodsScheduled).To(BeTrue())
+			Expect(simulation.Placements).To(HaveLen(2))
+			Expect(simulation.Unschedulable).To(BeEmpty())
+
+			// one of the pods fits on the remaining node and the other needs a new node
+			placements := []provisioning.PodPlacement{ExpectPlacement(simulation, pods[0]), ExpectPlacement(simulation, pods[1])}
+			existing, ok := lo.Find(placements, func(p provisioning.PodPlacement) bool { return p.ExistingNode != nil })
+			Expect(ok).To(BeTrue())
+			Expect(existing.NewNode).To(BeNil())
+			Expect(existing.ExistingNode.Node.Name).To(Equal(nodes[1].Name))
+			launched, ok := lo.Find(placements, func(p provisioning.PodPlacement) bool { return p.NewNode != nil })
+			Expect(ok).To(BeTrue())
+			Expect(launched.ExistingNode).To(BeNil())
+			Expect(launched.NewNode).To(Equal(simulation.NewNodes[0]))
+		})
+		It("should report the pods that can't be placed", func() {
+			// the limits are consumed by the remaining node, so no new capacity can be launched
+			provisioner.Spec.Limits = &v1alpha5.Limits{Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}}
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0], nodes[1])
+			pods := ExpectBoundPods(nodes[0], 2, "2")
+			ExpectBoundPods(nodes[1], 1, "1")
+
+			simulation, err := prov.SimulateDisplacedPods(ctx, nodes[0].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.AllPodsScheduled).To(BeFalse())
+			Expect(simulation.Placements).To(HaveLen(1))
+			Expect(simulation.Unschedulable).To(HaveLen(1))
+			placed := lo.Keys(simulation.Placements)[0]
+			Expect([]string{placed.Name, simulation.Unschedulable[0].Name}).To(ConsistOf(pods[0].Name, pods[1].Name))
+			Expect(simulation.Placements[placed].ExistingNode.Node.Name).To(Equal(nodes[1].Name))
+		})
+		It("should return an empty mapping for a node without pods", func() {
+			ExpectApplied(ctx, env.Client, provisioner, nodes[0])
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[0]))
+
+			simulation, err := prov.SimulateDisplacedPods(ctx, nodes[0].Name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(simulation.Placements).To(BeEmpty())
+			Expect(simulation.Unschedulable).To(BeEmpty())
+		})
+		It("should fail for a node that isn't tracked", func() {
+			_, err := prov.SimulateDisplacedPods(ctx, "unknown")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
 func ExpectMachineRequirements(machine *v1alpha1.Machine, requirements ...v1.NodeSelectorRequirement) {
 	for _, requirement := range requirements {
 		req, ok := lo.Find(machine.Spec.Requirements, func(r v1.NodeSelectorRequirement) bool {
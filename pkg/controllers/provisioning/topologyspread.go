@@ -0,0 +1,113 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func NewDefaultTopologySpread(kubeClient client.Client) *DefaultTopologySpread {
+	return &DefaultTopologySpread{kubeClient: kubeClient}
+}
+
+// DefaultTopologySpread applies cluster-level default topology spread constraints to pods that don't declare any,
+// matching the defaulting of the kube-scheduler's PodTopologySpread plugin
+type DefaultTopologySpread struct {
+	kubeClient client.Client
+}
+
+// Inject sets the pod's topology spread constraints to the defaults if it doesn't declare any of its own. The defaults
+// select the pods that share the pod's services, replication controller, replica set or stateful set, and they aren't
+// applied if it doesn't belong to any.
+func (d *DefaultTopologySpread) Inject(ctx context.Context, pod *v1.Pod, constraints []v1.TopologySpreadConstraint) error {
+	if len(constraints) == 0 || len(pod.Spec.TopologySpreadConstraints) != 0 {
+		return nil
+	}
+	selector, err := d.getSelector(ctx, pod)
+	if err != nil {
+		return err
+	}
+	if selector == nil {
+		return nil
+	}
+	for _, constraint := range constraints {
+		constraint.LabelSelector = selector.DeepCopy()
+		pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, constraint)
+	}
+	return nil
+}
+
+// getSelector returns the union of the selectors of the services that select the pod and of the controller that owns
+// it, or nil if there are none
+func (d *DefaultTopologySpread) getSelector(ctx context.Context, pod *v1.Pod) (*metav1.LabelSelector, error) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{}}
+	services := &v1.ServiceList{}
+	if err := d.kubeClient.List(ctx, services, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, fmt.Errorf("listing services, %w", err)
+	}
+	for _, service := range services.Items {
+		if len(service.Spec.Selector) != 0 && labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			selector.MatchLabels = lo.Assign(selector.MatchLabels, service.Spec.Selector)
+		}
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		ownerSelector, err := d.getOwnerSelector(ctx, pod.Namespace, owner)
+		if err != nil {
+			return nil, err
+		}
+		if ownerSelector != nil {
+			selector.MatchLabels = lo.Assign(selector.MatchLabels, ownerSelector.MatchLabels)
+			selector.MatchExpressions = append(selector.MatchExpressions, ownerSelector.MatchExpressions...)
+		}
+	}
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		return nil, nil
+	}
+	return selector, nil
+}
+
+func (d *DefaultTopologySpread) getOwnerSelector(ctx context.Context, namespace string, owner *metav1.OwnerReference) (*metav1.LabelSelector, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: owner.Name}
+	switch {
+	case owner.APIVersion == "v1" && owner.Kind == "ReplicationController":
+		rc := &v1.ReplicationController{}
+		if err := d.kubeClient.Get(ctx, key, rc); err != nil {
+			return nil, client.IgnoreNotFound(fmt.Errorf("getting replication controller, %w", err))
+		}
+		return &metav1.LabelSelector{MatchLabels: rc.Spec.Selector}, nil
+	case owner.APIVersion == appsv1.SchemeGroupVersion.String() && owner.Kind == "ReplicaSet":
+		rs := &appsv1.ReplicaSet{}
+		if err := d.kubeClient.Get(ctx, key, rs); err != nil {
+			return nil, client.IgnoreNotFound(fmt.Errorf("getting replica set, %w", err))
+		}
+		return rs.Spec.Selector, nil
+	case owner.APIVersion == appsv1.SchemeGroupVersion.String() && owner.Kind == "StatefulSet":
+		ss := &appsv1.StatefulSet{}
+		if err := d.kubeClient.Get(ctx, key, ss); err != nil {
+			return nil, client.IgnoreNotFound(fmt.Errorf("getting stateful set, %w", err))
+		}
+		return ss.Spec.Selector, nil
+	}
+	return nil, nil
+}
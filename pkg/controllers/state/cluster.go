@@ -27,8 +27,14 @@ import (
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/utils/clock"
+	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -49,14 +55,31 @@ type Cluster struct {
 	cloudProvider          cloudprovider.CloudProvider
 	clock                  clock.Clock
 	nominatedNodeObservers atomicutils.Slice[observerFunc]
+	nodeDeletionObservers  atomicutils.Slice[observerFunc]
 
 	// State: Node Status & Pod -> Node Binding
 	mu       sync.RWMutex
 	nodes    map[string]*Node                // node name -> node
 	bindings map[types.NamespacedName]string // pod namespaced named -> node name
+	// providerIDs indexes the nodes that have populated their provider ID
+	providerIDs map[string]string // provider id -> node name
+	// podVersions is the resource version of each pod as of its last successful update, so that reconciles which
+	// don't observe a new version of the pod can skip recomputing its usage
+	podVersions map[types.NamespacedName]string // pod namespaced name -> resource version
+	// reservations are the capacity that is being launched but isn't tracked as a node yet
+	reservations map[string]reservation // reservation id -> reservation
+
+	// snapshot is the cached view of the nodes that's returned until a node changes. The copies of the nodes that
+	// haven't changed since the last snapshot are reused when it's rebuilt.
+	snapshotMu    sync.Mutex
+	snapshot      *Snapshot
+	snapshotNodes map[string]*Node // node name -> copy of the node
 
 	nominatedNodes   *cache.Cache
 	antiAffinityPods sync.Map // mapping of pod namespaced name to *v1.Pod of pods that have required anti affinities
+	// nominatedPods are the pending pods that nodes were nominated for, so that a nomination can be released if its
+	// pods are bound somewhere else instead
+	nominatedPods map[types.NamespacedName]string // pod namespaced name -> nominated node name
 
 	// consolidationState is a number indicating the state of the cluster with respect to consolidation.  If this number
 	// hasn't changed, it indicates that the cluster hasn't changed in a state which would enable consolidation if
@@ -64,6 +87,21 @@ type Cluster struct {
 	consolidationState   int64
 	lastNodeDeletionTime int64
 	lastNodeCreationTime int64
+
+	// rebuilding is held while the state is rebuilt, blocking the readers that schedule against the state without
+	// blocking the controllers that keep it up to date
+	rebuilding sync.RWMutex
+	// changes are the nodes and pods that were updated while the state was being rebuilt, nil if it isn't
+	changesMu sync.Mutex
+	changes   *changes
+
+	// initialization tracks the nodes and pods that were discovered in the cluster and which of them have been
+	// reconciled into the cluster state
+	initializationMu sync.Mutex
+	nodesDiscovered  bool
+	podsDiscovered   bool
+	discovered       sets.String
+	reconciled       sets.String
 }
 
 func NewCluster(ctx context.Context, clk clock.Clock, client client.Client, cp cloudprovider.CloudProvider) *Cluster {
@@ -82,6 +120,13 @@ func NewCluster(ctx context.Context, clk clock.Clock, client client.Client, cp c
 		nominatedNodes: cache.New(nominationPeriod, 10*time.Second),
 		nodes:          map[string]*Node{},
 		bindings:       map[types.NamespacedName]string{},
+		podVersions:    map[types.NamespacedName]string{},
+		nominatedPods:  map[types.NamespacedName]string{},
+		providerIDs:    map[string]string{},
+		reservations:   map[string]reservation{},
+		snapshotNodes:  map[string]*Node{},
+		discovered:     sets.NewString(),
+		reconciled:     sets.NewString(),
 	}
 	c.nominatedNodes.OnEvicted(c.onNominatedNodeEviction)
 	return c
@@ -113,6 +158,8 @@ type Node struct {
 
 	podRequests map[types.NamespacedName]v1.ResourceList
 	podLimits   map[types.NamespacedName]v1.ResourceList
+	// terminatingPods are the deletion timestamps of the node's terminating pods, excluding daemonset pods
+	terminatingPods map[types.NamespacedName]metav1.Time
 
 	// PodTotalRequests is the total resources on pods scheduled to this node
 	PodTotalRequests v1.ResourceList
@@ -121,6 +168,21 @@ type Node struct {
 	// MarkedForDeletion marks this node to say that there is some controller that is
 	// planning to delete this node so consider pods that are present on it available for scheduling
 	MarkedForDeletion bool
+	// UnrecognizedInstanceType marks an uninitialized node whose instance type isn't offered by the cloud provider, e.g.
+	// as it was deprecated after the node launched. Its capacity is only what the node has reported so far.
+	UnrecognizedInstanceType bool
+}
+
+// TerminatingPodRequests returns the total requests of the node's terminating pods, excluding daemonset pods, whose
+// deletion timestamp is before the time
+func (n *Node) TerminatingPodRequests(before time.Time) v1.ResourceList {
+	var requests []v1.ResourceList
+	for podKey, deletionTimestamp := range n.terminatingPods {
+		if deletionTimestamp.Time.Before(before) {
+			requests = append(requests, n.podRequests[podKey])
+		}
+	}
+	return resources.Merge(requests...)
 }
 
 // ForPodsWithAntiAffinity calls the supplied function once for each pod with required anti affinity terms that is
@@ -147,13 +209,63 @@ func (c *Cluster) ForPodsWithAntiAffinity(fn func(p *v1.Pod, n *v1.Node) bool) {
 // ForEachNode calls the supplied function once per node object that is being tracked. It is not safe to store the
 // state.Node object, it should be only accessed from within the function provided to this method.
 func (c *Cluster) ForEachNode(f func(n *Node) bool) {
+	c.rebuilding.RLock()
+	defer c.rebuilding.RUnlock()
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	var nodes []*Node
 	for _, node := range c.nodes {
 		nodes = append(nodes, node)
 	}
-	// sort nodes by creation time so we provide a consistent ordering
+	sortNodes(nodes)
+
+	for _, node := range nodes {
+		if !f(node) {
+			return
+		}
+	}
+}
+
+// Snapshot returns an immutable view of the nodes that are being tracked. The snapshot is cached until a node changes
+// and only the nodes that have changed are copied again when it's rebuilt, so it's cheap to take a snapshot of a
+// cluster that's mostly static, e.g. to construct a scheduler for every solve.
+func (c *Cluster) Snapshot() *Snapshot {
+	c.rebuilding.RLock()
+	defer c.rebuilding.RUnlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	if c.snapshot != nil {
+		return c.snapshot
+	}
+	nodes := make([]*Node, 0, len(c.nodes))
+	for name, node := range c.nodes {
+		copied, ok := c.snapshotNodes[name]
+		if !ok {
+			copied = node.DeepCopy()
+			c.snapshotNodes[name] = copied
+		}
+		nodes = append(nodes, copied)
+	}
+	sortNodes(nodes)
+	c.snapshot = &Snapshot{nodes: nodes}
+	return c.snapshot
+}
+
+// invalidateSnapshot discards the cached snapshot and the copies of the nodes that have changed. It must be called
+// while holding the lock whenever a node is modified.
+func (c *Cluster) invalidateSnapshot(nodeNames ...string) {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	c.snapshot = nil
+	for _, nodeName := range nodeNames {
+		delete(c.snapshotNodes, nodeName)
+	}
+}
+
+// sortNodes sorts nodes by creation time so we provide a consistent ordering
+func sortNodes(nodes []*Node) {
 	sort.Slice(nodes, func(a, b int) bool {
 		if nodes[a].Node.CreationTimestamp != nodes[b].Node.CreationTimestamp {
 			return nodes[a].Node.CreationTimestamp.Time.Before(nodes[b].Node.CreationTimestamp.Time)
@@ -161,12 +273,99 @@ func (c *Cluster) ForEachNode(f func(n *Node) bool) {
 		// sometimes we get nodes created in the same second, so sort again by node UID to provide a consistent ordering
 		return nodes[a].Node.UID < nodes[b].Node.UID
 	})
+}
 
-	for _, node := range nodes {
-		if !f(node) {
-			return
+// ClusterTotals are the resources aggregated across all of the nodes tracked in the cluster state
+type ClusterTotals struct {
+	// Nodes is the number of nodes that the totals are aggregated across
+	Nodes int
+	// Allocatable is the total allocatable resources of the nodes
+	Allocatable v1.ResourceList
+	// DaemonSetRequested is the total resources requested by daemonset pods on the nodes
+	DaemonSetRequested v1.ResourceList
+	// PodRequested is the total resources requested by all pods bound to the nodes, including daemonset pods
+	PodRequested v1.ResourceList
+}
+
+// Totals returns the allocatable resources, daemonset overhead and pod requests summed across all tracked nodes
+func (c *Cluster) Totals() ClusterTotals {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	totals := ClusterTotals{
+		Nodes:              len(c.nodes),
+		Allocatable:        v1.ResourceList{},
+		DaemonSetRequested: v1.ResourceList{},
+		PodRequested:       v1.ResourceList{},
+	}
+	for _, n := range c.nodes {
+		totals.Allocatable = resources.Merge(totals.Allocatable, n.Allocatable)
+		totals.DaemonSetRequested = resources.Merge(totals.DaemonSetRequested, n.DaemonSetRequested)
+		totals.PodRequested = resources.Merge(totals.PodRequested, n.PodTotalRequests)
+	}
+	return totals
+}
+
+// NodeByProviderID returns a copy of the tracked node with the provider ID. Nodes can only be found once their provider
+// ID has been populated, which can be some time after the node is created.
+func (c *Cluster) NodeByProviderID(providerID string) (*Node, bool) {
+	if providerID == "" {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n, ok := c.nodes[c.providerIDs[providerID]]
+	if !ok {
+		return nil, false
+	}
+	return n.DeepCopy(), true
+}
+
+// ReservationTTL is how long reserved capacity counts against a provisioner if it's never released. Reservations are
+// normally released as soon as the node is tracked, this only bounds how long a launch that fails part way through
+// can hold capacity.
+const ReservationTTL = 5 * time.Minute
+
+type reservation struct {
+	provisionerName string
+	capacity        v1.ResourceList
+	expiration      time.Time
+}
+
+// Reserve records capacity that a provisioner is launching but that isn't tracked as a node yet, so that solves that
+// happen in the meantime account for it against the provisioner's limits. It returns the id of the reservation to
+// release once the node is tracked.
+func (c *Cluster) Reserve(provisionerName string, capacity v1.ResourceList) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := string(uuid.NewUUID())
+	c.reservations[id] = reservation{
+		provisionerName: provisionerName,
+		capacity:        capacity,
+		expiration:      c.clock.Now().Add(ReservationTTL),
+	}
+	return id
+}
+
+// Release releases a reservation, it's a no-op if the reservation was already released or has expired
+func (c *Cluster) Release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reservations, id)
+}
+
+// Reserved returns the capacity reserved for each provisioner by reservations that haven't expired
+func (c *Cluster) Reserved() map[string]v1.ResourceList {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reserved := map[string]v1.ResourceList{}
+	for id, r := range c.reservations {
+		if !c.clock.Now().Before(r.expiration) {
+			delete(c.reservations, id)
+			continue
 		}
+		reserved[r.provisionerName] = resources.Merge(reserved[r.provisionerName], r.capacity)
 	}
+	return reserved
 }
 
 // IsNodeNominated returns true if the given node was expected to have a pod bound to it during a recent scheduling
@@ -176,9 +375,18 @@ func (c *Cluster) IsNodeNominated(nodeName string) bool {
 	return exists
 }
 
-// NominateNodeForPod records that a node was the target of a pending pod during a scheduling batch
-func (c *Cluster) NominateNodeForPod(nodeName string) {
+// NominateNodeForPod records that a node was the target of a pending pod during a scheduling batch. If the pending
+// pods are given, the nomination is released early once they've all been bound to other nodes.
+func (c *Cluster) NominateNodeForPod(nodeName string, pods ...*v1.Pod) {
 	c.nominatedNodes.SetDefault(nodeName, nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pod := range pods {
+		// pods that are already bound, e.g. pods on nodes that are being deleted, aren't waiting on the nomination
+		if pod.Spec.NodeName == "" {
+			c.nominatedPods[client.ObjectKeyFromObject(pod)] = nodeName
+		}
+	}
 }
 
 // AddNominatedNodeEvictionObserver adds an observer function to be called when any cache entry from the
@@ -190,8 +398,56 @@ func (c *Cluster) AddNominatedNodeEvictionObserver(f observerFunc) {
 // onNominatedNodeEviction is registered as the function called when a nominatedNode cache
 // entry expires. It will alert all registered observer functions by calling the registered function
 func (c *Cluster) onNominatedNodeEviction(key string, _ interface{}) {
+	c.forgetNominatedPods(key)
+	notifyObservers(&c.nominatedNodeObservers, key)
+}
+
+func (c *Cluster) forgetNominatedPods(nodeName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for podKey, nominatedNodeName := range c.nominatedPods {
+		if nominatedNodeName == nodeName {
+			delete(c.nominatedPods, podKey)
+		}
+	}
+}
+
+// releaseStaleNomination releases the nomination of the node that a pending pod was nominated for if the pod has been
+// bound to another node instead, e.g. by a different scheduler, and none of the other pods nominated for it are pending
+func (c *Cluster) releaseStaleNomination(pod *v1.Pod) {
+	if pod.Spec.NodeName == "" {
+		return
+	}
+	nodeName, stale := func() (string, bool) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		podKey := client.ObjectKeyFromObject(pod)
+		nominatedNodeName, ok := c.nominatedPods[podKey]
+		if !ok {
+			return "", false
+		}
+		delete(c.nominatedPods, podKey)
+		if nominatedNodeName == pod.Spec.NodeName {
+			return "", false
+		}
+		return nominatedNodeName, !lo.Contains(lo.Values(c.nominatedPods), nominatedNodeName)
+	}()
+	if stale {
+		// Deleting the nomination notifies the nominated node eviction observers, so this has to happen outside the lock
+		c.nominatedNodes.Delete(nodeName)
+	}
+}
+
+// OnNodeDeleted adds an observer function to be called after a node that was being tracked has been removed from
+// the cluster state and any nominations or pod bindings against it have been released
+func (c *Cluster) OnNodeDeleted(f observerFunc) {
+	c.nodeDeletionObservers.Add(f)
+}
+
+// notifyObservers calls each of the observers concurrently with the given key and waits for them to complete
+func notifyObservers(observers *atomicutils.Slice[observerFunc], key string) {
 	wg := &sync.WaitGroup{}
-	c.nominatedNodeObservers.Range(func(observer observerFunc) bool {
+	observers.Range(func(observer observerFunc) bool {
 		wg.Add(1)
 		go func(f observerFunc) {
 			defer wg.Done()
@@ -209,6 +465,7 @@ func (c *Cluster) UnmarkForDeletion(nodeNames ...string) {
 	for _, nodeName := range nodeNames {
 		if _, ok := c.nodes[nodeName]; ok {
 			c.nodes[nodeName].MarkedForDeletion = false
+			c.invalidateSnapshot(nodeName)
 		}
 	}
 }
@@ -220,6 +477,7 @@ func (c *Cluster) MarkForDeletion(nodeNames ...string) {
 	for _, nodeName := range nodeNames {
 		if _, ok := c.nodes[nodeName]; ok {
 			c.nodes[nodeName].MarkedForDeletion = true
+			c.invalidateSnapshot(nodeName)
 		}
 	}
 }
@@ -237,6 +495,7 @@ func (c *Cluster) newNode(ctx context.Context, node *v1.Node) (*Node, error) {
 		MarkedForDeletion: !node.DeletionTimestamp.IsZero(),
 		podRequests:       map[types.NamespacedName]v1.ResourceList{},
 		podLimits:         map[types.NamespacedName]v1.ResourceList{},
+		terminatingPods:   map[types.NamespacedName]metav1.Time{},
 	}
 	if err := multierr.Combine(
 		c.populateCapacity(ctx, node, n),
@@ -272,7 +531,13 @@ func (c *Cluster) populateCapacity(ctx context.Context, node *v1.Node, n *Node)
 	}
 	instanceType, ok := lo.Find(instanceTypes, func(it *cloudprovider.InstanceType) bool { return it.Name == node.Labels[v1.LabelInstanceTypeStable] })
 	if !ok {
-		return fmt.Errorf("instance type '%s' not found", node.Labels[v1.LabelInstanceTypeStable])
+		// The instance type may have been deprecated and removed by the cloud provider since the node was launched, so
+		// rather than assuming capacity that we can't know, we only use what the node has reported
+		logging.FromContext(ctx).With("node", node.Name).Debugf("instance type %q not found, using the capacity reported by the node", node.Labels[v1.LabelInstanceTypeStable])
+		n.Allocatable = lo.Assign(node.Status.Allocatable)
+		n.Capacity = lo.Assign(node.Status.Capacity)
+		n.UnrecognizedInstanceType = true
+		return nil
 	}
 
 	n.Capacity = lo.Assign(node.Status.Capacity) // ensure map not nil
@@ -311,6 +576,7 @@ func (c *Cluster) populateResourceRequests(ctx context.Context, node *v1.Node, n
 		podKey := client.ObjectKeyFromObject(pod)
 		n.podRequests[podKey] = requests
 		n.podLimits[podKey] = podLimits
+		updateTerminatingPod(n, pod)
 		c.bindings[podKey] = n.Node.Name
 		if podutils.IsOwnedByDaemonSet(pod) {
 			daemonsetRequested = append(daemonsetRequested, requests)
@@ -345,15 +611,42 @@ func (c *Cluster) populateVolumeLimits(ctx context.Context, node *v1.Node, n *No
 	return nil
 }
 
+// DeleteNode stops tracking the node and releases any nominations or pod bindings that reference it. Registered
+// node deletion observers are notified if the node was previously known.
 func (c *Cluster) DeleteNode(nodeName string) {
+	c.recordChange(func(changes *changes) { changes.nodes.Insert(nodeName) })
+	known := c.deleteNode(nodeName)
+	// Deleting the nomination notifies the nominated node eviction observers, so this has to happen outside the lock
+	c.nominatedNodes.Delete(nodeName)
+	if known {
+		notifyObservers(&c.nodeDeletionObservers, nodeName)
+	}
+}
+
+func (c *Cluster) deleteNode(nodeName string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.nodes, nodeName)
 	c.recordConsolidationChange()
+	n, ok := c.nodes[nodeName]
+	if !ok {
+		return false
+	}
+	delete(c.nodes, nodeName)
+	c.invalidateSnapshot(nodeName)
+	c.unindexProviderID(n.Node)
+	// The pods bound to this node no longer consume capacity that we are tracking. If they are re-created and bound
+	// elsewhere, the pod controller will record the new binding.
+	for podKey, boundNodeName := range c.bindings {
+		if boundNodeName == nodeName {
+			delete(c.bindings, podKey)
+		}
+	}
+	return true
 }
 
 // updateNode is called for every node reconciliation
 func (c *Cluster) UpdateNode(ctx context.Context, node *v1.Node) error {
+	c.recordChange(func(changes *changes) { changes.nodes.Insert(node.Name) })
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	n, err := c.newNode(ctx, node)
@@ -372,8 +665,15 @@ func (c *Cluster) UpdateNode(ctx context.Context, node *v1.Node) error {
 		// 1. If the DeletionTimestamp is set (the node is explicitly being deleted)
 		// 2. If the last state of the node has the node MarkedForDeletion
 		n.MarkedForDeletion = n.MarkedForDeletion || oldNode.MarkedForDeletion
+		c.unindexProviderID(oldNode.Node)
 	}
 	c.nodes[node.Name] = n
+	c.invalidateSnapshot(node.Name)
+	// The provider ID is populated by the cloud provider after the node is created, so the node is indexed once it's
+	// observed
+	if node.Spec.ProviderID != "" {
+		c.providerIDs[node.Spec.ProviderID] = node.Name
+	}
 
 	if node.DeletionTimestamp != nil {
 		nodeDeletionTime := node.DeletionTimestamp.UnixMilli()
@@ -388,6 +688,13 @@ func (c *Cluster) UpdateNode(ctx context.Context, node *v1.Node) error {
 	return nil
 }
 
+// unindexProviderID removes the node from the provider ID index, unless another node has since claimed its provider ID
+func (c *Cluster) unindexProviderID(node *v1.Node) {
+	if c.providerIDs[node.Spec.ProviderID] == node.Name {
+		delete(c.providerIDs, node.Spec.ProviderID)
+	}
+}
+
 // ClusterConsolidationState returns a number representing the state of the cluster with respect to consolidation.  If
 // consolidation can't occur and this number hasn't changed, there is no point in re-attempting consolidation. This
 // allows reducing overall CPU utilization by pausing consolidation when the cluster is in a static state.
@@ -415,11 +722,20 @@ func (c *Cluster) LastNodeCreationTime() time.Time {
 
 // deletePod is called when the pod has been deleted
 func (c *Cluster) DeletePod(podKey types.NamespacedName) {
+	c.recordChange(func(changes *changes) { changes.pods[podKey] = struct{}{} })
+	c.forgetPodVersion(podKey)
 	c.antiAffinityPods.Delete(podKey)
+	c.forgetNominatedPod(podKey)
 	c.updateNodeUsageFromPodCompletion(podKey)
 	c.recordConsolidationChange()
 }
 
+func (c *Cluster) forgetNominatedPod(podKey types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nominatedPods, podKey)
+}
+
 func (c *Cluster) updateNodeUsageFromPodCompletion(podKey types.NamespacedName) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -436,6 +752,7 @@ func (c *Cluster) updateNodeUsageFromPodCompletion(podKey types.NamespacedName)
 		// we weren't tracking the node yet, so nothing to do
 		return
 	}
+	c.invalidateSnapshot(nodeName)
 	// pod has been deleted so our available capacity increases by the resources that had been
 	// requested by the pod
 	n.Available = resources.Merge(n.Available, n.podRequests[podKey])
@@ -443,6 +760,7 @@ func (c *Cluster) updateNodeUsageFromPodCompletion(podKey types.NamespacedName)
 	n.PodTotalLimits = resources.Subtract(n.PodTotalLimits, n.podLimits[podKey])
 	delete(n.podRequests, podKey)
 	delete(n.podLimits, podKey)
+	delete(n.terminatingPods, podKey)
 	n.HostPortUsage.DeletePod(podKey)
 	n.VolumeUsage.DeletePod(podKey)
 
@@ -453,6 +771,12 @@ func (c *Cluster) updateNodeUsageFromPodCompletion(podKey types.NamespacedName)
 
 // updatePod is called every time the pod is reconciled
 func (c *Cluster) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	c.recordChange(func(changes *changes) { changes.pods[client.ObjectKeyFromObject(pod)] = struct{}{} })
+	// Pods are requeued periodically and a pod that flaps between phases can be reconciled many times in quick
+	// succession. If we've already processed this version of the pod, nothing that we track can have changed.
+	if c.isPodVersionSeen(pod) {
+		return nil
+	}
 	var err error
 	if podutils.IsTerminal(pod) {
 		c.updateNodeUsageFromPodCompletion(client.ObjectKeyFromObject(pod))
@@ -460,7 +784,36 @@ func (c *Cluster) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 		err = c.updateNodeUsageFromPod(ctx, pod)
 	}
 	c.updatePodAntiAffinities(pod)
-	return err
+	c.releaseStaleNomination(pod)
+	if err != nil {
+		return err
+	}
+	c.recordPodVersion(pod)
+	return nil
+}
+
+func (c *Cluster) isPodVersionSeen(pod *v1.Pod) bool {
+	if pod.ResourceVersion == "" {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.podVersions[client.ObjectKeyFromObject(pod)] == pod.ResourceVersion
+}
+
+func (c *Cluster) recordPodVersion(pod *v1.Pod) {
+	if pod.ResourceVersion == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.podVersions[client.ObjectKeyFromObject(pod)] = pod.ResourceVersion
+}
+
+func (c *Cluster) forgetPodVersion(podKey types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.podVersions, podKey)
 }
 
 func (c *Cluster) updatePodAntiAffinities(pod *v1.Pod) {
@@ -490,7 +843,14 @@ func (c *Cluster) updateNodeUsageFromPod(ctx context.Context, pod *v1.Pod) error
 	oldNodeName, bindingKnown := c.bindings[podKey]
 	if bindingKnown {
 		if oldNodeName == pod.Spec.NodeName {
-			// we are already tracking the pod binding, so nothing to update
+			// we are already tracking the pod binding, so the only things that can change are the pod's resources if
+			// they were resized in place, and whether it's terminating
+			if n, ok := c.nodes[oldNodeName]; ok {
+				c.updateNodeUsageFromPodResize(n, pod)
+				if updateTerminatingPod(n, pod) {
+					c.invalidateSnapshot(oldNodeName)
+				}
+			}
 			return nil
 		}
 		// the pod has switched nodes, this can occur if a pod name was re-used and it was deleted/re-created rapidly,
@@ -500,12 +860,14 @@ func (c *Cluster) updateNodeUsageFromPod(ctx context.Context, pod *v1.Pod) error
 			// we were tracking the old node, so we need to reduce its capacity by the amount of the pod that has
 			// left it
 			delete(c.bindings, podKey)
+			c.invalidateSnapshot(oldNodeName)
 			n.Available = resources.Merge(n.Available, n.podRequests[podKey])
 			n.PodTotalRequests = resources.Subtract(n.PodTotalRequests, n.podRequests[podKey])
 			n.PodTotalLimits = resources.Subtract(n.PodTotalLimits, n.podLimits[podKey])
 			n.HostPortUsage.DeletePod(podKey)
 			delete(n.podRequests, podKey)
 			delete(n.podLimits, podKey)
+			delete(n.terminatingPods, podKey)
 		}
 	} else {
 		// new pod binding has occurred
@@ -528,6 +890,7 @@ func (c *Cluster) updateNodeUsageFromPod(ctx context.Context, pod *v1.Pod) error
 			return err
 		}
 		c.nodes[node.Name] = n
+		c.invalidateSnapshot(node.Name)
 		return nil
 	}
 
@@ -547,10 +910,297 @@ func (c *Cluster) updateNodeUsageFromPod(ctx context.Context, pod *v1.Pod) error
 	n.VolumeUsage.Add(ctx, pod)
 	n.podRequests[podKey] = podRequests
 	n.podLimits[podKey] = podLimits
+	updateTerminatingPod(n, pod)
 	c.bindings[podKey] = n.Node.Name
+	c.invalidateSnapshot(n.Node.Name)
+	return nil
+}
+
+// updateTerminatingPod records the deletion timestamp of the pod if it's terminating, returning true if it changed.
+// Daemonset pods aren't tracked, as they're replaced on the node by a new pod that needs the same capacity.
+func updateTerminatingPod(n *Node, pod *v1.Pod) bool {
+	podKey := client.ObjectKeyFromObject(pod)
+	if pod.DeletionTimestamp.IsZero() || podutils.IsOwnedByDaemonSet(pod) {
+		_, ok := n.terminatingPods[podKey]
+		delete(n.terminatingPods, podKey)
+		return ok
+	}
+	if deletionTimestamp, ok := n.terminatingPods[podKey]; ok && deletionTimestamp.Equal(pod.DeletionTimestamp) {
+		return false
+	}
+	n.terminatingPods[podKey] = *pod.DeletionTimestamp
+	return true
+}
+
+// updateNodeUsageFromPodResize updates the node's usage if the requests or limits of a pod that is already bound to it
+// have changed, e.g. due to an in-place resize of the pod's containers.
+func (c *Cluster) updateNodeUsageFromPodResize(n *Node, pod *v1.Pod) {
+	podKey := client.ObjectKeyFromObject(pod)
+	oldRequests, oldLimits := n.podRequests[podKey], n.podLimits[podKey]
+	podRequests := resources.RequestsForPods(pod)
+	podLimits := resources.LimitsForPods(pod)
+	if equality.Semantic.DeepEqual(oldRequests, podRequests) && equality.Semantic.DeepEqual(oldLimits, podLimits) {
+		return
+	}
+	n.Available = resources.Subtract(resources.Merge(n.Available, oldRequests), podRequests)
+	n.PodTotalRequests = resources.Merge(resources.Subtract(n.PodTotalRequests, oldRequests), podRequests)
+	n.PodTotalLimits = resources.Merge(resources.Subtract(n.PodTotalLimits, oldLimits), podLimits)
+	if podutils.IsOwnedByDaemonSet(pod) {
+		n.DaemonSetRequested = resources.Merge(resources.Subtract(n.DaemonSetRequested, oldRequests), podRequests)
+		n.DaemonSetLimits = resources.Merge(resources.Subtract(n.DaemonSetLimits, oldLimits), podLimits)
+	}
+	n.podRequests[podKey] = podRequests
+	n.podLimits[podKey] = podLimits
+	c.invalidateSnapshot(n.Node.Name)
+	c.recordConsolidationChange()
+}
+
+// InitializationProgress returns the number of nodes and pods that have been reconciled into the cluster state and the
+// total number that have been discovered. The cluster state is fully initialized once all of the discovered nodes and
+// pods have been reconciled.
+func (c *Cluster) InitializationProgress() (done, total int) {
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	return c.reconciled.Len(), c.discovered.Len()
+}
+
+// discoverNodes records the nodes that exist in the cluster the first time that it's called, so that initialization
+// progress is measured against them
+func (c *Cluster) discoverNodes(ctx context.Context) error {
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	if c.nodesDiscovered {
+		return nil
+	}
+	nodeList := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodeList); err != nil {
+		return fmt.Errorf("listing nodes, %w", err)
+	}
+	for i := range nodeList.Items {
+		c.discovered.Insert(nodeInitializationKey(nodeList.Items[i].Name))
+	}
+	c.nodesDiscovered = true
+	return nil
+}
+
+// discoverPods records the pods that exist in the cluster the first time that it's called, so that initialization
+// progress is measured against them
+func (c *Cluster) discoverPods(ctx context.Context) error {
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	if c.podsDiscovered {
+		return nil
+	}
+	podList := &v1.PodList{}
+	if err := c.kubeClient.List(ctx, podList); err != nil {
+		return fmt.Errorf("listing pods, %w", err)
+	}
+	for i := range podList.Items {
+		c.discovered.Insert(podInitializationKey(client.ObjectKeyFromObject(&podList.Items[i])))
+	}
+	c.podsDiscovered = true
+	return nil
+}
+
+// markReconciled records that the object has been reconciled into the cluster state
+func (c *Cluster) markReconciled(key string) {
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	c.discovered.Insert(key)
+	c.reconciled.Insert(key)
+}
+
+// forget stops tracking the initialization of an object that no longer exists
+func (c *Cluster) forget(key string) {
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	c.discovered.Delete(key)
+	c.reconciled.Delete(key)
+}
+
+func nodeInitializationKey(nodeName string) string {
+	return "node/" + nodeName
+}
+
+func podInitializationKey(podKey types.NamespacedName) string {
+	return "pod/" + podKey.String()
+}
+
+// Rebuild discards the tracked nodes and pod bindings and reconstructs them from the nodes and pods listed from the API
+// server, e.g. to recover from state that has drifted after a failure. The new state is built without holding the lock
+// and swapped in atomically, and the readers that schedule against the cluster state, i.e. Snapshot and ForEachNode,
+// block until it's complete. The nodes and pods that are updated while the state is rebuilt are applied again once
+// it's swapped in, as the listed state may predate them. Marks for deletion, nominations and reservations aren't
+// observable from the API server and are kept. Registered node deletion observers are notified of the nodes that no
+// longer exist. If the state can't be rebuilt, the existing state is left as it was.
+func (c *Cluster) Rebuild(ctx context.Context) error {
+	deleted, err := c.rebuild(ctx)
+	if err != nil {
+		return err
+	}
+	// Deleting the nominations notifies the nominated node eviction observers, so this has to happen outside the lock
+	for _, nodeName := range deleted {
+		c.nominatedNodes.Delete(nodeName)
+		notifyObservers(&c.nodeDeletionObservers, nodeName)
+	}
 	return nil
 }
 
+// changes are the nodes and pods that were updated while the state was being rebuilt
+type changes struct {
+	nodes sets.String
+	pods  map[types.NamespacedName]struct{}
+}
+
+// recordChange records the update if the state is being rebuilt
+func (c *Cluster) recordChange(record func(*changes)) {
+	c.changesMu.Lock()
+	defer c.changesMu.Unlock()
+	if c.changes != nil {
+		record(c.changes)
+	}
+}
+
+// stopRecordingChanges stops recording updates and returns those that were recorded
+func (c *Cluster) stopRecordingChanges() *changes {
+	c.changesMu.Lock()
+	defer c.changesMu.Unlock()
+	recorded := c.changes
+	c.changes = nil
+	return recorded
+}
+
+// rebuild reconstructs the state from the API server, swaps it in and reapplies the updates that were made while it was
+// being reconstructed. It returns the names of the nodes that are no longer tracked.
+func (c *Cluster) rebuild(ctx context.Context) ([]string, error) {
+	c.rebuilding.Lock()
+	defer c.rebuilding.Unlock()
+	c.changesMu.Lock()
+	c.changes = &changes{nodes: sets.NewString(), pods: map[types.NamespacedName]struct{}{}}
+	c.changesMu.Unlock()
+
+	rebuilt, antiAffinityPods, err := c.list(ctx)
+	if err != nil {
+		c.stopRecordingChanges()
+		return nil, err
+	}
+	deleted, changed := c.swap(rebuilt, antiAffinityPods)
+	c.reapply(ctx, changed)
+	return deleted, nil
+}
+
+// list reconstructs the nodes and pod bindings from the nodes and pods listed from the API server. The nodes are
+// populated into a separate cluster so that the bindings they record don't touch the current state.
+func (c *Cluster) list(ctx context.Context) (*Cluster, []*v1.Pod, error) {
+	nodeList := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodeList); err != nil {
+		return nil, nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	podList := &v1.PodList{}
+	if err := c.kubeClient.List(ctx, podList); err != nil {
+		return nil, nil, fmt.Errorf("listing pods, %w", err)
+	}
+	rebuilt := &Cluster{
+		kubeClient:    c.kubeClient,
+		cloudProvider: c.cloudProvider,
+		clock:         c.clock,
+		nodes:         map[string]*Node{},
+		bindings:      map[types.NamespacedName]string{},
+		podVersions:   map[types.NamespacedName]string{},
+		providerIDs:   map[string]string{},
+	}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		n, err := rebuilt.newNode(ctx, node)
+		if err != nil {
+			return nil, nil, fmt.Errorf("populating node %s, %w", node.Name, err)
+		}
+		rebuilt.nodes[node.Name] = n
+		if node.Spec.ProviderID != "" {
+			rebuilt.providerIDs[node.Spec.ProviderID] = node.Name
+		}
+	}
+	var antiAffinityPods []*v1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.ResourceVersion != "" {
+			rebuilt.podVersions[client.ObjectKeyFromObject(pod)] = pod.ResourceVersion
+		}
+		if podutils.HasRequiredPodAntiAffinity(pod) {
+			antiAffinityPods = append(antiAffinityPods, pod)
+		}
+	}
+	return rebuilt, antiAffinityPods, nil
+}
+
+// swap replaces the state with the rebuilt state, returning the names of the nodes that are no longer tracked and the
+// updates that were made to the replaced state while it was being rebuilt
+func (c *Cluster) swap(rebuilt *Cluster, antiAffinityPods []*v1.Pod) ([]string, *changes) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var deleted []string
+	for nodeName, oldNode := range c.nodes {
+		n, ok := rebuilt.nodes[nodeName]
+		if !ok {
+			deleted = append(deleted, nodeName)
+			continue
+		}
+		n.MarkedForDeletion = n.MarkedForDeletion || oldNode.MarkedForDeletion
+	}
+	c.nodes = rebuilt.nodes
+	c.bindings = rebuilt.bindings
+	c.podVersions = rebuilt.podVersions
+	c.providerIDs = rebuilt.providerIDs
+	c.antiAffinityPods.Range(func(key, _ interface{}) bool {
+		c.antiAffinityPods.Delete(key)
+		return true
+	})
+	for _, pod := range antiAffinityPods {
+		c.antiAffinityPods.Store(client.ObjectKeyFromObject(pod), pod)
+	}
+	c.snapshotMu.Lock()
+	c.snapshot = nil
+	c.snapshotNodes = map[string]*Node{}
+	c.snapshotMu.Unlock()
+	c.recordConsolidationChange()
+	// Updates are recorded before they take the lock, so any that are still to be applied are applied to the new state
+	return deleted, c.stopRecordingChanges()
+}
+
+// reapply reads the nodes and pods that were updated while the state was being rebuilt and applies them to the new
+// state, as the nodes and pods that were listed may predate the updates. Objects that can't be read or applied are left
+// to their controllers to reconcile.
+func (c *Cluster) reapply(ctx context.Context, changed *changes) {
+	for nodeName := range changed.nodes {
+		node := &v1.Node{}
+		if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+			if errors.IsNotFound(err) {
+				c.DeleteNode(nodeName)
+			} else {
+				logging.FromContext(ctx).With("node", nodeName).Errorf("reading node updated while rebuilding cluster state, %s", err)
+			}
+			continue
+		}
+		if err := c.UpdateNode(ctx, node); err != nil {
+			logging.FromContext(ctx).With("node", nodeName).Errorf("applying node updated while rebuilding cluster state, %s", err)
+		}
+	}
+	for podKey := range changed.pods {
+		pod := &v1.Pod{}
+		if err := c.kubeClient.Get(ctx, podKey, pod); err != nil {
+			if errors.IsNotFound(err) {
+				c.DeletePod(podKey)
+			} else {
+				logging.FromContext(ctx).With("pod", podKey).Errorf("reading pod updated while rebuilding cluster state, %s", err)
+			}
+			continue
+		}
+		if err := c.UpdatePod(ctx, pod); err != nil {
+			logging.FromContext(ctx).With("pod", podKey).Errorf("applying pod updated while rebuilding cluster state, %s", err)
+		}
+	}
+}
+
 func (c *Cluster) recordConsolidationChange() {
 	atomic.StoreInt64(&c.consolidationState, c.clock.Now().UnixMilli())
 }
@@ -561,5 +1211,19 @@ func (c *Cluster) Reset(ctx context.Context) {
 	defer c.mu.Unlock()
 	c.nodes = map[string]*Node{}
 	c.bindings = map[types.NamespacedName]string{}
+	c.podVersions = map[types.NamespacedName]string{}
+	c.nominatedPods = map[types.NamespacedName]string{}
+	c.providerIDs = map[string]string{}
+	c.reservations = map[string]reservation{}
 	c.antiAffinityPods = sync.Map{}
+	c.snapshotMu.Lock()
+	c.snapshot = nil
+	c.snapshotNodes = map[string]*Node{}
+	c.snapshotMu.Unlock()
+	c.initializationMu.Lock()
+	defer c.initializationMu.Unlock()
+	c.nodesDiscovered = false
+	c.podsDiscovered = false
+	c.discovered = sets.NewString()
+	c.reconciled = sets.NewString()
 }
@@ -49,17 +49,22 @@ func (c *NodeController) Name() string {
 
 func (c *NodeController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(c.Name()).With("node", req.NamespacedName.Name))
+	if err := c.cluster.discoverNodes(ctx); err != nil {
+		return reconcile.Result{}, err
+	}
 	node := &v1.Node{}
 	if err := c.kubeClient.Get(ctx, req.NamespacedName, node); err != nil {
 		if errors.IsNotFound(err) {
+			c.cluster.forget(nodeInitializationKey(req.Name))
 			// notify cluster state of the node deletion
 			c.cluster.DeleteNode(req.Name)
 		}
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
-	if err := c.cluster.UpdateNode(ctx, node); err != nil {
+	if err := retryTransient(ctx, func() error { return c.cluster.UpdateNode(ctx, node) }); err != nil {
 		return reconcile.Result{}, err
 	}
+	c.cluster.markReconciled(nodeInitializationKey(req.Name))
 	// ensure it's aware of any nodes we discover, this is a no-op if the node is already known to our cluster state
 	return reconcile.Result{Requeue: true, RequeueAfter: stateRetryPeriod}, nil
 }
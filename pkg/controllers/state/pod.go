@@ -51,17 +51,22 @@ func (c *PodController) Name() string {
 
 func (c *PodController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(c.Name()).With("pod", req.NamespacedName))
+	if err := c.cluster.discoverPods(ctx); err != nil {
+		return reconcile.Result{}, err
+	}
 	pod := &v1.Pod{}
 	if err := c.kubeClient.Get(ctx, req.NamespacedName, pod); err != nil {
 		if errors.IsNotFound(err) {
+			c.cluster.forget(podInitializationKey(req.NamespacedName))
 			// notify cluster state of the node deletion
 			c.cluster.DeletePod(req.NamespacedName)
 		}
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
-	if err := c.cluster.UpdatePod(ctx, pod); err != nil {
+	if err := retryTransient(ctx, func() error { return c.cluster.UpdatePod(ctx, pod) }); err != nil {
 		return reconcile.Result{}, err
 	}
+	c.cluster.markReconciled(podInitializationKey(req.NamespacedName))
 	return reconcile.Result{Requeue: true, RequeueAfter: stateRetryPeriod}, nil
 }
 
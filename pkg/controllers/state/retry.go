@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/avast/retry-go"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
+)
+
+// transientRetryOptions bound the retries of a cluster state update within a single reconcile. Anything that is still
+// failing after these attempts is returned so that the controller requeues the object with its own backoff.
+var transientRetryOptions = []retry.Option{
+	retry.Attempts(3),
+	retry.Delay(100 * time.Millisecond),
+	retry.MaxDelay(time.Second),
+	retry.LastErrorOnly(true),
+	retry.RetryIf(isTransient),
+}
+
+// retryTransient calls fn, retrying it with backoff for as long as it fails with a transient error
+func retryTransient(ctx context.Context, fn func() error) error {
+	return retry.Do(fn, append([]retry.Option{
+		retry.Context(ctx),
+		retry.OnRetry(func(n uint, err error) {
+			logging.FromContext(ctx).Debugf("retrying cluster state update after transient error (attempt %d), %s", n+1, err)
+		}),
+	}, transientRetryOptions...)...)
+}
+
+// isTransient returns true if the error is expected to resolve on its own if the call is retried shortly, as opposed
+// to errors that will keep failing until something in the cluster changes.
+func isTransient(err error) bool {
+	return cloudprovider.IsTransientError(err) ||
+		errors.IsServerTimeout(err) ||
+		errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) ||
+		errors.IsServiceUnavailable(err) ||
+		errors.IsInternalError(err)
+}
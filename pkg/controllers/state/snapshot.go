@@ -0,0 +1,38 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+// Snapshot is an immutable view of the nodes tracked in the cluster state at a point in time. Changes to the cluster
+// state aren't reflected in a snapshot that has already been taken, the cluster replaces the copy of a node that has
+// changed rather than modifying it.
+type Snapshot struct {
+	nodes []*Node
+}
+
+// Nodes returns the nodes in the snapshot, ordered by creation time. The nodes are shared with other snapshots and
+// must not be modified.
+func (s *Snapshot) Nodes() []*Node {
+	return append([]*Node{}, s.nodes...)
+}
+
+// ForEachNode calls the supplied function once per node in the snapshot, ordered by creation time. The nodes are
+// shared with other snapshots and must not be modified.
+func (s *Snapshot) ForEachNode(f func(n *Node) bool) {
+	for _, node := range s.nodes {
+		if !f(node) {
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//go:build test_performance
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+
+	"github.com/aws/karpenter-core/pkg/apis"
+	"github.com/aws/karpenter-core/pkg/apis/config/settings"
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter-core/pkg/controllers/state"
+	"github.com/aws/karpenter-core/pkg/operator/scheme"
+	"github.com/aws/karpenter-core/pkg/test"
+)
+
+// BenchmarkClusterForEachNode measures copying the nodes out of the cluster state, which is how the nodes were
+// collected for every solve before snapshots
+func BenchmarkClusterForEachNode(b *testing.B) {
+	benchmarkStateNodes(b, func(c *state.Cluster) []*state.Node {
+		var nodes []*state.Node
+		c.ForEachNode(func(n *state.Node) bool {
+			nodes = append(nodes, n.DeepCopy())
+			return true
+		})
+		return nodes
+	})
+}
+
+// BenchmarkClusterSnapshot measures taking a snapshot of the cluster state when a single node changes between solves
+func BenchmarkClusterSnapshot(b *testing.B) {
+	benchmarkStateNodes(b, func(c *state.Cluster) []*state.Node {
+		return c.Snapshot().Nodes()
+	})
+}
+
+func benchmarkStateNodes(b *testing.B, stateNodes func(c *state.Cluster) []*state.Node) {
+	env := test.NewEnvironment(scheme.Scheme, apis.CRDs...)
+	defer func() {
+		if err := env.Stop(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+	ctx := settings.ToContext(context.Background(), test.Settings())
+	cloudProvider := fake.NewCloudProvider()
+	cloudProvider.InstanceTypes = fake.InstanceTypesAssorted()
+	cluster := state.NewCluster(ctx, &clock.RealClock{}, env.Client, cloudProvider)
+
+	for _, nodeCount := range []int{100, 1000} {
+		cluster.Reset(ctx)
+		var nodes []*v1.Node
+		for i := 0; i < nodeCount; i++ {
+			node := test.Node(test.NodeOptions{
+				ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			})
+			if err := cluster.UpdateNode(ctx, node); err != nil {
+				b.Fatal(err)
+			}
+			nodes = append(nodes, node)
+		}
+		b.Run(fmt.Sprintf("%d nodes", nodeCount), func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// a node is updated between each solve, as it would be in a live cluster
+				b.StopTimer()
+				if err := cluster.UpdateNode(ctx, nodes[i%len(nodes)]); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if len(stateNodes(cluster)) != nodeCount {
+					b.FailNow()
+				}
+			}
+		})
+	}
+}
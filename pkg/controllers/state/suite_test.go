@@ -18,10 +18,13 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/samber/lo"
 	clock "k8s.io/utils/clock/testing"
 	"knative.dev/pkg/ptr"
 
@@ -31,6 +34,7 @@ import (
 	"github.com/aws/karpenter-core/pkg/operator/controller"
 	"github.com/aws/karpenter-core/pkg/operator/scheme"
 
+	"github.com/aws/karpenter-core/pkg/cloudprovider"
 	"github.com/aws/karpenter-core/pkg/cloudprovider/fake"
 
 	v1 "k8s.io/api/core/v1"
@@ -236,6 +240,157 @@ var _ = Describe("Node Resource Level", func() {
 		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod1))
 		ExpectNodeResourceRequest(node, v1.ResourceCPU, "0")
 	})
+	It("should update requests if the pod is resized in place", func() {
+		pod1 := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1.5"),
+				}},
+		})
+		pod2 := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("2"),
+				}},
+		})
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, pod1, pod2)
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		ExpectManualBinding(ctx, env.Client, pod1, node)
+		ExpectManualBinding(ctx, env.Client, pod2, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod1))
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod2))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "3.5")
+
+		// increase the CPU request of the bound pod in place
+		oldConsolidationState := cluster.ClusterConsolidationState()
+		fakeClock.Step(time.Minute)
+		pod1 = ExpectPodExists(ctx, env.Client, pod1.Name, pod1.Namespace)
+		pod1.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = resource.MustParse("2")
+		pod1.ResourceVersion = "resized"
+		Expect(cluster.UpdatePod(ctx, pod1)).To(Succeed())
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "4")
+		ExpectNodePodTotalRequests(node, v1.ResourceCPU, "4")
+		Expect(oldConsolidationState).To(BeNumerically("<", cluster.ClusterConsolidationState()))
+
+		// and decrease it again
+		pod1.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = resource.MustParse("500m")
+		pod1.ResourceVersion = "resized-again"
+		Expect(cluster.UpdatePod(ctx, pod1)).To(Succeed())
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "2.5")
+		ExpectNodePodTotalRequests(node, v1.ResourceCPU, "2.5")
+
+		// the resized requests are released when the pod is deleted
+		ExpectDeleted(ctx, env.Client, pod1)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod1))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "2")
+	})
+	It("should not change consolidation state if a bound pod is updated without resizing", func() {
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1.5"),
+				}},
+		})
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+
+		oldConsolidationState := cluster.ClusterConsolidationState()
+		fakeClock.Step(time.Minute)
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+		pod.Labels = map[string]string{"foo": "bar"}
+		pod.ResourceVersion = "relabeled"
+		Expect(cluster.UpdatePod(ctx, pod)).To(Succeed())
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1.5")
+		Expect(cluster.ClusterConsolidationState()).To(Equal(oldConsolidationState))
+	})
+	It("should skip updates for a version of the pod that has already been processed", func() {
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1.5"),
+				}},
+		})
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1.5")
+
+		// Rapid reconciles of the same version are no-ops. The requests are changed without changing the resource
+		// version so that we can tell if the pod's usage was recomputed.
+		oldConsolidationState := cluster.ClusterConsolidationState()
+		fakeClock.Step(time.Minute)
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+		pod.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = resource.MustParse("3")
+		for i := 0; i < 10; i++ {
+			Expect(cluster.UpdatePod(ctx, pod)).To(Succeed())
+			ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		}
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1.5")
+		Expect(cluster.ClusterConsolidationState()).To(Equal(oldConsolidationState))
+
+		// a new version of the pod is processed
+		pod.ResourceVersion = "resized"
+		Expect(cluster.UpdatePod(ctx, pod)).To(Succeed())
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "3")
+	})
+	It("should process a pod that is re-created with the same name", func() {
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1.5"),
+				}},
+		})
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+
+		ExpectDeleted(ctx, env.Client, pod)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "0")
+
+		// even if we were to observe the same resource version again, the deleted pod has been forgotten
+		Expect(cluster.UpdatePod(ctx, pod)).To(Succeed())
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1.5")
+	})
 	It("should not add requests if the pod is terminal", func() {
 		pod1 := test.UnschedulablePod(test.PodOptions{
 			ResourceRequirements: v1.ResourceRequirements{
@@ -562,6 +717,184 @@ var _ = Describe("Node Resource Level", func() {
 			g.Expect(calledFunc2.Load()).To(BeTrue())
 		}, time.Second*30).Should(Succeed())
 	})
+	It("should release node nominations when a node is deleted", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		cluster.NominateNodeForPod(node.Name)
+		Expect(cluster.IsNodeNominated(node.Name)).To(BeTrue())
+
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(cluster.IsNodeNominated(node.Name)).To(BeFalse())
+	})
+	It("should release node nominations when the nominated pods bind to another node", func() {
+		nominated := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		other := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		pod := test.UnschedulablePod()
+		ExpectApplied(ctx, env.Client, pod, nominated, other)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nominated))
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(other))
+
+		cluster.NominateNodeForPod(nominated.Name, pod)
+		Expect(cluster.IsNodeNominated(nominated.Name)).To(BeTrue())
+
+		ExpectManualBinding(ctx, env.Client, pod, other)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		Expect(cluster.IsNodeNominated(nominated.Name)).To(BeFalse())
+	})
+	It("should keep node nominations while other nominated pods are pending", func() {
+		nominated := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		other := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		pod1 := test.UnschedulablePod()
+		pod2 := test.UnschedulablePod()
+		ExpectApplied(ctx, env.Client, pod1, pod2, nominated, other)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nominated))
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(other))
+
+		cluster.NominateNodeForPod(nominated.Name, pod1, pod2)
+
+		ExpectManualBinding(ctx, env.Client, pod1, other)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod1))
+		Expect(cluster.IsNodeNominated(nominated.Name)).To(BeTrue())
+
+		ExpectManualBinding(ctx, env.Client, pod2, other)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod2))
+		Expect(cluster.IsNodeNominated(nominated.Name)).To(BeFalse())
+	})
+	It("should keep node nominations when the nominated pods bind to the nominated node", func() {
+		nominated := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		pod := test.UnschedulablePod()
+		ExpectApplied(ctx, env.Client, pod, nominated)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nominated))
+
+		cluster.NominateNodeForPod(nominated.Name, pod)
+
+		ExpectManualBinding(ctx, env.Client, pod, nominated)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		Expect(cluster.IsNodeNominated(nominated.Name)).To(BeTrue())
+	})
+	It("should release pod bindings when a node is deleted", func() {
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1.5"),
+				}},
+		})
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		ExpectApplied(ctx, env.Client, pod, node)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1.5")
+
+		// the node goes away and a new node with the same name is registered before we see the pod update, the pod
+		// that had been bound to the old node shouldn't be counted against the new node
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectDeleted(ctx, env.Client, pod)
+
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Name: node.Name, Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		pod2 := test.UnschedulablePod(test.PodOptions{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: map[v1.ResourceName]resource.Quantity{
+					v1.ResourceCPU: resource.MustParse("1"),
+				}},
+		})
+		ExpectApplied(ctx, env.Client, node, pod2)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		// a re-created pod with the same name is bound to the new node and must be recorded as a new binding
+		ExpectManualBinding(ctx, env.Client, pod2, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod2))
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "1")
+	})
+	It("should trigger node deletion observers", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("4"),
+			}})
+		var deleted []string
+		mu := sync.Mutex{}
+		cluster.OnNodeDeleted(func(nodeName string) {
+			mu.Lock()
+			defer mu.Unlock()
+			deleted = append(deleted, nodeName)
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		// a repeated deletion of a node that isn't tracked shouldn't notify the observers again
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(deleted).To(ConsistOf(node.Name))
+	})
 })
 
 var _ = Describe("Pod Anti-Affinity", func() {
@@ -765,6 +1098,17 @@ func ExpectNodeResourceRequest(node *v1.Node, resourceName v1.ResourceName, amou
 		return false
 	})
 }
+func ExpectNodePodTotalRequests(node *v1.Node, resourceName v1.ResourceName, amount string) {
+	cluster.ForEachNode(func(n *state.Node) bool {
+		if n.Node.Name != node.Name {
+			return true
+		}
+		podRequests := n.PodTotalRequests[resourceName]
+		expected := resource.MustParse(amount)
+		ExpectWithOffset(1, podRequests.AsApproximateFloat64()).To(BeNumerically("~", expected.AsApproximateFloat64(), 0.001))
+		return false
+	})
+}
 func ExpectNodeDaemonSetRequested(node *v1.Node, resourceName v1.ResourceName, amount string) {
 	cluster.ForEachNode(func(n *state.Node) bool {
 		if n.Node.Name != node.Name {
@@ -786,3 +1130,746 @@ func ExpectNodeDeletionMarked(node *v1.Node) {
 		return false
 	})
 }
+
+var _ = Describe("Initialization Progress", func() {
+	It("should report no progress before anything is reconciled", func() {
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(Equal(0))
+		Expect(total).To(Equal(0))
+	})
+	It("should report progress against all of the nodes discovered in the cluster", func() {
+		nodes := []*v1.Node{test.Node(), test.Node(), test.Node()}
+		for _, node := range nodes {
+			ExpectApplied(ctx, env.Client, node)
+		}
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[0]))
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(Equal(1))
+		Expect(total).To(Equal(3))
+
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[1]))
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[2]))
+		done, total = cluster.InitializationProgress()
+		Expect(done).To(Equal(3))
+		Expect(total).To(Equal(3))
+	})
+	It("should report progress against all of the pods discovered in the cluster", func() {
+		node := test.Node(test.NodeOptions{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}})
+		pods := []*v1.Pod{test.UnschedulablePod(), test.UnschedulablePod()}
+		ExpectApplied(ctx, env.Client, node, pods[0], pods[1])
+		ExpectManualBinding(ctx, env.Client, pods[0], node)
+		ExpectManualBinding(ctx, env.Client, pods[1], node)
+
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pods[0]))
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(Equal(2))
+		Expect(total).To(Equal(3))
+
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pods[1]))
+		done, total = cluster.InitializationProgress()
+		Expect(done).To(Equal(3))
+		Expect(total).To(Equal(3))
+	})
+	It("should stop waiting for nodes that are deleted before they're reconciled", func() {
+		nodes := []*v1.Node{test.Node(), test.Node()}
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1])
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[0]))
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(Equal(1))
+		Expect(total).To(Equal(2))
+
+		ExpectDeleted(ctx, env.Client, nodes[1])
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[1]))
+		done, total = cluster.InitializationProgress()
+		Expect(done).To(Equal(1))
+		Expect(total).To(Equal(1))
+	})
+	It("should track nodes that are created after initialization", func() {
+		node := test.Node()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		node = test.Node()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		done, total := cluster.InitializationProgress()
+		Expect(done).To(Equal(2))
+		Expect(total).To(Equal(2))
+	})
+})
+
+var _ = Describe("Cluster Totals", func() {
+	ExpectQuantity := func(resources v1.ResourceList, resourceName v1.ResourceName, amount string) {
+		quantity := resources[resourceName]
+		expected := resource.MustParse(amount)
+		ExpectWithOffset(1, quantity.AsApproximateFloat64()).To(BeNumerically("~", expected.AsApproximateFloat64(), 0.001))
+	}
+	// initialized nodes report their own allocatable resources rather than those of their instance type
+	initializedNode := func(allocatable v1.ResourceList) *v1.Node {
+		return test.Node(test.NodeOptions{
+			ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+			Allocatable: allocatable,
+		})
+	}
+	It("should be empty without any nodes", func() {
+		totals := cluster.Totals()
+		Expect(totals.Nodes).To(BeZero())
+		Expect(totals.Allocatable).To(BeEmpty())
+		Expect(totals.DaemonSetRequested).To(BeEmpty())
+		Expect(totals.PodRequested).To(BeEmpty())
+	})
+	It("should sum the allocatable resources of the nodes", func() {
+		nodes := []*v1.Node{
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")}),
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourceMemory: resource.MustParse("16Gi")}),
+		}
+		for _, node := range nodes {
+			ExpectApplied(ctx, env.Client, node)
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		totals := cluster.Totals()
+		Expect(totals.Nodes).To(Equal(2))
+		ExpectQuantity(totals.Allocatable, v1.ResourceCPU, "12")
+		ExpectQuantity(totals.Allocatable, v1.ResourceMemory, "24Gi")
+	})
+	It("should sum the requests of the pods bound to the nodes", func() {
+		nodes := []*v1.Node{
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}),
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}),
+		}
+		pods := []*v1.Pod{
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1.5")}}}),
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}}),
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}),
+		}
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], pods[0], pods[1], pods[2])
+		ExpectManualBinding(ctx, env.Client, pods[0], nodes[0])
+		ExpectManualBinding(ctx, env.Client, pods[1], nodes[1])
+		for _, node := range nodes {
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		for _, pod := range pods {
+			ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		}
+
+		// the third pod isn't bound, so it isn't counted
+		totals := cluster.Totals()
+		ExpectQuantity(totals.Allocatable, v1.ResourceCPU, "8")
+		ExpectQuantity(totals.PodRequested, v1.ResourceCPU, "3.5")
+		ExpectQuantity(totals.DaemonSetRequested, v1.ResourceCPU, "0")
+	})
+	It("should sum the daemonset requests separately", func() {
+		ds := test.DaemonSet()
+		ExpectApplied(ctx, env.Client, ds)
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
+		node := initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		pod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1.5")}}})
+		dsPod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}})
+		dsPod.OwnerReferences = append(dsPod.OwnerReferences, metav1.OwnerReference{
+			APIVersion:         "apps/v1",
+			Kind:               "DaemonSet",
+			Name:               ds.Name,
+			UID:                ds.UID,
+			Controller:         ptr.Bool(true),
+			BlockOwnerDeletion: ptr.Bool(true),
+		})
+		ExpectApplied(ctx, env.Client, node, pod, dsPod)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectManualBinding(ctx, env.Client, dsPod, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(dsPod))
+
+		totals := cluster.Totals()
+		ExpectQuantity(totals.DaemonSetRequested, v1.ResourceCPU, "1")
+		ExpectQuantity(totals.PodRequested, v1.ResourceCPU, "2.5")
+	})
+	It("should update as pods and nodes are deleted", func() {
+		nodes := []*v1.Node{
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}),
+			initializedNode(v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}),
+		}
+		pods := []*v1.Pod{
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}),
+			test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}}),
+		}
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], pods[0], pods[1])
+		ExpectManualBinding(ctx, env.Client, pods[0], nodes[0])
+		ExpectManualBinding(ctx, env.Client, pods[1], nodes[1])
+		for _, node := range nodes {
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		for _, pod := range pods {
+			ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		}
+		totals := cluster.Totals()
+		Expect(totals.Nodes).To(Equal(2))
+		ExpectQuantity(totals.Allocatable, v1.ResourceCPU, "12")
+		ExpectQuantity(totals.PodRequested, v1.ResourceCPU, "3")
+
+		ExpectDeleted(ctx, env.Client, pods[0])
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pods[0]))
+		totals = cluster.Totals()
+		Expect(totals.Nodes).To(Equal(2))
+		ExpectQuantity(totals.PodRequested, v1.ResourceCPU, "2")
+
+		ExpectDeleted(ctx, env.Client, nodes[1])
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(nodes[1]))
+		totals = cluster.Totals()
+		Expect(totals.Nodes).To(Equal(1))
+		ExpectQuantity(totals.Allocatable, v1.ResourceCPU, "4")
+		ExpectQuantity(totals.PodRequested, v1.ResourceCPU, "0")
+	})
+})
+
+var _ = Describe("Provider ID Lookup", func() {
+	It("should find nodes by their provider ID", func() {
+		nodes := []*v1.Node{
+			test.Node(test.NodeOptions{ProviderID: "fake:///test-zone-1/i-1"}),
+			test.Node(test.NodeOptions{ProviderID: "fake:///test-zone-1/i-2"}),
+		}
+		for _, node := range nodes {
+			ExpectApplied(ctx, env.Client, node)
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		for _, node := range nodes {
+			n, ok := cluster.NodeByProviderID(node.Spec.ProviderID)
+			Expect(ok).To(BeTrue())
+			Expect(n.Node.Name).To(Equal(node.Name))
+		}
+		_, ok := cluster.NodeByProviderID("fake:///test-zone-1/i-3")
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.NodeByProviderID("")
+		Expect(ok).To(BeFalse())
+	})
+	It("should find nodes once their provider ID is populated", func() {
+		node := test.Node()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		_, ok := cluster.NodeByProviderID("fake:///test-zone-1/i-1")
+		Expect(ok).To(BeFalse())
+
+		node.Spec.ProviderID = "fake:///test-zone-1/i-1"
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		n, ok := cluster.NodeByProviderID("fake:///test-zone-1/i-1")
+		Expect(ok).To(BeTrue())
+		Expect(n.Node.Name).To(Equal(node.Name))
+	})
+	It("should stop finding nodes once they're deleted", func() {
+		node := test.Node(test.NodeOptions{ProviderID: "fake:///test-zone-1/i-1"})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		_, ok := cluster.NodeByProviderID(node.Spec.ProviderID)
+		Expect(ok).To(BeTrue())
+
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		_, ok = cluster.NodeByProviderID(node.Spec.ProviderID)
+		Expect(ok).To(BeFalse())
+	})
+	It("should return a copy of the node", func() {
+		node := test.Node(test.NodeOptions{ProviderID: "fake:///test-zone-1/i-1"})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		n, ok := cluster.NodeByProviderID(node.Spec.ProviderID)
+		Expect(ok).To(BeTrue())
+		n.Node.Labels["test"] = "test"
+		n, ok = cluster.NodeByProviderID(node.Spec.ProviderID)
+		Expect(ok).To(BeTrue())
+		Expect(n.Node.Labels).ToNot(HaveKey("test"))
+	})
+})
+
+var _ = Describe("Capacity Reservations", func() {
+	ExpectQuantity := func(resources v1.ResourceList, resourceName v1.ResourceName, amount string) {
+		quantity := resources[resourceName]
+		expected := resource.MustParse(amount)
+		ExpectWithOffset(1, quantity.AsApproximateFloat64()).To(BeNumerically("~", expected.AsApproximateFloat64(), 0.001))
+	}
+	It("should sum the reserved capacity per provisioner", func() {
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("1Gi")})
+		cluster.Reserve("other", v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+		reserved := cluster.Reserved()
+		Expect(reserved).To(HaveLen(2))
+		ExpectQuantity(reserved["default"], v1.ResourceCPU, "6")
+		ExpectQuantity(reserved["default"], v1.ResourceMemory, "1Gi")
+		ExpectQuantity(reserved["other"], v1.ResourceCPU, "1")
+	})
+	It("should not count released reservations", func() {
+		id := cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+		cluster.Release(id)
+		ExpectQuantity(cluster.Reserved()["default"], v1.ResourceCPU, "2")
+		// releasing twice is a no-op
+		cluster.Release(id)
+		ExpectQuantity(cluster.Reserved()["default"], v1.ResourceCPU, "2")
+	})
+	It("should not count expired reservations", func() {
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		fakeClock.Step(state.ReservationTTL / 2)
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+		ExpectQuantity(cluster.Reserved()["default"], v1.ResourceCPU, "6")
+		fakeClock.Step(state.ReservationTTL / 2)
+		ExpectQuantity(cluster.Reserved()["default"], v1.ResourceCPU, "2")
+		fakeClock.Step(state.ReservationTTL / 2)
+		Expect(cluster.Reserved()).To(BeEmpty())
+	})
+	It("should clear reservations on reset", func() {
+		cluster.Reserve("default", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+		cluster.Reset(ctx)
+		Expect(cluster.Reserved()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Snapshot", func() {
+	ExpectQuantity := func(resources v1.ResourceList, resourceName v1.ResourceName, amount string) {
+		quantity := resources[resourceName]
+		expected := resource.MustParse(amount)
+		ExpectWithOffset(1, quantity.AsApproximateFloat64()).To(BeNumerically("~", expected.AsApproximateFloat64(), 0.001))
+	}
+	initializedNode := func() *v1.Node {
+		return test.Node(test.NodeOptions{
+			ObjectMeta:  metav1.ObjectMeta{Labels: map[string]string{v1alpha5.LabelNodeInitialized: "true"}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		})
+	}
+	podRequesting := func(cpu string) *v1.Pod {
+		return test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}}})
+	}
+	snapshotNode := func(snapshot *state.Snapshot, name string) *state.Node {
+		var found *state.Node
+		snapshot.ForEachNode(func(n *state.Node) bool {
+			if n.Node.Name == name {
+				found = n
+				return false
+			}
+			return true
+		})
+		return found
+	}
+	It("should match the live state", func() {
+		nodes := []*v1.Node{initializedNode(), initializedNode(), initializedNode()}
+		pods := []*v1.Pod{podRequesting("1"), podRequesting("2")}
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], nodes[2], pods[0], pods[1])
+		ExpectManualBinding(ctx, env.Client, pods[0], nodes[0])
+		ExpectManualBinding(ctx, env.Client, pods[1], nodes[1])
+		for _, node := range nodes {
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		for _, pod := range pods {
+			ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		}
+		cluster.MarkForDeletion(nodes[2].Name)
+
+		var live []*state.Node
+		cluster.ForEachNode(func(n *state.Node) bool {
+			live = append(live, n.DeepCopy())
+			return true
+		})
+		snapshot := cluster.Snapshot().Nodes()
+		Expect(snapshot).To(HaveLen(len(live)))
+		for i := range live {
+			Expect(snapshot[i].Node.Name).To(Equal(live[i].Node.Name))
+			Expect(snapshot[i].MarkedForDeletion).To(Equal(live[i].MarkedForDeletion))
+			Expect(snapshot[i].Available).To(Equal(live[i].Available))
+			Expect(snapshot[i].PodTotalRequests).To(Equal(live[i].PodTotalRequests))
+		}
+	})
+	It("should be reused until the cluster state changes", func() {
+		node := initializedNode()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		snapshot := cluster.Snapshot()
+		Expect(cluster.Snapshot()).To(BeIdenticalTo(snapshot))
+
+		cluster.MarkForDeletion(node.Name)
+		Expect(cluster.Snapshot()).ToNot(BeIdenticalTo(snapshot))
+		Expect(cluster.Snapshot().Nodes()[0].MarkedForDeletion).To(BeTrue())
+	})
+	It("should not reflect changes made after it was taken", func() {
+		node := initializedNode()
+		pod := podRequesting("1")
+		ExpectApplied(ctx, env.Client, node, pod)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		snapshot := cluster.Snapshot()
+
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectQuantity(snapshotNode(snapshot, node.Name).Available, v1.ResourceCPU, "4")
+		ExpectQuantity(snapshotNode(cluster.Snapshot(), node.Name).Available, v1.ResourceCPU, "3")
+
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(snapshotNode(snapshot, node.Name)).ToNot(BeNil())
+		Expect(cluster.Snapshot().Nodes()).To(BeEmpty())
+	})
+	It("should only copy the nodes that have changed", func() {
+		nodes := []*v1.Node{initializedNode(), initializedNode()}
+		pod := podRequesting("1")
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], pod)
+		for _, node := range nodes {
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+		snapshot := cluster.Snapshot()
+
+		ExpectManualBinding(ctx, env.Client, pod, nodes[0])
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		updated := cluster.Snapshot()
+		Expect(snapshotNode(updated, nodes[0].Name)).ToNot(BeIdenticalTo(snapshotNode(snapshot, nodes[0].Name)))
+		Expect(snapshotNode(updated, nodes[1].Name)).To(BeIdenticalTo(snapshotNode(snapshot, nodes[1].Name)))
+	})
+	It("should be cleared on reset", func() {
+		node := initializedNode()
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(cluster.Snapshot().Nodes()).To(HaveLen(1))
+		cluster.Reset(ctx)
+		Expect(cluster.Snapshot().Nodes()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Transient Errors", func() {
+	var node *v1.Node
+	BeforeEach(func() {
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+		})
+		ExpectApplied(ctx, env.Client, node)
+	})
+	ExpectNodeTracked := func(node *v1.Node) {
+		tracked := false
+		cluster.ForEachNode(func(n *state.Node) bool {
+			if n.Node.Name == node.Name {
+				tracked = true
+				ExpectWithOffset(1, n.Capacity.Cpu().IsZero()).To(BeFalse())
+				return false
+			}
+			return true
+		})
+		ExpectWithOffset(1, tracked).To(BeTrue())
+	}
+	It("should retry a node update that failed transiently", func() {
+		cloudProvider.GetInstanceTypesErrors = []error{cloudprovider.NewTransientError(fmt.Errorf("throttled"))}
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(cloudProvider.GetInstanceTypesErrors).To(BeEmpty())
+		ExpectNodeTracked(node)
+	})
+	It("should retry a pod update that failed transiently", func() {
+		pod := test.UnschedulablePod()
+		ExpectApplied(ctx, env.Client, pod)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		cloudProvider.GetInstanceTypesErrors = []error{cloudprovider.NewTransientError(fmt.Errorf("throttled"))}
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		Expect(cloudProvider.GetInstanceTypesErrors).To(BeEmpty())
+		ExpectNodeTracked(node)
+	})
+	It("should give up after a bounded number of transient failures", func() {
+		cloudProvider.GetInstanceTypesErrors = []error{
+			cloudprovider.NewTransientError(fmt.Errorf("throttled")),
+			cloudprovider.NewTransientError(fmt.Errorf("throttled")),
+			cloudprovider.NewTransientError(fmt.Errorf("throttled")),
+			cloudprovider.NewTransientError(fmt.Errorf("throttled")),
+		}
+		ExpectReconcileFailed(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(cloudProvider.GetInstanceTypesErrors).To(HaveLen(1))
+		// the controller requeue picks the update up again
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		ExpectNodeTracked(node)
+	})
+	It("should not retry a permanent failure", func() {
+		cloudProvider.GetInstanceTypesErrors = []error{
+			fmt.Errorf("invalid provisioner"),
+			cloudprovider.NewTransientError(fmt.Errorf("throttled")),
+		}
+		ExpectReconcileFailed(ctx, nodeController, client.ObjectKeyFromObject(node))
+		Expect(cloudProvider.GetInstanceTypesErrors).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Unrecognized Instance Types", func() {
+	stateNode := func(node *v1.Node) *state.Node {
+		var found *state.Node
+		cluster.ForEachNode(func(n *state.Node) bool {
+			if n.Node.Name == node.Name {
+				found = n
+				return false
+			}
+			return true
+		})
+		ExpectWithOffset(1, found).ToNot(BeNil())
+		return found
+	}
+	It("should use the capacity reported by a node whose instance type isn't offered", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       "deprecated-instance-type",
+			}},
+			Allocatable: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("2"),
+			},
+			Capacity: map[v1.ResourceName]resource.Quantity{
+				v1.ResourceCPU: resource.MustParse("3"),
+			},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		n := stateNode(node)
+		Expect(n.UnrecognizedInstanceType).To(BeTrue())
+		Expect(n.Allocatable.Cpu().String()).To(Equal("2"))
+		Expect(n.Capacity.Cpu().String()).To(Equal("3"))
+		Expect(n.Allocatable.Memory().IsZero()).To(BeTrue())
+	})
+	It("should track a node whose instance type isn't offered before it reports any capacity", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       "deprecated-instance-type",
+			}},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		n := stateNode(node)
+		Expect(n.UnrecognizedInstanceType).To(BeTrue())
+		Expect(n.Allocatable.Cpu().IsZero()).To(BeTrue())
+	})
+	It("should not flag a node whose instance type is offered", func() {
+		node := test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+
+		n := stateNode(node)
+		Expect(n.UnrecognizedInstanceType).To(BeFalse())
+		Expect(n.Allocatable.Cpu().IsZero()).To(BeFalse())
+	})
+})
+
+var _ = Describe("Terminating Pods", func() {
+	var node *v1.Node
+	stateNode := func() *state.Node {
+		var found *state.Node
+		cluster.ForEachNode(func(n *state.Node) bool {
+			if n.Node.Name == node.Name {
+				found = n
+				return false
+			}
+			return true
+		})
+		ExpectWithOffset(1, found).ToNot(BeNil())
+		return found
+	}
+	boundPod := func(options test.PodOptions) *v1.Pod {
+		options.ResourceRequirements = v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}
+		pod := test.UnschedulablePod(options)
+		ExpectApplied(ctx, env.Client, pod)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		return pod
+	}
+	// terminate deletes the bound pod, which is left terminating without a kubelet to stop it
+	terminate := func(pod *v1.Pod) *v1.Pod {
+		Expect(env.Client.Delete(ctx, pod)).To(Succeed())
+		pod = ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		return pod
+	}
+	BeforeEach(func() {
+		node = test.Node(test.NodeOptions{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+			}},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		})
+		ExpectApplied(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+	})
+	It("should report the requests of pods terminating since before the time", func() {
+		pod := terminate(boundPod(test.PodOptions{}))
+		boundPod(test.PodOptions{})
+
+		requests := stateNode().TerminatingPodRequests(pod.DeletionTimestamp.Add(time.Second))
+		Expect(requests.Cpu().String()).To(Equal("1"))
+		requests = stateNode().TerminatingPodRequests(pod.DeletionTimestamp.Time)
+		Expect(requests.Cpu().IsZero()).To(BeTrue())
+		// the pod still counts against the node's capacity until it's gone
+		ExpectNodeResourceRequest(node, v1.ResourceCPU, "2")
+	})
+	It("should stop reporting the requests of terminating pods once they're gone", func() {
+		pod := terminate(boundPod(test.PodOptions{}))
+		ExpectDeleted(ctx, env.Client, pod)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+
+		requests := stateNode().TerminatingPodRequests(pod.DeletionTimestamp.Add(time.Hour))
+		Expect(requests.Cpu().IsZero()).To(BeTrue())
+	})
+	It("should not report the requests of terminating daemonset pods", func() {
+		pod := terminate(boundPod(test.PodOptions{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{
+			APIVersion:         "apps/v1",
+			Kind:               "DaemonSet",
+			Name:               "ds",
+			UID:                "8515d8c6-5bd4-4b8b-9f35-ee5e7e8a1d80",
+			Controller:         ptr.Bool(true),
+			BlockOwnerDeletion: ptr.Bool(true),
+		}}}}))
+
+		requests := stateNode().TerminatingPodRequests(pod.DeletionTimestamp.Add(time.Hour))
+		Expect(requests.Cpu().IsZero()).To(BeTrue())
+	})
+})
+
+// blockingClient blocks listing objects of the same type as blocks until it's released
+type blockingClient struct {
+	client.Client
+	blocks  client.ObjectList
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if reflect.TypeOf(list) == reflect.TypeOf(c.blocks) {
+		c.listing <- struct{}{}
+		<-c.release
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+var _ = Describe("Rebuild", func() {
+	var nodes []*v1.Node
+	BeforeEach(func() {
+		nodes = nil
+		for i := 0; i < 3; i++ {
+			nodes = append(nodes, test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+					v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+					v1alpha5.LabelNodeInitialized:    "true",
+				}},
+				ProviderID:  fmt.Sprintf("fake:///test-zone-1/i-%d", i),
+				Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			}))
+		}
+	})
+	boundPod := func(node *v1.Node, cpu string) *v1.Pod {
+		pod := test.UnschedulablePod(test.PodOptions{ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}}})
+		ExpectApplied(ctx, env.Client, pod)
+		ExpectManualBinding(ctx, env.Client, pod, node)
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		return pod
+	}
+	trackedNodes := func() []*state.Node {
+		var tracked []*state.Node
+		cluster.ForEachNode(func(n *state.Node) bool {
+			tracked = append(tracked, n.DeepCopy())
+			return true
+		})
+		return tracked
+	}
+	BeforeEach(func() {
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], nodes[2])
+		for _, node := range nodes {
+			ExpectReconcileSucceeded(ctx, nodeController, client.ObjectKeyFromObject(node))
+		}
+	})
+	It("should match the incrementally maintained state", func() {
+		boundPod(nodes[0], "1")
+		boundPod(nodes[0], "2")
+		boundPod(nodes[1], "1.5")
+		cluster.MarkForDeletion(nodes[2].Name)
+		incremental := trackedNodes()
+
+		Expect(cluster.Rebuild(ctx)).To(Succeed())
+		rebuilt := trackedNodes()
+		Expect(rebuilt).To(HaveLen(len(incremental)))
+		for i := range incremental {
+			Expect(rebuilt[i].Node.Name).To(Equal(incremental[i].Node.Name))
+			Expect(rebuilt[i].MarkedForDeletion).To(Equal(incremental[i].MarkedForDeletion))
+			Expect(rebuilt[i].Available).To(Equal(incremental[i].Available))
+			Expect(rebuilt[i].PodTotalRequests).To(Equal(incremental[i].PodTotalRequests))
+		}
+		for _, node := range nodes {
+			n, ok := cluster.NodeByProviderID(node.Spec.ProviderID)
+			Expect(ok).To(BeTrue())
+			Expect(n.Node.Name).To(Equal(node.Name))
+		}
+	})
+	It("should discard state that has drifted from the API server", func() {
+		pod := boundPod(nodes[0], "3")
+		ExpectNodeResourceRequest(nodes[0], v1.ResourceCPU, "3")
+		// the deletions are never reconciled
+		ExpectDeleted(ctx, env.Client, pod, nodes[1])
+		deleted := make(chan string, 1)
+		cluster.OnNodeDeleted(func(nodeName string) { deleted <- nodeName })
+
+		Expect(cluster.Rebuild(ctx)).To(Succeed())
+		ExpectNodeResourceRequest(nodes[0], v1.ResourceCPU, "0")
+		Expect(lo.Map(trackedNodes(), func(n *state.Node, _ int) string { return n.Node.Name })).To(ConsistOf(nodes[0].Name, nodes[2].Name))
+		Expect(deleted).To(Receive(Equal(nodes[1].Name)))
+		// the pod's deletion is still handled once it's reconciled
+		ExpectReconcileSucceeded(ctx, podController, client.ObjectKeyFromObject(pod))
+		ExpectNodeResourceRequest(nodes[0], v1.ResourceCPU, "0")
+	})
+	It("should leave the state as it was if it can't be rebuilt", func() {
+		boundPod(nodes[0], "1")
+		ExpectApplied(ctx, env.Client, test.Node(test.NodeOptions{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+			v1.LabelInstanceTypeStable:       cloudProvider.InstanceTypes[0].Name,
+		}}}))
+		cloudProvider.GetInstanceTypesErrors = []error{fmt.Errorf("invalid provisioner")}
+
+		Expect(cluster.Rebuild(ctx)).ToNot(Succeed())
+		Expect(trackedNodes()).To(HaveLen(3))
+		ExpectNodeResourceRequest(nodes[0], v1.ResourceCPU, "1")
+	})
+	It("should block readers until the state is rebuilt", func() {
+		c := &blockingClient{Client: env.Client, blocks: &v1.NodeList{}, listing: make(chan struct{}), release: make(chan struct{})}
+		blocked := state.NewCluster(ctx, fakeClock, c, cloudProvider)
+
+		rebuilt := make(chan error, 1)
+		go func() { rebuilt <- blocked.Rebuild(ctx) }()
+		Eventually(c.listing).Should(Receive())
+		snapshotted := make(chan *state.Snapshot, 1)
+		go func() { snapshotted <- blocked.Snapshot() }()
+		Consistently(snapshotted, 500*time.Millisecond).ShouldNot(Receive())
+
+		close(c.release)
+		var snapshot *state.Snapshot
+		Eventually(snapshotted).Should(Receive(&snapshot))
+		Expect(snapshot.Nodes()).To(HaveLen(3))
+		Eventually(rebuilt).Should(Receive(BeNil()))
+	})
+	It("should apply updates made while the state is rebuilt", func() {
+		ExpectApplied(ctx, env.Client, nodes[0], nodes[1], nodes[2])
+		c := &blockingClient{Client: env.Client, blocks: &v1.PodList{}, listing: make(chan struct{}), release: make(chan struct{})}
+		blocked := state.NewCluster(ctx, fakeClock, c, cloudProvider)
+
+		rebuilt := make(chan error, 1)
+		go func() { rebuilt <- blocked.Rebuild(ctx) }()
+		// The nodes have been listed, so the deletion is only observed through the update
+		Eventually(c.listing).Should(Receive())
+		ExpectDeleted(ctx, env.Client, nodes[2])
+		deleted := make(chan struct{})
+		go func() {
+			blocked.DeleteNode(nodes[2].Name)
+			close(deleted)
+		}()
+		Eventually(deleted).Should(BeClosed())
+
+		close(c.release)
+		Eventually(rebuilt).Should(Receive(BeNil()))
+		Expect(blocked.Snapshot().Nodes()).To(HaveLen(2))
+	})
+})
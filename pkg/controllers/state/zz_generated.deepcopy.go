@@ -22,6 +22,7 @@ package state
 import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/aws/karpenter-core/pkg/scheduling"
@@ -119,6 +120,13 @@ func (in *Node) DeepCopyInto(out *Node) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.terminatingPods != nil {
+		in, out := &in.terminatingPods, &out.terminatingPods
+		*out = make(map[types.NamespacedName]metav1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	if in.PodTotalRequests != nil {
 		in, out := &in.PodTotalRequests, &out.PodTotalRequests
 		*out = make(v1.ResourceList, len(*in))
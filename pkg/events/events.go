@@ -16,9 +16,18 @@ package events
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
+	"github.com/aws/karpenter-core/pkg/utils/resources"
 )
 
 // PodNominationRateLimiter is a pointer so it rate-limits across events
@@ -46,15 +55,28 @@ func EvictPod(pod *v1.Pod) Event {
 }
 
 func PodFailedToSchedule(pod *v1.Pod, err error) Event {
+	message := fmt.Sprintf("Failed to schedule pod, %s", err)
+	if pod.Spec.PriorityClassName != "" {
+		message = fmt.Sprintf("Failed to schedule pod with priority class %s, %s", pod.Spec.PriorityClassName, err)
+	}
 	return Event{
 		InvolvedObject: pod,
 		Type:           v1.EventTypeWarning,
 		Reason:         "FailedScheduling",
-		Message:        fmt.Sprintf("Failed to schedule pod, %s", err),
+		Message:        message,
 		DedupeValues:   []string{string(pod.UID), err.Error()},
 	}
 }
 
+// PodTooLargeToSchedule reports a pod whose requests exceed every instance type that could be launched for it. It's
+// deduped for longer than other scheduling failures as it fails identically every time the pod is solved, but is
+// published again once the event has likely expired from the API server.
+func PodTooLargeToSchedule(pod *v1.Pod, err error) Event {
+	evt := PodFailedToSchedule(pod, err)
+	evt.DedupeTimeout = time.Hour
+	return evt
+}
+
 func NodeFailedToDrain(node *v1.Node, err error) Event {
 	return Event{
 		InvolvedObject: node,
@@ -74,3 +96,102 @@ func NodeInflightCheck(node *v1.Node, message string) Event {
 		DedupeValues:   []string{node.Name, message},
 	}
 }
+
+func ProvisionerDaemonSetOverheadExceeded(provisioner *v1alpha5.Provisioner, overhead v1.ResourceList) Event {
+	return Event{
+		InvolvedObject: provisioner,
+		Type:           v1.EventTypeWarning,
+		Reason:         "DaemonSetOverheadExceeded",
+		Message:        fmt.Sprintf("Daemonset overhead %s exceeds the capacity of all instance types, reduce the daemonset requests or allow larger instance types", resources.String(overhead)),
+		DedupeValues:   []string{provisioner.Name},
+	}
+}
+
+// ProvisionerProvisionedNodes reports the nodes that were launched from the provisioner by a single launch
+func ProvisionerProvisionedNodes(provisioner *v1alpha5.Provisioner, nodeNames []string) Event {
+	return Event{
+		InvolvedObject: provisioner,
+		Type:           v1.EventTypeNormal,
+		Reason:         "ProvisionedNodes",
+		Message:        fmt.Sprintf("Provisioned %d node(s), %s", len(nodeNames), strings.Join(nodeNames, ", ")),
+	}
+}
+
+func ProvisionerLimitsExceeded(provisioner *v1alpha5.Provisioner, err error) Event {
+	return Event{
+		InvolvedObject: provisioner,
+		Type:           v1.EventTypeWarning,
+		Reason:         "LimitsExceeded",
+		Message:        fmt.Sprintf("Resource limits exceeded, %s", err),
+		DedupeValues:   []string{provisioner.Name},
+	}
+}
+
+// ProvisionerNoViableInstanceTypes reports a provisioner that can't launch any nodes since none of its instance types
+// have an available offering
+func ProvisionerNoViableInstanceTypes(provisioner *v1alpha5.Provisioner, instanceTypes int) Event {
+	message := "The cloud provider has no instance types for the provisioner"
+	if instanceTypes > 0 {
+		message = fmt.Sprintf("None of the provisioner's %d instance type(s) have an available offering", instanceTypes)
+	}
+	return Event{
+		InvolvedObject: provisioner,
+		Type:           v1.EventTypeWarning,
+		Reason:         "NoViableInstanceTypes",
+		Message:        message,
+		DedupeValues:   []string{provisioner.Name},
+	}
+}
+
+// ProvisioningDecision summarizes the outcome of a scheduling solve in a single event. newNodes counts the new nodes by
+// provisioner and preferred instance type, and failedToSchedule counts the pods that failed to schedule by reason.
+func ProvisioningDecision(obj runtime.Object, scheduledToExistingNodes int, scheduledToNewNodes int, newNodes map[string]int,
+	failedToSchedule map[string]int) Event {
+	newNodeCount := lo.Sum(lo.Values(newNodes))
+	failedCount := lo.Sum(lo.Values(failedToSchedule))
+	annotations := map[string]string{
+		"scheduledToExistingNodes": strconv.Itoa(scheduledToExistingNodes),
+		"scheduledToNewNodes":      strconv.Itoa(scheduledToNewNodes),
+		"failedToSchedule":         strconv.Itoa(failedCount),
+	}
+	if len(newNodes) > 0 {
+		annotations["newNodes"] = counts(newNodes)
+	}
+	if len(failedToSchedule) > 0 {
+		annotations["failureReasons"] = counts(failedToSchedule)
+	}
+	message := fmt.Sprintf("Scheduled %d pod(s) to existing nodes and %d pod(s) to %d new node(s)", scheduledToExistingNodes, scheduledToNewNodes, newNodeCount)
+	if newNodeCount > 0 {
+		message = fmt.Sprintf("%s (%s)", message, annotations["newNodes"])
+	}
+	if failedCount > 0 {
+		message = fmt.Sprintf("%s, %d pod(s) failed to schedule (%s)", message, failedCount, annotations["failureReasons"])
+	}
+	return Event{
+		InvolvedObject: obj,
+		Type:           v1.EventTypeNormal,
+		Reason:         "ProvisioningDecision",
+		Message:        message,
+		Annotations:    annotations,
+	}
+}
+
+// SchedulingFrozen reports a solve that left its pods pending since scheduling is frozen
+func SchedulingFrozen(obj runtime.Object, pods int) Event {
+	return Event{
+		InvolvedObject: obj,
+		Type:           v1.EventTypeWarning,
+		Reason:         "SchedulingFrozen",
+		Message:        fmt.Sprintf("Scheduling is frozen, %d pod(s) were left pending", pods),
+	}
+}
+
+// counts formats the counts as comma separated key=count pairs, sorted by key
+func counts(m map[string]int) string {
+	var pairs []string
+	for key, count := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", key, count))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
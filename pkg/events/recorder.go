@@ -31,7 +31,11 @@ type Event struct {
 	Reason         string
 	Message        string
 	DedupeValues   []string
-	RateLimiter    flowcontrol.RateLimiter
+	// DedupeTimeout is how long identical events are deduped for, defaults to the recorder's if unset
+	DedupeTimeout time.Duration
+	RateLimiter   flowcontrol.RateLimiter
+	// Annotations are optional key/value pairs attached to the event for consumers that need structured data
+	Annotations map[string]string
 }
 
 func (e Event) dedupeKey() string {
@@ -60,20 +64,28 @@ func NewRecorder(r record.EventRecorder) Recorder {
 // Publish creates a Kubernetes event using the passed event struct
 func (r *recorder) Publish(evt Event) {
 	// Dedupe same events that involve the same object and are close together
-	if len(evt.DedupeValues) > 0 && !r.shouldCreateEvent(evt.dedupeKey()) {
+	if len(evt.DedupeValues) > 0 && !r.shouldCreateEvent(evt.dedupeKey(), evt.DedupeTimeout) {
 		return
 	}
 	// If the event is rate-limited, then validate we should create the event
 	if evt.RateLimiter != nil && !evt.RateLimiter.TryAccept() {
 		return
 	}
+	if len(evt.Annotations) > 0 {
+		r.rec.AnnotatedEventf(evt.InvolvedObject, evt.Annotations, evt.Type, evt.Reason, "%s", evt.Message)
+		return
+	}
 	r.rec.Event(evt.InvolvedObject, evt.Type, evt.Reason, evt.Message)
 }
 
-func (r *recorder) shouldCreateEvent(key string) bool {
+func (r *recorder) shouldCreateEvent(key string, timeout time.Duration) bool {
 	if _, exists := r.cache.Get(key); exists {
 		return false
 	}
-	r.cache.SetDefault(key, nil)
+	if timeout > 0 {
+		r.cache.Set(key, nil, timeout)
+	} else {
+		r.cache.SetDefault(key, nil)
+	}
 	return true
 }
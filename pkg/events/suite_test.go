@@ -25,6 +25,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/util/flowcontrol"
 
+	"github.com/aws/karpenter-core/pkg/apis/v1alpha5"
 	"github.com/aws/karpenter-core/pkg/events"
 	"github.com/aws/karpenter-core/pkg/test"
 )
@@ -56,10 +57,67 @@ var _ = Describe("Event Creation", func() {
 		eventRecorder.Publish(events.PodFailedToSchedule(PodWithUID(), fmt.Errorf("")))
 		Expect(internalRecorder.Calls(events.PodFailedToSchedule(PodWithUID(), fmt.Errorf("")).Reason)).To(Equal(1))
 	})
+	It("should include the priority class in the PodFailedToSchedule message", func() {
+		pod := PodWithUID()
+		pod.Spec.PriorityClassName = "high-priority"
+		Expect(events.PodFailedToSchedule(pod, fmt.Errorf("test error")).Message).To(Equal("Failed to schedule pod with priority class high-priority, test error"))
+		Expect(events.PodFailedToSchedule(PodWithUID(), fmt.Errorf("test error")).Message).To(Equal("Failed to schedule pod, test error"))
+	})
+	It("should create a PodTooLargeToSchedule event", func() {
+		evt := events.PodTooLargeToSchedule(PodWithUID(), fmt.Errorf("pod too large for any available instance type"))
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(evt.Type).To(Equal(v1.EventTypeWarning))
+		Expect(evt.Message).To(Equal("Failed to schedule pod, pod too large for any available instance type"))
+		Expect(evt.DedupeTimeout).To(Equal(time.Hour))
+	})
 	It("should create a NodeFailedToDrain event", func() {
 		eventRecorder.Publish(events.NodeFailedToDrain(NodeWithUID(), fmt.Errorf("")))
 		Expect(internalRecorder.Calls(events.NodeFailedToDrain(NodeWithUID(), fmt.Errorf("")).Reason)).To(Equal(1))
 	})
+	It("should create a ProvisionerProvisionedNodes event", func() {
+		evt := events.ProvisionerProvisionedNodes(ProvisionerWithUID(), []string{"node-a", "node-b"})
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(evt.Type).To(Equal(v1.EventTypeNormal))
+		Expect(evt.Message).To(Equal("Provisioned 2 node(s), node-a, node-b"))
+	})
+	It("should create a ProvisionerLimitsExceeded event", func() {
+		evt := events.ProvisionerLimitsExceeded(ProvisionerWithUID(), fmt.Errorf("cpu resource usage of 100 exceeds limit of 20"))
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(evt.Type).To(Equal(v1.EventTypeWarning))
+		Expect(evt.Message).To(Equal("Resource limits exceeded, cpu resource usage of 100 exceeds limit of 20"))
+	})
+	It("should create a ProvisionerNoViableInstanceTypes event", func() {
+		evt := events.ProvisionerNoViableInstanceTypes(ProvisionerWithUID(), 3)
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(evt.Type).To(Equal(v1.EventTypeWarning))
+		Expect(evt.Message).To(Equal("None of the provisioner's 3 instance type(s) have an available offering"))
+		Expect(events.ProvisionerNoViableInstanceTypes(ProvisionerWithUID(), 0).Message).To(Equal("The cloud provider has no instance types for the provisioner"))
+	})
+	It("should create a SchedulingFrozen event", func() {
+		evt := events.SchedulingFrozen(ProvisionerWithUID(), 3)
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(evt.Message).To(Equal("Scheduling is frozen, 3 pod(s) were left pending"))
+	})
+})
+
+var _ = Describe("Annotations", func() {
+	It("should attach annotations to the event", func() {
+		evt := events.PodFailedToSchedule(PodWithUID(), fmt.Errorf(""))
+		evt.Annotations = map[string]string{"provisioners": "default"}
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		Expect(internalRecorder.Annotations(evt.Reason)).To(Equal(map[string]string{"provisioners": "default"}))
+	})
+	It("should not attach annotations to events without them", func() {
+		eventRecorder.Publish(events.EvictPod(PodWithUID()))
+		Expect(internalRecorder.Calls(events.EvictPod(PodWithUID()).Reason)).To(Equal(1))
+		Expect(internalRecorder.Annotations(events.EvictPod(PodWithUID()).Reason)).To(BeNil())
+	})
 })
 
 var _ = Describe("Dedupe", func() {
@@ -70,6 +128,33 @@ var _ = Describe("Dedupe", func() {
 		}
 		Expect(internalRecorder.Calls(events.EvictPod(PodWithUID()).Reason)).To(Equal(1))
 	})
+	It("should only create a single warning per provisioner when many are created quickly", func() {
+		provisioner := ProvisionerWithUID()
+		for i := 0; i < 100; i++ {
+			eventRecorder.Publish(events.ProvisionerLimitsExceeded(provisioner, fmt.Errorf("")))
+			eventRecorder.Publish(events.ProvisionerNoViableInstanceTypes(provisioner, 1))
+		}
+		Expect(internalRecorder.Calls(events.ProvisionerLimitsExceeded(provisioner, fmt.Errorf("")).Reason)).To(Equal(1))
+		Expect(internalRecorder.Calls(events.ProvisionerNoViableInstanceTypes(provisioner, 1).Reason)).To(Equal(1))
+	})
+	It("should dedupe events for their dedupe timeout", func() {
+		pod := PodWithUID()
+		evt := events.PodFailedToSchedule(pod, fmt.Errorf("test error"))
+		evt.DedupeTimeout = 100 * time.Millisecond
+		eventRecorder.Publish(evt)
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(1))
+		time.Sleep(200 * time.Millisecond)
+		eventRecorder.Publish(evt)
+		Expect(internalRecorder.Calls(evt.Reason)).To(Equal(2))
+	})
+	It("should only create a single event for a pod that is too large when many are created", func() {
+		pod := PodWithUID()
+		for i := 0; i < 100; i++ {
+			eventRecorder.Publish(events.PodTooLargeToSchedule(pod, fmt.Errorf("pod too large for any available instance type")))
+		}
+		Expect(internalRecorder.Calls(events.PodTooLargeToSchedule(pod, fmt.Errorf("")).Reason)).To(Equal(1))
+	})
 	It("should allow events with different entities to be created", func() {
 		for i := 0; i < 100; i++ {
 			eventRecorder.Publish(events.EvictPod(PodWithUID()))
@@ -107,3 +192,9 @@ func NodeWithUID() *v1.Node {
 	n.UID = uuid.NewUUID()
 	return n
 }
+
+func ProvisionerWithUID() *v1alpha5.Provisioner {
+	p := test.Provisioner()
+	p.UID = uuid.NewUUID()
+	return p
+}
@@ -16,12 +16,14 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"strings"
 
 	"github.com/samber/lo"
 	"go.uber.org/multierr"
 	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -105,18 +107,62 @@ func (t *typedDecorator[T]) patch(ctx context.Context, obj, updated client.Objec
 	// Patch Body if changed
 	if !bodyEqual(obj, updated) {
 		if err := t.kubeClient.Patch(ctx, updated, client.MergeFrom(obj)); err != nil {
-			return err
+			return newPatchError(err)
 		}
 	}
 	// Patch Status if changed
 	if !statusEqual(obj, updated) {
 		if err := t.kubeClient.Status().Patch(ctx, updated, client.MergeFrom(obj)); err != nil {
-			return err
+			return newPatchError(err)
 		}
 	}
 	return nil
 }
 
+// PatchConflictError is returned when a patch fails because the object was modified since it was read. The patch is
+// expected to succeed once the object is reconciled again against its latest version.
+type PatchConflictError struct {
+	error
+}
+
+func (e *PatchConflictError) Unwrap() error {
+	return e.error
+}
+
+// IsPatchConflictError returns true if any error in the chain is a PatchConflictError
+func IsPatchConflictError(err error) bool {
+	var conflictErr *PatchConflictError
+	return errors.As(err, &conflictErr)
+}
+
+// PatchValidationError is returned when a patch is rejected because the patched object is invalid. The patch isn't
+// expected to succeed if retried.
+type PatchValidationError struct {
+	error
+}
+
+func (e *PatchValidationError) Unwrap() error {
+	return e.error
+}
+
+// IsPatchValidationError returns true if any error in the chain is a PatchValidationError
+func IsPatchValidationError(err error) bool {
+	var validationErr *PatchValidationError
+	return errors.As(err, &validationErr)
+}
+
+// newPatchError wraps a patch failure in the typed error of its cause, other failures are returned as is
+func newPatchError(err error) error {
+	switch {
+	case apierrors.IsConflict(err):
+		return &PatchConflictError{error: err}
+	case apierrors.IsInvalid(err):
+		return &PatchValidationError{error: err}
+	default:
+		return err
+	}
+}
+
 // bodyEqual compares two objects, ignoring their status and determines if they are deeply-equal
 func bodyEqual(a, b client.Object) bool {
 	unstructuredA := lo.Must(runtime.DefaultUnstructuredConverter.ToUnstructured(a))
@@ -16,10 +16,14 @@ package controller_test
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
 
 	"github.com/samber/lo"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -135,8 +139,174 @@ var _ = Describe("Typed", func() {
 		ExpectReconcileSucceeded(ctx, typedController, client.ObjectKeyFromObject(node))
 		ExpectNotFound(ctx, env.Client, node)
 	})
+	Context("Patching", func() {
+		var node *v1.Node
+		var kubeClient *CountingClient
+		BeforeEach(func() {
+			node = test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						v1alpha5.ProvisionerNameLabelKey: "default",
+					},
+				},
+			})
+			ExpectApplied(ctx, env.Client, node)
+			kubeClient = &CountingClient{Client: env.Client}
+		})
+		It("should not patch when nothing changed", func() {
+			typedController := controller.Typed[*v1.Node](kubeClient, &FakeTypedController[*v1.Node]{})
+			ExpectReconcileSucceeded(ctx, typedController, client.ObjectKeyFromObject(node))
+			Expect(kubeClient.Patches).To(Equal(0))
+			Expect(kubeClient.StatusPatches).To(Equal(0))
+		})
+		It("should only patch the body when only the body changed", func() {
+			typedController := controller.Typed[*v1.Node](kubeClient, &FakeTypedController[*v1.Node]{
+				ReconcileAssertions: []TypedReconcileAssertion[*v1.Node]{
+					func(ctx context.Context, n *v1.Node) {
+						n.Labels = lo.Assign(n.Labels, map[string]string{"custom-key": "custom-value"})
+					},
+				},
+			})
+			ExpectReconcileSucceeded(ctx, typedController, client.ObjectKeyFromObject(node))
+			Expect(kubeClient.Patches).To(Equal(1))
+			Expect(kubeClient.StatusPatches).To(Equal(0))
+			Expect(ExpectNodeExists(ctx, env.Client, node.Name).Labels).To(HaveKeyWithValue("custom-key", "custom-value"))
+		})
+		It("should only patch the status when only the status changed", func() {
+			typedController := controller.Typed[*v1.Node](kubeClient, &FakeTypedController[*v1.Node]{
+				ReconcileAssertions: []TypedReconcileAssertion[*v1.Node]{
+					func(ctx context.Context, n *v1.Node) {
+						n.Status.Phase = v1.NodeRunning
+					},
+				},
+			})
+			ExpectReconcileSucceeded(ctx, typedController, client.ObjectKeyFromObject(node))
+			Expect(kubeClient.Patches).To(Equal(0))
+			Expect(kubeClient.StatusPatches).To(Equal(1))
+			Expect(ExpectNodeExists(ctx, env.Client, node.Name).Status.Phase).To(Equal(v1.NodeRunning))
+		})
+		It("should patch the body and the status once each when both changed", func() {
+			// The status is a subresource, so it can't be updated in the same call as the body
+			typedController := controller.Typed[*v1.Node](kubeClient, &FakeTypedController[*v1.Node]{
+				ReconcileAssertions: []TypedReconcileAssertion[*v1.Node]{
+					func(ctx context.Context, n *v1.Node) {
+						n.Labels = lo.Assign(n.Labels, map[string]string{"custom-key": "custom-value"})
+						n.Status.Phase = v1.NodeRunning
+					},
+				},
+			})
+			ExpectReconcileSucceeded(ctx, typedController, client.ObjectKeyFromObject(node))
+			Expect(kubeClient.Patches).To(Equal(1))
+			Expect(kubeClient.StatusPatches).To(Equal(1))
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(node.Labels).To(HaveKeyWithValue("custom-key", "custom-value"))
+			Expect(node.Status.Phase).To(Equal(v1.NodeRunning))
+		})
+	})
+	Context("Patch Errors", func() {
+		var node *v1.Node
+		BeforeEach(func() {
+			node = test.Node(test.NodeOptions{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						v1alpha5.ProvisionerNameLabelKey: "default",
+					},
+				},
+			})
+			ExpectApplied(ctx, env.Client, node)
+		})
+		reconcileWith := func(patchErr error, modify func(*v1.Node)) error {
+			typedController := controller.Typed[*v1.Node](&FailingPatchClient{Client: env.Client, Err: patchErr}, &FakeTypedController[*v1.Node]{
+				ReconcileAssertions: []TypedReconcileAssertion[*v1.Node]{
+					func(ctx context.Context, n *v1.Node) { modify(n) },
+				},
+			})
+			_, err := typedController.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(node)})
+			return err
+		}
+		addLabel := func(n *v1.Node) { n.Labels = lo.Assign(n.Labels, map[string]string{"custom-key": "custom-value"}) }
+		It("should return a conflict error when the body patch conflicts", func() {
+			conflict := errors.NewConflict(v1.Resource("nodes"), node.Name, fmt.Errorf("the object has been modified"))
+			err := reconcileWith(conflict, addLabel)
+			Expect(controller.IsPatchConflictError(err)).To(BeTrue())
+			Expect(controller.IsPatchValidationError(err)).To(BeFalse())
+			Expect(errors.IsConflict(err)).To(BeTrue())
+			Expect(stderrors.Unwrap(err)).To(BeIdenticalTo(conflict))
+		})
+		It("should return a validation error when the patched object is invalid", func() {
+			invalid := errors.NewInvalid(v1.SchemeGroupVersion.WithKind("Node").GroupKind(), node.Name, field.ErrorList{
+				field.Invalid(field.NewPath("metadata", "labels"), "custom-value", "invalid label"),
+			})
+			err := reconcileWith(invalid, addLabel)
+			Expect(controller.IsPatchValidationError(err)).To(BeTrue())
+			Expect(controller.IsPatchConflictError(err)).To(BeFalse())
+			Expect(errors.IsInvalid(err)).To(BeTrue())
+			Expect(stderrors.Unwrap(err)).To(BeIdenticalTo(invalid))
+		})
+		It("should return a conflict error when the status patch conflicts", func() {
+			conflict := errors.NewConflict(v1.Resource("nodes"), node.Name, fmt.Errorf("the object has been modified"))
+			err := reconcileWith(conflict, func(n *v1.Node) { n.Status.Phase = v1.NodeRunning })
+			Expect(controller.IsPatchConflictError(err)).To(BeTrue())
+		})
+		It("should return other patch errors as is", func() {
+			unavailable := errors.NewServiceUnavailable("unavailable")
+			err := reconcileWith(unavailable, addLabel)
+			Expect(err).To(BeIdenticalTo(unavailable))
+			Expect(controller.IsPatchConflictError(err)).To(BeFalse())
+			Expect(controller.IsPatchValidationError(err)).To(BeFalse())
+		})
+	})
 })
 
+// FailingPatchClient fails every body and status patch that is sent through it with the error
+type FailingPatchClient struct {
+	client.Client
+	Err error
+}
+
+func (c *FailingPatchClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return c.Err
+}
+
+func (c *FailingPatchClient) Status() client.StatusWriter {
+	return &failingStatusWriter{StatusWriter: c.Client.Status(), err: c.Err}
+}
+
+type failingStatusWriter struct {
+	client.StatusWriter
+	err error
+}
+
+func (w *failingStatusWriter) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return w.err
+}
+
+// CountingClient records the number of body and status patches that are sent through it
+type CountingClient struct {
+	client.Client
+	Patches       int
+	StatusPatches int
+}
+
+func (c *CountingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.Patches++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *CountingClient) Status() client.StatusWriter {
+	return &countingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type countingStatusWriter struct {
+	client.StatusWriter
+	client *CountingClient
+}
+
+func (w *countingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.client.StatusPatches++
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
 type TypedReconcileAssertion[T client.Object] func(context.Context, T)
 
 type FakeTypedController[T client.Object] struct {
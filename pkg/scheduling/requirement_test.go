@@ -77,6 +77,58 @@ var _ = Describe("Requirement", func() {
 				))
 			}
 		})
+		It("should intersect node selectors with node affinity", func() {
+			requirements := NewPodRequirements(&v1.Pod{
+				Spec: v1.PodSpec{
+					NodeSelector: map[string]string{
+						v1.LabelFailureDomainBetaZone: "test-zone-1",
+						v1.LabelInstanceTypeStable:    "small",
+					},
+					Affinity: &v1.Affinity{
+						NodeAffinity: &v1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{
+								{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}},
+								{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm64"}},
+							}}}},
+						},
+					},
+				},
+			})
+			Expect(requirements.Keys().List()).To(ConsistOf(v1.LabelTopologyZone, v1.LabelInstanceTypeStable, v1.LabelArchStable))
+			Expect(requirements.Get(v1.LabelTopologyZone).Values()).To(ConsistOf("test-zone-1"))
+			Expect(requirements.Get(v1.LabelInstanceTypeStable).Values()).To(ConsistOf("small"))
+			Expect(requirements.Get(v1.LabelArchStable).Values()).To(ConsistOf("arm64"))
+		})
+		It("should not satisfy node selectors that conflict with node affinity", func() {
+			requirements := NewPodRequirements(&v1.Pod{
+				Spec: v1.PodSpec{
+					NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "small"},
+					Affinity: &v1.Affinity{
+						NodeAffinity: &v1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{
+								{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"large"}},
+							}}}},
+						},
+					},
+				},
+			})
+			Expect(requirements.Get(v1.LabelInstanceTypeStable).Len()).To(BeZero())
+			Expect(NewLabelRequirements(map[string]string{v1.LabelInstanceTypeStable: "small"}).Compatible(requirements)).ToNot(Succeed())
+		})
+		It("should require the operating system that the pod declares", func() {
+			requirements := NewPodRequirements(&v1.Pod{Spec: v1.PodSpec{OS: &v1.PodOS{Name: v1.Windows}}})
+			Expect(requirements.Keys().List()).To(ConsistOf(v1.LabelOSStable))
+			Expect(requirements.Get(v1.LabelOSStable).Values()).To(ConsistOf(string(v1.Windows)))
+		})
+		It("should intersect the operating system that the pod declares with its node selectors", func() {
+			requirements := NewPodRequirements(&v1.Pod{Spec: v1.PodSpec{
+				OS:           &v1.PodOS{Name: v1.Windows},
+				NodeSelector: map[string]string{v1.LabelOSStable: string(v1.Linux)},
+			}})
+			Expect(requirements.Get(v1.LabelOSStable).Len()).To(BeZero())
+			Expect(NewLabelRequirements(map[string]string{v1.LabelOSStable: string(v1.Linux)}).Compatible(requirements)).ToNot(Succeed())
+			Expect(NewLabelRequirements(map[string]string{v1.LabelOSStable: string(v1.Windows)}).Compatible(requirements)).ToNot(Succeed())
+		})
 	})
 	Context("Intersection", func() {
 		It("should intersect sets", func() {
@@ -60,6 +60,11 @@ func NewLabelRequirements(labels map[string]string) Requirements {
 // NewPodRequirements constructs requirements from a pod
 func NewPodRequirements(pod *v1.Pod) Requirements {
 	requirements := NewLabelRequirements(pod.Spec.NodeSelector)
+	// The kubelet rejects pods whose declared operating system doesn't match its node's, so it's required like a node
+	// selector on the operating system label
+	if pod.Spec.OS != nil && pod.Spec.OS.Name != "" {
+		requirements.Add(NewRequirement(v1.LabelOSStable, v1.NodeSelectorOpIn, string(pod.Spec.OS.Name)))
+	}
 	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
 		return requirements
 	}
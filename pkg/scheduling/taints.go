@@ -38,3 +38,169 @@ func (ts Taints) Tolerates(pod *v1.Pod) (errs error) {
 	}
 	return errs
 }
+
+// ToleratesIndefinitely returns true if the pod tolerates all taints, where a NoExecute taint is only tolerated
+// indefinitely, as a toleration with tolerationSeconds only delays the pod's eviction from a node with the taint. This
+// is used for the taints of a machine template, which stay on the node for its lifetime. It must not be used for the
+// taints of existing nodes, as every pod is given bounded tolerations for the NoExecute not-ready and unreachable
+// taints that are applied while a node is briefly unavailable.
+func (ts Taints) ToleratesIndefinitely(pod *v1.Pod) (errs error) {
+	for i := range ts {
+		taint := ts[i]
+		tolerates := false
+		var bounded *v1.Toleration
+		for j := range pod.Spec.Tolerations {
+			t := &pod.Spec.Tolerations[j]
+			if !t.ToleratesTaint(&taint) {
+				continue
+			}
+			if taint.Effect == v1.TaintEffectNoExecute && t.TolerationSeconds != nil {
+				bounded = t
+				continue
+			}
+			tolerates = true
+			break
+		}
+		if tolerates {
+			continue
+		}
+		if bounded != nil {
+			errs = multierr.Append(errs, fmt.Errorf("only tolerated %s=%s:%s for %ds", taint.Key, taint.Value, taint.Effect, *bounded.TolerationSeconds))
+		} else {
+			errs = multierr.Append(errs, fmt.Errorf("did not tolerate %s=%s:%s", taint.Key, taint.Value, taint.Effect))
+		}
+	}
+	return errs
+}
+
+// TolerationCache memoizes the result of Taints.ToleratesIndefinitely keyed by the hash of the taints and the hash of
+// the pod's tolerations. Large batches of pods commonly share identical tolerations, and every node created from a
+// template shares the template's taints, so the same comparison is otherwise repeated for every pod and node. The
+// hashes aren't collision resistant and tolerations are user controlled, so each entry also holds copies of the taints
+// and tolerations it was computed for, which are compared on a hit. A nil *TolerationCache is valid and always falls
+// through to Taints.ToleratesIndefinitely. It is not safe for concurrent use.
+type TolerationCache struct {
+	results map[tolerationCacheKey][]tolerationCacheEntry
+}
+
+type tolerationCacheKey struct {
+	taints      uint64
+	tolerations uint64
+}
+
+type tolerationCacheEntry struct {
+	taints      Taints
+	tolerations []v1.Toleration
+	err         error
+}
+
+func NewTolerationCache() *TolerationCache {
+	return &TolerationCache{results: map[tolerationCacheKey][]tolerationCacheEntry{}}
+}
+
+// ToleratesIndefinitely returns the same result as ts.ToleratesIndefinitely(pod), consulting the cache first.
+func (c *TolerationCache) ToleratesIndefinitely(ts Taints, pod *v1.Pod) error {
+	if c == nil {
+		return ts.ToleratesIndefinitely(pod)
+	}
+	key := tolerationCacheKey{taints: hashTaints(ts), tolerations: hashTolerations(pod.Spec.Tolerations)}
+	for _, entry := range c.results[key] {
+		if equalTaints(entry.taints, ts) && equalTolerations(entry.tolerations, pod.Spec.Tolerations) {
+			return entry.err
+		}
+	}
+	err := ts.ToleratesIndefinitely(pod)
+	// the taints and tolerations are copied so that later changes to them, e.g. relaxing the pod, can't alter the entry
+	c.results[key] = append(c.results[key], tolerationCacheEntry{
+		taints:      append(Taints(nil), ts...),
+		tolerations: append([]v1.Toleration(nil), pod.Spec.Tolerations...),
+		err:         err,
+	})
+	return err
+}
+
+// equalTaints compares every field of the taints that is considered by Taints.ToleratesIndefinitely
+func equalTaints(a, b Taints) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Value != b[i].Value || a[i].Effect != b[i].Effect {
+			return false
+		}
+	}
+	return true
+}
+
+// equalTolerations compares all fields of the tolerations
+func equalTolerations(a, b []v1.Toleration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].MatchToleration(&b[i]) {
+			return false
+		}
+		if (a[i].TolerationSeconds == nil) != (b[i].TolerationSeconds == nil) ||
+			(a[i].TolerationSeconds != nil && *a[i].TolerationSeconds != *b[i].TolerationSeconds) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashTaints hashes every field of the taints that is considered by Taints.ToleratesIndefinitely
+func hashTaints(ts Taints) uint64 {
+	h := uint64(fnvOffset64)
+	for i := range ts {
+		h = hashString(h, ts[i].Key)
+		h = hashString(h, ts[i].Value)
+		h = hashString(h, string(ts[i].Effect))
+	}
+	return h
+}
+
+// hashTolerations hashes all fields of the tolerations, including TolerationSeconds as it determines whether a
+// NoExecute taint is tolerated.
+func hashTolerations(tolerations []v1.Toleration) uint64 {
+	h := uint64(fnvOffset64)
+	for i := range tolerations {
+		h = hashString(h, tolerations[i].Key)
+		h = hashString(h, string(tolerations[i].Operator))
+		h = hashString(h, tolerations[i].Value)
+		h = hashString(h, string(tolerations[i].Effect))
+		if seconds := tolerations[i].TolerationSeconds; seconds != nil {
+			h = hashUint64(h, uint64(*seconds))
+		} else {
+			h = hashString(h, "nil")
+		}
+	}
+	return h
+}
+
+// FNV-1a is implemented inline rather than with hash/fnv to avoid allocating when converting strings to byte slices,
+// which would otherwise cost more than the toleration check being cached.
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+func hashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	// terminate each field so that e.g. ("ab", "c") and ("a", "bc") hash differently
+	h ^= 0xff
+	h *= fnvPrime64
+	return h
+}
+
+func hashUint64(h uint64, v uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h ^= v & 0xff
+		h *= fnvPrime64
+		v >>= 8
+	}
+	return h
+}
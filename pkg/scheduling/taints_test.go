@@ -0,0 +1,168 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/ptr"
+)
+
+var _ = Describe("Taints", func() {
+	noSchedule := Taints{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}}
+	noExecute := Taints{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoExecute}}
+	podWith := func(tolerations ...v1.Toleration) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Tolerations: tolerations}}
+	}
+
+	Context("Tolerates", func() {
+		It("should tolerate NoExecute taints with a bounded toleration", func() {
+			Expect(noExecute.Tolerates(podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)}))).To(Succeed())
+		})
+		It("should tolerate the default not-ready and unreachable tolerations", func() {
+			taints := Taints{
+				{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoExecute},
+				{Key: v1.TaintNodeUnreachable, Effect: v1.TaintEffectNoExecute},
+			}
+			Expect(taints.Tolerates(podWith(
+				v1.Toleration{Key: v1.TaintNodeNotReady, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+				v1.Toleration{Key: v1.TaintNodeUnreachable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+			))).To(Succeed())
+		})
+		It("should not tolerate taints without a matching toleration", func() {
+			Expect(noExecute.Tolerates(podWith())).To(MatchError("did not tolerate dedicated=gpu:NoExecute"))
+		})
+	})
+	Context("ToleratesIndefinitely", func() {
+		It("should tolerate NoExecute taints with an unbounded toleration", func() {
+			Expect(noExecute.ToleratesIndefinitely(podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoExecute}))).To(Succeed())
+			Expect(noExecute.ToleratesIndefinitely(podWith(v1.Toleration{Operator: v1.TolerationOpExists}))).To(Succeed())
+		})
+		It("should not tolerate NoExecute taints with a bounded toleration", func() {
+			err := noExecute.ToleratesIndefinitely(podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)}))
+			Expect(err).To(MatchError("only tolerated dedicated=gpu:NoExecute for 300s"))
+		})
+		It("should not tolerate NoExecute taints with a zero second toleration", func() {
+			Expect(noExecute.ToleratesIndefinitely(podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(0)}))).ToNot(Succeed())
+		})
+		It("should tolerate NoExecute taints if any matching toleration is unbounded", func() {
+			Expect(noExecute.ToleratesIndefinitely(podWith(
+				v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+				v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists},
+			))).To(Succeed())
+		})
+		It("should ignore tolerationSeconds for NoSchedule taints", func() {
+			Expect(noSchedule.ToleratesIndefinitely(podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, TolerationSeconds: ptr.Int64(300)}))).To(Succeed())
+		})
+		It("should not tolerate taints without a matching toleration", func() {
+			Expect(noExecute.ToleratesIndefinitely(podWith())).To(MatchError("did not tolerate dedicated=gpu:NoExecute"))
+		})
+	})
+})
+
+var _ = Describe("TolerationCache", func() {
+	taints := Taints{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}}
+	noExecute := Taints{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoExecute}}
+	podWith := func(tolerations ...v1.Toleration) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{Tolerations: tolerations}}
+	}
+
+	It("should return the same result as Taints.ToleratesIndefinitely", func() {
+		cache := NewTolerationCache()
+		tolerating := podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule})
+		intolerant := podWith()
+		for i := 0; i < 3; i++ {
+			Expect(cache.ToleratesIndefinitely(taints, tolerating)).To(Succeed())
+			Expect(cache.ToleratesIndefinitely(taints, intolerant)).ToNot(Succeed())
+		}
+		Expect(cache.results).To(HaveLen(2))
+	})
+	It("should share results between pods with identical tolerations", func() {
+		cache := NewTolerationCache()
+		toleration := v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists}
+		Expect(cache.ToleratesIndefinitely(taints, podWith(toleration))).To(Succeed())
+		Expect(cache.ToleratesIndefinitely(taints, podWith(toleration))).To(Succeed())
+		Expect(cache.results).To(HaveLen(1))
+	})
+	It("should not share results between different taint sets", func() {
+		cache := NewTolerationCache()
+		pod := podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule})
+		Expect(cache.ToleratesIndefinitely(taints, pod)).To(Succeed())
+		Expect(cache.ToleratesIndefinitely(noExecute, pod)).ToNot(Succeed())
+		Expect(cache.results).To(HaveLen(2))
+	})
+	It("should not share results between tolerations that differ only by tolerationSeconds", func() {
+		cache := NewTolerationCache()
+		bounded := podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(30)})
+		unbounded := podWith(v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute})
+		Expect(cache.ToleratesIndefinitely(noExecute, bounded)).ToNot(Succeed())
+		Expect(cache.ToleratesIndefinitely(noExecute, unbounded)).To(Succeed())
+		Expect(cache.results).To(HaveLen(2))
+	})
+	It("should not cache a relaxed pod's stale result", func() {
+		cache := NewTolerationCache()
+		pod := podWith()
+		Expect(cache.ToleratesIndefinitely(taints, pod)).ToNot(Succeed())
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, v1.Toleration{Operator: v1.TolerationOpExists})
+		Expect(cache.ToleratesIndefinitely(taints, pod)).To(Succeed())
+	})
+	It("should not return the result of a different pod whose tolerations hash identically", func() {
+		cache := NewTolerationCache()
+		pod := podWith()
+		key := tolerationCacheKey{taints: hashTaints(taints), tolerations: hashTolerations(pod.Spec.Tolerations)}
+		// simulate a collision with a pod that tolerates the taints
+		cache.results[key] = []tolerationCacheEntry{{
+			taints:      taints,
+			tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+		}}
+		Expect(cache.ToleratesIndefinitely(taints, pod)).ToNot(Succeed())
+		Expect(cache.results[key]).To(HaveLen(2))
+		Expect(cache.ToleratesIndefinitely(taints, pod)).ToNot(Succeed())
+		Expect(cache.results[key]).To(HaveLen(2))
+	})
+	It("should fall through when nil", func() {
+		var cache *TolerationCache
+		Expect(cache.ToleratesIndefinitely(taints, podWith())).ToNot(Succeed())
+	})
+})
+
+// benchmarkTaints and benchmarkPod model a batch of identical pods against a provisioner with several taints
+var benchmarkTaints = Taints{
+	{Key: "dedicated", Value: "ml", Effect: v1.TaintEffectNoSchedule},
+	{Key: "team", Value: "research", Effect: v1.TaintEffectNoSchedule},
+	{Key: "example.com/maintenance", Effect: v1.TaintEffectPreferNoSchedule},
+}
+var benchmarkPod = &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{
+	{Key: v1.TaintNodeNotReady, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+	{Key: v1.TaintNodeUnreachable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: ptr.Int64(300)},
+	{Key: "team", Operator: v1.TolerationOpEqual, Value: "research", Effect: v1.TaintEffectNoSchedule},
+	{Key: "example.com/maintenance", Operator: v1.TolerationOpExists},
+}}}
+
+func BenchmarkTaintsTolerates(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = benchmarkTaints.ToleratesIndefinitely(benchmarkPod)
+	}
+}
+
+func BenchmarkTolerationCacheTolerates(b *testing.B) {
+	cache := NewTolerationCache()
+	for i := 0; i < b.N; i++ {
+		_ = cache.ToleratesIndefinitely(benchmarkTaints, benchmarkPod)
+	}
+}
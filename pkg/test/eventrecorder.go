@@ -112,13 +112,15 @@ func (e *EventRecorder) ForEachBinding(f func(pod *v1.Pod, node *v1.Node)) {
 var _ record.EventRecorder = (*InternalRecorder)(nil)
 
 type InternalRecorder struct {
-	mu    sync.RWMutex
-	calls map[string]int
+	mu          sync.RWMutex
+	calls       map[string]int
+	annotations map[string]map[string]string
 }
 
 func NewInternalRecorder() *InternalRecorder {
 	return &InternalRecorder{
-		calls: map[string]int{},
+		calls:       map[string]int{},
+		annotations: map[string]map[string]string{},
 	}
 }
 
@@ -132,8 +134,11 @@ func (i *InternalRecorder) Eventf(object runtime.Object, eventtype, reason, mess
 	i.Event(object, eventtype, reason, messageFmt)
 }
 
-func (i *InternalRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, _ ...interface{}) {
+func (i *InternalRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, _ ...interface{}) {
 	i.Event(object, eventtype, reason, messageFmt)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.annotations[reason] = annotations
 }
 
 func (i *InternalRecorder) Calls(reason string) int {
@@ -141,3 +146,10 @@ func (i *InternalRecorder) Calls(reason string) int {
 	defer i.mu.RUnlock()
 	return i.calls[reason]
 }
+
+// Annotations returns the annotations of the last annotated event that was recorded with the given reason
+func (i *InternalRecorder) Annotations(reason string) map[string]string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.annotations[reason]
+}
@@ -39,7 +39,8 @@ func (ss SettingsStore) InjectSettings(ctx context.Context) context.Context {
 }
 
 type SettingsOptions struct {
-	DriftEnabled bool
+	DriftEnabled             bool
+	AnnotateProvisioningPods bool
 }
 
 func Settings(overrides ...SettingsOptions) settings.Settings {
@@ -50,8 +51,9 @@ func Settings(overrides ...SettingsOptions) settings.Settings {
 		}
 	}
 	return settings.Settings{
-		BatchMaxDuration:  metav1.Duration{},
-		BatchIdleDuration: metav1.Duration{},
-		DriftEnabled:      options.DriftEnabled,
+		BatchMaxDuration:         metav1.Duration{},
+		BatchIdleDuration:        metav1.Duration{},
+		DriftEnabled:             options.DriftEnabled,
+		AnnotateProvisioningPods: options.AnnotateProvisioningPods,
 	}
 }
@@ -15,6 +15,8 @@ limitations under the License.
 package pod
 
 import (
+	"strconv"
+
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
@@ -43,8 +45,16 @@ func IsScheduled(pod *v1.Pod) bool {
 	return pod.Spec.NodeName != ""
 }
 
+// IsPreempting returns true if the kube-scheduler nominated a node for the pod to preempt lower priority pods on. A pod
+// that can't preempt isn't preempting, regardless of its nomination, and waits for capacity instead.
 func IsPreempting(pod *v1.Pod) bool {
-	return pod.Status.NominatedNodeName != ""
+	return pod.Status.NominatedNodeName != "" && CanPreempt(pod)
+}
+
+// CanPreempt returns true if the pod may preempt lower priority pods to be scheduled. Pods with a preemption policy of
+// Never must only ever be scheduled to available capacity.
+func CanPreempt(pod *v1.Pod) bool {
+	return pod.Spec.PreemptionPolicy == nil || *pod.Spec.PreemptionPolicy != v1.PreemptNever
 }
 
 func IsTerminal(pod *v1.Pod) bool {
@@ -68,6 +78,13 @@ func IsOwnedByNode(pod *v1.Pod) bool {
 	})
 }
 
+// IsOwnedByJob returns true if the pod is run to completion by a job
+func IsOwnedByJob(pod *v1.Pod) bool {
+	return IsOwnedBy(pod, []schema.GroupVersionKind{
+		{Group: "batch", Version: "v1", Kind: "Job"},
+	})
+}
+
 func IsOwnedBy(pod *v1.Pod, gvks []schema.GroupVersionKind) bool {
 	for _, ignoredOwner := range gvks {
 		for _, owner := range pod.ObjectMeta.OwnerReferences {
@@ -86,6 +103,48 @@ func HasDoNotEvict(pod *v1.Pod) bool {
 	return pod.Annotations[v1alpha5.DoNotEvictPodAnnotationKey] == "true"
 }
 
+// HasDedicatedNode returns true if the pod requires a new node that no other pods are scheduled to
+func HasDedicatedNode(pod *v1.Pod) bool {
+	if pod.Annotations == nil {
+		return false
+	}
+	return pod.Annotations[v1alpha5.DedicatedNodePodAnnotationKey] == "true"
+}
+
+// MinZones returns the minimum number of zones that the pod's group must be spread across, or zero if the pod doesn't
+// require a minimum or the annotation isn't a positive integer
+func MinZones(pod *v1.Pod) int32 {
+	value, ok := pod.Annotations[v1alpha5.MinZonesPodAnnotationKey]
+	if !ok {
+		return 0
+	}
+	minZones, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || minZones < 0 {
+		return 0
+	}
+	return int32(minZones)
+}
+
+// PodGroup returns the namespaced name of the group that the pod is gang scheduled with, or an empty string if the pod
+// isn't part of a group
+func PodGroup(pod *v1.Pod) string {
+	name := pod.Annotations[v1alpha5.PodGroupPodAnnotationKey]
+	if name == "" {
+		return ""
+	}
+	return pod.Namespace + "/" + name
+}
+
+// SpreadThenPackGroup returns the namespaced name of the spread-then-pack group of the pod, or an empty string if the
+// pod isn't part of one
+func SpreadThenPackGroup(pod *v1.Pod) string {
+	name := pod.Annotations[v1alpha5.SpreadThenPackPodAnnotationKey]
+	if name == "" {
+		return ""
+	}
+	return pod.Namespace + "/" + name
+}
+
 // HasUnschedulableToleration returns true if the pod tolerates node.kubernetes.io/unschedulable taint
 func ToleratesUnschedulableTaint(pod *v1.Pod) bool {
 	return (scheduling.Taints{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}}).Tolerates(pod) == nil
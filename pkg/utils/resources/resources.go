@@ -32,6 +32,18 @@ func RequestsForPods(pods ...*v1.Pod) v1.ResourceList {
 	return merged
 }
 
+// DefaultRequests returns a copy of the requests with the default quantity substituted for any resource that isn't
+// requested
+func DefaultRequests(requests v1.ResourceList, defaults v1.ResourceList) v1.ResourceList {
+	result := requests.DeepCopy()
+	for resourceName, quantity := range defaults {
+		if requested, ok := result[resourceName]; !ok || requested.IsZero() {
+			result[resourceName] = quantity.DeepCopy()
+		}
+	}
+	return result
+}
+
 // LimitsForPods returns the total resources of a variadic list of podspecs
 func LimitsForPods(pods ...*v1.Pod) v1.ResourceList {
 	var resources []v1.ResourceList
@@ -43,6 +55,25 @@ func LimitsForPods(pods ...*v1.Pod) v1.ResourceList {
 	return merged
 }
 
+// RequestsOrLimitsForPods returns the total resources of a variadic list of podspecs, taking the greater of the request
+// and limit of each container's resources rather than its request alone
+func RequestsOrLimitsForPods(pods ...*v1.Pod) v1.ResourceList {
+	var resources []v1.ResourceList
+	for _, pod := range pods {
+		var ceiling v1.ResourceList
+		for _, container := range pod.Spec.Containers {
+			ceiling = Merge(ceiling, MaxResources(MergeResourceLimitsIntoRequests(container), container.Resources.Limits))
+		}
+		for _, container := range pod.Spec.InitContainers {
+			ceiling = MaxResources(ceiling, MergeResourceLimitsIntoRequests(container), container.Resources.Limits)
+		}
+		resources = append(resources, ceiling)
+	}
+	merged := Merge(resources...)
+	merged[v1.ResourcePods] = *resource.NewQuantity(int64(len(pods)), resource.DecimalExponent)
+	return merged
+}
+
 // Merge the resources from the variadic into a single v1.ResourceList
 func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	if len(resources) == 0 {
@@ -144,6 +175,25 @@ func Fits(candidate, total v1.ResourceList) bool {
 	return true
 }
 
+// DefaultGranularity is the precision that requests are rounded up to before they are compared with capacity. CPU is
+// rounded up to whole millicores, which is the precision the kubelet uses when admitting pods, so requests that sum to
+// fractional millicores are compared consistently. Resources that aren't listed are compared exactly.
+var DefaultGranularity = map[v1.ResourceName]resource.Scale{v1.ResourceCPU: resource.Milli}
+
+// RoundUp returns a copy of the resource list with each quantity rounded up to the scale that the granularity lists for
+// its resource. Quantities of resources without a granularity are copied unchanged.
+func RoundUp(list v1.ResourceList, granularity map[v1.ResourceName]resource.Scale) v1.ResourceList {
+	result := make(v1.ResourceList, len(list))
+	for resourceName, quantity := range list {
+		rounded := quantity.DeepCopy()
+		if scale, ok := granularity[resourceName]; ok {
+			rounded.RoundUp(scale)
+		}
+		result[resourceName] = rounded
+	}
+	return result
+}
+
 // String returns a string version of the resource list suitable for presenting in a log
 func String(list v1.ResourceList) string {
 	if len(list) == 0 {